@@ -0,0 +1,23 @@
+// Package accounting tracks the state of sessions from the
+// Accounting-Requests of NASes - rfc2866.
+//
+// A Tracker keeps a session for each Acct-Session-Id of a NAS, which is
+// created by its Start request, updated by Interim-Update requests and
+// closed by its Stop request:
+//
+//	t := &accounting.Tracker{
+//		InterimInterval: 5 * time.Minute,
+//	}
+//	events, err := t.Add(r.Packet, time.Now())
+//	...
+//	for _, e := range events {
+//		log.Printf("%s closed: %v, %d bytes", e.Session.UserName, e.Reason, e.Session.InputOctets)
+//	}
+//
+// All sessions of a NAS are closed by its Accounting-On and Accounting-Off
+// requests, and sessions that have not been updated in time are closed by
+// Tracker.Expire. Closed sessions are remembered for a while, so that
+// retransmitted Stop requests do not close them a second time.
+//
+// API is currently unstable.
+package accounting
@@ -0,0 +1,374 @@
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3162"
+)
+
+// Default values of the fields of Tracker.
+const (
+	// DefaultMissedUpdates is the number of interim updates a session may
+	// miss before it is stale, if Tracker.MissedUpdates is zero.
+	DefaultMissedUpdates = 2
+	// DefaultRetention is the time for which closed sessions are
+	// remembered, if Tracker.Retention is zero.
+	DefaultRetention = 24 * time.Hour
+)
+
+// Errors returned for requests that cannot be tracked.
+var (
+	ErrNoStatusType = errors.New("accounting: request has no Acct-Status-Type")
+	ErrNoSessionID  = errors.New("accounting: request has no Acct-Session-Id")
+	ErrNoNAS        = errors.New("accounting: request has no NAS-IP-Address, NAS-IPv6-Address or NAS-Identifier")
+)
+
+// Key identifies a session.
+type Key struct {
+	// NAS is the NAS-IP-Address of the NAS, or its NAS-IPv6-Address or
+	// NAS-Identifier if the requests do not contain one.
+	NAS            string
+	SessionID      string
+	MultiSessionID string
+}
+
+// Session is the state of a session.
+type Session struct {
+	Key
+	UserName string
+
+	// Start is the time at which the session started. It is estimated from
+	// the Acct-Session-Time of the first request of the session if its Start
+	// request was not seen.
+	Start time.Time
+	// Stop is the time at which the session was closed, or zero if it is
+	// open.
+	Stop time.Time
+	// LastUpdate is the time of the latest request of the session.
+	LastUpdate time.Time
+	// Updates is the number of requests of the session.
+	Updates int
+	// InterimInterval is the Acct-Interim-Interval of the session, or the
+	// Tracker's InterimInterval if its requests do not contain one.
+	InterimInterval time.Duration
+
+	// SessionTime is the latest Acct-Session-Time of the session.
+	SessionTime time.Duration
+	// InputOctets and OutputOctets combine Acct-Input-Octets and
+	// Acct-Output-Octets with Acct-Input-Gigawords and Acct-Output-Gigawords
+	// - rfc2869, 5.1 and 5.2.
+	InputOctets   uint64
+	OutputOctets  uint64
+	InputPackets  uint32
+	OutputPackets uint32
+	// TerminateCause is the Acct-Terminate-Cause of the Stop request of the
+	// session, if any.
+	TerminateCause rfc2866.AcctTerminateCause
+
+	// Packet is the latest request of the session.
+	Packet *radius.Packet
+}
+
+// Reason is the reason for which a session was closed.
+type Reason int
+
+// Reasons for which sessions are closed.
+const (
+	// ReasonStop is given for sessions closed by their Stop request.
+	ReasonStop Reason = iota + 1
+	// ReasonAccountingOn and ReasonAccountingOff are given for the sessions
+	// of a NAS that sent an Accounting-On or Accounting-Off request.
+	ReasonAccountingOn
+	ReasonAccountingOff
+	// ReasonStale is given for sessions that missed interim updates. A
+	// later Stop request of the session gives a second event, with
+	// ReasonStop.
+	ReasonStale
+)
+
+var reasonStrings = map[Reason]string{
+	ReasonStop:          "Stop",
+	ReasonAccountingOn:  "Accounting-On",
+	ReasonAccountingOff: "Accounting-Off",
+	ReasonStale:         "Stale",
+}
+
+func (r Reason) String() string {
+	if str, ok := reasonStrings[r]; ok {
+		return str
+	}
+	return "Reason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// Event is the closing of a session.
+type Event struct {
+	Session *Session
+	Reason  Reason
+}
+
+// Tracker tracks the sessions of Accounting-Requests. It is safe for
+// concurrent use.
+type Tracker struct {
+	// InterimInterval is the interval of the interim updates of sessions
+	// whose requests do not contain Acct-Interim-Interval. Such sessions
+	// never become stale if zero.
+	InterimInterval time.Duration
+
+	// MissedUpdates is the number of interim intervals after the latest
+	// request of a session at which it becomes stale. DefaultMissedUpdates
+	// is used if zero.
+	MissedUpdates int
+
+	// Retention is the time for which closed sessions are remembered, so
+	// that their late or retransmitted requests are dropped. Closed
+	// sessions are forgotten by Expire. DefaultRetention is used if zero.
+	Retention time.Duration
+
+	mu       sync.Mutex
+	sessions map[Key]*Session
+	closed   map[Key]*tombstone
+}
+
+// tombstone is a closed session.
+type tombstone struct {
+	// stop is the Stop time of the session
+	stop time.Time
+	// expires is the time after which the session is forgotten
+	expires time.Time
+	// stale is the session if it was closed as stale, so that its Stop
+	// request can still be accounted for
+	stale *Session
+}
+
+// Add updates the session of the Accounting-Request p, which was received
+// at t. The time at which the request was sent is determined by its
+// Acct-Delay-Time. Add returns the events of the sessions that p closes.
+//
+// A session that was not started by a Start request is created by its first
+// request, and a Stop request of an unknown session returns the event of a
+// session that consists of only that request. Requests of other
+// Acct-Status-Types, such as Failed, are ignored.
+//
+// Interim-Update and Stop requests of a session that has been closed are
+// dropped, as are Start requests sent before it was closed, so that
+// retransmitted requests do not reopen the session. The exception is the
+// first Stop request of a session closed as stale, such as a session whose
+// NAS was unreachable: it returns a second event of the session, with
+// ReasonStop, carrying its final counters and Acct-Terminate-Cause.
+func (t *Tracker) Add(p *radius.Packet, received time.Time) ([]*Event, error) {
+	if p.Code != radius.CodeAccountingRequest {
+		return nil, fmt.Errorf("accounting: unexpected %v", p.Code)
+	}
+	statusType, err := rfc2866.AcctStatusType_Lookup(p)
+	if err != nil {
+		return nil, ErrNoStatusType
+	}
+	nas := nasOf(p)
+	if nas == "" {
+		return nil, ErrNoNAS
+	}
+	at := received.Add(-time.Duration(rfc2866.AcctDelayTime_Get(p)) * time.Second)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessions == nil {
+		t.sessions = make(map[Key]*Session)
+		t.closed = make(map[Key]*tombstone)
+	}
+
+	switch statusType {
+	case rfc2866.AcctStatusType_Value_AccountingOn:
+		return t.closeNAS(nas, at, received, ReasonAccountingOn), nil
+	case rfc2866.AcctStatusType_Value_AccountingOff:
+		return t.closeNAS(nas, at, received, ReasonAccountingOff), nil
+	case rfc2866.AcctStatusType_Value_Start, rfc2866.AcctStatusType_Value_InterimUpdate,
+		rfc2866.AcctStatusType_Value_Stop:
+	default:
+		return nil, nil
+	}
+
+	sessionID := rfc2866.AcctSessionID_GetString(p)
+	if sessionID == "" {
+		return nil, ErrNoSessionID
+	}
+	key := Key{
+		NAS:            nas,
+		SessionID:      sessionID,
+		MultiSessionID: rfc2866.AcctMultiSessionID_GetString(p),
+	}
+	if closed := t.closed[key]; closed != nil {
+		if closed.stale != nil && statusType == rfc2866.AcctStatusType_Value_Stop {
+			s := *closed.stale
+			s.Updates++
+			if !at.Before(s.LastUpdate) {
+				t.update(&s, p, at)
+			}
+			s.TerminateCause = rfc2866.AcctTerminateCause_Get(p)
+			return []*Event{t.close(&s, at, received, ReasonStop)}, nil
+		}
+		if statusType != rfc2866.AcctStatusType_Value_Start || !at.After(closed.stop) {
+			return nil, nil
+		}
+		// the session identifier has been reused for a new session
+		delete(t.closed, key)
+	}
+	s := t.sessions[key]
+	if s == nil {
+		s = &Session{
+			Key:   key,
+			Start: at,
+		}
+		if statusType != rfc2866.AcctStatusType_Value_Start {
+			s.Start = at.Add(-time.Duration(rfc2866.AcctSessionTime_Get(p)) * time.Second)
+		}
+		t.sessions[key] = s
+	}
+	s.Updates++
+	if !at.Before(s.LastUpdate) {
+		// otherwise, p was overtaken by a later request, and its counters
+		// are outdated
+		t.update(s, p, at)
+	}
+
+	if statusType != rfc2866.AcctStatusType_Value_Stop {
+		return nil, nil
+	}
+	s.TerminateCause = rfc2866.AcctTerminateCause_Get(p)
+	return []*Event{t.close(s, at, received, ReasonStop)}, nil
+}
+
+// update updates s with the request p, which was sent at at.
+func (t *Tracker) update(s *Session, p *radius.Packet, at time.Time) {
+	s.LastUpdate = at
+	s.Packet = p
+	if userName := rfc2865.UserName_GetString(p); userName != "" {
+		s.UserName = userName
+	}
+	if interval, err := rfc2869.AcctInterimInterval_Lookup(p); err == nil {
+		s.InterimInterval = time.Duration(interval) * time.Second
+	} else if s.InterimInterval == 0 {
+		s.InterimInterval = t.InterimInterval
+	}
+
+	if sessionTime, err := rfc2866.AcctSessionTime_Lookup(p); err == nil {
+		s.SessionTime = time.Duration(sessionTime) * time.Second
+	}
+	if octets, err := rfc2866.AcctInputOctets_Lookup(p); err == nil {
+		s.InputOctets = uint64(rfc2869.AcctInputGigawords_Get(p))<<32 | uint64(octets)
+	}
+	if octets, err := rfc2866.AcctOutputOctets_Lookup(p); err == nil {
+		s.OutputOctets = uint64(rfc2869.AcctOutputGigawords_Get(p))<<32 | uint64(octets)
+	}
+	if packets, err := rfc2866.AcctInputPackets_Lookup(p); err == nil {
+		s.InputPackets = uint32(packets)
+	}
+	if packets, err := rfc2866.AcctOutputPackets_Lookup(p); err == nil {
+		s.OutputPackets = uint32(packets)
+	}
+}
+
+// closeNAS closes all sessions of nas.
+func (t *Tracker) closeNAS(nas string, at, now time.Time, reason Reason) []*Event {
+	var events []*Event
+	for key, s := range t.sessions {
+		if key.NAS == nas {
+			events = append(events, t.close(s, at, now, reason))
+		}
+	}
+	return events
+}
+
+// close closes s at the time at, remembering it until Retention after now,
+// and returns its event.
+func (t *Tracker) close(s *Session, at, now time.Time, reason Reason) *Event {
+	retention := t.Retention
+	if retention == 0 {
+		retention = DefaultRetention
+	}
+	delete(t.sessions, s.Key)
+	closed := &tombstone{
+		stop:    at,
+		expires: now.Add(retention),
+	}
+	if reason == ReasonStale {
+		closed.stale = s
+	}
+	t.closed[s.Key] = closed
+	s.Stop = at
+	return &Event{
+		Session: s,
+		Reason:  reason,
+	}
+}
+
+// Expire closes the sessions that are stale at now, which are those whose
+// latest request was sent more than MissedUpdates interim intervals before
+// now. The Stop time of stale sessions is the time of their latest request.
+//
+// Closed sessions whose Retention has passed are forgotten, so Expire should
+// be called periodically.
+func (t *Tracker) Expire(now time.Time) []*Event {
+	missed := t.MissedUpdates
+	if missed == 0 {
+		missed = DefaultMissedUpdates
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, closed := range t.closed {
+		if now.After(closed.expires) {
+			delete(t.closed, key)
+		}
+	}
+	var events []*Event
+	for _, s := range t.sessions {
+		if s.InterimInterval > 0 && now.Sub(s.LastUpdate) > time.Duration(missed)*s.InterimInterval {
+			events = append(events, t.close(s, s.LastUpdate, now, ReasonStale))
+		}
+	}
+	return events
+}
+
+// Session returns a copy of the open session of key, or nil if there is none.
+func (t *Tracker) Session(key Key) *Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.sessions[key]
+	if s == nil {
+		return nil
+	}
+	copied := *s
+	return &copied
+}
+
+// Sessions returns copies of the open sessions, in no particular order.
+func (t *Tracker) Sessions() []*Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := make([]*Session, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		copied := *s
+		sessions = append(sessions, &copied)
+	}
+	return sessions
+}
+
+// nasOf returns the identity of the NAS that sent p, or an empty string if
+// it is unknown.
+func nasOf(p *radius.Packet) string {
+	if ip := rfc2865.NASIPAddress_Get(p); ip != nil {
+		return ip.String()
+	}
+	if ip := rfc3162.NASIPv6Address_Get(p); ip != nil {
+		return ip.String()
+	}
+	return rfc2865.NASIdentifier_GetString(p)
+}
@@ -0,0 +1,278 @@
+package accounting_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/accounting"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+var epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func request(nas, session string, statusType rfc2866.AcctStatusType) *radius.Packet {
+	p := radius.New(radius.CodeAccountingRequest, []byte(`secret`))
+	rfc2866.AcctStatusType_Set(p, statusType)
+	rfc2865.NASIPAddress_Set(p, net.ParseIP(nas))
+	if session != "" {
+		rfc2866.AcctSessionID_SetString(p, session)
+	}
+	return p
+}
+
+func add(t *testing.T, tracker *accounting.Tracker, p *radius.Packet, received time.Time) []*accounting.Event {
+	t.Helper()
+	events, err := tracker.Add(p, received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestTracker(t *testing.T) {
+	tracker := &accounting.Tracker{}
+
+	start := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Start)
+	rfc2865.UserName_SetString(start, "bob")
+	if events := add(t, tracker, start, epoch); len(events) != 0 {
+		t.Fatalf("got events %v", events)
+	}
+
+	interim := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_InterimUpdate)
+	rfc2866.AcctSessionTime_Set(interim, 60)
+	rfc2866.AcctInputOctets_Set(interim, 100)
+	rfc2869.AcctInputGigawords_Set(interim, 1)
+	rfc2866.AcctOutputOctets_Set(interim, 200)
+	rfc2866.AcctInputPackets_Set(interim, 10)
+	add(t, tracker, interim, epoch.Add(time.Minute))
+
+	key := accounting.Key{NAS: "192.0.2.1", SessionID: "a"}
+	s := tracker.Session(key)
+	if s == nil {
+		t.Fatal("expected session")
+	}
+	if s.UserName != "bob" || s.Updates != 2 || !s.Start.Equal(epoch) || s.SessionTime != time.Minute {
+		t.Fatalf("got session %+v", s)
+	}
+	if s.InputOctets != 1<<32+100 || s.OutputOctets != 200 || s.InputPackets != 10 {
+		t.Fatalf("got counters %d %d %d", s.InputOctets, s.OutputOctets, s.InputPackets)
+	}
+
+	// a retransmission of an earlier request does not reset the counters
+	old := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_InterimUpdate)
+	rfc2866.AcctInputOctets_Set(old, 50)
+	rfc2866.AcctDelayTime_Set(old, 90)
+	add(t, tracker, old, epoch.Add(2*time.Minute))
+	if s := tracker.Session(key); s.InputOctets != 1<<32+100 || s.Updates != 3 {
+		t.Fatalf("got session %+v", s)
+	}
+
+	// the key includes the Acct-Multi-Session-Id
+	other := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Start)
+	rfc2866.AcctMultiSessionID_SetString(other, "m")
+	add(t, tracker, other, epoch)
+	if sessions := tracker.Sessions(); len(sessions) != 2 {
+		t.Fatalf("got sessions %v", sessions)
+	}
+
+	stop := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Stop)
+	rfc2866.AcctSessionTime_Set(stop, 300)
+	rfc2866.AcctInputOctets_Set(stop, 300)
+	rfc2869.AcctInputGigawords_Set(stop, 2)
+	rfc2866.AcctTerminateCause_Set(stop, rfc2866.AcctTerminateCause_Value_UserRequest)
+	rfc2866.AcctDelayTime_Set(stop, 5)
+	events := add(t, tracker, stop, epoch.Add(5*time.Minute+5*time.Second))
+	if len(events) != 1 {
+		t.Fatalf("got events %v", events)
+	}
+	e := events[0]
+	if e.Reason != accounting.ReasonStop || e.Session.Key != key {
+		t.Fatalf("got event %v %+v", e.Reason, e.Session)
+	}
+	if !e.Session.Stop.Equal(epoch.Add(5*time.Minute)) || e.Session.InputOctets != 2<<32+300 ||
+		e.Session.TerminateCause != rfc2866.AcctTerminateCause_Value_UserRequest {
+		t.Fatalf("got session %+v", e.Session)
+	}
+	if s := tracker.Session(key); s != nil {
+		t.Fatalf("got session %+v", s)
+	}
+}
+
+func TestTracker_missedStart(t *testing.T) {
+	tracker := &accounting.Tracker{}
+
+	interim := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_InterimUpdate)
+	rfc2866.AcctSessionTime_Set(interim, 600)
+	add(t, tracker, interim, epoch)
+	s := tracker.Session(accounting.Key{NAS: "192.0.2.1", SessionID: "a"})
+	if s == nil || !s.Start.Equal(epoch.Add(-10*time.Minute)) {
+		t.Fatalf("got session %+v", s)
+	}
+
+	// a Stop of an unknown session
+	stop := request("192.0.2.1", "b", rfc2866.AcctStatusType_Value_Stop)
+	rfc2866.AcctSessionTime_Set(stop, 60)
+	events := add(t, tracker, stop, epoch)
+	if len(events) != 1 || !events[0].Session.Start.Equal(epoch.Add(-time.Minute)) || events[0].Session.Updates != 1 {
+		t.Fatalf("got events %v", events)
+	}
+}
+
+func TestTracker_accountingOnOff(t *testing.T) {
+	tracker := &accounting.Tracker{}
+	for _, session := range []string{"a", "b"} {
+		add(t, tracker, request("192.0.2.1", session, rfc2866.AcctStatusType_Value_Start), epoch)
+	}
+	add(t, tracker, request("192.0.2.2", "a", rfc2866.AcctStatusType_Value_Start), epoch)
+
+	events := add(t, tracker, request("192.0.2.1", "", rfc2866.AcctStatusType_Value_AccountingOn), epoch.Add(time.Hour))
+	if len(events) != 2 {
+		t.Fatalf("got events %v", events)
+	}
+	for _, e := range events {
+		if e.Reason != accounting.ReasonAccountingOn || e.Session.NAS != "192.0.2.1" || !e.Session.Stop.Equal(epoch.Add(time.Hour)) {
+			t.Fatalf("got event %v %+v", e.Reason, e.Session)
+		}
+	}
+
+	events = add(t, tracker, request("192.0.2.2", "", rfc2866.AcctStatusType_Value_AccountingOff), epoch.Add(time.Hour))
+	if len(events) != 1 || events[0].Reason != accounting.ReasonAccountingOff {
+		t.Fatalf("got events %v", events)
+	}
+	if sessions := tracker.Sessions(); len(sessions) != 0 {
+		t.Fatalf("got sessions %v", sessions)
+	}
+}
+
+func TestTracker_Expire(t *testing.T) {
+	tracker := &accounting.Tracker{
+		InterimInterval: 10 * time.Minute,
+	}
+
+	add(t, tracker, request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Start), epoch)
+	withInterval := request("192.0.2.1", "b", rfc2866.AcctStatusType_Value_Start)
+	rfc2869.AcctInterimInterval_Set(withInterval, 3600)
+	add(t, tracker, withInterval, epoch)
+
+	if events := tracker.Expire(epoch.Add(20 * time.Minute)); len(events) != 0 {
+		t.Fatalf("got events %v", events)
+	}
+	events := tracker.Expire(epoch.Add(21 * time.Minute))
+	if len(events) != 1 || events[0].Reason != accounting.ReasonStale || events[0].Session.SessionID != "a" ||
+		!events[0].Session.Stop.Equal(epoch) {
+		t.Fatalf("got events %v", events)
+	}
+	if events := tracker.Expire(epoch.Add(2*time.Hour + time.Second)); len(events) != 1 || events[0].Session.SessionID != "b" {
+		t.Fatalf("got events %v", events)
+	}
+}
+
+func TestTracker_staleStop(t *testing.T) {
+	tracker := &accounting.Tracker{
+		InterimInterval: 10 * time.Minute,
+	}
+	add(t, tracker, request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Start), epoch)
+	stale := tracker.Expire(epoch.Add(time.Hour))
+	if len(stale) != 1 || stale[0].Reason != accounting.ReasonStale {
+		t.Fatalf("got events %v", stale)
+	}
+
+	// the Stop of a stale session gives its final counters
+	stop := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Stop)
+	rfc2866.AcctSessionTime_Set(stop, 7200)
+	rfc2866.AcctInputOctets_Set(stop, 100)
+	rfc2866.AcctTerminateCause_Set(stop, rfc2866.AcctTerminateCause_Value_LostCarrier)
+	events := add(t, tracker, stop, epoch.Add(2*time.Hour))
+	if len(events) != 1 || events[0].Reason != accounting.ReasonStop {
+		t.Fatalf("got events %v", events)
+	}
+	s := events[0].Session
+	if !s.Start.Equal(epoch) || !s.Stop.Equal(epoch.Add(2*time.Hour)) || s.Updates != 2 || s.SessionTime != 2*time.Hour ||
+		s.InputOctets != 100 || s.TerminateCause != rfc2866.AcctTerminateCause_Value_LostCarrier {
+		t.Fatalf("got session %+v", s)
+	}
+	if !stale[0].Session.Stop.Equal(epoch) || stale[0].Session.Updates != 1 {
+		t.Fatalf("stale event modified: %+v", stale[0].Session)
+	}
+
+	// retransmissions of the Stop are dropped
+	if events := add(t, tracker, stop, epoch.Add(2*time.Hour)); len(events) != 0 {
+		t.Fatalf("got events %v", events)
+	}
+}
+
+func TestTracker_Add_invalid(t *testing.T) {
+	tracker := &accounting.Tracker{}
+
+	noNAS := radius.New(radius.CodeAccountingRequest, nil)
+	rfc2866.AcctStatusType_Set(noNAS, rfc2866.AcctStatusType_Value_Start)
+	tests := []struct {
+		Packet *radius.Packet
+		Err    error
+	}{
+		{radius.New(radius.CodeAccountingRequest, nil), accounting.ErrNoStatusType},
+		{noNAS, accounting.ErrNoNAS},
+		{request("192.0.2.1", "", rfc2866.AcctStatusType_Value_Start), accounting.ErrNoSessionID},
+	}
+	for i, tt := range tests {
+		if _, err := tracker.Add(tt.Packet, epoch); err != tt.Err {
+			t.Errorf("#%d: got error %v; expecting %v", i, err, tt.Err)
+		}
+	}
+	if _, err := tracker.Add(radius.New(radius.CodeAccessRequest, nil), epoch); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Failed requests are ignored
+	if events := add(t, tracker, request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Failed), epoch); len(events) != 0 {
+		t.Fatalf("got events %v", events)
+	}
+	if sessions := tracker.Sessions(); len(sessions) != 0 {
+		t.Fatalf("got sessions %v", sessions)
+	}
+}
+
+func TestTracker_closed(t *testing.T) {
+	tracker := &accounting.Tracker{
+		Retention: time.Hour,
+	}
+	start := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Start)
+	add(t, tracker, start, epoch)
+	interim := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_InterimUpdate)
+	rfc2866.AcctDelayTime_Set(interim, 30)
+	stop := request("192.0.2.1", "a", rfc2866.AcctStatusType_Value_Stop)
+	if events := add(t, tracker, stop, epoch.Add(time.Minute)); len(events) != 1 {
+		t.Fatalf("got events %v", events)
+	}
+
+	// retransmitted and late requests do not reopen the session
+	rfc2866.AcctDelayTime_Set(start, 120)
+	for _, p := range []*radius.Packet{stop, interim, start} {
+		if events := add(t, tracker, p, epoch.Add(2*time.Minute)); len(events) != 0 {
+			t.Fatalf("got events %v", events)
+		}
+		if sessions := tracker.Sessions(); len(sessions) != 0 {
+			t.Fatalf("got sessions %v", sessions)
+		}
+	}
+
+	// a Start sent after the session was closed reuses its identifier
+	rfc2866.AcctDelayTime_Set(start, 0)
+	add(t, tracker, start, epoch.Add(3*time.Minute))
+	if sessions := tracker.Sessions(); len(sessions) != 1 || !sessions[0].Start.Equal(epoch.Add(3*time.Minute)) {
+		t.Fatalf("got sessions %v", sessions)
+	}
+	if events := add(t, tracker, stop, epoch.Add(4*time.Minute)); len(events) != 1 {
+		t.Fatalf("got events %v", events)
+	}
+
+	// closed sessions are forgotten after their retention
+	tracker.Expire(epoch.Add(time.Hour + 4*time.Minute + time.Second))
+	if events := add(t, tracker, stop, epoch.Add(2*time.Hour)); len(events) != 1 {
+		t.Fatalf("got events %v", events)
+	}
+}
@@ -0,0 +1,292 @@
+// Package challenge implements multi-round Access-Challenge conversations,
+// such as one-time password prompts following a first factor.
+//
+// Handler correlates each Access-Request carrying a State attribute with the
+// conversation started by a previous Access-Challenge, and makes it available
+// to the wrapped handler through the request's context:
+//
+//	handler := &challenge.Handler{
+//		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+//			c := challenge.FromContext(r.Context())
+//			if c == nil {
+//				// first round: verify the password, then prompt for the token
+//				response := r.Response(radius.CodeAccessChallenge)
+//				rfc2865.ReplyMessage_SetString(response, "Enter token code")
+//				challenge.Challenge(w, r, response, rfc2865.UserName_GetString(r.Packet))
+//				return
+//			}
+//			// c.Data holds the user name of the first round
+//		}),
+//	}
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/internal/statestore"
+	"layeh.com/radius/rfc2865"
+)
+
+// Conversation is the state of a multi-round exchange, carried between
+// rounds by the State attribute.
+type Conversation struct {
+	// State is the value of the State attribute of the last Access-Challenge.
+	State []byte
+	// Round is the number of Access-Challenges sent in the conversation.
+	Round int
+	// Data is the application data passed to the last call to Challenge.
+	Data interface{}
+}
+
+// Handler is a radius.Handler tracking Access-Challenge conversations.
+//
+// An Access-Request carrying a State attribute unknown to the store, such as
+// the State of an expired conversation, is answered with an Access-Reject
+// without calling the wrapped handler. Each State is only accepted once:
+// retransmissions of the request continuing a conversation are answered with
+// the response to that request until TTL has passed, and other requests
+// carrying the same State are rejected.
+type Handler struct {
+	// Handler serves the requests.
+	Handler radius.Handler
+
+	// Store holds conversations between rounds. If nil, conversations are
+	// kept in memory.
+	Store Store
+
+	// TTL is how long a conversation waits for the next Access-Request.
+	// Defaults to 60 seconds.
+	TTL time.Duration
+
+	// MaxRounds limits the number of Access-Challenges sent in a
+	// conversation. Defaults to 3.
+	MaxRounds int
+
+	// ErrorLog specifies an optional logger for errors around packet
+	// processing. If nil, logging is done via the log package's standard
+	// logger.
+	ErrorLog *log.Logger
+
+	initOnce sync.Once
+	// replies holds the responses to the requests that continued
+	// conversations, keyed by their State
+	replies statestore.Memory
+	mu      sync.Mutex
+}
+
+// Errors returned by Challenge.
+var (
+	ErrNoHandler     = errors.New("challenge: request not served by a challenge.Handler")
+	ErrTooManyRounds = errors.New("challenge: too many rounds")
+)
+
+type contextKey struct{}
+
+// round is the context value of a request served by Handler.
+type round struct {
+	handler      *Handler
+	conversation *Conversation
+}
+
+// ServeRADIUS implements radius.Handler.
+func (h *Handler) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	h.initOnce.Do(func() {
+		if h.Store == nil {
+			h.Store = NewMemoryStore()
+		}
+	})
+
+	var conversation *Conversation
+	if r.Code == radius.CodeAccessRequest {
+		if state, err := rfc2865.State_Lookup(r.Packet); err == nil {
+			var rp *reply
+			var replayed bool
+			conversation, rp, replayed = h.claim(string(state), r)
+			if replayed {
+				h.replay(w, r, rp)
+				return
+			}
+			if conversation == nil {
+				h.logf("challenge: unknown State from %v", r.RemoteAddr)
+				if err := w.Write(r.Response(radius.CodeAccessReject)); err != nil {
+					h.logf("challenge: %v", err)
+				}
+				return
+			}
+			w = &replyWriter{ResponseWriter: w, handler: h, reply: rp}
+			defer func() {
+				h.mu.Lock()
+				defer h.mu.Unlock()
+				if rp.response == nil {
+					// let the NAS retry the round
+					h.Store.Put(string(state), conversation, rp.expires)
+					h.replies.Delete(string(state))
+				}
+			}()
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), contextKey{}, &round{
+		handler:      h,
+		conversation: conversation,
+	})
+	h.Handler.ServeRADIUS(w, r.WithContext(ctx))
+}
+
+// claim takes the conversation stored under state for r. Each State value is
+// only accepted once: if the conversation has already been continued, the
+// reply recorded for the request continuing it is returned with replayed
+// set, so that retransmissions of that request are answered with the same
+// response. The lookup and the claim are done under h.mu, so concurrent
+// requests carrying the same State cannot both continue the conversation.
+func (h *Handler) claim(state string, r *radius.Request) (c *Conversation, rp *reply, replayed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rp, ok := h.replies.Get(state).(*reply); ok {
+		return nil, rp, true
+	}
+	c = h.Store.Get(state)
+	if c == nil {
+		return nil, nil, false
+	}
+	rp = &reply{
+		identifier:    r.Identifier,
+		authenticator: r.Authenticator,
+		expires:       time.Now().Add(h.ttl()),
+	}
+	h.replies.Put(state, rp, rp.expires)
+	h.Store.Delete(state)
+	return c, rp, false
+}
+
+// replay answers r, which carries the State of a conversation that has
+// already been continued, with the response to the request continuing it.
+// Requests other than retransmissions of that request are rejected, and
+// retransmissions received before it is answered are discarded.
+func (h *Handler) replay(w radius.ResponseWriter, r *radius.Request, rp *reply) {
+	h.mu.Lock()
+	response := rp.response
+	h.mu.Unlock()
+	if r.Identifier != rp.identifier || r.Authenticator != rp.authenticator {
+		h.logf("challenge: reused State from %v", r.RemoteAddr)
+		response = r.Response(radius.CodeAccessReject)
+	} else if response == nil {
+		return
+	}
+	if err := w.Write(response); err != nil {
+		h.logf("challenge: %v", err)
+	}
+}
+
+// reply is the response to the request that continued a conversation.
+type reply struct {
+	identifier    byte
+	authenticator [16]byte
+	expires       time.Time
+	// response is nil until the request is answered; guarded by
+	// Handler.mu
+	response *radius.Packet
+}
+
+// replyWriter records the response written to a request continuing a
+// conversation.
+type replyWriter struct {
+	radius.ResponseWriter
+	handler *Handler
+	reply   *reply
+}
+
+func (w *replyWriter) Write(p *radius.Packet) error {
+	if err := w.ResponseWriter.Write(p); err != nil {
+		return err
+	}
+	w.handler.mu.Lock()
+	w.reply.response = p
+	w.handler.mu.Unlock()
+	return nil
+}
+
+// FromContext returns the conversation continued by the request whose
+// context is ctx, or nil if the request starts a new conversation.
+func FromContext(ctx context.Context) *Conversation {
+	if r, ok := ctx.Value(contextKey{}).(*round); ok {
+		return r.conversation
+	}
+	return nil
+}
+
+// Challenge continues the conversation of r, or starts a new one, and writes
+// response, which must be an Access-Challenge, to w. The response's State is
+// set to a new random value, and the conversation is stored with the given
+// data until the next request arrives.
+//
+// ErrTooManyRounds is returned, and nothing is written, if the conversation
+// has reached the handler's maximum number of rounds. r's context error is
+// returned if it is done.
+func Challenge(w radius.ResponseWriter, r *radius.Request, response *radius.Packet, data interface{}) error {
+	ctx := r.Context()
+	rd, ok := ctx.Value(contextKey{}).(*round)
+	if !ok {
+		return ErrNoHandler
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if response.Code != radius.CodeAccessChallenge {
+		return errors.New("challenge: response is not an Access-Challenge")
+	}
+
+	c := &Conversation{
+		Round: 1,
+		Data:  data,
+	}
+	if rd.conversation != nil {
+		c.Round = rd.conversation.Round + 1
+	}
+	if c.Round > rd.handler.maxRounds() {
+		return ErrTooManyRounds
+	}
+
+	c.State = make([]byte, 16)
+	if _, err := rand.Read(c.State); err != nil {
+		return err
+	}
+	if err := rfc2865.State_Set(response, c.State); err != nil {
+		return err
+	}
+	rd.handler.Store.Put(string(c.State), c, time.Now().Add(rd.handler.ttl()))
+	if err := w.Write(response); err != nil {
+		rd.handler.Store.Delete(string(c.State))
+		return err
+	}
+	rd.conversation = c
+	return nil
+}
+
+func (h *Handler) ttl() time.Duration {
+	if h.TTL > 0 {
+		return h.TTL
+	}
+	return 60 * time.Second
+}
+
+func (h *Handler) maxRounds() int {
+	if h.MaxRounds > 0 {
+		return h.MaxRounds
+	}
+	return 3
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if l := h.ErrorLog; l != nil {
+		l.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,202 @@
+package challenge
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+type responseRecorder struct {
+	packets []*radius.Packet
+}
+
+func (r *responseRecorder) Write(p *radius.Packet) error {
+	r.packets = append(r.packets, p)
+	return nil
+}
+
+func newRequest(state []byte, password string) *radius.Request {
+	packet := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	rfc2865.UserName_SetString(packet, "tim")
+	rfc2865.UserPassword_SetString(packet, password)
+	if state != nil {
+		rfc2865.State_Set(packet, state)
+	}
+	return &radius.Request{
+		LocalAddr:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1812},
+		RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000},
+		Packet:     packet,
+	}
+}
+
+// otpHandler asks for a token code after the password, allowing one retry.
+func otpHandler(t *testing.T) *Handler {
+	return &Handler{
+		MaxRounds: 2,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			password := rfc2865.UserPassword_GetString(r.Packet)
+			c := FromContext(r.Context())
+			if c == nil {
+				if password != "password" {
+					w.Write(r.Response(radius.CodeAccessReject))
+					return
+				}
+			} else {
+				if c.Data != "tim" {
+					t.Errorf("unexpected conversation data %v", c.Data)
+				}
+				if password == "123456" {
+					w.Write(r.Response(radius.CodeAccessAccept))
+					return
+				}
+			}
+
+			response := r.Response(radius.CodeAccessChallenge)
+			rfc2865.ReplyMessage_SetString(response, "Enter token code")
+			if err := Challenge(w, r, response, rfc2865.UserName_GetString(r.Packet)); err != nil {
+				w.Write(r.Response(radius.CodeAccessReject))
+			}
+		}),
+	}
+}
+
+func serve(h *Handler, r *radius.Request) *radius.Packet {
+	w := &responseRecorder{}
+	h.ServeRADIUS(w, r)
+	if len(w.packets) != 1 {
+		return nil
+	}
+	return w.packets[0]
+}
+
+func TestHandler(t *testing.T) {
+	h := otpHandler(t)
+
+	response := serve(h, newRequest(nil, "password"))
+	if response.Code != radius.CodeAccessChallenge {
+		t.Fatalf("got %v", response.Code)
+	}
+	state := rfc2865.State_Get(response)
+	if len(state) != 16 {
+		t.Fatalf("got State %x", state)
+	}
+
+	request := newRequest(state, "000000")
+	response = serve(h, request)
+	if response.Code != radius.CodeAccessChallenge {
+		t.Fatalf("got %v", response.Code)
+	}
+	next := rfc2865.State_Get(response)
+	if string(next) == string(state) {
+		t.Fatal("State reused across rounds")
+	}
+
+	// a retransmission is answered with the same response
+	if retransmitted := serve(h, request); retransmitted != response {
+		t.Fatal("retransmitted request was not answered with the previous response")
+	}
+
+	// State values are only accepted once
+	if response := serve(h, newRequest(state, "123456")); response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v", response.Code)
+	}
+
+	if response := serve(h, newRequest(next, "123456")); response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %v", response.Code)
+	}
+}
+
+// slowStore widens the window between looking up a conversation and
+// claiming it.
+type slowStore struct {
+	Store
+}
+
+func (s slowStore) Get(state string) *Conversation {
+	c := s.Store.Get(state)
+	time.Sleep(time.Millisecond)
+	return c
+}
+
+func TestHandler_concurrent(t *testing.T) {
+	h := otpHandler(t)
+	h.Store = slowStore{NewMemoryStore()}
+	state := rfc2865.State_Get(serve(h, newRequest(nil, "password")))
+
+	// only one of the requests carrying the same State continues the
+	// conversation
+	const n = 16
+	responses := make([]*radius.Packet, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		request := newRequest(state, "123456")
+		request.Identifier = byte(i)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = serve(h, request)
+		}(i)
+	}
+	wg.Wait()
+
+	var accepted int
+	for _, response := range responses {
+		if response != nil && response.Code == radius.CodeAccessAccept {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("got %d Access-Accepts; expecting 1", accepted)
+	}
+}
+
+func TestHandler_maxRounds(t *testing.T) {
+	h := otpHandler(t)
+
+	response := serve(h, newRequest(nil, "password"))
+	for i := 0; i < 2; i++ {
+		response = serve(h, newRequest(rfc2865.State_Get(response), "000000"))
+	}
+	if response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v", response.Code)
+	}
+}
+
+func TestHandler_expired(t *testing.T) {
+	h := otpHandler(t)
+	h.TTL = time.Nanosecond
+
+	response := serve(h, newRequest(nil, "password"))
+	time.Sleep(time.Millisecond)
+	response = serve(h, newRequest(rfc2865.State_Get(response), "123456"))
+	if response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v", response.Code)
+	}
+}
+
+func TestChallenge_errors(t *testing.T) {
+	r := newRequest(nil, "password")
+	response := r.Response(radius.CodeAccessChallenge)
+	if err := Challenge(&responseRecorder{}, r, response, nil); err != ErrNoHandler {
+		t.Fatalf("got %v", err)
+	}
+
+	h := &Handler{
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			ctx, cancel := context.WithCancel(r.Context())
+			cancel()
+			r = r.WithContext(ctx)
+			if err := Challenge(w, r, r.Response(radius.CodeAccessChallenge), nil); err != context.Canceled {
+				t.Errorf("got %v", err)
+			}
+		}),
+	}
+	if response := serve(h, r); response != nil {
+		t.Fatalf("unexpected response %v", response.Code)
+	}
+}
@@ -0,0 +1,41 @@
+package challenge
+
+import (
+	"time"
+
+	"layeh.com/radius/internal/statestore"
+)
+
+// Store holds in-progress conversations between Access-Challenge rounds,
+// keyed by the value of the State attribute.
+type Store interface {
+	// Get returns the conversation stored under state, or nil if no
+	// unexpired conversation exists.
+	Get(state string) *Conversation
+	// Put stores c under state until the given expiry time.
+	Put(state string, c *Conversation, expires time.Time)
+	// Delete removes the conversation stored under state.
+	Delete(state string)
+}
+
+// NewMemoryStore returns a Store that keeps conversations in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+type memoryStore struct {
+	conversations statestore.Memory
+}
+
+func (m *memoryStore) Get(state string) *Conversation {
+	c, _ := m.conversations.Get(state).(*Conversation)
+	return c
+}
+
+func (m *memoryStore) Put(state string, c *Conversation, expires time.Time) {
+	m.conversations.Put(state, c, expires)
+}
+
+func (m *memoryStore) Delete(state string) {
+	m.conversations.Delete(state)
+}
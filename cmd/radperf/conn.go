@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+)
+
+// result is the outcome of a request.
+type result struct {
+	Code    radius.Code
+	RTT     time.Duration
+	Timeout bool
+}
+
+// slot is an identifier of a conn that is not used by an outstanding
+// request.
+type slot struct {
+	conn *conn
+	id   byte
+}
+
+// conn is a UDP socket over which up to 256 requests, one per identifier,
+// are outstanding at the same time.
+type conn struct {
+	udp     *net.UDPConn
+	secret  []byte
+	timeout time.Duration
+	slots   chan<- slot
+	stats   *stats
+
+	mu      sync.Mutex
+	pending [256]*pendingRequest
+	invalid int
+	closed  bool
+}
+
+type pendingRequest struct {
+	wire  []byte
+	sent  time.Time
+	timer *time.Timer
+}
+
+// dial returns a conn to addr, and adds its identifiers to slots.
+// Results are added to s.
+func dial(addr *net.UDPAddr, secret []byte, timeout time.Duration, slots chan<- slot, s *stats) (*conn, error) {
+	udp, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		udp:     udp,
+		secret:  secret,
+		timeout: timeout,
+		slots:   slots,
+		stats:   s,
+	}
+	for id := 0; id < 256; id++ {
+		slots <- slot{conn: c, id: byte(id)}
+	}
+	go c.read()
+	return c, nil
+}
+
+// send sends p with the given identifier, which must have been taken from
+// slots.
+func (c *conn) send(id byte, p *radius.Packet) error {
+	p.Identifier = id
+	wire, err := p.Encode()
+	if err != nil {
+		c.slots <- slot{conn: c, id: id}
+		return err
+	}
+
+	req := &pendingRequest{
+		wire: wire,
+		sent: time.Now(),
+	}
+	c.mu.Lock()
+	c.pending[id] = req
+	req.timer = time.AfterFunc(c.timeout, func() {
+		c.expire(id, req)
+	})
+	c.mu.Unlock()
+
+	if _, err := c.udp.Write(wire); err != nil {
+		c.mu.Lock()
+		cancelled := c.pending[id] == req
+		if cancelled {
+			c.pending[id] = nil
+			req.timer.Stop()
+		}
+		c.mu.Unlock()
+		if cancelled {
+			c.slots <- slot{conn: c, id: id}
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *conn) expire(id byte, req *pendingRequest) {
+	c.mu.Lock()
+	if c.pending[id] != req {
+		c.mu.Unlock()
+		return
+	}
+	c.pending[id] = nil
+	c.mu.Unlock()
+
+	c.stats.add(result{
+		Timeout: true,
+	})
+	c.slots <- slot{conn: c, id: id}
+}
+
+func (c *conn) read() {
+	var b [radius.MaxPacketLength]byte
+	for {
+		n, err := c.udp.Read(b[:])
+		if err != nil {
+			// errors such as ECONNREFUSED only affect a single read
+			c.mu.Lock()
+			closed := c.closed
+			c.mu.Unlock()
+			if closed {
+				return
+			}
+			continue
+		}
+		received := time.Now()
+		if n < 20 {
+			continue
+		}
+		id := b[1]
+
+		c.mu.Lock()
+		req := c.pending[id]
+		if req == nil || !radius.IsAuthenticResponse(b[:n], req.wire, c.secret) {
+			c.invalid++
+			c.mu.Unlock()
+			continue
+		}
+		c.pending[id] = nil
+		c.mu.Unlock()
+
+		req.timer.Stop()
+		c.stats.add(result{
+			Code: radius.Code(b[0]),
+			RTT:  received.Sub(req.sent),
+		})
+		c.slots <- slot{conn: c, id: id}
+	}
+}
+
+// Invalid returns the number of responses that did not match an outstanding
+// request.
+func (c *conn) Invalid() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.invalid
+}
+
+func (c *conn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.udp.Close()
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
+)
+
+const usage = `
+Sends Access-Request or Accounting-Request packets to a server at a target
+rate, and reports the throughput, latency and responses of the server.
+
+The packets are built from the templates in the file given by -f, in the
+FreeRADIUS text format used by radclient, separated by blank lines. The
+templates are used in turn, and may contain the following variables, which
+are expanded for each request:
+
+	${n}            sequence number of the request
+	${n:M}          sequence number of the request modulo M
+	${rand:A-B}     random integer between A and B, inclusive, as in -5-10
+	${ip:PREFIX}    random address in the IPv4 or IPv6 prefix
+	${hex:N}        N random bytes, hex encoded
+	${time}         current Unix time
+
+For example:
+
+	User-Name = "user${n:10000}", User-Password = "password"
+	Framed-IP-Address = ${ip:10.0.0.0/8}
+
+Requests are multiplexed over -sockets UDP sockets, with up to 256 requests
+outstanding on each of them.
+`
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <radius-server>[:port] <auth|acct> <secret>\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(os.Stderr, usage)
+	}
+	file := flag.String("f", "", "file to read packet templates from (required)")
+	rate := flag.Float64("rate", 1000, "target number of requests per second (0 for unlimited)")
+	duration := flag.Duration("duration", 10*time.Second, "duration of the test")
+	total := flag.Uint64("n", 0, "number of requests to send (overrides -duration)")
+	timeout := flag.Duration("timeout", 5*time.Second, "time after which a request is counted as timed out")
+	sockets := flag.Int("sockets", 4, "number of UDP sockets to send requests over")
+	interval := flag.Duration("interval", time.Second, "interval of progress reports (0 to disable)")
+	var dictionaryFiles stringsFlag
+	flag.Var(&dictionaryFiles, "d", "FreeRADIUS dictionary file to load (may be repeated)")
+	flag.Parse()
+
+	if flag.NArg() != 3 || *file == "" || *sockets < 1 || *rate < 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var code radius.Code
+	var defaultPort string
+	switch flag.Arg(1) {
+	case "auth":
+		code, defaultPort = radius.CodeAccessRequest, "1812"
+	case "acct":
+		code, defaultPort = radius.CodeAccountingRequest, "1813"
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	server := flag.Arg(0)
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, defaultPort)
+	}
+	addr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		fatal(err)
+	}
+	secret := []byte(flag.Arg(2))
+
+	dict := debug.IncludedDictionary
+	parser := &dictionary.Parser{
+		Opener: &dictionary.FileSystemOpener{},
+	}
+	for _, file := range dictionaryFiles {
+		next, err := parser.ParseFile(file)
+		if err == nil {
+			dict, err = dictionary.Merge(dict, next)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	}
+	codec := &radius.Codec{
+		Dictionary: dict,
+	}
+
+	text, err := ioutil.ReadFile(*file)
+	if err != nil {
+		fatal(err)
+	}
+	var templates []*template
+	for _, packet := range splitPackets(string(text)) {
+		t, err := parseTemplate(packet)
+		if err != nil {
+			fatal(err)
+		}
+		templates = append(templates, t)
+	}
+	if len(templates) == 0 {
+		fatal(fmt.Errorf("%s: no packet templates", *file))
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// check the templates before starting
+	for _, t := range templates {
+		if err := codec.ParseText(radius.New(code, secret), t.expand(0, rnd)); err != nil {
+			fatal(err)
+		}
+	}
+
+	s := newStats()
+	slots := make(chan slot, 256**sockets)
+	var conns []*conn
+	for i := 0; i < *sockets; i++ {
+		c, err := dial(addr, secret, *timeout, slots, s)
+		if err != nil {
+			fatal(err)
+		}
+		conns = append(conns, c)
+	}
+
+	s.start = time.Now()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	if *interval > 0 {
+		go func() {
+			for range time.Tick(*interval) {
+				s.progress(os.Stderr)
+			}
+		}()
+	}
+
+	// requests are paced by sending, every millisecond, the number of
+	// requests that are due at the target rate
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	var n uint64
+send:
+	for {
+		elapsed := time.Since(s.start)
+		if *total > 0 && n >= *total || *total == 0 && elapsed >= *duration {
+			break
+		}
+		due := uint64(math.MaxUint64)
+		if *rate > 0 {
+			due = uint64(*rate * elapsed.Seconds())
+		}
+		if *total > 0 && due > *total {
+			due = *total
+		}
+		for n < due && (*total > 0 || time.Since(s.start) < *duration) {
+			select {
+			case <-interrupt:
+				break send
+			default:
+			}
+			p := radius.New(code, secret)
+			if err := codec.ParseText(p, templates[n%uint64(len(templates))].expand(n, rnd)); err != nil {
+				s.error(err)
+				n++
+				continue
+			}
+			var sl slot
+			select {
+			case sl = <-slots:
+			case <-interrupt:
+				break send
+			}
+			if err := sl.conn.send(sl.id, p); err != nil {
+				s.error(err)
+			} else {
+				atomic.AddUint64(&s.sent, 1)
+			}
+			n++
+		}
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			break send
+		}
+	}
+	s.stop = time.Now()
+
+	// wait for the outstanding requests to be answered or time out
+wait:
+	for len(slots) < cap(slots) && time.Since(s.stop) < *timeout+time.Second {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-interrupt:
+			break wait
+		}
+	}
+	for _, c := range conns {
+		c.Close()
+		s.addInvalid(c.Invalid())
+	}
+
+	s.report(os.Stdout)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// stats collects the results of requests.
+type stats struct {
+	sent uint64 // accessed atomically
+
+	start, stop time.Time
+
+	mu        sync.Mutex
+	received  uint64
+	timeouts  uint64
+	errors    uint64
+	lastError error
+	invalid   int
+	codes     map[radius.Code]uint64
+	latencies []time.Duration
+
+	lastSent, lastReceived uint64
+	lastProgress           time.Time
+}
+
+func newStats() *stats {
+	return &stats{
+		codes: make(map[radius.Code]uint64),
+	}
+}
+
+func (s *stats) add(r result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Timeout {
+		s.timeouts++
+		return
+	}
+	s.received++
+	s.codes[r.Code]++
+	s.latencies = append(s.latencies, r.RTT)
+}
+
+func (s *stats) addInvalid(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invalid += n
+}
+
+func (s *stats) error(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors++
+	s.lastError = err
+}
+
+// progress writes the rates since the last progress report.
+func (s *stats) progress(w *os.File) {
+	now := time.Now()
+	sent := atomic.LoadUint64(&s.sent)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.lastProgress
+	if last.IsZero() {
+		last = s.start
+	}
+	seconds := now.Sub(last).Seconds()
+	fmt.Fprintf(w, "%8.1fs  sent %8.1f/s  received %8.1f/s  timeouts %d  errors %d\n",
+		now.Sub(s.start).Seconds(),
+		float64(sent-s.lastSent)/seconds,
+		float64(s.received-s.lastReceived)/seconds,
+		s.timeouts, s.errors)
+	s.lastSent, s.lastReceived, s.lastProgress = sent, s.received, now
+}
+
+func (s *stats) report(w *os.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent := atomic.LoadUint64(&s.sent)
+	seconds := s.stop.Sub(s.start).Seconds()
+	fmt.Fprintf(w, "Duration:    %v\n", s.stop.Sub(s.start).Round(time.Millisecond))
+	fmt.Fprintf(w, "Sent:        %d (%.1f/s)\n", sent, float64(sent)/seconds)
+	fmt.Fprintf(w, "Received:    %d (%.1f/s)\n", s.received, float64(s.received)/seconds)
+	fmt.Fprintf(w, "Timeouts:    %d", s.timeouts)
+	if sent > 0 {
+		fmt.Fprintf(w, " (%.2f%%)", 100*float64(s.timeouts)/float64(sent))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Invalid:     %d\n", s.invalid)
+	fmt.Fprintf(w, "Errors:      %d", s.errors)
+	if s.lastError != nil {
+		fmt.Fprintf(w, " (last: %v)", s.lastError)
+	}
+	fmt.Fprintln(w)
+
+	if len(s.latencies) > 0 {
+		sort.Slice(s.latencies, func(i, j int) bool {
+			return s.latencies[i] < s.latencies[j]
+		})
+		fmt.Fprintf(w, "Latency:     min %v  p50 %v  p99 %v  p99.9 %v  max %v\n",
+			s.latencies[0],
+			percentile(s.latencies, 0.5),
+			percentile(s.latencies, 0.99),
+			percentile(s.latencies, 0.999),
+			s.latencies[len(s.latencies)-1])
+	}
+
+	var codes []radius.Code
+	for code := range s.codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+	if len(codes) > 0 {
+		fmt.Fprintln(w, "Responses:")
+	}
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %-22s %d\n", code.String(), s.codes[code])
+	}
+}
+
+// percentile returns the q-th quantile of the sorted latencies.
+func percentile(latencies []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return latencies[i]
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// template is the text of a packet, in the FreeRADIUS text format, that
+// contains variables which are expanded for each request.
+type template struct {
+	parts []templatePart
+}
+
+// templatePart is a literal string, or a variable if expand is non-nil.
+type templatePart struct {
+	literal string
+	expand  func(n uint64, rnd *rand.Rand) string
+}
+
+// parseTemplate parses the variables of the form ${name} and ${name:arg} in
+// text.
+func parseTemplate(text string) (*template, error) {
+	t := &template{}
+	for {
+		i := strings.Index(text, "${")
+		if i < 0 {
+			t.parts = append(t.parts, templatePart{literal: text})
+			return t, nil
+		}
+		j := strings.IndexByte(text[i:], '}')
+		if j < 0 {
+			return nil, errors.New("unterminated variable " + strconv.Quote(text[i:]))
+		}
+		expand, err := parseVariable(text[i+2 : i+j])
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, templatePart{literal: text[:i]}, templatePart{expand: expand})
+		text = text[i+j+1:]
+	}
+}
+
+// expand returns the text of the template for the n-th request.
+func (t *template) expand(n uint64, rnd *rand.Rand) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.expand != nil {
+			b.WriteString(part.expand(n, rnd))
+		} else {
+			b.WriteString(part.literal)
+		}
+	}
+	return b.String()
+}
+
+func parseVariable(v string) (func(n uint64, rnd *rand.Rand) string, error) {
+	name, arg := v, ""
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		name, arg = v[:i], v[i+1:]
+	}
+
+	switch name {
+	case "n":
+		if arg == "" {
+			return func(n uint64, rnd *rand.Rand) string {
+				return strconv.FormatUint(n, 10)
+			}, nil
+		}
+		mod, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil || mod == 0 {
+			return nil, errors.New("invalid modulus in ${" + v + "}")
+		}
+		return func(n uint64, rnd *rand.Rand) string {
+			return strconv.FormatUint(n%mod, 10)
+		}, nil
+
+	case "rand":
+		// the bounds may be negative, so the separator is looked for after
+		// the sign of MIN
+		i := -1
+		if arg != "" {
+			if i = strings.IndexByte(arg[1:], '-'); i >= 0 {
+				i++
+			}
+		}
+		if i < 0 {
+			return nil, errors.New("expecting ${rand:MIN-MAX}, got ${" + v + "}")
+		}
+		min, err1 := strconv.ParseInt(arg[:i], 10, 64)
+		max, err2 := strconv.ParseInt(arg[i+1:], 10, 64)
+		// the size of the range overflows if it exceeds math.MaxInt64
+		size := max - min + 1
+		if err1 != nil || err2 != nil || max < min || size <= 0 {
+			return nil, errors.New("invalid range in ${" + v + "}")
+		}
+		return func(n uint64, rnd *rand.Rand) string {
+			return strconv.FormatInt(min+rnd.Int63n(size), 10)
+		}, nil
+
+	case "ip":
+		_, prefix, err := net.ParseCIDR(arg)
+		if err != nil {
+			return nil, errors.New("invalid prefix in ${" + v + "}")
+		}
+		return func(n uint64, rnd *rand.Rand) string {
+			ip := make(net.IP, len(prefix.IP))
+			for i := 0; i < len(ip); i += 8 {
+				var random [8]byte
+				binary.BigEndian.PutUint64(random[:], rnd.Uint64())
+				copy(ip[i:], random[:])
+			}
+			for i := range ip {
+				ip[i] = prefix.IP[i] | ip[i]&^prefix.Mask[i]
+			}
+			return ip.String()
+		}, nil
+
+	case "hex":
+		size, err := strconv.Atoi(arg)
+		if err != nil || size <= 0 {
+			return nil, errors.New("invalid size in ${" + v + "}")
+		}
+		return func(n uint64, rnd *rand.Rand) string {
+			b := make([]byte, size)
+			rnd.Read(b)
+			return hex.EncodeToString(b)
+		}, nil
+
+	case "time":
+		return func(n uint64, rnd *rand.Rand) string {
+			return strconv.FormatInt(time.Now().Unix(), 10)
+		}, nil
+	}
+	return nil, errors.New("unknown variable ${" + v + "}")
+}
+
+// splitPackets splits text into the attribute lists of packets, which are
+// separated by blank lines.
+func splitPackets(text string) []string {
+	var packets []string
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if strings.TrimSpace(b.String()) != "" {
+				packets = append(packets, b.String())
+			}
+			b.Reset()
+			continue
+		}
+		b.WriteString(line)
+	}
+	if strings.TrimSpace(b.String()) != "" {
+		packets = append(packets, b.String())
+	}
+	return packets
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		Text     string
+		N        uint64
+		Expected string
+		Error    string
+	}{
+		{Text: `User-Name = "bob"`, Expected: `User-Name = "bob"`},
+		{Text: `User-Name = "user${n}"`, N: 42, Expected: `User-Name = "user42"`},
+		{Text: `User-Name = "user${n:10}", NAS-Port = ${n:3}`, N: 42, Expected: `User-Name = "user2", NAS-Port = 0`},
+		{Text: `${n}${n}`, N: 7, Expected: `77`},
+		{Text: `User-Name = "${n`, Error: "unterminated variable"},
+		{Text: `User-Name = "${user}"`, Error: "unknown variable ${user}"},
+		{Text: `NAS-Port = ${n:0}`, Error: "invalid modulus in ${n:0}"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.Text)
+		if tt.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.Error) {
+				t.Errorf("%q: got error %v; expecting %q", tt.Text, err, tt.Error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.Text, err)
+			continue
+		}
+		if text := tmpl.expand(tt.N, rand.New(rand.NewSource(1))); text != tt.Expected {
+			t.Errorf("%q: got %q; expecting %q", tt.Text, text, tt.Expected)
+		}
+	}
+}
+
+func TestParseVariable(t *testing.T) {
+	tests := []struct {
+		Variable string
+		// Check reports whether an expansion of the variable is valid
+		Check func(s string) bool
+		Error string
+	}{
+		{
+			Variable: "n:5",
+			Check: func(s string) bool {
+				n, err := strconv.ParseUint(s, 10, 64)
+				return err == nil && n < 5
+			},
+		},
+		{
+			Variable: "rand:-2-3",
+			Check: func(s string) bool {
+				n, err := strconv.ParseInt(s, 10, 64)
+				return err == nil && n >= -2 && n <= 3
+			},
+		},
+		{
+			Variable: "rand:-10--5",
+			Check: func(s string) bool {
+				n, err := strconv.ParseInt(s, 10, 64)
+				return err == nil && n >= -10 && n <= -5
+			},
+		},
+		{Variable: "rand:-5--10", Error: "invalid range"},
+		{Variable: "rand:-9223372036854775808-9223372036854775807", Error: "invalid range"},
+		{Variable: "rand:-5", Error: "expecting ${rand:MIN-MAX}"},
+		{
+			Variable: "rand:10-12",
+			Check: func(s string) bool {
+				n, err := strconv.ParseInt(s, 10, 64)
+				return err == nil && n >= 10 && n <= 12
+			},
+		},
+		{
+			Variable: "rand:5-5",
+			Check:    func(s string) bool { return s == "5" },
+		},
+		{Variable: "rand:12-10", Error: "invalid range"},
+		{Variable: "rand:10", Error: "expecting ${rand:MIN-MAX}"},
+		{
+			Variable: "ip:192.0.2.128/25",
+			Check: func(s string) bool {
+				ip := net.ParseIP(s).To4()
+				return ip != nil && ip[0] == 192 && ip[1] == 0 && ip[2] == 2 && ip[3] >= 128
+			},
+		},
+		{
+			// the host bits of the prefix are masked
+			Variable: "ip:10.1.2.3/16",
+			Check: func(s string) bool {
+				ip := net.ParseIP(s).To4()
+				return ip != nil && ip[0] == 10 && ip[1] == 1
+			},
+		},
+		{
+			Variable: "ip:2001:db8::/120",
+			Check: func(s string) bool {
+				ip := net.ParseIP(s)
+				_, prefix, _ := net.ParseCIDR("2001:db8::/120")
+				return ip != nil && ip.To4() == nil && prefix.Contains(ip)
+			},
+		},
+		{Variable: "ip:192.0.2.1", Error: "invalid prefix"},
+		{
+			Variable: "hex:4",
+			Check: func(s string) bool {
+				_, err := strconv.ParseUint(s, 16, 32)
+				return err == nil && len(s) == 8
+			},
+		},
+		{Variable: "hex:0", Error: "invalid size"},
+		{
+			Variable: "time",
+			Check: func(s string) bool {
+				_, err := strconv.ParseInt(s, 10, 64)
+				return err == nil
+			},
+		},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		expand, err := parseVariable(tt.Variable)
+		if tt.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.Error) {
+				t.Errorf("%q: got error %v; expecting %q", tt.Variable, err, tt.Error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.Variable, err)
+			continue
+		}
+		for n := uint64(0); n < 100; n++ {
+			if s := expand(n, rnd); !tt.Check(s) {
+				t.Errorf("%q: got invalid expansion %q", tt.Variable, s)
+				break
+			}
+		}
+	}
+}
+
+func TestSplitPackets(t *testing.T) {
+	tests := []struct {
+		Text     string
+		Expected []string
+	}{
+		{"", nil},
+		{"\n \n", nil},
+		{"User-Name = \"a\"", []string{"User-Name = \"a\""}},
+		{
+			"User-Name = \"a\"\nNAS-Port = 1\n\n\nUser-Name = \"b\"\n",
+			[]string{"User-Name = \"a\"\nNAS-Port = 1\n", "User-Name = \"b\"\n"},
+		},
+		{
+			"\nUser-Name = \"a\"\n \t\nUser-Name = \"b\"",
+			[]string{"User-Name = \"a\"\n", "User-Name = \"b\""},
+		},
+	}
+	for _, tt := range tests {
+		if packets := splitPackets(tt.Text); !reflect.DeepEqual(packets, tt.Expected) {
+			t.Errorf("%q: got %q; expecting %q", tt.Text, packets, tt.Expected)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc1994"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc3079"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+// backend decides the responses to Access-Requests.
+type backend interface {
+	// authenticate returns the response to r, or an error if r cannot be
+	// answered.
+	authenticate(r *radius.Request, c *client) (*radius.Packet, error)
+}
+
+// verifyPassword reports whether the PAP, CHAP or MS-CHAPv2 credentials of
+// the Access-Request req match password. For MS-CHAPv2, the
+// MS-CHAP2-Success and MPPE key attributes are added to resp.
+func verifyPassword(req, resp *radius.Packet, password []byte) bool {
+	if userPassword, err := rfc2865.UserPassword_Lookup(req); err == nil {
+		return subtle.ConstantTimeCompare(userPassword, password) == 1
+	}
+	if ok, err := rfc1994.VerifyPassword(req, password); err == nil {
+		return ok
+	}
+
+	challenge, err1 := microsoft.MSCHAPChallenge_Lookup(req)
+	response, err2 := microsoft.MSCHAP2Response_Lookup(req)
+	if err1 != nil || err2 != nil || len(challenge) != 16 || len(response) != 50 {
+		return false
+	}
+	// rfc2548, 2.3.2
+	ident := response[0]
+	peerChallenge := response[2:18]
+	peerResponse := response[26:50]
+	username := rfc2865.UserName_Get(req)
+	ntResponse, err := rfc2759.GenerateNTResponse(challenge, peerChallenge, username, password)
+	if err != nil || !bytes.Equal(ntResponse, peerResponse) {
+		return false
+	}
+
+	authenticatorResponse, err := rfc2759.GenerateAuthenticatorResponse(challenge, peerChallenge, ntResponse, username, password)
+	if err != nil {
+		return false
+	}
+	recvKey, err := rfc3079.MakeKey(ntResponse, password, false)
+	if err != nil {
+		return false
+	}
+	sendKey, err := rfc3079.MakeKey(ntResponse, password, true)
+	if err != nil {
+		return false
+	}
+	success := make([]byte, 1+len(authenticatorResponse))
+	success[0] = ident
+	copy(success[1:], authenticatorResponse)
+	microsoft.MSCHAP2Success_Add(resp, success)
+	microsoft.MSMPPERecvKey_Add(resp, recvKey)
+	microsoft.MSMPPESendKey_Add(resp, sendKey)
+	return true
+}
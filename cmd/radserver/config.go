@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// config is the configuration file of the server, in JSON.
+type config struct {
+	// Listen are the addresses on which the server listens.
+	Listen []listenConfig `json:"listen"`
+	// Clients are the clients from which requests are accepted.
+	Clients []clientConfig `json:"clients"`
+	// Dictionaries are FreeRADIUS dictionary files that are loaded in
+	// addition to the included dictionary.
+	Dictionaries []string `json:"dictionaries"`
+
+	Auth       authConfig       `json:"auth"`
+	Accounting accountingConfig `json:"accounting"`
+	Log        logConfig        `json:"log"`
+
+	// ShutdownTimeout is the time for which running requests are waited
+	// for when the server is stopped.
+	ShutdownTimeout duration `json:"shutdown_timeout"`
+}
+
+type listenConfig struct {
+	Addr string `json:"addr"`
+	// Type is auth or acct. Status-Server requests are answered on both.
+	Type string `json:"type"`
+}
+
+type clientConfig struct {
+	Name string `json:"name"`
+	// Addr is the address or prefix of the client.
+	Addr   string `json:"addr"`
+	Secret string `json:"secret"`
+}
+
+type authConfig struct {
+	// Users is the path of a users file.
+	Users string `json:"users"`
+	// Exec is the program, and its arguments, that is executed for each
+	// Access-Request.
+	Exec    []string `json:"exec"`
+	Timeout duration `json:"timeout"`
+}
+
+type accountingConfig struct {
+	// Detail is the path of the detail file, which may contain the
+	// placeholders of detail.Writer.
+	Detail string `json:"detail"`
+	// MaxSize is the size in bytes after which the detail file is rotated.
+	MaxSize int64 `json:"max_size"`
+	// Sync specifies whether the detail file is synced to stable storage
+	// before requests are acknowledged.
+	Sync bool `json:"sync"`
+}
+
+type logConfig struct {
+	// Packets enables logging of the packets that are received and sent.
+	Packets bool `json:"packets"`
+	// Format is the format of logged packets: text, freeradius, json or
+	// logfmt.
+	Format string `json:"format"`
+}
+
+// duration is a time.Duration in the format accepted by
+// time.ParseDuration.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadConfig reads the configuration file name. Relative paths in the file
+// are relative to its directory.
+func loadConfig(name string) (*config, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	dir := filepath.Dir(name)
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	for i := range c.Dictionaries {
+		resolve(&c.Dictionaries[i])
+	}
+	resolve(&c.Auth.Users)
+	resolve(&c.Accounting.Detail)
+	if len(c.Auth.Exec) > 0 && strings.ContainsRune(c.Auth.Exec[0], filepath.Separator) {
+		resolve(&c.Auth.Exec[0])
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return c, nil
+}
+
+func (c *config) validate() error {
+	if len(c.Listen) == 0 {
+		return errors.New("no listen addresses")
+	}
+	for _, l := range c.Listen {
+		if l.Type != "auth" && l.Type != "acct" {
+			return fmt.Errorf("listener %q: unknown type %q", l.Addr, l.Type)
+		}
+	}
+	if len(c.Clients) == 0 {
+		return errors.New("no clients")
+	}
+	if c.Auth.Users != "" && len(c.Auth.Exec) > 0 {
+		return errors.New("auth: only one of users and exec may be set")
+	}
+	switch c.Log.Format {
+	case "", "text", "freeradius", "json", "logfmt":
+	default:
+		return fmt.Errorf("log: unknown format %q", c.Log.Format)
+	}
+	return nil
+}
+
+// client is a client from which requests are accepted.
+type client struct {
+	name   string
+	prefix *net.IPNet
+	secret []byte
+}
+
+// clientTable is a radius.SecretSource of the secrets of the configured
+// clients.
+type clientTable struct {
+	clients []*client
+}
+
+func newClientTable(configs []clientConfig) (*clientTable, error) {
+	t := &clientTable{}
+	for _, c := range configs {
+		prefix := c.Addr
+		if !strings.Contains(prefix, "/") {
+			if strings.Contains(prefix, ":") {
+				prefix += "/128"
+			} else {
+				prefix += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("client %q: invalid address %q", c.Name, c.Addr)
+		}
+		if c.Secret == "" {
+			return nil, fmt.Errorf("client %q: empty secret", c.Name)
+		}
+		name := c.Name
+		if name == "" {
+			name = c.Addr
+		}
+		t.clients = append(t.clients, &client{
+			name:   name,
+			prefix: ipNet,
+			secret: []byte(c.Secret),
+		})
+	}
+	return t, nil
+}
+
+// lookup returns the client of the longest prefix containing the address
+// of addr, or nil if there is none.
+func (t *clientTable) lookup(addr net.Addr) *client {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	var found *client
+	longest := -1
+	for _, c := range t.clients {
+		if ones, _ := c.prefix.Mask.Size(); ones > longest && c.prefix.Contains(udpAddr.IP) {
+			found, longest = c, ones
+		}
+	}
+	return found
+}
+
+func (t *clientTable) RADIUSSecret(ctx context.Context, remoteAddr net.Addr) ([]byte, error) {
+	c := t.lookup(remoteAddr)
+	if c == nil {
+		return nil, fmt.Errorf("request from unknown client %v", remoteAddr)
+	}
+	return c.secret, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// execBackend executes a program for each Access-Request.
+//
+// The request is written to the standard input of the program in the JSON
+// format of radius.Codec.EncodeJSON, and the environment variables
+// RADIUS_USERNAME, RADIUS_PASSWORD and RADIUS_CLIENT are set to the user
+// name, password and client name of the request.
+//
+// If the program exits successfully, an Access-Accept is sent, otherwise an
+// Access-Reject. If the standard output of the program is a JSON object, it
+// is decoded as the response by radius.Codec.DecodeJSON, and its code
+// overrides the exit status if present. Other output is sent as a
+// Reply-Message.
+type execBackend struct {
+	codec   *radius.Codec
+	command []string
+	timeout time.Duration
+}
+
+func (b *execBackend) authenticate(r *radius.Request, c *client) (*radius.Packet, error) {
+	input, err := b.codec.EncodeJSON(r.Packet, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := r.Context()
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, b.command[0], b.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"RADIUS_USERNAME="+rfc2865.UserName_GetString(r.Packet),
+		"RADIUS_PASSWORD="+rfc2865.UserPassword_GetString(r.Packet),
+		"RADIUS_CLIENT="+c.name)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+
+	output, err := cmd.Output()
+	code := radius.CodeAccessAccept
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok || ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: %v", b.command[0], err)
+		}
+		code = radius.CodeAccessReject
+	}
+
+	output = bytes.TrimSpace(output)
+	if len(output) > 0 && output[0] == '{' {
+		return b.decodeResponse(r, code, output)
+	}
+	resp := r.Response(code)
+	if len(output) > 0 {
+		if err := rfc2865.ReplyMessage_Set(resp, output); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// decodeResponse returns the response of the JSON output of the program.
+func (b *execBackend) decodeResponse(r *radius.Request, code radius.Code, output []byte) (*radius.Packet, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(output, &fields); err != nil {
+		return nil, fmt.Errorf("%s: invalid output: %v", b.command[0], err)
+	}
+	if _, ok := fields["code"]; !ok {
+		fields["code"], _ = json.Marshal(code.String())
+	}
+	// the response is encoded, and its attributes are encrypted, using the
+	// authenticator of the request
+	fields["id"], _ = json.Marshal(r.Identifier)
+	fields["authenticator"], _ = json.Marshal(hex.EncodeToString(r.Authenticator[:]))
+	output, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.codec.DecodeJSON(output, r.Secret, r.Authenticator[:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid output: %v", b.command[0], err)
+	}
+	switch resp.Code {
+	case radius.CodeAccessAccept, radius.CodeAccessReject, radius.CodeAccessChallenge:
+	default:
+		return nil, fmt.Errorf("%s: invalid response code %v", b.command[0], resp.Code)
+	}
+	return resp, nil
+}
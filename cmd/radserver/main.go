@@ -1,90 +1,333 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
-	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/detail"
+	"layeh.com/radius/dictionary"
 	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
 )
 
-var secret = flag.String("secret", "", "shared RADIUS secret between clients and server")
-var command string
-var arguments []string
+const usage = `
+radserver answers RADIUS requests as given by the JSON configuration file of
+-config:
 
-func handler(w radius.ResponseWriter, r *radius.Request) {
-	username, err1 := rfc2865.UserName_LookupString(r.Packet)
-	password, err2 := rfc2865.UserPassword_LookupString(r.Packet)
-	if err1 != nil || err2 != nil {
-		w.Write(r.Response(radius.CodeAccessReject))
-		return
+	{
+	  "listen": [
+	    {"addr": ":1812", "type": "auth"},
+	    {"addr": ":1813", "type": "acct"}
+	  ],
+	  "clients": [
+	    {"name": "nas1", "addr": "10.0.0.1", "secret": "secret1"},
+	    {"name": "lan", "addr": "192.168.0.0/16", "secret": "secret2"}
+	  ],
+	  "dictionaries": ["dictionary.local"],
+	  "auth": {"users": "users"},
+	  "accounting": {"detail": "radacct/%{Packet-Src-IP-Address}/detail-%Y%m%d"},
+	  "log": {"packets": true, "format": "text"},
+	  "shutdown_timeout": "10s"
 	}
-	log.Printf("%s requesting access (%s #%d)\n", username, r.RemoteAddr, r.Identifier)
-
-	cmd := exec.Command(command, arguments...)
 
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "RADIUS_USERNAME="+username, "RADIUS_PASSWORD="+password)
+Access-Requests are authenticated by either a FreeRADIUS users file, or by a
+program given as "auth": {"exec": ["program", "args..."], "timeout": "5s"}.
+The Cleartext-Password of users file entries is checked against PAP, CHAP or
+MS-CHAPv2 credentials, unless Auth-Type is Accept or Reject.
 
-	output, err := cmd.Output()
-	if err != nil {
-		log.Printf("handler error: %s\n", err)
-	}
-
-	var code radius.Code
-	if cmd.ProcessState != nil && cmd.ProcessState.Success() {
-		code = radius.CodeAccessAccept
-		log.Printf("%s accepted (%s #%d)\n", username, r.RemoteAddr, r.Identifier)
-	} else {
-		code = radius.CodeAccessReject
-		log.Printf("%s rejected (%s #%d)\n", username, r.RemoteAddr, r.Identifier)
-	}
-	resp := r.Response(code)
+The program is executed for each Access-Request, with the request written to
+its standard input in JSON. If the program exits successfully, an
+Access-Accept response is sent, otherwise, an Access-Reject is sent. If
+standard out is a JSON object, it is used as the response, and if it is other
+non-empty output, it is included as a Reply-Message attribute in the
+response. The environment variables RADIUS_USERNAME, RADIUS_PASSWORD and
+RADIUS_CLIENT are set which hold the username, password and client name,
+respectively.
 
-	if len(output) > 0 {
-		rfc2865.ReplyMessage_Set(r.Packet, output)
-	}
+Accounting-Requests are appended to the FreeRADIUS detail file, if any,
+before they are acknowledged. The file is rotated daily by the placeholders
+of its path, and when its size exceeds "max_size" bytes if set.
 
-	w.Write(resp)
-}
+Status-Server requests are answered on all listeners.
 
-const usage = `
-program is executed when an Access-Request RADIUS packet is received. If
-program exits sucessfully, an Access-Accept response is sent, otherwise, an
-Access-Reject is sent. If standard out is non-empty, it is included as an
-Reply-Message attribute in the response.
+If no configuration file is given, program is executed for Access-Requests
+received on :1812 from any client using the secret of -secret.
 
-The environment variables RADIUS_USERNAME and RADIUS_PASSWORD are set which hold
-the username and password, respectively.
+The server is stopped gracefully on SIGTERM or SIGINT.
 `
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [flags] <program> [program arguments...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s -config <file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] <program> [program arguments...]\n", os.Args[0])
 		flag.PrintDefaults()
-		fmt.Fprint(os.Stderr, usage)
+		io.WriteString(os.Stderr, usage)
 	}
+	configFile := flag.String("config", "", "configuration file")
+	secret := flag.String("secret", "", "shared RADIUS secret between clients and server, if no configuration file is given")
 	flag.Parse()
 
-	if *secret == "" || flag.NArg() < 1 {
+	var c *config
+	switch {
+	case *configFile != "" && flag.NArg() == 0:
+		var err error
+		if c, err = loadConfig(*configFile); err != nil {
+			log.Fatal(err)
+		}
+	case *configFile == "" && *secret != "" && flag.NArg() >= 1:
+		c = &config{
+			Listen: []listenConfig{
+				{Addr: ":1812", Type: "auth"},
+			},
+			Clients: []clientConfig{
+				{Name: "any", Addr: "0.0.0.0/0", Secret: *secret},
+				{Name: "any", Addr: "::/0", Secret: *secret},
+			},
+			Auth: authConfig{
+				Exec: flag.Args(),
+			},
+		}
+	default:
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	command = flag.Arg(0)
-	arguments = flag.Args()[1:]
+	s, err := newServer(c)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	log.Println("radserver starting")
 
-	server := radius.PacketServer{
-		Handler:      radius.HandlerFunc(handler),
-		SecretSource: radius.StaticSecretSource([]byte(*secret)),
+	var wg sync.WaitGroup
+	servers := make([]*radius.PacketServer, len(c.Listen))
+	for i, l := range c.Listen {
+		server := &radius.PacketServer{
+			Addr:         l.Addr,
+			Handler:      s.handler(l.Type),
+			SecretSource: s.clients,
+		}
+		servers[i] = server
+		wg.Add(1)
+		go func(l listenConfig) {
+			defer wg.Done()
+			if err := server.ListenAndServe(); err != nil && err != radius.ErrServerShutdown {
+				log.Fatalf("%s: %v", l.Addr, err)
+			}
+		}(l)
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+	log.Println("radserver stopping")
+
+	timeout := time.Duration(c.ShutdownTimeout)
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}
+	wg.Wait()
+	if s.detail != nil {
+		s.detail.Close()
+	}
+}
+
+// server handles the requests of all listeners.
+type server struct {
+	clients *clientTable
+	codec   *radius.Codec
+	backend backend
+	detail  *detail.Writer
+	// dump is the configuration of logged packets, or nil if packets are
+	// not logged.
+	dump *debug.Config
+}
+
+func newServer(c *config) (*server, error) {
+	clients, err := newClientTable(c.Clients)
+	if err != nil {
+		return nil, err
+	}
+
+	dict := debug.IncludedDictionary
+	parser := &dictionary.Parser{
+		Opener: &dictionary.FileSystemOpener{},
+	}
+	for _, file := range c.Dictionaries {
+		next, err := parser.ParseFile(file)
+		if err == nil {
+			dict, err = dictionary.Merge(dict, next)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	codec := &radius.Codec{
+		Dictionary: dict,
+	}
+
+	s := &server{
+		clients: clients,
+		codec:   codec,
+	}
+	switch {
+	case c.Auth.Users != "":
+		if s.backend, err = loadUsers(dict, c.Auth.Users); err != nil {
+			return nil, err
+		}
+	case len(c.Auth.Exec) > 0:
+		s.backend = &execBackend{
+			codec:   codec,
+			command: c.Auth.Exec,
+			timeout: time.Duration(c.Auth.Timeout),
+		}
+	}
+	if c.Accounting.Detail != "" {
+		s.detail = &detail.Writer{
+			Dictionary: dict,
+			Path:       c.Accounting.Detail,
+			MaxSize:    c.Accounting.MaxSize,
+			Sync:       c.Accounting.Sync,
+		}
+	}
+	if c.Log.Packets {
+		s.dump = &debug.Config{
+			Dictionary: dict,
+		}
+		switch c.Log.Format {
+		case "freeradius":
+			s.dump.Formatter = debug.FreeRADIUSFormatter
+		case "json":
+			s.dump.Formatter = debug.JSONFormatter
+		case "logfmt":
+			s.dump.Formatter = debug.LogfmtFormatter
+		}
+	}
+	return s, nil
+}
+
+// handler returns the handler of requests received on listeners of the
+// given type.
+func (s *server) handler(listenerType string) radius.Handler {
+	return radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+		c := s.clients.lookup(r.RemoteAddr)
+		if c == nil {
+			return
+		}
+		s.logPacket(r.Packet, r)
+
+		var resp *radius.Packet
+		var err error
+		switch {
+		case r.Code == radius.CodeStatusServer:
+			resp, err = s.status(r, listenerType)
+		case r.Code == radius.CodeAccessRequest && listenerType == "auth":
+			resp, err = s.access(r, c)
+		case r.Code == radius.CodeAccountingRequest && listenerType == "acct":
+			resp, err = s.accounting(r, c)
+		default:
+			err = fmt.Errorf("unexpected %v", r.Code)
+		}
+		if err != nil {
+			log.Printf("%s: %v (%s #%d)", c.name, err, r.RemoteAddr, r.Identifier)
+			return
+		}
+		if resp == nil {
+			return
+		}
+
+		if resp.Code == radius.CodeAccessAccept || resp.Code == radius.CodeAccessReject || resp.Code == radius.CodeAccessChallenge {
+			if err := rfc2869.MessageAuthenticator_Sign(resp); err != nil {
+				log.Printf("%s: %v (%s #%d)", c.name, err, r.RemoteAddr, r.Identifier)
+				return
+			}
+		}
+		s.logPacket(resp, r)
+		if err := w.Write(resp); err != nil {
+			log.Printf("%s: %v (%s #%d)", c.name, err, r.RemoteAddr, r.Identifier)
+		}
+	})
+}
+
+// status returns the response to a Status-Server request - rfc5997.
+func (s *server) status(r *radius.Request, listenerType string) (*radius.Packet, error) {
+	if err := rfc2869.MessageAuthenticator_Verify(r.Packet, nil); err != nil {
+		return nil, err
+	}
+	if listenerType == "acct" {
+		return r.Response(radius.CodeAccountingResponse), nil
+	}
+	return r.Response(radius.CodeAccessAccept), nil
+}
+
+func (s *server) access(r *radius.Request, c *client) (*radius.Packet, error) {
+	if err := rfc2869.MessageAuthenticator_Verify(r.Packet, nil); err != nil && err != radius.ErrNoAttribute {
+		return nil, err
+	}
+	username := rfc2865.UserName_GetString(r.Packet)
+	log.Printf("%s requesting access (%s #%d)\n", username, r.RemoteAddr, r.Identifier)
+	if s.backend == nil {
+		return nil, fmt.Errorf("no authentication backend")
+	}
+
+	resp, err := s.backend.authenticate(r, c)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Code {
+	case radius.CodeAccessAccept:
+		log.Printf("%s accepted (%s #%d)\n", username, r.RemoteAddr, r.Identifier)
+	case radius.CodeAccessReject:
+		log.Printf("%s rejected (%s #%d)\n", username, r.RemoteAddr, r.Identifier)
+	}
+	return resp, nil
+}
+
+func (s *server) accounting(r *radius.Request, c *client) (*radius.Packet, error) {
+	if s.detail != nil {
+		record := &detail.Record{
+			Time:   time.Now(),
+			Packet: r.Packet,
+		}
+		record.Src, _ = r.RemoteAddr.(*net.UDPAddr)
+		record.Dst, _ = r.LocalAddr.(*net.UDPAddr)
+		if err := s.detail.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	return r.Response(radius.CodeAccountingResponse), nil
+}
+
+// logPacket logs p, which is received or sent as part of the request r, if
+// packets are logged.
+func (s *server) logPacket(p *radius.Packet, r *radius.Request) {
+	if s.dump == nil {
+		return
+	}
+	var b bytes.Buffer
+	if p == r.Packet {
+		debug.DumpRequest(&b, s.dump, r)
+	} else {
+		debug.DumpResponse(&b, s.dump, r, p)
 	}
+	log.Print(b.String())
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/users"
+)
+
+// usersBackend authenticates users of a FreeRADIUS users file.
+type usersBackend struct {
+	users *users.Users
+}
+
+func loadUsers(dict *dictionary.Dictionary, name string) (*usersBackend, error) {
+	parser := &users.Parser{
+		Dictionary: dict,
+		Opener: &dictionary.FileSystemOpener{
+			Root: filepath.Dir(name),
+		},
+	}
+	u, err := parser.ParseFile(filepath.Base(name))
+	if err != nil {
+		return nil, err
+	}
+	return &usersBackend{
+		users: u,
+	}, nil
+}
+
+func (b *usersBackend) authenticate(r *radius.Request, c *client) (*radius.Packet, error) {
+	result, err := b.users.Authorize(r.Packet)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Entries) == 0 {
+		return r.Response(radius.CodeAccessReject), nil
+	}
+	resp := r.Response(radius.CodeAccessAccept)
+	switch result.AuthType() {
+	case "Accept":
+	case "Reject":
+		resp = r.Response(radius.CodeAccessReject)
+	default:
+		// the reply items are only sent to users with valid credentials
+		password, ok := result.Password()
+		if !ok || !verifyPassword(r.Packet, resp, []byte(password)) {
+			return r.Response(radius.CodeAccessReject), nil
+		}
+	}
+	if err := result.Apply(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/sniff"
+)
+
+const usage = `
+Reads RADIUS packets from pcap or pcapng capture files, matches requests to
+their responses, and prints the exchanges followed by statistics of the
+response times and the requests that were not answered.
+
+If secrets are given by -secret or -secrets, the authenticators of requests
+and responses are verified. The file given by -secrets contains lines of a
+client address or prefix and its secret:
+
+	10.0.0.1        secret1
+	192.168.0.0/16  secret2
+
+With -format json, each exchange is printed as a JSON object on its own line.
+`
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <capture-file>...\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(os.Stderr, usage)
+	}
+	secret := flag.String("secret", "", "secret of all clients")
+	secretsFile := flag.String("secrets", "", "file of client addresses and their secrets")
+	portList := flag.String("ports", "1812,1813,1645,1646,3799", "comma separated UDP ports of RADIUS traffic")
+	format := flag.String("format", "text", "format of printed exchanges: text, freeradius, json, logfmt or none")
+	timeout := flag.Duration("timeout", sniff.DefaultTimeout, "time after which requests without a response are unanswered")
+	unanswered := flag.Bool("unanswered", false, "only print requests that were not answered")
+	var dictionaryFiles stringsFlag
+	flag.Var(&dictionaryFiles, "d", "FreeRADIUS dictionary file to load (may be repeated)")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ports := make(map[int]bool)
+	for _, s := range strings.Split(*portList, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || port < 1 || port > 65535 {
+			fatal(fmt.Errorf("invalid port %q", s))
+		}
+		ports[port] = true
+	}
+
+	secrets := &secretTable{}
+	if *secretsFile != "" {
+		if err := secrets.load(*secretsFile); err != nil {
+			fatal(err)
+		}
+	}
+	if *secret != "" {
+		secrets.fallback = []byte(*secret)
+	}
+
+	dict := debug.IncludedDictionary
+	parser := &dictionary.Parser{
+		Opener: &dictionary.FileSystemOpener{},
+	}
+	for _, file := range dictionaryFiles {
+		next, err := parser.ParseFile(file)
+		if err == nil {
+			dict, err = dictionary.Merge(dict, next)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	p := &printer{
+		w: bufio.NewWriter(os.Stdout),
+		config: &debug.Config{
+			Dictionary: dict,
+		},
+		unanswered: *unanswered,
+	}
+	defer p.w.Flush()
+	switch *format {
+	case "text":
+		p.config.Formatter = debug.TextFormatter
+	case "freeradius":
+		p.config.Formatter = debug.FreeRADIUSFormatter
+	case "json":
+		p.config.Formatter = debug.JSONFormatter
+		p.json = true
+	case "logfmt":
+		p.config.Formatter = debug.LogfmtFormatter
+	case "none":
+		p.none = true
+	default:
+		fatal(fmt.Errorf("unknown format %q", *format))
+	}
+
+	m := &sniff.Matcher{
+		SecretSource: secrets,
+		Timeout:      *timeout,
+	}
+	var stats sniff.Stats
+	var lost []*sniff.Exchange
+	invalid := 0
+	add := func(exchanges []*sniff.Exchange) {
+		for _, e := range exchanges {
+			stats.Add(e)
+			if e.Response == nil {
+				lost = append(lost, e)
+			}
+			p.print(e)
+		}
+	}
+	for _, name := range flag.Args() {
+		if err := readFile(name, ports, m, add, &invalid); err != nil {
+			p.w.Flush()
+			fatal(fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	add(m.Flush())
+
+	if !p.json {
+		printStats(p.w, &stats, invalid, lost)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// readFile adds the RADIUS packets of the capture file name to m, and calls
+// add with the completed exchanges.
+func readFile(name string, ports map[int]bool, m *sniff.Matcher, add func([]*sniff.Exchange), invalid *int) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := sniff.NewReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		d, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !ports[d.Src.Port] && !ports[d.Dst.Port] {
+			continue
+		}
+		exchanges, err := m.Add(d)
+		if err != nil {
+			*invalid++
+		}
+		add(exchanges)
+	}
+}
+
+// secretTable is a radius.SecretSource of the secrets of client prefixes.
+type secretTable struct {
+	prefixes []*net.IPNet
+	secrets  [][]byte
+	fallback []byte
+}
+
+// load reads the lines of client addresses or prefixes and their secrets of
+// the file name.
+func (t *secretTable) load(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected address and secret", name, line)
+		}
+		prefix := fields[0]
+		if !strings.Contains(prefix, "/") {
+			if strings.Contains(prefix, ":") {
+				prefix += "/128"
+			} else {
+				prefix += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid address %q", name, line, fields[0])
+		}
+		t.prefixes = append(t.prefixes, ipNet)
+		t.secrets = append(t.secrets, []byte(fields[1]))
+	}
+	return s.Err()
+}
+
+// RADIUSSecret returns the secret of the longest prefix containing the
+// address of the client.
+func (t *secretTable) RADIUSSecret(ctx context.Context, remoteAddr net.Addr) ([]byte, error) {
+	addr, ok := remoteAddr.(*net.UDPAddr)
+	if !ok {
+		return t.fallback, nil
+	}
+	secret := t.fallback
+	longest := -1
+	for i, prefix := range t.prefixes {
+		if ones, _ := prefix.Mask.Size(); ones > longest && prefix.Contains(addr.IP) {
+			secret, longest = t.secrets[i], ones
+		}
+	}
+	return secret, nil
+}
+
+// printer prints exchanges.
+type printer struct {
+	w          *bufio.Writer
+	config     *debug.Config
+	json       bool
+	none       bool
+	unanswered bool
+}
+
+func (p *printer) print(e *sniff.Exchange) {
+	if p.none || p.unanswered && e.Response != nil {
+		return
+	}
+	if p.json {
+		p.printJSON(e)
+		return
+	}
+
+	for _, m := range []*sniff.Message{e.Request, e.Response} {
+		if m == nil {
+			continue
+		}
+		fmt.Fprintf(p.w, "%s ", m.Time.UTC().Format("2006-01-02T15:04:05.000000Z"))
+		p.dump(p.w, e, m)
+	}
+	fmt.Fprintf(p.w, "--> %s\n\n", describe(e))
+}
+
+// describe returns a summary of the outcome of e.
+func describe(e *sniff.Exchange) string {
+	var parts []string
+	switch {
+	case e.Request == nil:
+		parts = append(parts, "response without request")
+	case e.Response == nil:
+		parts = append(parts, "unanswered")
+	default:
+		parts = append(parts, "rtt "+e.RTT().String())
+	}
+	if e.Retransmissions > 0 {
+		parts = append(parts, fmt.Sprintf("%d retransmissions", e.Retransmissions))
+	}
+	if e.Verified {
+		if e.Authentic {
+			parts = append(parts, "authentic")
+		} else {
+			parts = append(parts, "NOT AUTHENTIC")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type jsonExchange struct {
+	Time            time.Time       `json:"time"`
+	RTT             float64         `json:"rtt,omitempty"`
+	Retransmissions int             `json:"retransmissions,omitempty"`
+	Authentic       *bool           `json:"authentic,omitempty"`
+	Request         json.RawMessage `json:"request,omitempty"`
+	Response        json.RawMessage `json:"response,omitempty"`
+}
+
+func (p *printer) printJSON(e *sniff.Exchange) {
+	out := jsonExchange{
+		RTT:             e.RTT().Seconds(),
+		Retransmissions: e.Retransmissions,
+	}
+	if e.Verified {
+		out.Authentic = &e.Authentic
+	}
+	if e.Request != nil {
+		out.Time = e.Request.Time.UTC()
+		out.Request = p.packetJSON(e, e.Request)
+	} else {
+		out.Time = e.Response.Time.UTC()
+	}
+	if e.Response != nil {
+		out.Response = p.packetJSON(e, e.Response)
+	}
+	enc := json.NewEncoder(p.w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(&out)
+}
+
+func (p *printer) packetJSON(e *sniff.Exchange, m *sniff.Message) json.RawMessage {
+	var b bytes.Buffer
+	p.dump(&b, e, m)
+	return json.RawMessage(bytes.TrimSpace(b.Bytes()))
+}
+
+// dump writes m, the request or response of e. The encrypted attributes of
+// a response are decrypted using the authenticator of its request.
+func (p *printer) dump(w io.Writer, e *sniff.Exchange, m *sniff.Message) {
+	if m == e.Response && e.Request != nil {
+		debug.DumpResponse(w, p.config, &radius.Request{
+			Packet:     e.Request.Packet,
+			RemoteAddr: e.Request.Src,
+			LocalAddr:  e.Request.Dst,
+		}, m.Packet)
+		return
+	}
+	debug.DumpRequest(w, p.config, &radius.Request{
+		Packet:     m.Packet,
+		RemoteAddr: m.Src,
+		LocalAddr:  m.Dst,
+	})
+}
+
+func printStats(w io.Writer, s *sniff.Stats, invalid int, lost []*sniff.Exchange) {
+	fmt.Fprintf(w, "Requests:        %d\n", s.Requests)
+	fmt.Fprintf(w, "Responses:       %d\n", s.Responses)
+	fmt.Fprintf(w, "Retransmissions: %d\n", s.Retransmissions)
+	fmt.Fprintf(w, "Unanswered:      %d\n", s.Unanswered)
+	fmt.Fprintf(w, "Unmatched:       %d\n", s.Unmatched)
+	fmt.Fprintf(w, "Not authentic:   %d\n", s.NotAuthentic)
+	fmt.Fprintf(w, "Invalid:         %d\n", invalid)
+	if answered := s.Requests - s.Unanswered; answered > 0 {
+		fmt.Fprintf(w, "Response time:   min %v  p50 %v  p99 %v  p99.9 %v  max %v\n",
+			s.RTT(0), s.RTT(0.5), s.RTT(0.99), s.RTT(0.999), s.RTT(1))
+	}
+
+	var codes []radius.Code
+	for code := range s.Codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+	if len(codes) > 0 {
+		fmt.Fprintln(w, "Packets:")
+	}
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %-22s %d\n", code.String(), s.Codes[code])
+	}
+
+	if len(lost) > 0 {
+		fmt.Fprintln(w, "Unanswered requests:")
+	}
+	for _, e := range lost {
+		fmt.Fprintf(w, "  %s %s Id %d from %v to %v\n",
+			e.Request.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+			e.Request.Packet.Code, e.Request.Packet.Identifier, e.Request.Src, e.Request.Dst)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/rfc1994"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+// newRequest returns a copy of template with a new identifier and
+// authenticator, to be sent to the server. If template is an Access-Request
+// with a User-Password, it is replaced with the credentials of the given
+// authentication method, and a function that checks the server's response is
+// returned.
+func newRequest(codec *radius.Codec, template *radius.Packet, method string) (*radius.Packet, func(*radius.Packet) error, error) {
+	p := radius.New(template.Code, template.Secret)
+	if err := copyAttributes(codec, p, template); err != nil {
+		return nil, nil, err
+	}
+
+	var check func(*radius.Packet) error
+	if password, err := rfc2865.UserPassword_Lookup(template); err == nil && p.Code == radius.CodeAccessRequest {
+		switch method {
+		case "chap":
+			rfc2865.UserPassword_Del(p)
+			if err := rfc1994.SetPassword(p, password); err != nil {
+				return nil, nil, err
+			}
+		case "mschapv2":
+			rfc2865.UserPassword_Del(p)
+			if check, err = setMSCHAPv2(p, password); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// rfc5997, 3 and rfc3579, 3.2
+	if _, ok := p.Lookup(rfc2869.EAPMessage_Type); ok || p.Code == radius.CodeStatusServer {
+		if err := rfc2869.MessageAuthenticator_Sign(p); err != nil {
+			return nil, nil, err
+		}
+	}
+	return p, check, nil
+}
+
+// copyAttributes appends the attributes of template to p, in order.
+// Encrypted attributes are decrypted with the authenticator of template, and
+// encrypted again with the authenticator of p.
+func copyAttributes(codec *radius.Codec, p, template *radius.Packet) error {
+	for _, avp := range template.Attributes {
+		if !encrypted(codec.Dictionary, avp) {
+			p.Attributes = append(p.Attributes, &radius.AVP{
+				Type:      avp.Type,
+				Attribute: avp.Attribute,
+			})
+			continue
+		}
+		single := &radius.Packet{
+			Code:          template.Code,
+			Secret:        template.Secret,
+			Authenticator: template.Authenticator,
+			Attributes:    radius.Attributes{avp},
+		}
+		for _, attr := range codec.Decode(single) {
+			if err := codec.Add(p, attr.FullName(), attr.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encrypted reports whether avp is, or is a Vendor-Specific attribute that
+// may contain, an attribute with the encrypt flag in dict.
+func encrypted(dict *dictionary.Dictionary, avp *radius.AVP) bool {
+	if dict == nil {
+		return false
+	}
+	if avp.Type == 26 {
+		vendorID, _, err := radius.VendorSpecific(avp.Attribute)
+		if err != nil {
+			return false
+		}
+		vendor := dictionary.VendorByNumber(dict.Vendors, int(vendorID))
+		if vendor == nil {
+			return false
+		}
+		for _, attr := range vendor.Attributes {
+			if attr.FlagEncrypt.Valid && attr.FlagEncrypt.Int != 0 {
+				return true
+			}
+		}
+		return false
+	}
+	attr := dictionary.AttributeByOID(dict.Attributes, dictionary.OID{int(avp.Type)})
+	return attr != nil && attr.FlagEncrypt.Valid && attr.FlagEncrypt.Int != 0
+}
+
+// setMSCHAPv2 adds the MS-CHAP-Challenge and MS-CHAP2-Response attributes
+// for password to p, and returns a function that verifies the
+// MS-CHAP2-Success attribute of an Access-Accept - rfc2548, 2.3
+func setMSCHAPv2(p *radius.Packet, password []byte) (func(*radius.Packet) error, error) {
+	username := rfc2865.UserName_Get(p)
+
+	var challenge, peerChallenge [16]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(peerChallenge[:]); err != nil {
+		return nil, err
+	}
+	ntResponse, err := rfc2759.GenerateNTResponse(challenge[:], peerChallenge[:], username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 50)
+	response[0] = p.Identifier
+	copy(response[2:18], peerChallenge[:])
+	copy(response[26:50], ntResponse)
+	if err := microsoft.MSCHAPChallenge_Add(p, challenge[:]); err != nil {
+		return nil, err
+	}
+	if err := microsoft.MSCHAP2Response_Add(p, response); err != nil {
+		return nil, err
+	}
+
+	check := func(resp *radius.Packet) error {
+		if resp.Code != radius.CodeAccessAccept {
+			return nil
+		}
+		success, err := microsoft.MSCHAP2Success_Lookup(resp)
+		if err != nil {
+			return errors.New("Access-Accept is missing MS-CHAP2-Success")
+		}
+		expected, err := rfc2759.GenerateAuthenticatorResponse(challenge[:], peerChallenge[:], ntResponse, username, password)
+		if err != nil {
+			return err
+		}
+		if len(success) < 1 || string(success[1:]) != expected {
+			return errors.New("invalid MS-CHAP2-Success authenticator response")
+		}
+		return nil
+	}
+	return check, nil
+}
@@ -4,61 +4,315 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
 	"layeh.com/radius/rfc2865"
 )
 
 const usage = `
-Sends an Access-Request RADIUS packet to a server and prints the result.
+Sends RADIUS packets to a server and prints the results.
+
+command is one of auth, acct, status, coa, disconnect, or a packet code name
+or number. The attributes of the packets are read from the file given by -f,
+or from standard input, in the FreeRADIUS text format used by radclient:
+
+	User-Name = "bob", User-Password = "hello"
+	NAS-Port = 7
+
+Packets are separated by blank lines.
+
+If the first form is used, a single Access-Request with the given User-Name,
+User-Password and NAS-Port is sent.
+
+The exit status is 0 if all packets were answered positively, 2 if any of them
+were rejected or NAKed, and 1 on error.
 `
 
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+var commands = map[string]radius.Code{
+	"auth":       radius.CodeAccessRequest,
+	"acct":       radius.CodeAccountingRequest,
+	"status":     radius.CodeStatusServer,
+	"coa":        radius.CodeCoARequest,
+	"disconnect": radius.CodeDisconnectRequest,
+}
+
+func defaultPort(code radius.Code) string {
+	switch code {
+	case radius.CodeAccountingRequest:
+		return "1813"
+	case radius.CodeCoARequest, radius.CodeDisconnectRequest:
+		return "3799"
+	}
+	return "1812"
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: %s [flags] <user> <password> <radius-server>[:port] <nas-port-number> <secret>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] <radius-server>[:port] <command> <secret>\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprint(os.Stderr, usage)
 	}
-	timeout := flag.Duration("timeout", time.Second*10, "timeout for the request to finish")
+	timeout := flag.Duration("timeout", time.Second*10, "timeout for each packet to be answered, including retries")
+	retries := flag.Int("retries", 3, "number of times each packet is resent before it times out")
+	chap := flag.Bool("chap", false, "authenticate using CHAP-Password instead of User-Password (same as -auth chap)")
+	auth := flag.String("auth", "pap", "authentication method of Access-Requests with a User-Password: pap, chap or mschapv2")
+	file := flag.String("f", "", "file to read attributes from (default standard input)")
+	count := flag.Int("c", 1, "number of times each packet is sent")
+	parallel := flag.Int("p", 1, "number of packets sent in parallel")
+	verbose := flag.Bool("x", false, "print the packets that are sent and received")
+	format := flag.String("format", "text", "format of printed packets: text, freeradius, json or logfmt")
+	summary := flag.Bool("s", false, "print a summary of the results")
+	quiet := flag.Bool("q", false, "do not print the result of each packet")
+	var dictionaryFiles stringsFlag
+	flag.Var(&dictionaryFiles, "d", "FreeRADIUS dictionary file to load (may be repeated)")
 	flag.Parse()
-	if flag.NArg() != 5 {
+
+	if *chap {
+		*auth = "chap"
+	}
+	switch *auth {
+	case "pap", "chap", "mschapv2":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown authentication method %q\n", *auth)
+		os.Exit(1)
+	}
+	if *count < 1 || *parallel < 1 || *retries < 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dict := debug.IncludedDictionary
+	parser := &dictionary.Parser{
+		Opener: &dictionary.FileSystemOpener{},
+	}
+	for _, file := range dictionaryFiles {
+		next, err := parser.ParseFile(file)
+		if err == nil {
+			dict, err = dictionary.Merge(dict, next)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	codec := &radius.Codec{
+		Dictionary: dict,
+	}
+
+	var server string
+	var code radius.Code
+	var templates []*radius.Packet
+	switch flag.NArg() {
+	case 5:
+		server = flag.Arg(2)
+		code = radius.CodeAccessRequest
+		nasPort, err := strconv.Atoi(flag.Arg(3))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid NAS port number")
+			os.Exit(1)
+		}
+		packet := radius.New(code, []byte(flag.Arg(4)))
+		rfc2865.UserName_SetString(packet, flag.Arg(0))
+		rfc2865.UserPassword_SetString(packet, flag.Arg(1))
+		rfc2865.NASPort_Set(packet, rfc2865.NASPort(nasPort))
+		templates = append(templates, packet)
+	case 3:
+		server = flag.Arg(0)
+		var ok bool
+		if code, ok = commands[flag.Arg(1)]; !ok {
+			var err error
+			if code, err = radius.ParseCode(flag.Arg(1)); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		var text []byte
+		var err error
+		if *file == "" || *file == "-" {
+			text, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			text, err = ioutil.ReadFile(*file)
+		}
+		if err == nil {
+			templates, err = codec.ParseTextPackets(code, []byte(flag.Arg(2)), string(text))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(templates) == 0 {
+			templates = append(templates, radius.New(code, []byte(flag.Arg(2))))
+		}
+	default:
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	host, port, err := net.SplitHostPort(flag.Arg(2))
+	host, port, err := net.SplitHostPort(server)
 	if err != nil {
-		host = flag.Arg(2)
-		port = "1812"
+		host = server
+		port = defaultPort(code)
 	}
 	hostport := net.JoinHostPort(host, port)
 
-	packet := radius.New(radius.CodeAccessRequest, []byte(flag.Arg(4)))
-	rfc2865.UserName_SetString(packet, flag.Arg(0))
-	rfc2865.UserPassword_SetString(packet, flag.Arg(1))
-	nasPort, _ := strconv.Atoi(flag.Arg(3))
-	rfc2865.NASPort_Set(packet, rfc2865.NASPort(nasPort))
+	config := &debug.Config{
+		Dictionary: dict,
+	}
+	switch *format {
+	case "text":
+		config.Formatter = debug.TextFormatter
+	case "freeradius":
+		config.Formatter = debug.FreeRADIUSFormatter
+	case "json":
+		config.Formatter = debug.JSONFormatter
+	case "logfmt":
+		config.Formatter = debug.LogfmtFormatter
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(1)
+	}
+
+	client := &radius.Client{
+		Retry:           *timeout / time.Duration(*retries+1),
+		MaxPacketErrors: 10,
+	}
+	if *retries == 0 {
+		client.Retry = 0
+	}
+
+	var (
+		mu    sync.Mutex
+		stats results
+		wg    sync.WaitGroup
+	)
+	jobs := make(chan *radius.Packet)
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for template := range jobs {
+				start := time.Now()
+				req, check, err := newRequest(codec, template, *auth)
+				var resp *radius.Packet
+				if err == nil {
+					ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+					resp, err = client.Exchange(ctx, req, hostport)
+					cancel()
+				}
+				if err == nil && check != nil {
+					err = check(resp)
+				}
+				rtt := time.Since(start)
+
+				mu.Lock()
+				stats.add(resp, err, rtt)
+				if *verbose && req != nil {
+					debug.Dump(os.Stdout, config, req)
+					if resp != nil {
+						debug.DumpResponse(os.Stdout, config, &radius.Request{Packet: req}, resp)
+					}
+				}
+				if !*quiet {
+					printResult(resp, err, rtt)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < *count; i++ {
+		for _, template := range templates {
+			jobs <- template
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if *summary {
+		stats.print()
+	}
+	os.Exit(stats.exitStatus())
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
-	defer cancel()
-	received, err := radius.Exchange(ctx, packet, hostport)
+func printResult(resp *radius.Packet, err error, rtt time.Duration) {
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		return
 	}
-
-	status := received.Code.String()
-	if msg, err := rfc2865.ReplyMessage_LookupString(received); err == nil {
+	status := resp.Code.String()
+	if msg, err := rfc2865.ReplyMessage_LookupString(resp); err == nil {
 		status += " (" + msg + ")"
 	}
+	fmt.Printf("%s in %v\n", status, rtt.Round(time.Microsecond))
+}
 
-	fmt.Println(status)
+// results counts the outcomes of sent packets.
+type results struct {
+	Sent     int
+	Positive int
+	Negative int
+	Errors   int
+	Total    time.Duration
+}
+
+func (r *results) add(resp *radius.Packet, err error, rtt time.Duration) {
+	r.Sent++
+	switch {
+	case err != nil:
+		r.Errors++
+		return
+	case isNegative(resp.Code):
+		r.Negative++
+	default:
+		r.Positive++
+	}
+	r.Total += rtt
+}
+
+func (r *results) print() {
+	fmt.Printf("Sent: %d\n", r.Sent)
+	fmt.Printf("Accepted/ACKed: %d\n", r.Positive)
+	fmt.Printf("Rejected/NAKed: %d\n", r.Negative)
+	fmt.Printf("Lost/errors: %d\n", r.Errors)
+	if answered := r.Positive + r.Negative; answered > 0 {
+		fmt.Printf("Average response time: %v\n", (r.Total / time.Duration(answered)).Round(time.Microsecond))
+	}
+}
+
+func (r *results) exitStatus() int {
+	switch {
+	case r.Errors > 0:
+		return 1
+	case r.Negative > 0:
+		return 2
+	}
+	return 0
+}
 
-	if received.Code != radius.CodeAccessAccept {
-		os.Exit(2)
+func isNegative(code radius.Code) bool {
+	switch code {
+	case radius.CodeAccessReject, radius.CodeAccessChallenge, radius.CodeCoANAK, radius.CodeDisconnectNAK:
+		return true
 	}
+	return false
 }
@@ -1,7 +1,9 @@
 package radius
 
 import (
+	"errors"
 	"strconv"
+	"strings"
 )
 
 // Code defines the RADIUS packet type.
@@ -62,3 +64,40 @@ func (c Code) String() string {
 	}
 	return "Code(" + strconv.Itoa(int(c)) + ")"
 }
+
+var codes = []Code{
+	CodeAccessRequest,
+	CodeAccessAccept,
+	CodeAccessReject,
+	CodeAccountingRequest,
+	CodeAccountingResponse,
+	CodeAccessChallenge,
+	CodeStatusServer,
+	CodeStatusClient,
+	CodeDisconnectRequest,
+	CodeDisconnectACK,
+	CodeDisconnectNAK,
+	CodeCoARequest,
+	CodeCoAACK,
+	CodeCoANAK,
+	CodeReserved,
+}
+
+// ParseCode returns the code whose string representation is s, including the
+// Code(n) representation of unknown codes. Codes may also be given as decimal
+// numbers.
+func ParseCode(s string) (Code, error) {
+	for _, code := range codes {
+		if code.String() == s {
+			return code, nil
+		}
+	}
+	number := s
+	if strings.HasPrefix(s, "Code(") && strings.HasSuffix(s, ")") {
+		number = s[len("Code(") : len(s)-1]
+	}
+	if n, err := strconv.ParseUint(number, 10, 8); err == nil {
+		return Code(n), nil
+	}
+	return 0, errors.New("radius: unknown code " + strconv.Quote(s))
+}
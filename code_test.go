@@ -0,0 +1,39 @@
+package radius_test
+
+import (
+	"testing"
+
+	"layeh.com/radius"
+)
+
+func TestParseCode(t *testing.T) {
+	tests := []struct {
+		String string
+		Code   radius.Code
+	}{
+		{"Access-Request", radius.CodeAccessRequest},
+		{"CoA-NAK", radius.CodeCoANAK},
+		{"4", radius.CodeAccountingRequest},
+		{"Code(200)", radius.Code(200)},
+		{"200", radius.Code(200)},
+	}
+	for _, tt := range tests {
+		code, err := radius.ParseCode(tt.String)
+		if err != nil {
+			t.Errorf("%q: %v", tt.String, err)
+		} else if code != tt.Code {
+			t.Errorf("%q: got %v; expecting %v", tt.String, code, tt.Code)
+		}
+	}
+
+	// unknown codes round-trip through their string representation
+	if code, err := radius.ParseCode(radius.Code(250).String()); err != nil || code != 250 {
+		t.Errorf("got %v, %v", code, err)
+	}
+
+	for _, s := range []string{"", "Bogus", "256", "Code(256)", "Code(-1)", "Code(", "Code()"} {
+		if _, err := radius.ParseCode(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,1025 @@
+package radius
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"layeh.com/radius/dictionary"
+)
+
+// Codec converts between Go values and attributes, using a dictionary to
+// resolve attribute names and to determine how their values are encoded.
+//
+// Attributes are referred to either by their dictionary name (e.g.
+// "User-Name") or by OID: "1" for a standard attribute, and "26.311.11" for
+// attribute 11 of vendor 311. Attributes that are not in the dictionary can
+// only be referred to by OID, and their values are encoded based on the Go
+// type of the value.
+//
+// The encoding rules match those of the code generated by dictionarygen:
+// tagged attributes carry their tag in the first octet of the value, and
+// attributes with the encrypt=1 and encrypt=2 flags are encrypted with the
+// User-Password and Tunnel-Password methods.
+type Codec struct {
+	// Dictionary used to resolve attribute names and types. May be nil.
+	Dictionary *dictionary.Dictionary
+}
+
+// DefaultCodec is the codec used by Marshal and Unmarshal. Its Dictionary
+// must be set before attributes can be referred to by name.
+var DefaultCodec = &Codec{}
+
+// codecAttribute describes how the values of an attribute are encoded.
+type codecAttribute struct {
+	Name string
+	Type dictionary.AttributeType // zero if unknown
+
+	// Number is the attribute type; within the vendor's attribute space for
+	// vendor-specific attributes.
+	Number int
+
+	VendorID     uint32 // zero for standard attributes
+	TypeOctets   int
+	LengthOctets int
+
+	HasTag  bool
+	Encrypt int
+	Size    int // zero if the value length is not fixed
+
+	Values []*dictionary.Value
+}
+
+// attribute resolves the given attribute name or OID.
+func (c *Codec) attribute(name string) (*codecAttribute, error) {
+	if oid := parseCodecOID(name); oid != nil {
+		return c.attributeByOID(name, oid)
+	}
+	if d := c.Dictionary; d != nil {
+		if attr := dictionary.AttributeByName(d.Attributes, name); attr != nil && len(attr.OID) == 1 {
+			return newCodecAttribute(attr, nil, d.Values), nil
+		}
+		for _, vendor := range d.Vendors {
+			if attr := dictionary.AttributeByName(vendor.Attributes, name); attr != nil && len(attr.OID) == 1 {
+				return newCodecAttribute(attr, vendor, vendor.Values), nil
+			}
+		}
+	}
+	return nil, &UnknownAttributeError{
+		Name:       name,
+		Suggestion: c.suggestAttribute(name),
+	}
+}
+
+// suggestAttribute returns the name of a dictionary attribute that only
+// differs from name in case or punctuation.
+func (c *Codec) suggestAttribute(name string) string {
+	if c.Dictionary == nil {
+		return ""
+	}
+	normalize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '-' || r == '_' || r == ' ' {
+				return -1
+			}
+			return unicode.ToLower(r)
+		}, s)
+	}
+	key := normalize(name)
+	for _, attr := range c.Dictionary.Attributes {
+		if normalize(attr.Name) == key {
+			return attr.Name
+		}
+	}
+	for _, vendor := range c.Dictionary.Vendors {
+		for _, attr := range vendor.Attributes {
+			if normalize(attr.Name) == key {
+				return attr.Name
+			}
+		}
+	}
+	return ""
+}
+
+func (c *Codec) attributeByOID(name string, oid dictionary.OID) (*codecAttribute, error) {
+	var attrs []*dictionary.Attribute
+	var values []*dictionary.Value
+	var vendor *dictionary.Vendor
+	a := &codecAttribute{
+		Name:         name,
+		TypeOctets:   1,
+		LengthOctets: 1,
+	}
+
+	switch {
+	case len(oid) == 1 && oid[0] >= 1 && oid[0] <= 255:
+		a.Number = oid[0]
+		if c.Dictionary != nil {
+			attrs, values = c.Dictionary.Attributes, c.Dictionary.Values
+		}
+	case len(oid) == 3 && oid[0] == 26 && oid[1] >= 1 && oid[2] >= 0:
+		a.VendorID = uint32(oid[1])
+		a.Number = oid[2]
+		if c.Dictionary != nil {
+			vendor = dictionary.VendorByNumber(c.Dictionary.Vendors, oid[1])
+		}
+		if vendor != nil {
+			attrs, values = vendor.Attributes, vendor.Values
+			a.TypeOctets = vendor.GetTypeOctets()
+			a.LengthOctets = vendor.GetLengthOctets()
+		}
+		if a.Number >= 1<<uint(8*a.TypeOctets) {
+			return nil, &UnknownAttributeError{Name: name}
+		}
+	default:
+		return nil, &UnknownAttributeError{Name: name}
+	}
+
+	if attr := dictionary.AttributeByOID(attrs, dictionary.OID{a.Number}); attr != nil {
+		return newCodecAttribute(attr, vendor, values), nil
+	}
+	return a, nil
+}
+
+func newCodecAttribute(attr *dictionary.Attribute, vendor *dictionary.Vendor, values []*dictionary.Value) *codecAttribute {
+	a := &codecAttribute{
+		Name:         attr.Name,
+		Type:         attr.Type,
+		Number:       attr.OID[0],
+		TypeOctets:   1,
+		LengthOctets: 1,
+		HasTag:       attr.HasTag(),
+		Values:       dictionary.ValuesByAttribute(values, attr.Name),
+	}
+	if vendor != nil {
+		a.VendorID = uint32(vendor.Number)
+		a.TypeOctets = vendor.GetTypeOctets()
+		a.LengthOctets = vendor.GetLengthOctets()
+	}
+	if attr.FlagEncrypt.Valid {
+		a.Encrypt = attr.FlagEncrypt.Int
+	}
+	if attr.Size.Valid {
+		a.Size = attr.Size.Int
+	}
+	return a
+}
+
+// parseCodecOID parses s as an attribute OID. nil is returned if s is not an
+// OID.
+func parseCodecOID(s string) dictionary.OID {
+	if s == "" {
+		return nil
+	}
+	var oid dictionary.OID
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil
+		}
+		oid = append(oid, int(n))
+	}
+	return oid
+}
+
+// valueNumber returns the number of the named value of the attribute.
+func (a *codecAttribute) valueNumber(name string) (uint64, bool) {
+	for _, value := range a.Values {
+		if value.Name == name {
+			return value.Number, true
+		}
+	}
+	return 0, false
+}
+
+// valueName returns the name of the given value of the attribute.
+func (a *codecAttribute) valueName(number uint64) (string, bool) {
+	var name string
+	for _, value := range a.Values {
+		// later definitions of a value override earlier ones
+		if value.Number == number {
+			name = value.Name
+		}
+	}
+	return name, name != ""
+}
+
+// Encode returns the wire value of v, including the tag of tagged
+// attributes, and encrypted if the attribute requires it.
+func (a *codecAttribute) Encode(p *Packet, tag byte, v interface{}) (Attribute, error) {
+	if a.HasTag && tag > 0x1F {
+		return nil, errors.New("invalid tag")
+	}
+
+	attr, err := a.encodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if a.Size > 0 && len(attr) != a.Size {
+		return nil, errors.New("invalid value length")
+	}
+
+	typ := a.Type
+	if typ == 0 {
+		typ = inferAttributeType(v)
+	}
+	if a.HasTag && typ == dictionary.AttributeInteger {
+		if attr[0] != 0 {
+			return nil, errors.New("value out of range for tagged attribute")
+		}
+		attr[0] = tag
+		tag = 0
+	}
+
+	switch a.Encrypt {
+	case 0:
+	case dictionary.EncryptUserPassword:
+		attr, err = NewUserPassword(attr, p.Secret, p.Authenticator[:])
+	case dictionary.EncryptTunnelPassword:
+		var salt [2]byte
+		if _, err = rand.Read(salt[:]); err != nil {
+			return nil, err
+		}
+		salt[0] |= 1 << 7 // RFC 2868 § 3.5
+		attr, err = NewTunnelPassword(attr, salt[:], p.Secret, p.Authenticator[:])
+	default:
+		return nil, errors.New("unsupported encryption method " + strconv.Itoa(a.Encrypt))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if a.HasTag && typ != dictionary.AttributeInteger {
+		attr = append(Attribute{tag}, attr...)
+	}
+	return attr, nil
+}
+
+// Decode returns the tag and value of the wire value attr of p. Encrypted
+// values are decrypted with requestAuthenticator.
+func (a *codecAttribute) Decode(p *Packet, requestAuthenticator []byte, attr Attribute) (tag byte, v interface{}, err error) {
+	if a.HasTag {
+		if a.Type == dictionary.AttributeInteger {
+			if len(attr) == 4 {
+				tag = attr[0]
+				attr = append(Attribute{0}, attr[1:]...)
+			}
+		} else if len(attr) >= 1 && attr[0] <= 0x1F {
+			tag = attr[0]
+			attr = attr[1:]
+		}
+	}
+
+	switch a.Encrypt {
+	case 0:
+	case dictionary.EncryptUserPassword:
+		attr, err = UserPassword(attr, p.Secret, requestAuthenticator)
+	case dictionary.EncryptTunnelPassword:
+		attr, _, err = TunnelPassword(attr, p.Secret, requestAuthenticator)
+	default:
+		err = errors.New("unsupported encryption method " + strconv.Itoa(a.Encrypt))
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if a.Size > 0 && len(attr) != a.Size {
+		return 0, nil, errors.New("invalid value length")
+	}
+
+	v, err = a.decodeValue(attr)
+	return tag, v, err
+}
+
+// encodeValue returns the wire encoding of the value v. Values may be given
+// as their Go type (e.g. net.IP for ipaddr attributes) or as a string.
+func (a *codecAttribute) encodeValue(v interface{}) (Attribute, error) {
+	typ := a.Type
+	if typ == 0 {
+		typ = inferAttributeType(v)
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil, errors.New("nil value")
+	}
+	// byte slices are taken as the wire encoding of values of any type
+	switch v := v.(type) {
+	case []byte:
+		return NewBytes(v)
+	case Attribute:
+		return NewBytes(v)
+	}
+
+	switch typ {
+	case dictionary.AttributeInteger, dictionary.AttributeInteger64, dictionary.AttributeShort,
+		dictionary.AttributeByte, dictionary.AttributeSigned:
+		return a.encodeInteger(typ, rv)
+
+	case dictionary.AttributeIPAddr, dictionary.AttributeIPv6Addr:
+		var ip net.IP
+		switch v := v.(type) {
+		case net.IP:
+			ip = v
+		case string:
+			if ip = net.ParseIP(v); ip == nil {
+				return nil, errors.New("invalid IP address " + strconv.Quote(v))
+			}
+		default:
+			return nil, invalidValueType(typ, v)
+		}
+		if typ == dictionary.AttributeIPAddr {
+			return NewIPAddr(ip)
+		}
+		return NewIPv6Addr(ip)
+
+	case dictionary.AttributeIPv6Prefix, dictionary.AttributeIPv4Prefix:
+		var prefix *net.IPNet
+		switch v := v.(type) {
+		case *net.IPNet:
+			prefix = v
+		case net.IPNet:
+			prefix = &v
+		case string:
+			_, n, err := net.ParseCIDR(v)
+			if err != nil {
+				return nil, err
+			}
+			prefix = n
+		default:
+			return nil, invalidValueType(typ, v)
+		}
+		if typ == dictionary.AttributeIPv4Prefix {
+			return newIPv4Prefix(prefix)
+		}
+		if ip := prefix.IP.To16(); ip != nil && prefix.IP.To4() == nil {
+			prefix = &net.IPNet{IP: ip, Mask: prefix.Mask}
+		}
+		return NewIPv6Prefix(prefix)
+
+	case dictionary.AttributeIFID, dictionary.AttributeEther:
+		var addr net.HardwareAddr
+		switch v := v.(type) {
+		case net.HardwareAddr:
+			addr = v
+		case string:
+			var err error
+			if addr, err = net.ParseMAC(v); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, invalidValueType(typ, v)
+		}
+		if typ == dictionary.AttributeIFID {
+			return NewIFID(addr)
+		}
+		if len(addr) != 6 {
+			return nil, errors.New("invalid length")
+		}
+		return Attribute(append([]byte(nil), addr...)), nil
+
+	case dictionary.AttributeDate:
+		switch v := v.(type) {
+		case time.Time:
+			return NewDate(v)
+		case string:
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				// the format used by FreeRADIUS
+				if t, err = time.Parse("Jan _2 2006 15:04:05 MST", v); err != nil {
+					return nil, errors.New("invalid date " + strconv.Quote(v))
+				}
+			}
+			return NewDate(t)
+		}
+		return a.encodeInteger(dictionary.AttributeInteger, rv)
+	}
+
+	// string, octets and other types without further structure
+	switch {
+	case rv.Kind() == reflect.String:
+		return NewBytes([]byte(rv.String()))
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		return NewBytes(rv.Bytes())
+	}
+	return nil, invalidValueType(typ, v)
+}
+
+// encodeInteger encodes an integer value of one of the integer types. The
+// value may be of any Go integer type, the name of a dictionary value, or a
+// decimal string.
+func (a *codecAttribute) encodeInteger(typ dictionary.AttributeType, rv reflect.Value) (Attribute, error) {
+	var n int64
+	var u uint64
+	var signed bool
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, signed = rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u = rv.Uint()
+	case reflect.String:
+		s := rv.String()
+		if number, ok := a.valueNumber(s); ok {
+			u = number
+			break
+		}
+		var err error
+		if typ == dictionary.AttributeSigned {
+			n, err = strconv.ParseInt(s, 0, 32)
+			signed = true
+		} else {
+			u, err = strconv.ParseUint(s, 0, 64)
+		}
+		if err != nil {
+			return nil, errors.New("invalid " + typ.String() + " value " + strconv.Quote(s))
+		}
+	default:
+		return nil, invalidValueType(typ, rv.Interface())
+	}
+
+	if typ == dictionary.AttributeSigned {
+		if !signed {
+			if u > math.MaxInt32 {
+				return nil, errors.New("value out of range")
+			}
+			n = int64(u)
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, errors.New("value out of range")
+		}
+		return NewInteger(uint32(int32(n))), nil
+	}
+
+	if signed {
+		if n < 0 {
+			return nil, errors.New("value out of range")
+		}
+		u = uint64(n)
+	}
+	switch typ {
+	case dictionary.AttributeByte:
+		if u > math.MaxUint8 {
+			return nil, errors.New("value out of range")
+		}
+		return Attribute{byte(u)}, nil
+	case dictionary.AttributeShort:
+		if u > math.MaxUint16 {
+			return nil, errors.New("value out of range")
+		}
+		return NewShort(uint16(u)), nil
+	case dictionary.AttributeInteger64:
+		return NewInteger64(u), nil
+	}
+	if u > math.MaxUint32 {
+		return nil, errors.New("value out of range")
+	}
+	return NewInteger(uint32(u)), nil
+}
+
+// decodeValue decodes the wire value attr to the Go type of the attribute's
+// data type. Values of unknown types are returned as []byte.
+func (a *codecAttribute) decodeValue(attr Attribute) (interface{}, error) {
+	switch a.Type {
+	case dictionary.AttributeString:
+		return string(attr), nil
+	case dictionary.AttributeInteger:
+		return Integer(attr)
+	case dictionary.AttributeInteger64:
+		return Integer64(attr)
+	case dictionary.AttributeShort:
+		return Short(attr)
+	case dictionary.AttributeByte:
+		if len(attr) != 1 {
+			return nil, errors.New("invalid length")
+		}
+		return attr[0], nil
+	case dictionary.AttributeSigned:
+		n, err := Integer(attr)
+		return int32(n), err
+	case dictionary.AttributeIPAddr:
+		return IPAddr(attr)
+	case dictionary.AttributeIPv6Addr:
+		return IPv6Addr(attr)
+	case dictionary.AttributeIPv6Prefix:
+		return IPv6Prefix(attr)
+	case dictionary.AttributeIPv4Prefix:
+		return ipv4Prefix(attr)
+	case dictionary.AttributeIFID:
+		return IFID(attr)
+	case dictionary.AttributeEther:
+		if len(attr) != 6 {
+			return nil, errors.New("invalid length")
+		}
+		return net.HardwareAddr(Bytes(attr)), nil
+	case dictionary.AttributeDate:
+		return Date(attr)
+	}
+	return Bytes(attr), nil
+}
+
+// inferAttributeType returns the attribute type used for values of v's type
+// when the type of an attribute is unknown.
+func inferAttributeType(v interface{}) dictionary.AttributeType {
+	switch v := v.(type) {
+	case net.IP:
+		if v.To4() != nil {
+			return dictionary.AttributeIPAddr
+		}
+		return dictionary.AttributeIPv6Addr
+	case *net.IPNet, net.IPNet:
+		return dictionary.AttributeIPv6Prefix
+	case time.Time:
+		return dictionary.AttributeDate
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.String:
+		return dictionary.AttributeString
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return dictionary.AttributeSigned
+	case reflect.Uint8:
+		return dictionary.AttributeByte
+	case reflect.Uint16:
+		return dictionary.AttributeShort
+	case reflect.Int, reflect.Uint, reflect.Uint32:
+		return dictionary.AttributeInteger
+	case reflect.Int64, reflect.Uint64:
+		return dictionary.AttributeInteger64
+	}
+	return dictionary.AttributeOctets
+}
+
+func invalidValueType(typ dictionary.AttributeType, v interface{}) error {
+	return errors.New("cannot encode " + reflect.TypeOf(v).String() + " as " + typ.String())
+}
+
+// newIPv4Prefix encodes an IPv4 prefix - rfc8044, 3.11
+func newIPv4Prefix(prefix *net.IPNet) (Attribute, error) {
+	ip := prefix.IP.To4()
+	if ip == nil {
+		return nil, errors.New("IP is not IPv4")
+	}
+	ones, bits := prefix.Mask.Size()
+	if bits != net.IPv4len*8 && bits != net.IPv6len*8 {
+		return nil, errors.New("mask is not IPv4")
+	}
+	if bits == net.IPv6len*8 {
+		ones -= 96
+	}
+	if ones < 0 {
+		return nil, errors.New("mask is not IPv4")
+	}
+	attr := make(Attribute, 2+net.IPv4len)
+	attr[1] = byte(ones)
+	copy(attr[2:], ip.Mask(net.CIDRMask(ones, net.IPv4len*8)))
+	return attr, nil
+}
+
+func ipv4Prefix(a Attribute) (*net.IPNet, error) {
+	if len(a) != 2+net.IPv4len {
+		return nil, errors.New("invalid length")
+	}
+	if a[1] > net.IPv4len*8 {
+		return nil, errors.New("invalid prefix length")
+	}
+	return &net.IPNet{
+		IP:   net.IP(Bytes(a[2:])),
+		Mask: net.CIDRMask(int(a[1]), net.IPv4len*8),
+	}, nil
+}
+
+// Lookup returns the wire values of the attribute in p, in order.
+func (a *codecAttribute) Lookup(p *Packet) []Attribute {
+	var values []Attribute
+	for _, avp := range p.Attributes {
+		if a.VendorID == 0 {
+			if avp.Type == Type(a.Number) {
+				values = append(values, avp.Attribute)
+			}
+			continue
+		}
+		if avp.Type != vendorSpecificType {
+			continue
+		}
+		vendorID, vsa, err := VendorSpecific(avp.Attribute)
+		if err != nil || vendorID != a.VendorID {
+			continue
+		}
+		for len(vsa) > 0 {
+			typ, value, rest, ok := a.splitVendor(vsa)
+			if !ok {
+				break
+			}
+			if typ == a.Number {
+				values = append(values, value)
+			}
+			vsa = rest
+		}
+	}
+	return values
+}
+
+// Add appends the wire value attr of the attribute to p.
+func (a *codecAttribute) Add(p *Packet, attr Attribute) error {
+	if a.VendorID == 0 {
+		if len(attr) > 253 {
+			return errors.New("value too long")
+		}
+		p.Add(Type(a.Number), attr)
+		return nil
+	}
+
+	header := a.TypeOctets + a.LengthOctets
+	vsa := make(Attribute, header+len(attr))
+	putVendorField(vsa[:a.TypeOctets], a.Number)
+	if a.LengthOctets > 0 {
+		if len(vsa) >= 1<<uint(8*a.LengthOctets) {
+			return errors.New("value too long")
+		}
+		putVendorField(vsa[a.TypeOctets:header], len(vsa))
+	}
+	copy(vsa[header:], attr)
+	vsa, err := NewVendorSpecific(a.VendorID, vsa)
+	if err != nil {
+		return err
+	}
+	p.Add(vendorSpecificType, vsa)
+	return nil
+}
+
+// vendorSpecificType is the type of the Vendor-Specific attribute - rfc2865, 5.26
+const vendorSpecificType Type = 26
+
+// splitVendor splits the first vendor attribute from vsa, using the vendor's
+// type and length octet format.
+func (a *codecAttribute) splitVendor(vsa []byte) (typ int, value, rest []byte, ok bool) {
+	header := a.TypeOctets + a.LengthOctets
+	if len(vsa) < header {
+		return 0, nil, nil, false
+	}
+	typ = vendorField(vsa[:a.TypeOctets])
+	if a.LengthOctets == 0 {
+		return typ, vsa[header:], nil, true
+	}
+	length := vendorField(vsa[a.TypeOctets:header])
+	if length < header || length > len(vsa) {
+		return 0, nil, nil, false
+	}
+	return typ, vsa[header:length], vsa[length:], true
+}
+
+func vendorField(b []byte) int {
+	var n int
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+func putVendorField(b []byte, n int) {
+	switch len(b) {
+	case 1:
+		b[0] = byte(n)
+	case 2:
+		binary.BigEndian.PutUint16(b, uint16(n))
+	case 4:
+		binary.BigEndian.PutUint32(b, uint32(n))
+	}
+}
+
+// Del removes the wire values of the attribute from p for which match
+// returns true.
+func (a *codecAttribute) Del(p *Packet, match func(attr Attribute) bool) {
+	for i := 0; i < len(p.Attributes); {
+		avp := p.Attributes[i]
+		if a.VendorID == 0 {
+			if avp.Type == Type(a.Number) && match(avp.Attribute) {
+				p.Attributes = append(p.Attributes[:i], p.Attributes[i+1:]...)
+				continue
+			}
+			i++
+			continue
+		}
+		if avp.Type != vendorSpecificType {
+			i++
+			continue
+		}
+		vendorID, vsa, err := VendorSpecific(avp.Attribute)
+		if err != nil || vendorID != a.VendorID {
+			i++
+			continue
+		}
+
+		var kept []byte
+		removed := false
+		for rest := vsa; len(rest) > 0; {
+			typ, value, next, ok := a.splitVendor(rest)
+			if !ok {
+				// keep malformed data as is
+				kept = append(kept, rest...)
+				break
+			}
+			if typ == a.Number && match(value) {
+				removed = true
+			} else {
+				kept = append(kept, rest[:len(rest)-len(next)]...)
+			}
+			rest = next
+		}
+		if !removed {
+			i++
+			continue
+		}
+		if len(kept) == 0 {
+			p.Attributes = append(p.Attributes[:i], p.Attributes[i+1:]...)
+			continue
+		}
+		p.Attributes[i] = &AVP{
+			Type:      vendorSpecificType,
+			Attribute: append(Attribute(avp.Attribute[:4:4]), kept...),
+		}
+		i++
+	}
+}
+
+// Tag returns the tag of the wire value attr of a tagged attribute, without
+// decrypting the value.
+func (a *codecAttribute) Tag(attr Attribute) byte {
+	if len(attr) >= 1 && attr[0] <= 0x1F {
+		return attr[0]
+	}
+	return 0
+}
+
+// namedAttribute resolves an attribute name or OID that is optionally
+// followed by a tag, as in "Tunnel-Type:1".
+func (c *Codec) namedAttribute(name string) (a *codecAttribute, tag byte, hasTag bool, err error) {
+	attrName := name
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		n, err := strconv.ParseUint(name[i+1:], 10, 8)
+		if err != nil || n > 0x1F {
+			return nil, 0, false, errors.New("radius: invalid tag in attribute name " + strconv.Quote(name))
+		}
+		attrName, tag, hasTag = name[:i], byte(n), true
+	}
+	a, err = c.attribute(attrName)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if hasTag && !a.HasTag {
+		if a.Type != 0 {
+			return nil, 0, false, errors.New("radius: attribute " + strconv.Quote(a.Name) + " does not have a tag")
+		}
+		a.HasTag = true
+	}
+	return a, tag, hasTag, nil
+}
+
+// Get returns the value of the first attribute in p with the given name. If
+// the name is followed by a tag, as in "Tunnel-Type:1", only values with that
+// tag are considered. ErrNoAttribute is returned if p does not contain the
+// attribute.
+//
+// The value has the Go type of the attribute's data type:
+//
+//	string                  string
+//	octets, abinary, tlv    []byte
+//	integer                 uint32
+//	integer64               uint64
+//	short                   uint16
+//	byte                    byte
+//	signed                  int32
+//	date                    time.Time
+//	ipaddr, ipv6addr        net.IP
+//	ipv4prefix, ipv6prefix  *net.IPNet
+//	ifid, ether             net.HardwareAddr
+//
+// Values of attributes that are not in the dictionary are returned as
+// []byte.
+func (c *Codec) Get(p *Packet, name string) (interface{}, error) {
+	return c.get(p, p.Authenticator[:], name)
+}
+
+// GetResponse returns the value of the first attribute in the received
+// response p with the given name, as Get does. Encrypted attributes are
+// decrypted using the authenticator of request, the request that p answers.
+func (c *Codec) GetResponse(p, request *Packet, name string) (interface{}, error) {
+	return c.get(p, request.Authenticator[:], name)
+}
+
+func (c *Codec) get(p *Packet, requestAuthenticator []byte, name string) (interface{}, error) {
+	values, err := c.gets(p, requestAuthenticator, name, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNoAttribute
+	}
+	return values[0], nil
+}
+
+// Gets returns the values of all attributes in p with the given name. See
+// Get.
+func (c *Codec) Gets(p *Packet, name string) ([]interface{}, error) {
+	return c.gets(p, p.Authenticator[:], name, false)
+}
+
+// GetsResponse returns the values of all attributes in the received response
+// p with the given name. See GetResponse.
+func (c *Codec) GetsResponse(p, request *Packet, name string) ([]interface{}, error) {
+	return c.gets(p, request.Authenticator[:], name, false)
+}
+
+func (c *Codec) gets(p *Packet, requestAuthenticator []byte, name string, first bool) ([]interface{}, error) {
+	a, tag, hasTag, err := c.namedAttribute(name)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, attr := range a.Lookup(p) {
+		valueTag, v, err := a.Decode(p, requestAuthenticator, attr)
+		if err != nil {
+			return nil, errors.New("radius: " + a.Name + ": " + err.Error())
+		}
+		if hasTag && valueTag != tag {
+			continue
+		}
+		values = append(values, v)
+		if first {
+			break
+		}
+	}
+	return values, nil
+}
+
+// Add appends the attribute with the given name and value to p. If the name
+// is followed by a tag, as in "Tunnel-Type:1", the value is tagged with it.
+//
+// The value may be of the Go type returned by Get, any Go integer type for
+// integer attributes, or a string representation of the value: the name of
+// a dictionary value for integers, an address or CIDR prefix, a MAC address,
+// or an RFC 3339 time.
+func (c *Codec) Add(p *Packet, name string, value interface{}) error {
+	a, tag, _, err := c.namedAttribute(name)
+	if err != nil {
+		return err
+	}
+	attr, err := a.Encode(p, tag, value)
+	if err == nil {
+		err = a.Add(p, attr)
+	}
+	if err != nil {
+		return errors.New("radius: " + a.Name + ": " + err.Error())
+	}
+	return nil
+}
+
+// Set replaces all attributes in p with the given name with a single
+// attribute with the given value. See Add.
+func (c *Codec) Set(p *Packet, name string, value interface{}) error {
+	a, tag, _, err := c.namedAttribute(name)
+	if err != nil {
+		return err
+	}
+	attr, err := a.Encode(p, tag, value)
+	if err != nil {
+		return errors.New("radius: " + a.Name + ": " + err.Error())
+	}
+	if a.VendorID == 0 {
+		if len(attr) > 253 {
+			return errors.New("radius: " + a.Name + ": value too long")
+		}
+		p.Set(Type(a.Number), attr)
+		return nil
+	}
+	a.Del(p, func(Attribute) bool { return true })
+	if err := a.Add(p, attr); err != nil {
+		return errors.New("radius: " + a.Name + ": " + err.Error())
+	}
+	return nil
+}
+
+// Del removes all attributes in p with the given name. If the name is
+// followed by a tag, as in "Tunnel-Type:1", only values with that tag are
+// removed.
+func (c *Codec) Del(p *Packet, name string) error {
+	a, tag, hasTag, err := c.namedAttribute(name)
+	if err != nil {
+		return err
+	}
+	a.Del(p, func(attr Attribute) bool {
+		return !hasTag || a.Tag(attr) == tag
+	})
+	return nil
+}
+
+// DecodedAttribute is an attribute of a packet decoded by Codec.Decode.
+type DecodedAttribute struct {
+	// Name is the dictionary name of the attribute. It is the attribute's
+	// OID if the attribute is not in the dictionary, or if its value could
+	// not be decoded.
+	Name string
+	// Type is the data type of the attribute. It is zero if Name is an OID.
+	Type dictionary.AttributeType
+	// Tag is the tag of a tagged attribute.
+	Tag byte
+	// Value has the Go type described for Codec.Get, or is the []byte wire
+	// value if Type is zero. Encrypted values are decrypted.
+	Value interface{}
+	// ValueName is the dictionary name of the value of an integer
+	// attribute, if any.
+	ValueName string
+}
+
+// FullName returns the attribute's name, followed by its tag if it has one,
+// as accepted by Codec.Add.
+func (d *DecodedAttribute) FullName() string {
+	if d.Tag == 0 {
+		return d.Name
+	}
+	return d.Name + ":" + strconv.Itoa(int(d.Tag))
+}
+
+// Decode decodes all attributes of p, in order. Vendor-Specific attributes
+// are split into the vendor attributes they contain.
+//
+// As with Unmarshal, encrypted attributes are decrypted using p's
+// authenticator. DecodeResponse must be used for received responses.
+func (c *Codec) Decode(p *Packet) []*DecodedAttribute {
+	return c.decode(p, p.Authenticator[:])
+}
+
+// DecodeResponse decodes all attributes of the received response p, as Decode
+// does. Encrypted attributes are decrypted using the authenticator of
+// request, the request that p answers.
+func (c *Codec) DecodeResponse(p, request *Packet) []*DecodedAttribute {
+	return c.decode(p, request.Authenticator[:])
+}
+
+func (c *Codec) decode(p *Packet, requestAuthenticator []byte) []*DecodedAttribute {
+	var attrs []*DecodedAttribute
+	for _, avp := range p.Attributes {
+		if avp.Type == vendorSpecificType {
+			if vsa := c.decodeVendor(p, requestAuthenticator, avp.Attribute); vsa != nil {
+				attrs = append(attrs, vsa...)
+				continue
+			}
+		}
+		oid := dictionary.OID{int(avp.Type)}
+		attrs = append(attrs, c.decodeAttribute(p, requestAuthenticator, oid, avp.Attribute))
+	}
+	return attrs
+}
+
+// decodeVendor decodes the vendor attributes of a Vendor-Specific
+// attribute. nil is returned if the attribute is malformed.
+func (c *Codec) decodeVendor(p *Packet, requestAuthenticator []byte, attr Attribute) []*DecodedAttribute {
+	vendorID, vsa, err := VendorSpecific(attr)
+	if err != nil || vendorID == 0 || vendorID > math.MaxInt32 {
+		return nil
+	}
+	format, err := c.attributeByOID("", dictionary.OID{26, int(vendorID), 0})
+	if err != nil {
+		return nil
+	}
+
+	var attrs []*DecodedAttribute
+	for len(vsa) > 0 {
+		typ, value, rest, ok := format.splitVendor(vsa)
+		if !ok {
+			return nil
+		}
+		oid := dictionary.OID{26, int(vendorID), typ}
+		attrs = append(attrs, c.decodeAttribute(p, requestAuthenticator, oid, value))
+		vsa = rest
+	}
+	return attrs
+}
+
+func (c *Codec) decodeAttribute(p *Packet, requestAuthenticator []byte, oid dictionary.OID, attr Attribute) *DecodedAttribute {
+	name := oid.String()
+	a, err := c.attributeByOID(name, oid)
+	if err == nil && a.Type != 0 {
+		if tag, v, err := a.Decode(p, requestAuthenticator, attr); err == nil {
+			d := &DecodedAttribute{
+				Name:  a.Name,
+				Type:  a.Type,
+				Tag:   tag,
+				Value: v,
+			}
+			switch n := reflect.ValueOf(v); n.Kind() {
+			case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				d.ValueName, _ = a.valueName(n.Uint())
+			}
+			return d
+		}
+	}
+	return &DecodedAttribute{
+		Name:  name,
+		Value: Bytes(attr),
+	}
+}
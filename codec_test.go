@@ -0,0 +1,234 @@
+package radius_test
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2868"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+func loadCodec(t *testing.T) *radius.Codec {
+	t.Helper()
+	parser := &dictionary.Parser{
+		Opener: &dictionary.FileSystemOpener{},
+	}
+	dict := &dictionary.Dictionary{}
+	for _, file := range []string{"rfc2865/dictionary.rfc2865", "rfc2866/dictionary.rfc2866", "rfc2868/dictionary.rfc2868", "vendors/microsoft/dictionary.microsoft"} {
+		next, err := parser.ParseFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dict, err = dictionary.Merge(dict, next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dict.Vendors = append(dict.Vendors, &dictionary.Vendor{
+		Name:   "Cisco",
+		Number: 9,
+		Attributes: []*dictionary.Attribute{
+			{Name: "Cisco-AVPair", OID: dictionary.OID{1}, Type: dictionary.AttributeString},
+		},
+	})
+	return &radius.Codec{Dictionary: dict}
+}
+
+func TestCodec_generated(t *testing.T) {
+	codec := loadCodec(t)
+
+	expected := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	rfc2865.UserName_SetString(expected, "tim")
+	rfc2865.ServiceType_Set(expected, rfc2865.ServiceType_Value_FramedUser)
+	rfc2865.FramedIPAddress_Set(expected, net.IPv4(10, 0, 0, 1))
+	rfc2868.TunnelType_Add(expected, 1, rfc2868.TunnelType_Value_GRE)
+	rfc2868.TunnelPrivateGroupID_AddString(expected, 1, "100")
+	microsoft.MSPrimaryDNSServer_Add(expected, net.IPv4(10, 0, 0, 53))
+	microsoft.MSCHAPError_AddString(expected, "E=691")
+
+	p := radius.New(radius.CodeAccessRequest, expected.Secret)
+	p.Authenticator = expected.Authenticator
+	for _, attr := range []struct {
+		Name  string
+		Value interface{}
+	}{
+		{"User-Name", "tim"},
+		{"Service-Type", "Framed-User"},
+		{"Framed-IP-Address", net.IPv4(10, 0, 0, 1)},
+		{"Tunnel-Type:1", "GRE"},
+		{"Tunnel-Private-Group-Id:1", "100"},
+		{"MS-Primary-DNS-Server", "10.0.0.53"},
+		{"MS-CHAP-Error", "E=691"},
+	} {
+		if err := codec.Add(p, attr.Name, attr.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(p.Attributes, expected.Attributes) {
+		t.Fatalf("got %v\nexpecting %v", p.Attributes, expected.Attributes)
+	}
+}
+
+func TestCodec_encrypted(t *testing.T) {
+	codec := loadCodec(t)
+	p := radius.New(radius.CodeAccessAccept, []byte(`secret`))
+
+	if err := codec.Set(p, "User-Password", "12345"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rfc2865.UserPassword_GetString(p); got != "12345" {
+		t.Fatalf("got User-Password %q", got)
+	}
+
+	if err := codec.Add(p, "Tunnel-Password:2", "tunnel"); err != nil {
+		t.Fatal(err)
+	}
+	tag, password := rfc2868.TunnelPassword_GetString(p, p)
+	if tag != 2 || password != "tunnel" {
+		t.Fatalf("got Tunnel-Password %d, %q", tag, password)
+	}
+
+	key := bytes.Repeat([]byte{7}, 32)
+	if err := microsoft.MSMPPESendKey_Add(p, key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := codec.Get(p, "MS-MPPE-Send-Key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.([]byte), key) {
+		t.Fatalf("got MS-MPPE-Send-Key %x", got)
+	}
+}
+
+func TestCodec_response(t *testing.T) {
+	codec := loadCodec(t)
+	request := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	response := request.Response(radius.CodeAccessAccept)
+	if err := codec.Add(response, "Tunnel-Password:1", "tunnel"); err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, 32)
+	if err := codec.Add(response, "MS-MPPE-Send-Key", key); err != nil {
+		t.Fatal(err)
+	}
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the received response carries its own authenticator
+	received, err := radius.Parse(wire, request.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := codec.GetResponse(received, request, "Tunnel-Password:1"); err != nil || v != "tunnel" {
+		t.Fatalf("got Tunnel-Password %v, %v", v, err)
+	}
+	if v, err := codec.Get(received, "Tunnel-Password:1"); err == nil && v == "tunnel" {
+		t.Fatal("Tunnel-Password decrypted with the response authenticator")
+	}
+	values, err := codec.GetsResponse(received, request, "MS-MPPE-Send-Key")
+	if err != nil || len(values) != 1 || !bytes.Equal(values[0].([]byte), key) {
+		t.Fatalf("got MS-MPPE-Send-Key %x, %v", values, err)
+	}
+
+	var names []string
+	for _, attr := range codec.DecodeResponse(received, request) {
+		names = append(names, attr.FullName()+" = "+attr.ValueString())
+	}
+	if expected := []string{`Tunnel-Password:1 = "tunnel"`, "MS-MPPE-Send-Key = 0x" + strings.Repeat("07", 32)}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("got attributes %q", names)
+	}
+
+	var v struct {
+		Password string `radius:"Tunnel-Password,tag=1"`
+	}
+	if err := codec.UnmarshalResponse(received, request, &v); err != nil || v.Password != "tunnel" {
+		t.Fatalf("got Tunnel-Password %q, %v", v.Password, err)
+	}
+}
+
+func TestCodec_getSetDel(t *testing.T) {
+	codec := loadCodec(t)
+	p := radius.New(radius.CodeAccessAccept, []byte(`secret`))
+
+	for _, value := range []string{"a=1", "b=2", "c=3"} {
+		if err := codec.Add(p, "Cisco-AVPair", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	codec.Add(p, "Session-Timeout", 60)
+	codec.Add(p, "Tunnel-Type:1", "L2TP")
+	codec.Add(p, "Tunnel-Type:2", uint32(13))
+
+	values, err := codec.Gets(p, "Cisco-AVPair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []interface{}{"a=1", "b=2", "c=3"}) {
+		t.Fatalf("got %v", values)
+	}
+
+	if err := codec.Set(p, "Cisco-AVPair", "x=y"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := codec.Gets(p, "26.9.1"); !reflect.DeepEqual(values, []interface{}{"x=y"}) {
+		t.Fatalf("got %v", values)
+	}
+
+	if v, err := codec.Get(p, "Session-Timeout"); err != nil || v != uint32(60) {
+		t.Fatalf("got Session-Timeout %v, %v", v, err)
+	}
+	if v, err := codec.Get(p, "Tunnel-Type:2"); err != nil || v != uint32(13) {
+		t.Fatalf("got Tunnel-Type:2 %v, %v", v, err)
+	}
+
+	if err := codec.Del(p, "Tunnel-Type:1"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := codec.Gets(p, "Tunnel-Type"); !reflect.DeepEqual(values, []interface{}{uint32(13)}) {
+		t.Fatalf("got Tunnel-Type %v", values)
+	}
+	codec.Del(p, "Cisco-AVPair")
+	if _, err := codec.Get(p, "Cisco-AVPair"); err != radius.ErrNoAttribute {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestCodec_errors(t *testing.T) {
+	codec := loadCodec(t)
+	p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+
+	tests := []struct {
+		Name  string
+		Value interface{}
+		Error string
+	}{
+		{"user-name", "tim", `radius: unknown attribute "user-name" (did you mean "User-Name"?)`},
+		{"No-Such-Attribute", "tim", `radius: unknown attribute "No-Such-Attribute"`},
+		{"User-Name:1", "tim", `radius: attribute "User-Name" does not have a tag`},
+		{"Tunnel-Type:40", 1, `radius: invalid tag in attribute name "Tunnel-Type:40"`},
+		{"Service-Type", "Framed", `radius: Service-Type: invalid integer value "Framed"`},
+		{"Service-Type", net.IPv4(1, 2, 3, 4), `radius: Service-Type: cannot encode net.IP as integer`},
+		{"Framed-IP-Address", "localhost", `radius: Framed-IP-Address: invalid IP address "localhost"`},
+	}
+	for _, tt := range tests {
+		err := codec.Add(p, tt.Name, tt.Value)
+		if err == nil || err.Error() != tt.Error {
+			t.Errorf("%s: got error %v; expecting %s", tt.Name, err, tt.Error)
+		}
+	}
+
+	var unknown *radius.UnknownAttributeError
+	_, err := codec.Get(p, "Bogus")
+	if e, ok := err.(*radius.UnknownAttributeError); !ok || e.Name != "Bogus" {
+		t.Fatalf("got error %#v; expecting %T", err, unknown)
+	}
+}
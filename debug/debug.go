@@ -2,132 +2,234 @@ package debug
 
 import (
 	"bytes"
-	"encoding/binary"
-	"encoding/hex"
-	"fmt"
 	"io"
 	"net"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	"layeh.com/radius"
 	"layeh.com/radius/dictionary"
 )
 
+// DefaultRedacted is the list of attributes whose values are redacted if
+// Config.Redacted is nil. Attributes are listed by OID, so that they are
+// redacted even if the dictionary does not name them.
+var DefaultRedacted = []string{
+	"2",         // User-Password
+	"3",         // CHAP-Password
+	"69",        // Tunnel-Password
+	"26.311.1",  // MS-CHAP-Response
+	"26.311.3",  // MS-CHAP-CPW-1
+	"26.311.4",  // MS-CHAP-CPW-2
+	"26.311.5",  // MS-CHAP-LM-Enc-PW
+	"26.311.6",  // MS-CHAP-NT-Enc-PW
+	"26.311.11", // MS-CHAP-Challenge
+	"26.311.12", // MS-CHAP-MPPE-Keys
+	"26.311.16", // MS-MPPE-Send-Key
+	"26.311.17", // MS-MPPE-Recv-Key
+	"26.311.25", // MS-CHAP2-Response
+	"26.311.26", // MS-CHAP2-Success
+	"26.311.27", // MS-CHAP2-CPW
+}
+
 type Config struct {
 	Dictionary *dictionary.Dictionary
+
+	// Formatter renders packets. TextFormatter is used if nil.
+	Formatter Formatter
+
+	// Redacted lists the names or OIDs, such as "26.311.16", of attributes
+	// whose values are replaced with "<redacted>". The values of attributes
+	// with the encrypt flag are always redacted, even if they cannot be
+	// decoded. DefaultRedacted is used if nil.
+	Redacted []string
+	// Allowed lists the names or OIDs of attributes whose values are shown
+	// even if they would otherwise be redacted. Allowed takes precedence
+	// over Redacted, DefaultRedacted and the encrypt flag: an attribute
+	// listed in both Allowed and Redacted is shown.
+	Allowed []string
 }
 
 func Dump(w io.Writer, c *Config, p *radius.Packet) {
-	io.WriteString(w, p.Code.String())
-	io.WriteString(w, " Id ")
-	io.WriteString(w, strconv.Itoa(int(p.Identifier)))
-	io.WriteString(w, "\n")
-	dumpAttrs(w, c, p)
+	c.formatter().Format(w, c.message(p, p, nil, nil))
 }
 
 func DumpString(c *Config, p *radius.Packet) string {
 	var b bytes.Buffer
 	Dump(&b, c, p)
-	b.Truncate(b.Len() - 1) // remove trailing \n
+	if b.Len() > 0 {
+		b.Truncate(b.Len() - 1) // remove trailing \n
+	}
 	return b.String()
 }
 
 func DumpRequest(w io.Writer, c *Config, req *radius.Request) {
-	io.WriteString(w, req.Code.String())
-	io.WriteString(w, " Id ")
-	io.WriteString(w, strconv.Itoa(int(req.Identifier)))
-	io.WriteString(w, " from ")
-	io.WriteString(w, req.RemoteAddr.String())
-	io.WriteString(w, " to ")
-	io.WriteString(w, req.LocalAddr.String())
-	io.WriteString(w, "\n")
-	dumpAttrs(w, c, req.Packet)
+	c.formatter().Format(w, c.message(req.Packet, req.Packet, req.RemoteAddr, req.LocalAddr))
 }
 
 func DumpRequestString(c *Config, req *radius.Request) string {
 	var b bytes.Buffer
 	DumpRequest(&b, c, req)
-	b.Truncate(b.Len() - 1) // remove trailing \n
+	if b.Len() > 0 {
+		b.Truncate(b.Len() - 1) // remove trailing \n
+	}
+	return b.String()
+}
+
+// DumpResponse writes p, the response to req. It is sent from the local
+// address of req to its remote address, and its encrypted attributes are
+// decrypted using the authenticator of req.
+func DumpResponse(w io.Writer, c *Config, req *radius.Request, p *radius.Packet) {
+	c.formatter().Format(w, c.message(p, req.Packet, req.LocalAddr, req.RemoteAddr))
+}
+
+func DumpResponseString(c *Config, req *radius.Request, p *radius.Packet) string {
+	var b bytes.Buffer
+	DumpResponse(&b, c, req, p)
+	if b.Len() > 0 {
+		b.Truncate(b.Len() - 1) // remove trailing \n
+	}
 	return b.String()
 }
 
-func dumpAttrs(w io.Writer, c *Config, p *radius.Packet) {
+func (c *Config) formatter() Formatter {
+	if c.Formatter == nil {
+		return TextFormatter
+	}
+	return c.Formatter
+}
+
+// message returns the Message of p. Vendor-Specific attributes are split
+// into their vendor attributes, and encrypted attributes are decrypted using
+// the authenticator of request, which is p itself if p is a request.
+func (c *Config) message(p, request *radius.Packet, remoteAddr, localAddr net.Addr) *Message {
+	m := &Message{
+		Code:       p.Code,
+		Identifier: p.Identifier,
+		Length:     20,
+		RemoteAddr: remoteAddr,
+		LocalAddr:  localAddr,
+	}
 	for _, avp := range p.Attributes {
-		var attrTypeStr string
-		var attrStr string
-
-		searchAttrs := c.Dictionary.Attributes
-		searchValues := c.Dictionary.Values
-
-		dictAttr := dictionary.AttributeByOID(searchAttrs, dictionary.OID{int(avp.Type)})
-		if dictAttr != nil {
-			attrTypeStr = dictAttr.Name
-			switch dictAttr.Type {
-			case dictionary.AttributeString, dictionary.AttributeOctets:
-				if dictAttr != nil && dictAttr.FlagEncrypt.Valid && dictAttr.FlagEncrypt.Int == 1 {
-					decryptedValue, err := radius.UserPassword(avp.Attribute, p.Secret, p.Authenticator[:])
-					if err == nil {
-						attrStr = fmt.Sprintf("%q", decryptedValue)
-						break
-					}
-				}
-				attrStr = fmt.Sprintf("%q", avp.Attribute)
-
-			case dictionary.AttributeDate:
-				if len(avp.Attribute) == 4 {
-					t := time.Unix(int64(binary.BigEndian.Uint32(avp.Attribute)), 0).UTC()
-					attrStr = t.Format(time.RFC3339)
-				}
-
-			case dictionary.AttributeInteger:
-				switch len(avp.Attribute) {
-				case 4:
-					intVal := uint64(binary.BigEndian.Uint32(avp.Attribute))
-					if dictAttr != nil {
-						var matchedNames []string
-						for _, value := range dictionary.ValuesByAttribute(searchValues, dictAttr.Name) {
-							if value.Number == intVal {
-								matchedNames = append(matchedNames, value.Name)
-							}
-						}
-						if len(matchedNames) > 0 {
-							sort.Stable(sort.StringSlice(matchedNames))
-							attrStr = strings.Join(matchedNames, " / ")
-							break
-						}
-					}
-					attrStr = strconv.FormatUint(intVal, 10)
-				case 8:
-					attrStr = strconv.Itoa(int(binary.BigEndian.Uint64(avp.Attribute)))
-				}
-
-			case dictionary.AttributeIPAddr, dictionary.AttributeIPv6Addr:
-				switch len(avp.Attribute) {
-				case net.IPv4len, net.IPv6len:
-					attrStr = net.IP(avp.Attribute).String()
-				}
-
-			case dictionary.AttributeIFID:
-				if len(avp.Attribute) == 8 {
-					attrStr = net.HardwareAddr(avp.Attribute).String()
-				}
+		m.Length += 2 + len(avp.Attribute)
+	}
 
-			}
-		} else {
-			attrTypeStr = "#" + strconv.Itoa(int(avp.Type))
+	codec := &radius.Codec{
+		Dictionary: c.Dictionary,
+	}
+	for _, decoded := range codec.DecodeResponse(p, request) {
+		attr := &Attribute{
+			DecodedAttribute: *decoded,
+		}
+		if c.redacted(decoded) {
+			attr.Value = nil
+			attr.ValueName = ""
+			attr.Redacted = true
+		} else if decoded.ValueName != "" {
+			attr.ValueNames = c.valueNames(attr)
 		}
+		m.Attributes = append(m.Attributes, attr)
+	}
+	return m
+}
 
-		if len(attrStr) == 0 {
-			attrStr = "0x" + hex.EncodeToString(avp.Attribute)
+// redacted returns if the value of attr should be redacted.
+func (c *Config) redacted(attr *radius.DecodedAttribute) bool {
+	oid := c.oid(attr)
+	oidString := oid.String()
+	for _, allowed := range c.Allowed {
+		if allowed == attr.Name || allowed == oidString {
+			return false
 		}
+	}
+	redacted := c.Redacted
+	if redacted == nil {
+		redacted = DefaultRedacted
+	}
+	for _, r := range redacted {
+		if r == attr.Name || r == oidString {
+			return true
+		}
+	}
+
+	dictAttr := c.attributeByOID(oid)
+	return dictAttr != nil && dictAttr.FlagEncrypt.Valid && dictAttr.FlagEncrypt.Int != 0
+}
 
-		io.WriteString(w, "  ")
-		io.WriteString(w, attrTypeStr)
-		io.WriteString(w, " = ")
-		io.WriteString(w, attrStr)
-		io.WriteString(w, "\n")
+// oid returns the OID of attr, with vendor attributes prefixed by 26 and
+// their vendor number.
+func (c *Config) oid(attr *radius.DecodedAttribute) dictionary.OID {
+	if attr.Type == 0 {
+		// the attribute is named by its OID
+		var oid dictionary.OID
+		for _, s := range strings.Split(attr.Name, ".") {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil
+			}
+			oid = append(oid, n)
+		}
+		return oid
+	}
+	if c.Dictionary == nil {
+		return nil
+	}
+	if dictAttr := dictionary.AttributeByName(c.Dictionary.Attributes, attr.Name); dictAttr != nil {
+		return dictAttr.OID
+	}
+	for _, vendor := range c.Dictionary.Vendors {
+		if dictAttr := dictionary.AttributeByName(vendor.Attributes, attr.Name); dictAttr != nil {
+			return append(dictionary.OID{26, vendor.Number}, dictAttr.OID...)
+		}
+	}
+	return nil
+}
+
+// attributeByOID returns the dictionary attribute of oid, or nil if there is
+// none.
+func (c *Config) attributeByOID(oid dictionary.OID) *dictionary.Attribute {
+	if c.Dictionary == nil || len(oid) == 0 {
+		return nil
+	}
+	if oid[0] != 26 {
+		return dictionary.AttributeByOID(c.Dictionary.Attributes, oid)
+	}
+	if len(oid) < 3 {
+		return nil
+	}
+	vendor := dictionary.VendorByNumber(c.Dictionary.Vendors, oid[1])
+	if vendor == nil {
+		return nil
+	}
+	return dictionary.AttributeByOID(vendor.Attributes, oid[2:])
+}
+
+// valueNames returns the sorted names that the dictionary has for the
+// integer value of attr.
+func (c *Config) valueNames(attr *Attribute) []string {
+	var number uint64
+	switch v := attr.Value.(type) {
+	case uint8:
+		number = uint64(v)
+	case uint16:
+		number = uint64(v)
+	case uint32:
+		number = uint64(v)
+	case uint64:
+		number = v
+	}
+
+	values := dictionary.ValuesByAttribute(c.Dictionary.Values, attr.Name)
+	for _, vendor := range c.Dictionary.Vendors {
+		values = append(values, dictionary.ValuesByAttribute(vendor.Values, attr.Name)...)
+	}
+	var matchedNames []string
+	for _, value := range values {
+		if value.Number == number {
+			matchedNames = append(matchedNames, value.Name)
+		}
 	}
+	sort.Stable(sort.StringSlice(matchedNames))
+	return matchedNames
 }
@@ -9,8 +9,10 @@ import (
 
 	"layeh.com/radius"
 	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
 	. "layeh.com/radius/rfc2865"
 	. "layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
 	. "layeh.com/radius/rfc2869"
 	. "layeh.com/radius/rfc3162"
 )
@@ -47,7 +49,7 @@ func TestDumpPacket(t *testing.T) {
 			[]string{
 				`Access-Request Id 33`,
 				`  User-Name = "Tim"`,
-				`  User-Password = "12345"`,
+				`  User-Password = <redacted>`,
 				`  NAS-IP-Address = 10.0.2.5`,
 				`  Acct-Status-Type = Alive / Interim-Update`,
 				`  Acct-Status-Type = Alive / Interim-Update`,
@@ -75,6 +77,95 @@ func TestDumpPacket(t *testing.T) {
 	}
 }
 
+func TestDumpPacket_extended(t *testing.T) {
+	two := 2
+	extra := &dictionary.Dictionary{
+		Attributes: []*dictionary.Attribute{
+			{Name: "Tunnel-Type", OID: dictionary.OID{64}, Type: dictionary.AttributeInteger, FlagHasTag: dictionary.BoolFlag{Bool: true, Valid: true}},
+			{Name: "Tunnel-Password", OID: dictionary.OID{69}, Type: dictionary.AttributeString, FlagHasTag: dictionary.BoolFlag{Bool: true, Valid: true}, FlagEncrypt: dictionary.IntFlag{Int: 2, Valid: true}},
+		},
+		Values: []*dictionary.Value{
+			{Attribute: "Tunnel-Type", Name: "VLAN", Number: 13},
+		},
+		Vendors: []*dictionary.Vendor{
+			{
+				Name:   "Cisco",
+				Number: 9,
+				Attributes: []*dictionary.Attribute{
+					{Name: "Cisco-AVPair", OID: dictionary.OID{1}, Type: dictionary.AttributeString},
+				},
+			},
+			{
+				Name:         "Example",
+				Number:       32473,
+				TypeOctets:   &two,
+				LengthOctets: &two,
+				Attributes: []*dictionary.Attribute{
+					{Name: "Example-Short", OID: dictionary.OID{1}, Type: dictionary.AttributeShort},
+					{Name: "Example-Byte", OID: dictionary.OID{2}, Type: dictionary.AttributeByte},
+					{Name: "Example-Signed", OID: dictionary.OID{3}, Type: dictionary.AttributeSigned},
+					{Name: "Example-Counter", OID: dictionary.OID{4}, Type: dictionary.AttributeInteger64},
+					{Name: "Example-MAC", OID: dictionary.OID{5}, Type: dictionary.AttributeEther},
+				},
+			},
+		},
+	}
+	dict, err := dictionary.Merge(debug.IncludedDictionary, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &radius.Packet{
+		Code:       radius.CodeAccessRequest,
+		Identifier: 1,
+		Secret:     secret,
+	}
+	p.Authenticator[0] = 0x01
+
+	rfc2868.TunnelType_Add(p, 1, 13)
+	rfc2868.TunnelPassword_AddString(p, 2, "tunnel")
+	_, prefix, _ := net.ParseCIDR("2001:db8::/32")
+	FramedIPv6Prefix_Set(p, prefix)
+
+	vsa, _ := radius.NewVendorSpecific(9, radius.Attribute("\x01\x05a=b"))
+	p.Add(26, vsa)
+	vsa, _ = radius.NewVendorSpecific(32473, radius.Attribute(
+		"\x00\x01\x00\x06\x01\x02"+
+			"\x00\x02\x00\x05\x07"+
+			"\x00\x03\x00\x08\xff\xff\xff\xfe"+
+			"\x00\x04\x00\x0c\x00\x00\x00\x01\x00\x00\x00\x00"+
+			"\x00\x05\x00\x0a\x00\x11\x22\x33\x44\x55"+
+			"\x00\x09\x00\x05\xab"))
+	p.Add(26, vsa)
+	vsa, _ = radius.NewVendorSpecific(4242, radius.Attribute("\x01\x03\xcd"))
+	p.Add(26, vsa)
+	p.Add(224, radius.Attribute{0xef})
+
+	expected := []string{
+		`Access-Request Id 1`,
+		`  Tunnel-Type:1 = VLAN`,
+		`  Tunnel-Password:2 = "tunnel"`,
+		`  Framed-IPv6-Prefix = 2001:db8::/32`,
+		`  Cisco-AVPair = "a=b"`,
+		`  Example-Short = 258`,
+		`  Example-Byte = 7`,
+		`  Example-Signed = -2`,
+		`  Example-Counter = 4294967296`,
+		`  Example-MAC = 00:11:22:33:44:55`,
+		`  26.32473.9 = 0xab`,
+		`  26.4242.1 = 0xcd`,
+		`  224 = 0xef`,
+	}
+	config := &debug.Config{
+		Dictionary: dict,
+		Allowed:    []string{"Tunnel-Password"},
+	}
+	result := debug.DumpString(config, p)
+	if outputStr := strings.Join(expected, "\n"); result != outputStr {
+		t.Fatalf("\nexpected:\n%s\ngot:\n%s", outputStr, result)
+	}
+}
+
 func TestDumpRequest(t *testing.T) {
 	tests := []*struct {
 		Request func() *radius.Request
@@ -104,7 +195,7 @@ func TestDumpRequest(t *testing.T) {
 			[]string{
 				`Access-Request Id 5 from 10.0.10.3:34521 to 127.0.0.1:1812`,
 				`  User-Name = "Tim"`,
-				`  User-Password = "12345"`,
+				`  User-Password = <redacted>`,
 				`  NAS-IP-Address = 10.0.2.5`,
 			},
 		},
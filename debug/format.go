@@ -0,0 +1,251 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"layeh.com/radius"
+)
+
+// redactedValue replaces the values of redacted attributes.
+const redactedValue = "<redacted>"
+
+// Message is a packet as it is passed to a Formatter.
+type Message struct {
+	Code       radius.Code
+	Identifier byte
+	// Length is the length of the encoded packet.
+	Length int
+
+	// RemoteAddr and LocalAddr are the addresses of requests dumped with
+	// DumpRequest, and nil otherwise.
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+
+	Attributes []*Attribute
+}
+
+// Attribute is an attribute of a Message.
+type Attribute struct {
+	radius.DecodedAttribute
+
+	// ValueNames contains all of the names that the dictionary has for the
+	// integer value of the attribute, in sorted order.
+	ValueNames []string
+	// Redacted is true if the value of the attribute has been removed.
+	Redacted bool
+}
+
+// A Formatter writes the representation of a Message, ending with a newline.
+type Formatter interface {
+	Format(w io.Writer, m *Message) error
+}
+
+// FormatterFunc is an adapter to allow the use of ordinary functions as
+// formatters.
+type FormatterFunc func(w io.Writer, m *Message) error
+
+// Format calls f(w, m).
+func (f FormatterFunc) Format(w io.Writer, m *Message) error {
+	return f(w, m)
+}
+
+var (
+	// TextFormatter writes a header line followed by an indented line for
+	// each attribute:
+	//
+	//	Access-Request Id 5 from 10.0.10.3:34521 to 127.0.0.1:1812
+	//	  User-Name = "Tim"
+	//	  User-Password = <redacted>
+	//	  Acct-Status-Type = Alive / Interim-Update
+	//
+	// Integer values with more than one name are represented by all of them.
+	TextFormatter Formatter = FormatterFunc(formatText)
+
+	// FreeRADIUSFormatter writes packets like the debug output of
+	// FreeRADIUS and radclient, which can be parsed by
+	// radius.Codec.ParseText:
+	//
+	//	Received Access-Request Id 5 from 10.0.10.3:34521 to 127.0.0.1:1812 length 43
+	//		User-Name = "Tim"
+	//		User-Password = <redacted>
+	FreeRADIUSFormatter Formatter = FormatterFunc(formatFreeRADIUS)
+
+	// JSONFormatter writes a JSON object per packet, in the format of
+	// radius.Codec.EncodeJSON with the addition of the "length", "from" and
+	// "to" fields:
+	//
+	//	{"code":"Access-Request","id":5,"length":43,"from":"10.0.10.3:34521","to":"127.0.0.1:1812","attributes":{"User-Name":["Tim"],"User-Password":["<redacted>"]}}
+	JSONFormatter Formatter = FormatterFunc(formatJSON)
+
+	// LogfmtFormatter writes a logfmt line per packet:
+	//
+	//	code=Access-Request id=5 length=43 from=10.0.10.3:34521 to=127.0.0.1:1812 User-Name=Tim User-Password=<redacted>
+	LogfmtFormatter Formatter = FormatterFunc(formatLogfmt)
+)
+
+func formatText(w io.Writer, m *Message) error {
+	var b bytes.Buffer
+	b.WriteString(m.Code.String())
+	b.WriteString(" Id ")
+	b.WriteString(strconv.Itoa(int(m.Identifier)))
+	if m.RemoteAddr != nil && m.LocalAddr != nil {
+		b.WriteString(" from ")
+		b.WriteString(m.RemoteAddr.String())
+		b.WriteString(" to ")
+		b.WriteString(m.LocalAddr.String())
+	}
+	b.WriteByte('\n')
+	for _, attr := range m.Attributes {
+		b.WriteString("  ")
+		b.WriteString(attr.FullName())
+		b.WriteString(" = ")
+		switch {
+		case attr.Redacted:
+			b.WriteString(redactedValue)
+		case len(attr.ValueNames) > 1:
+			b.WriteString(strings.Join(attr.ValueNames, " / "))
+		default:
+			b.WriteString(attr.ValueString())
+		}
+		b.WriteByte('\n')
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func formatFreeRADIUS(w io.Writer, m *Message) error {
+	var b bytes.Buffer
+	if m.RemoteAddr != nil && m.LocalAddr != nil {
+		b.WriteString("Received ")
+	}
+	b.WriteString(m.Code.String())
+	b.WriteString(" Id ")
+	b.WriteString(strconv.Itoa(int(m.Identifier)))
+	if m.RemoteAddr != nil && m.LocalAddr != nil {
+		b.WriteString(" from ")
+		b.WriteString(m.RemoteAddr.String())
+		b.WriteString(" to ")
+		b.WriteString(m.LocalAddr.String())
+	}
+	b.WriteString(" length ")
+	b.WriteString(strconv.Itoa(m.Length))
+	b.WriteByte('\n')
+	for _, attr := range m.Attributes {
+		b.WriteByte('\t')
+		b.WriteString(attr.FullName())
+		b.WriteString(" = ")
+		if attr.Redacted {
+			b.WriteString(redactedValue)
+		} else {
+			b.WriteString(attr.ValueString())
+		}
+		b.WriteByte('\n')
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func formatJSON(w io.Writer, m *Message) error {
+	var keys []string
+	values := make(map[string][]interface{})
+	for _, attr := range m.Attributes {
+		key := attr.FullName()
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], jsonValue(attr))
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"code":`)
+	writeJSON(&b, m.Code.String())
+	b.WriteString(`,"id":`)
+	b.WriteString(strconv.Itoa(int(m.Identifier)))
+	b.WriteString(`,"length":`)
+	b.WriteString(strconv.Itoa(m.Length))
+	if m.RemoteAddr != nil && m.LocalAddr != nil {
+		b.WriteString(`,"from":`)
+		writeJSON(&b, m.RemoteAddr.String())
+		b.WriteString(`,"to":`)
+		writeJSON(&b, m.LocalAddr.String())
+	}
+	b.WriteString(`,"attributes":{`)
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		writeJSON(&b, key)
+		b.WriteByte(':')
+		writeJSON(&b, values[key])
+	}
+	b.WriteString("}}\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	b.Truncate(b.Len() - 1) // remove trailing \n
+}
+
+func jsonValue(attr *Attribute) interface{} {
+	if attr.Redacted {
+		return redactedValue
+	}
+	return attr.JSONValue()
+}
+
+func formatLogfmt(w io.Writer, m *Message) error {
+	var b bytes.Buffer
+	b.WriteString("code=")
+	writeLogfmt(&b, m.Code.String())
+	b.WriteString(" id=")
+	b.WriteString(strconv.Itoa(int(m.Identifier)))
+	b.WriteString(" length=")
+	b.WriteString(strconv.Itoa(m.Length))
+	if m.RemoteAddr != nil && m.LocalAddr != nil {
+		b.WriteString(" from=")
+		writeLogfmt(&b, m.RemoteAddr.String())
+		b.WriteString(" to=")
+		writeLogfmt(&b, m.LocalAddr.String())
+	}
+	for _, attr := range m.Attributes {
+		b.WriteByte(' ')
+		b.WriteString(attr.FullName())
+		b.WriteByte('=')
+		if v, ok := attr.Value.(string); ok {
+			writeLogfmt(&b, v)
+		} else if attr.Redacted {
+			writeLogfmt(&b, redactedValue)
+		} else {
+			writeLogfmt(&b, attr.ValueString())
+		}
+	}
+	b.WriteByte('\n')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeLogfmt writes s, quoted if it is empty or contains spaces, quotes,
+// equal signs or non-printable characters.
+func writeLogfmt(b *bytes.Buffer, s string) {
+	quote := s == ""
+	for _, r := range s {
+		if r == '"' || r == '=' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			quote = true
+			break
+		}
+	}
+	if quote {
+		s = strconv.Quote(s)
+	}
+	b.WriteString(s)
+}
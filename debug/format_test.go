@@ -0,0 +1,227 @@
+package debug_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
+	. "layeh.com/radius/rfc2865"
+	. "layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
+)
+
+func formatterRequest() *radius.Request {
+	local, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:1812")
+	remote, _ := net.ResolveUDPAddr("udp4", "10.0.10.3:34521")
+
+	req := &radius.Request{
+		LocalAddr:  local,
+		RemoteAddr: remote,
+		Packet: &radius.Packet{
+			Code:       radius.CodeAccessRequest,
+			Identifier: 5,
+			Secret:     secret,
+		},
+	}
+	UserName_SetString(req.Packet, "Tim Smith")
+	UserPassword_SetString(req.Packet, "12345")
+	NASPort_Set(req.Packet, 3)
+	AcctStatusType_Add(req.Packet, AcctStatusType_Value_Start)
+	return req
+}
+
+func TestFormatters(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Formatter debug.Formatter
+		Output    string
+	}{
+		{
+			"FreeRADIUS",
+			debug.FreeRADIUSFormatter,
+			"Received Access-Request Id 5 from 10.0.10.3:34521 to 127.0.0.1:1812 length 61\n" +
+				"\tUser-Name = \"Tim Smith\"\n" +
+				"\tUser-Password = <redacted>\n" +
+				"\tNAS-Port = 3\n" +
+				"\tAcct-Status-Type = Start\n",
+		},
+		{
+			"JSON",
+			debug.JSONFormatter,
+			`{"code":"Access-Request","id":5,"length":61,"from":"10.0.10.3:34521","to":"127.0.0.1:1812",` +
+				`"attributes":{"User-Name":["Tim Smith"],"User-Password":["<redacted>"],"NAS-Port":[3],"Acct-Status-Type":["Start"]}}` + "\n",
+		},
+		{
+			"logfmt",
+			debug.LogfmtFormatter,
+			`code=Access-Request id=5 length=61 from=10.0.10.3:34521 to=127.0.0.1:1812 ` +
+				`User-Name="Tim Smith" User-Password=<redacted> NAS-Port=3 Acct-Status-Type=Start` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			config := &debug.Config{
+				Dictionary: debug.IncludedDictionary,
+				Formatter:  tt.Formatter,
+			}
+			var b bytes.Buffer
+			debug.DumpRequest(&b, config, formatterRequest())
+			if b.String() != tt.Output {
+				t.Fatalf("\nexpected:\n%s\ngot:\n%s", tt.Output, b.String())
+			}
+		})
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	req := formatterRequest()
+	CHAPPassword_Set(req.Packet, []byte{1, 2, 3})
+
+	config := &debug.Config{
+		Dictionary: debug.IncludedDictionary,
+		Allowed:    []string{"User-Password"},
+	}
+	result := debug.DumpRequestString(config, req)
+	if !strings.Contains(result, `User-Password = "12345"`) || !strings.Contains(result, `CHAP-Password = <redacted>`) {
+		t.Fatalf("got:\n%s", result)
+	}
+
+	config = &debug.Config{
+		Dictionary: debug.IncludedDictionary,
+		Redacted:   []string{"User-Name"},
+	}
+	result = debug.DumpRequestString(config, req)
+	if !strings.Contains(result, `User-Name = <redacted>`) || !strings.Contains(result, `CHAP-Password = 0x010203`) {
+		t.Fatalf("got:\n%s", result)
+	}
+	// attributes with the encrypt flag are always redacted
+	if !strings.Contains(result, `User-Password = <redacted>`) {
+		t.Fatalf("got:\n%s", result)
+	}
+
+	// even if their value cannot be decoded
+	req.Packet.Add(UserPassword_Type, radius.Attribute("abc"))
+	result = debug.DumpRequestString(config, req)
+	if !strings.Contains(result, `2 = <redacted>`) {
+		t.Fatalf("got:\n%s", result)
+	}
+
+	// Allowed takes precedence over Redacted
+	config = &debug.Config{
+		Dictionary: debug.IncludedDictionary,
+		Redacted:   []string{"User-Name", "CHAP-Password"},
+		Allowed:    []string{"User-Name", "3"},
+	}
+	req = formatterRequest()
+	CHAPPassword_Set(req.Packet, []byte{1, 2, 3})
+	result = debug.DumpRequestString(config, req)
+	if !strings.Contains(result, `User-Name = "Tim Smith"`) || !strings.Contains(result, `CHAP-Password = 0x010203`) {
+		t.Fatalf("got:\n%s", result)
+	}
+}
+
+func TestRedaction_oid(t *testing.T) {
+	req := formatterRequest()
+	for _, typ := range []byte{16, 17, 2} {
+		vsa, _ := radius.NewVendorSpecific(311, radius.Attribute{typ, 4, 0xab, 0xcd})
+		req.Packet.Add(26, vsa)
+	}
+
+	// the dictionary has no Microsoft attributes
+	config := &debug.Config{
+		Dictionary: debug.IncludedDictionary,
+	}
+	result := debug.DumpRequestString(config, req)
+	for _, line := range []string{`26.311.16 = <redacted>`, `26.311.17 = <redacted>`, `26.311.2 = 0xabcd`} {
+		if !strings.Contains(result, line) {
+			t.Fatalf("got:\n%s", result)
+		}
+	}
+
+	config.Allowed = []string{"26.311.16"}
+	result = debug.DumpRequestString(config, req)
+	if !strings.Contains(result, `26.311.16 = 0xabcd`) || !strings.Contains(result, `26.311.17 = <redacted>`) {
+		t.Fatalf("got:\n%s", result)
+	}
+}
+
+func TestDumpResponse(t *testing.T) {
+	req := formatterRequest()
+	response := req.Response(radius.CodeAccessAccept)
+	rfc2868.TunnelPassword_AddString(response, 1, "tunnel")
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the received response carries its own authenticator
+	received, err := radius.Parse(wire, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dict, err := dictionary.Merge(debug.IncludedDictionary, &dictionary.Dictionary{
+		Attributes: []*dictionary.Attribute{
+			{Name: "Tunnel-Password", OID: dictionary.OID{69}, Type: dictionary.AttributeString, FlagHasTag: dictionary.BoolFlag{Bool: true, Valid: true}, FlagEncrypt: dictionary.IntFlag{Int: 2, Valid: true}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &debug.Config{
+		Dictionary: dict,
+		Allowed:    []string{"Tunnel-Password"},
+	}
+	expected := "Access-Accept Id 5 from 127.0.0.1:1812 to 10.0.10.3:34521\n" +
+		`  Tunnel-Password:1 = "tunnel"`
+	if result := debug.DumpResponseString(config, req, received); result != expected {
+		t.Fatalf("got:\n%s", result)
+	}
+}
+
+func TestDumpString_emptyFormatter(t *testing.T) {
+	config := &debug.Config{
+		Dictionary: debug.IncludedDictionary,
+		Formatter: debug.FormatterFunc(func(w io.Writer, m *debug.Message) error {
+			return nil
+		}),
+	}
+	req := formatterRequest()
+	if result := debug.DumpString(config, req.Packet); result != "" {
+		t.Fatalf("got %q", result)
+	}
+	if result := debug.DumpRequestString(config, req); result != "" {
+		t.Fatalf("got %q", result)
+	}
+}
+
+func TestFreeRADIUSFormatter_parse(t *testing.T) {
+	req := formatterRequest()
+	config := &debug.Config{
+		Dictionary: debug.IncludedDictionary,
+		Formatter:  debug.FreeRADIUSFormatter,
+		Allowed:    []string{"User-Password"},
+	}
+	output := debug.DumpRequestString(config, req)
+
+	codec := &radius.Codec{
+		Dictionary: debug.IncludedDictionary,
+	}
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.Authenticator = req.Authenticator
+	if err := codec.ParseText(p, output); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != req.Code || p.Identifier != req.Identifier {
+		t.Fatalf("got header %v %d", p.Code, p.Identifier)
+	}
+	if !reflect.DeepEqual(p.Attributes, req.Attributes) {
+		t.Fatalf("got attributes %v\nexpecting %v", p.Attributes, req.Attributes)
+	}
+}
@@ -0,0 +1,227 @@
+package detail_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/detail"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "detail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newRecord(sessionID string, t time.Time) *detail.Record {
+	p := radius.New(radius.CodeAccountingRequest, []byte(`secret`))
+	rfc2866.AcctStatusType_Set(p, rfc2866.AcctStatusType_Value_Start)
+	rfc2866.AcctSessionID_SetString(p, sessionID)
+	rfc2865.UserName_SetString(p, "bob")
+	rfc2865.NASIPAddress_Set(p, net.IPv4(192, 0, 2, 1))
+	return &detail.Record{
+		Time:   t,
+		Packet: p,
+		Src:    &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1024},
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w := &detail.Writer{
+		Dictionary: debug.IncludedDictionary,
+		Path:       filepath.Join(dir, "%{Packet-Src-IP-Address}", "detail-%Y%m%d"),
+	}
+	day := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, r := range []*detail.Record{
+		newRecord("1", day),
+		newRecord("2", day.Add(time.Hour)),
+		newRecord("3", day.Add(24*time.Hour)),
+	} {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "192.0.2.1", "detail-20060102"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Mon Jan  2 15:04:05 2006\n" +
+		"\tAcct-Status-Type = Start\n" +
+		"\tAcct-Session-Id = \"1\"\n" +
+		"\tUser-Name = \"bob\"\n" +
+		"\tNAS-IP-Address = 192.0.2.1\n" +
+		"\tPacket-Src-IP-Address = 192.0.2.1\n" +
+		"\tPacket-Src-IP-Port = 1024\n" +
+		"\tTimestamp = 1136214245\n" +
+		"\n"
+	if !strings.HasPrefix(string(b), expected) || strings.Count(string(b), "Timestamp") != 2 {
+		t.Fatalf("got\n%s", b)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "192.0.2.1", "detail-20060103"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := detail.NewReader(f, debug.IncludedDictionary)
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Time.Equal(day.Add(24*time.Hour)) || rec.Src.String() != "192.0.2.1:1024" || rec.Dst != nil {
+		t.Fatalf("got record %+v", rec)
+	}
+	if rec.Packet.Code != radius.CodeAccountingRequest || rfc2866.AcctSessionID_GetString(rec.Packet) != "3" ||
+		rfc2866.AcctStatusType_Get(rec.Packet) != rfc2866.AcctStatusType_Value_Start || !rfc2865.NASIPAddress_Get(rec.Packet).Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("got packet %v", rec.Packet.Attributes)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("got error %v; expecting EOF", err)
+	}
+}
+
+func TestWriter_maxSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "detail")
+	w := &detail.Writer{
+		Dictionary: debug.IncludedDictionary,
+		Path:       path,
+		MaxSize:    700,
+		Sync:       true,
+	}
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		if err := w.Write(newRecord(strings.Repeat("x", 100), time.Time{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each file holds two records
+	for _, name := range []string{"detail", "detail.1", "detail.2"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > 700 {
+			t.Fatalf("%s: got size %d", name, len(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestWriter_renamed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "detail")
+	w := &detail.Writer{
+		Dictionary: debug.IncludedDictionary,
+		Path:       path,
+	}
+	defer w.Close()
+	if err := w.Write(newRecord("1", time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	// the file is rotated by another program
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(newRecord("2", time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, sessionID := range map[string]string{"detail.old": "1", "detail": "2"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(b), "Timestamp") != 1 || !strings.Contains(string(b), "Acct-Session-Id = \""+sessionID+"\"") {
+			t.Fatalf("%s: got\n%s", name, b)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	const file = `Thu Jun  1 10:00:00 2023
+	Acct-Status-Type = Stop
+	User-Name = "alice"
+	Acct-Session-Time = 3600
+	Acct-Unique-Session-Id = "8f3a"
+	Packet-Dst-IPv6-Address = 2001:db8::1
+	Packet-Dst-IP-Port = 1813
+	Timestamp = 1685613600
+
+Thu Jun  1 10:00:01 2023
+	Acct-Status-Type = Accounting-On
+`
+	r := detail.NewReader(strings.NewReader(file), debug.IncludedDictionary)
+	r.Location = time.UTC
+
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rfc2865.UserName_GetString(rec.Packet) != "alice" || rfc2866.AcctSessionTime_Get(rec.Packet) != 3600 {
+		t.Fatalf("got packet %v", rec.Packet.Attributes)
+	}
+	if !reflect.DeepEqual(rec.Extra, map[string]string{"Acct-Unique-Session-Id": "8f3a"}) {
+		t.Fatalf("got extra %v", rec.Extra)
+	}
+	if rec.Dst.String() != "[2001:db8::1]:1813" || rec.Time.Unix() != 1685613600 {
+		t.Fatalf("got record %+v", rec)
+	}
+
+	rec, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Time.Equal(time.Date(2023, 6, 1, 10, 0, 1, 0, time.UTC)) || rfc2866.AcctStatusType_Get(rec.Packet) != rfc2866.AcctStatusType_Value_AccountingOn {
+		t.Fatalf("got record %+v", rec)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("got error %v; expecting EOF", err)
+	}
+}
+
+func TestReader_errors(t *testing.T) {
+	tests := []struct {
+		File  string
+		Error string
+	}{
+		{"yesterday\n", `detail: line 1: invalid record time "yesterday"`},
+		{"\tUser-Name = \"bob\"\n", "detail: line 1: attribute outside of record"},
+		{"Thu Jun  1 10:00:00 2023\n\tNAS-Port = x\n", `detail: line 2: radius: line 1: NAS-Port: invalid integer value "x"`},
+		{"Thu Jun  1 10:00:00 2023\n\tTimestamp = now\n", `detail: line 2: invalid Timestamp "now"`},
+		{"Thu Jun  1 10:00:00 2023\nThu Jun  1 10:00:00 2023\n", "detail: line 2: expected blank line before record"},
+	}
+	for _, tt := range tests {
+		r := detail.NewReader(strings.NewReader(tt.File), debug.IncludedDictionary)
+		if _, err := r.Next(); err == nil || err.Error() != tt.Error {
+			t.Errorf("%q: got error %v; expecting %q", tt.File, err, tt.Error)
+		}
+	}
+}
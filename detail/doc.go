@@ -0,0 +1,18 @@
+// Package detail writes and reads accounting requests in the FreeRADIUS
+// detail file format.
+//
+// A detail file is a list of records separated by blank lines. Each record
+// starts with the time at which the request was received, followed by the
+// attributes of the request and additional information about it:
+//
+//	Mon Jan  2 15:04:05 2006
+//		Acct-Status-Type = Start
+//		Acct-Session-Id = "4d2b0f1c"
+//		User-Name = "bob"
+//		NAS-IP-Address = 192.0.2.1
+//		Packet-Src-IP-Address = 192.0.2.1
+//		Packet-Src-IP-Port = 1024
+//		Timestamp = 1136214245
+//
+// API is currently unstable.
+package detail
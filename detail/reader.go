@@ -0,0 +1,153 @@
+package detail
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+)
+
+// Reader reads the records of a detail file.
+type Reader struct {
+	// Location is the time zone of the first line of records, which is
+	// used if a record does not have a Timestamp. Defaults to time.Local.
+	Location *time.Location
+
+	codec *radius.Codec
+	s     *bufio.Scanner
+	line  int
+}
+
+// NewReader returns a Reader of the detail file r, whose attributes are
+// resolved using the dictionary d.
+func NewReader(r io.Reader, d *dictionary.Dictionary) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	return &Reader{
+		codec: &radius.Codec{
+			Dictionary: d,
+		},
+		s: s,
+	}
+}
+
+// Next returns the next record of the file. io.EOF is returned at the end of
+// the file.
+//
+// The packets of records are Accounting-Requests without a secret and
+// identifier, which must be set before they are encoded.
+func (r *Reader) Next() (*Record, error) {
+	var rec *Record
+	for r.s.Scan() {
+		r.line++
+		line := r.s.Text()
+		if strings.TrimSpace(line) == "" {
+			if rec != nil {
+				return rec, nil
+			}
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			if rec != nil {
+				return nil, r.errorf("expected blank line before record")
+			}
+			loc := r.Location
+			if loc == nil {
+				loc = time.Local
+			}
+			t, err := time.ParseInLocation(timeLayout, strings.TrimSpace(line), loc)
+			if err != nil {
+				return nil, r.errorf("invalid record time %q", line)
+			}
+			rec = &Record{
+				Time:   t,
+				Packet: radius.New(radius.CodeAccountingRequest, nil),
+			}
+			continue
+		}
+
+		if rec == nil {
+			return nil, r.errorf("attribute outside of record")
+		}
+		if err := r.parseAttribute(rec, strings.TrimSpace(line)); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	if rec != nil {
+		return rec, nil
+	}
+	return nil, io.EOF
+}
+
+func (r *Reader) parseAttribute(rec *Record, line string) error {
+	i := strings.Index(line, " = ")
+	if i < 0 {
+		return r.errorf("expected attribute")
+	}
+	name, value := line[:i], strings.TrimSpace(line[i+3:])
+
+	switch name {
+	case "Timestamp":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return r.errorf("invalid Timestamp %q", value)
+		}
+		rec.Time = time.Unix(n, 0).In(rec.Time.Location())
+		return nil
+	case "Packet-Src-IP-Address", "Packet-Src-IPv6-Address", "Packet-Src-IP-Port":
+		return r.parseAddress(&rec.Src, name, value)
+	case "Packet-Dst-IP-Address", "Packet-Dst-IPv6-Address", "Packet-Dst-IP-Port":
+		return r.parseAddress(&rec.Dst, name, value)
+	}
+
+	if _, err := r.codec.Gets(rec.Packet, name); err != nil {
+		if _, ok := err.(*radius.UnknownAttributeError); ok {
+			if rec.Extra == nil {
+				rec.Extra = make(map[string]string)
+			}
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			rec.Extra[name] = value
+			return nil
+		}
+	}
+	if err := r.codec.ParseText(rec.Packet, line); err != nil {
+		return r.errorf("%v", err)
+	}
+	return nil
+}
+
+func (r *Reader) parseAddress(addr **net.UDPAddr, name, value string) error {
+	if *addr == nil {
+		*addr = &net.UDPAddr{}
+	}
+	if strings.HasSuffix(name, "-Port") {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return r.errorf("invalid %s %q", name, value)
+		}
+		(*addr).Port = int(port)
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return r.errorf("invalid %s %q", name, value)
+	}
+	(*addr).IP = ip
+	return nil
+}
+
+func (r *Reader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("detail: line %d: "+format, append([]interface{}{r.line}, args...)...)
+}
@@ -0,0 +1,301 @@
+package detail
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+)
+
+// Record is a record of a detail file.
+type Record struct {
+	// Time is the time at which the request was received.
+	Time time.Time
+	// Packet is the accounting request.
+	Packet *radius.Packet
+	// Src and Dst are the addresses from and to which the request was
+	// sent. They are optional.
+	Src, Dst *net.UDPAddr
+	// Extra are the attributes of the record that are neither attributes
+	// of the dictionary, nor the addresses and time of the record, such as
+	// attributes added by FreeRADIUS. They are only set by Reader.
+	Extra map[string]string
+}
+
+// timeLayout is the layout of the first line of records.
+const timeLayout = "Mon Jan _2 15:04:05 2006"
+
+// maxOpenFiles is the number of files that a Writer keeps open.
+const maxOpenFiles = 64
+
+// maxIdle is how long a Writer keeps a file open without writing to it, so
+// that files whose path is no longer current, such as the file of the
+// previous day, are closed.
+const maxIdle = time.Minute
+
+// Writer appends records to detail files.
+//
+// The path of the file to which a record is written may contain the
+// placeholders %Y, %m, %d, %H and %M, which are replaced by the year,
+// month, day, hour and minute of the time of the record, to rotate the file
+// periodically. The placeholder %{Name} is replaced by the value of the
+// attribute Name of the record, or of one of the address attributes
+// Packet-Src-IP-Address, Packet-Src-IPv6-Address, Packet-Dst-IP-Address and
+// Packet-Dst-IPv6-Address.
+//
+// Files may be rotated by other programs: a file that is renamed or removed
+// is created again at its path by the next write.
+type Writer struct {
+	// Dictionary is used to write the names and values of attributes.
+	Dictionary *dictionary.Dictionary
+
+	// Path is the path of the detail file, with placeholders.
+	Path string
+
+	// MaxSize is the size in bytes after which a file is rotated, by
+	// renaming it to its path followed by ".1", ".2", and so on. The size of
+	// files is not limited if MaxSize is zero.
+	MaxSize int64
+
+	// Sync specifies whether files are synced to stable storage after each
+	// record is written.
+	Sync bool
+
+	mu    sync.Mutex
+	files map[string]*detailFile
+}
+
+type detailFile struct {
+	f    *os.File
+	info os.FileInfo
+	size int64
+	// used is the time at which the file was last written to
+	used time.Time
+}
+
+// Write appends r to the detail file of its path. If r.Time is zero, the
+// current time is used.
+func (w *Writer) Write(r *Record) error {
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	codec := &radius.Codec{
+		Dictionary: w.Dictionary,
+	}
+	attrs := codec.Decode(r.Packet)
+
+	var b bytes.Buffer
+	b.WriteString(t.Format(timeLayout))
+	b.WriteByte('\n')
+	for _, attr := range attrs {
+		fmt.Fprintf(&b, "\t%s = %s\n", attr.FullName(), attr.ValueString())
+	}
+	for _, addr := range addressAttributes(r) {
+		fmt.Fprintf(&b, "\t%s = %s\n", addr[0], addr[1])
+	}
+	fmt.Fprintf(&b, "\tTimestamp = %d\n\n", t.Unix())
+
+	path := w.expand(t, attrs, r)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	df, err := w.open(path)
+	if err != nil {
+		return err
+	}
+	if w.MaxSize > 0 && df.size > 0 && df.size+int64(b.Len()) > w.MaxSize {
+		if df, err = w.rotate(path); err != nil {
+			return err
+		}
+	}
+	n, err := df.f.Write(b.Bytes())
+	df.size += int64(n)
+	df.used = time.Now()
+	if err == nil && w.Sync {
+		err = df.f.Sync()
+	}
+	return err
+}
+
+// open returns the open file of path, opening it if needed. The open file is
+// reopened if path no longer refers to it, such as after it was renamed or
+// removed by another program.
+func (w *Writer) open(path string) (*detailFile, error) {
+	w.closeIdle()
+	if df := w.files[path]; df != nil {
+		if info, err := os.Stat(path); err == nil && os.SameFile(info, df.info) {
+			return df, nil
+		}
+		df.f.Close()
+		delete(w.files, path)
+	}
+	if len(w.files) >= maxOpenFiles {
+		w.closeFiles()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if w.files == nil {
+		w.files = make(map[string]*detailFile)
+	}
+	df := &detailFile{
+		f:    f,
+		info: info,
+		size: info.Size(),
+		used: time.Now(),
+	}
+	w.files[path] = df
+	return df, nil
+}
+
+// rotate renames the file of path to the first unused rotated name, and
+// returns a new file of path.
+func (w *Writer) rotate(path string) (*detailFile, error) {
+	if df := w.files[path]; df != nil {
+		df.f.Close()
+		delete(w.files, path)
+	}
+	for i := 1; ; i++ {
+		rotated := path + "." + strconv.Itoa(i)
+		if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+			if err := os.Rename(path, rotated); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return w.open(path)
+}
+
+// closeIdle closes the files that have not been written to for maxIdle.
+func (w *Writer) closeIdle() {
+	now := time.Now()
+	for path, df := range w.files {
+		if now.Sub(df.used) > maxIdle {
+			df.f.Close()
+			delete(w.files, path)
+		}
+	}
+}
+
+func (w *Writer) closeFiles() error {
+	var err error
+	for path, df := range w.files {
+		if closeErr := df.f.Close(); err == nil {
+			err = closeErr
+		}
+		delete(w.files, path)
+	}
+	return err
+}
+
+// Close closes the open files of w. w may be used after it is closed, in
+// which case files are opened again.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFiles()
+}
+
+// addressAttributes returns the names and values of the address attributes
+// of r.
+func addressAttributes(r *Record) [][2]string {
+	var attrs [][2]string
+	for _, addr := range []struct {
+		prefix string
+		addr   *net.UDPAddr
+	}{
+		{"Packet-Src-", r.Src},
+		{"Packet-Dst-", r.Dst},
+	} {
+		if addr.addr == nil || addr.addr.IP == nil {
+			continue
+		}
+		if addr.addr.IP.To4() != nil {
+			attrs = append(attrs, [2]string{addr.prefix + "IP-Address", addr.addr.IP.String()})
+		} else {
+			attrs = append(attrs, [2]string{addr.prefix + "IPv6-Address", addr.addr.IP.String()})
+		}
+		if addr.addr.Port != 0 {
+			attrs = append(attrs, [2]string{addr.prefix + "IP-Port", strconv.Itoa(addr.addr.Port)})
+		}
+	}
+	return attrs
+}
+
+// expand replaces the placeholders of w.Path.
+func (w *Writer) expand(t time.Time, attrs []*radius.DecodedAttribute, r *Record) string {
+	path := strings.NewReplacer(
+		"%Y", t.Format("2006"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%H", t.Format("15"),
+		"%M", t.Format("04"),
+	).Replace(w.Path)
+
+	var b strings.Builder
+	for {
+		start := strings.Index(path, "%{")
+		end := -1
+		if start >= 0 {
+			end = strings.IndexByte(path[start:], '}')
+		}
+		if end < 0 {
+			b.WriteString(path)
+			return b.String()
+		}
+		end += start
+		b.WriteString(path[:start])
+		b.WriteString(w.attributeValue(path[start+2:end], attrs, r))
+		path = path[end+1:]
+	}
+}
+
+// attributeValue returns the value of the attribute name of a record, as
+// used in paths.
+func (w *Writer) attributeValue(name string, attrs []*radius.DecodedAttribute, r *Record) string {
+	value := ""
+	for _, addr := range addressAttributes(r) {
+		if addr[0] == name {
+			value = addr[1]
+		}
+	}
+	for _, attr := range attrs {
+		if attr.Name == name || attr.FullName() == name {
+			value = attr.ValueString()
+			if s, ok := attr.Value.(string); ok && attr.ValueName == "" {
+				value = s
+			}
+			break
+		}
+	}
+	// values must not escape the directory of the path
+	value = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "." || value == ".." {
+		value = "_"
+	}
+	return value
+}
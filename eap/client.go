@@ -0,0 +1,131 @@
+package eap
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+// Client runs the EAP conversations of peers with a RADIUS server, relaying
+// the EAP packets in Access-Requests as a NAS would (RFC 3579).
+type Client struct {
+	// Client exchanges the Access-Requests with the server. If nil,
+	// radius.DefaultClient is used.
+	Client *radius.Client
+
+	// MaxRounds limits the number of Access-Challenge rounds of a
+	// conversation. Defaults to 50.
+	MaxRounds int
+}
+
+// Errors returned by Client.Authenticate for invalid server responses.
+var (
+	ErrMissingMessage  = errors.New("eap: response without EAP-Message")
+	ErrUnexpectedCode  = errors.New("eap: EAP code does not match RADIUS code")
+	ErrTooManyRounds   = errors.New("eap: too many rounds")
+	ErrUnexpectedReply = errors.New("eap: unexpected RADIUS response code")
+)
+
+// Authenticate runs the EAP conversation of peer with the RADIUS server at
+// addr, starting with the peer's identity, and returns the server's final
+// Access-Accept or Access-Reject.
+//
+// Each Access-Request carries the attributes and secret of request, the
+// peer's EAP-Response split across EAP-Message attributes, the State of the
+// last Access-Challenge, and a Message-Authenticator. User-Name is set to
+// the peer's identity unless request contains one. The Message-Authenticator
+// and EAP-Message of every response are verified.
+func (c *Client) Authenticate(ctx context.Context, addr string, request *radius.Packet, peer *Peer) (*radius.Packet, error) {
+	client := c.Client
+	if client == nil {
+		client = radius.DefaultClient
+	}
+	maxRounds := c.MaxRounds
+	if maxRounds <= 0 {
+		maxRounds = 50
+	}
+
+	var identifier [1]byte
+	if _, err := rand.Read(identifier[:]); err != nil {
+		return nil, err
+	}
+	msg, err := peer.Respond(&Packet{
+		Code:       CodeRequest,
+		Identifier: identifier[0],
+		Type:       TypeIdentity,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var state []byte
+	for round := 0; round < maxRounds; round++ {
+		packet := radius.New(radius.CodeAccessRequest, request.Secret)
+		for _, avp := range request.Attributes {
+			switch avp.Type {
+			case rfc2869.EAPMessage_Type, rfc2865.State_Type, rfc2869.MessageAuthenticator_Type:
+				continue
+			}
+			packet.Add(avp.Type, avp.Attribute)
+		}
+		if _, ok := packet.Lookup(rfc2865.UserName_Type); !ok {
+			if err := rfc2865.UserName_SetString(packet, peer.Identity); err != nil {
+				return nil, err
+			}
+		}
+		if err := SetMessage(packet, msg); err != nil {
+			return nil, err
+		}
+		if state != nil {
+			if err := rfc2865.State_Set(packet, state); err != nil {
+				return nil, err
+			}
+		}
+		if err := rfc2869.MessageAuthenticator_Sign(packet); err != nil {
+			return nil, err
+		}
+
+		response, err := client.Exchange(ctx, packet, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := rfc2869.MessageAuthenticator_Verify(response, packet); err != nil {
+			return nil, err
+		}
+		reply, err := LookupMessage(response)
+		if err == radius.ErrNoAttribute {
+			return nil, ErrMissingMessage
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch response.Code {
+		case radius.CodeAccessAccept:
+			if reply.Code != CodeSuccess {
+				return nil, ErrUnexpectedCode
+			}
+			return response, nil
+		case radius.CodeAccessReject:
+			if reply.Code != CodeFailure {
+				return nil, ErrUnexpectedCode
+			}
+			return response, nil
+		case radius.CodeAccessChallenge:
+			if reply.Code != CodeRequest {
+				return nil, ErrUnexpectedCode
+			}
+		default:
+			return nil, ErrUnexpectedReply
+		}
+
+		state = rfc2865.State_Get(response)
+		if msg, err = peer.Respond(reply); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrTooManyRounds
+}
@@ -0,0 +1,64 @@
+package eap
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func TestClient(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nasIdentifiers := make(chan string, 1)
+	server := &Server{
+		Methods: []Method{&echoMethod{typ: TypeGTC}},
+		Authorize: func(s *Session, response *radius.Packet) error {
+			nasIdentifiers <- rfc2865.NASIdentifier_GetString(s.Request.Packet)
+			return nil
+		},
+	}
+	packetServer := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource(testSecret),
+		Handler:      server,
+	}
+	go packetServer.Serve(pc)
+	defer packetServer.Shutdown(context.Background())
+
+	client := &Client{
+		Client: &radius.Client{Retry: 50 * time.Millisecond},
+	}
+	request := radius.New(radius.CodeAccessRequest, testSecret)
+	rfc2865.NASIdentifier_SetString(request, "switch1")
+
+	tests := []struct {
+		Method Type
+		Code   radius.Code
+	}{
+		{TypeGTC, radius.CodeAccessAccept},
+		{TypeOTP, radius.CodeAccessReject},
+	}
+	for _, tt := range tests {
+		peer := &Peer{
+			Identity: "tim",
+			Methods:  []PeerMethod{&echoPeer{typ: tt.Method}},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		response, err := client.Authenticate(ctx, pc.LocalAddr().String(), request, peer)
+		cancel()
+		if err != nil {
+			t.Fatalf("%v: %v", tt.Method, err)
+		}
+		if response.Code != tt.Code {
+			t.Fatalf("%v: got %v, expected %v", tt.Method, response.Code, tt.Code)
+		}
+	}
+	if nasIdentifier := <-nasIdentifiers; nasIdentifier != "switch1" {
+		t.Fatalf("got NAS-Identifier %q", nasIdentifier)
+	}
+}
@@ -0,0 +1,16 @@
+// Package eap implements the Extensible Authentication Protocol (RFC 3748)
+// over RADIUS (RFC 3579).
+//
+// Packet and its helpers encode EAP packets and carry them in the
+// EAP-Message attributes of RADIUS packets. Server terminates EAP
+// conversations: it tracks each conversation across Access-Challenge rounds
+// using the State attribute, and dispatches EAP-Responses to pluggable
+// Method implementations. Peer is the peer side of a conversation, dispatching
+// EAP-Requests to PeerMethod implementations, and Client runs a Peer's
+// conversation with a RADIUS server, as a NAS would.
+//
+// Methods are implemented in the subpackages eaptls, peap, ttls, eapmschapv2,
+// eapmd5 and eapgtc.
+//
+// API is currently unstable.
+package eap
@@ -0,0 +1,82 @@
+// Package eapgtc implements EAP Generic Token Card (RFC 3748, 5.6), which
+// carries one-time passwords and other token card responses in the clear.
+//
+// EAP-GTC neither protects the response nor derives keys. Outside of a
+// tunnel it should only be used for lab and test setups.
+package eapgtc
+
+import (
+	"errors"
+
+	"layeh.com/radius/eap"
+)
+
+// Method is the authenticator side of EAP-GTC.
+type Method struct {
+	// Message is displayed by the peer when prompting for the response.
+	// Defaults to "Password: ".
+	Message string
+
+	// Verify reports whether the response entered by the user is valid for
+	// the session's identity.
+	Verify func(s *eap.Session, response []byte) (bool, error)
+}
+
+// Type implements eap.Method.
+func (m *Method) Type() eap.Type {
+	return eap.TypeGTC
+}
+
+// Start implements eap.Method.
+func (m *Method) Start(s *eap.Session) (eap.Conversation, []byte, error) {
+	if m.Verify == nil {
+		return nil, nil, errors.New("eapgtc: nil Verify")
+	}
+	message := m.Message
+	if message == "" {
+		message = "Password: "
+	}
+	return &conversation{method: m, session: s}, []byte(message), nil
+}
+
+type conversation struct {
+	method  *Method
+	session *eap.Session
+}
+
+func (c *conversation) Process(response []byte) (eap.Status, []byte, error) {
+	ok, err := c.method.Verify(c.session, response)
+	if err != nil || !ok {
+		return eap.StatusFailure, nil, err
+	}
+	return eap.StatusSuccess, nil, nil
+}
+
+// Peer is the peer side of EAP-GTC.
+type Peer struct {
+	// Response returns the response to the authenticator's message, such as
+	// the current token code. If nil, Password is sent.
+	Response func(message string) ([]byte, error)
+
+	// Password is the static response used if Response is nil.
+	Password []byte
+}
+
+// Type implements eap.PeerMethod.
+func (p *Peer) Type() eap.Type {
+	return eap.TypeGTC
+}
+
+// Start implements eap.PeerMethod. The method is stateless, so p is its own
+// conversation.
+func (p *Peer) Start() (eap.PeerConversation, error) {
+	return p, nil
+}
+
+// Respond implements eap.PeerConversation.
+func (p *Peer) Respond(request *eap.Packet) ([]byte, error) {
+	if p.Response != nil {
+		return p.Response(string(request.Data))
+	}
+	return p.Password, nil
+}
@@ -0,0 +1,57 @@
+package eapgtc
+
+import (
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/internal/eaptest"
+)
+
+func TestMethod(t *testing.T) {
+	server := &eap.Server{
+		Methods: []eap.Method{
+			&Method{
+				Message: "Token: ",
+				Verify: func(s *eap.Session, response []byte) (bool, error) {
+					return s.Identity == "tim" && string(response) == "123456", nil
+				},
+			},
+		},
+	}
+
+	var message string
+	peer := &eap.Peer{
+		Identity: "tim",
+		Methods: []eap.PeerMethod{
+			&Peer{
+				Response: func(m string) ([]byte, error) {
+					message = m
+					return []byte("123456"), nil
+				},
+			},
+		},
+	}
+	response, err := eaptest.Authenticate(server, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %v", response.Code)
+	}
+	if message != "Token: " {
+		t.Fatalf("got message %q", message)
+	}
+
+	peer = &eap.Peer{
+		Identity: "tim",
+		Methods:  []eap.PeerMethod{&Peer{Password: []byte("654321")}},
+	}
+	response, err = eaptest.Authenticate(server, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v", response.Code)
+	}
+}
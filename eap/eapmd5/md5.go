@@ -0,0 +1,115 @@
+// Package eapmd5 implements EAP-MD5-Challenge (RFC 3748, 5.4), the EAP
+// encapsulation of CHAP.
+//
+// EAP-MD5 neither authenticates the server nor derives keys, and is
+// vulnerable to offline dictionary attacks. It is intended for lab and test
+// setups.
+package eapmd5
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/rfc1994"
+)
+
+const challengeLength = 16
+
+// Method is the authenticator side of EAP-MD5.
+type Method struct {
+	// Password returns the cleartext password of the user with the given
+	// identity. Returning a nil password fails the authentication.
+	Password func(s *eap.Session, identity string) ([]byte, error)
+
+	// Name is sent in the Name field of the challenge. It is optional.
+	Name string
+}
+
+// Type implements eap.Method.
+func (m *Method) Type() eap.Type {
+	return eap.TypeMD5Challenge
+}
+
+// Start implements eap.Method.
+func (m *Method) Start(s *eap.Session) (eap.Conversation, []byte, error) {
+	if m.Password == nil {
+		return nil, nil, errors.New("eapmd5: nil Password")
+	}
+	c := &conversation{
+		method:    m,
+		session:   s,
+		challenge: make([]byte, challengeLength),
+	}
+	if _, err := rand.Read(c.challenge); err != nil {
+		return nil, nil, err
+	}
+	return c, encode(c.challenge, m.Name), nil
+}
+
+type conversation struct {
+	method    *Method
+	session   *eap.Session
+	challenge []byte
+}
+
+func (c *conversation) Process(response []byte) (eap.Status, []byte, error) {
+	value, _, err := parse(response)
+	if err != nil {
+		return eap.StatusFailure, nil, err
+	}
+	password, err := c.method.Password(c.session, c.session.Identity)
+	if err != nil || password == nil {
+		return eap.StatusFailure, nil, err
+	}
+	expected := rfc1994.ChallengeResponse(c.session.Identifier, password, c.challenge)
+	if subtle.ConstantTimeCompare(value, expected) != 1 {
+		return eap.StatusFailure, nil, nil
+	}
+	return eap.StatusSuccess, nil, nil
+}
+
+// Peer is the peer side of EAP-MD5.
+type Peer struct {
+	// Password is the secret shared with the authenticator.
+	Password []byte
+	// Name is sent in the Name field of the response. It is optional.
+	Name string
+}
+
+// Type implements eap.PeerMethod.
+func (p *Peer) Type() eap.Type {
+	return eap.TypeMD5Challenge
+}
+
+// Start implements eap.PeerMethod. The method is stateless, so p is its own
+// conversation.
+func (p *Peer) Start() (eap.PeerConversation, error) {
+	return p, nil
+}
+
+// Respond implements eap.PeerConversation.
+func (p *Peer) Respond(request *eap.Packet) ([]byte, error) {
+	challenge, _, err := parse(request.Data)
+	if err != nil {
+		return nil, err
+	}
+	return encode(rfc1994.ChallengeResponse(request.Identifier, p.Password, challenge), p.Name), nil
+}
+
+// parse splits the Type-Data of EAP-MD5 into its Value and Name fields.
+func parse(b []byte) (value []byte, name string, err error) {
+	if len(b) < 1 || int(b[0]) > len(b)-1 || b[0] == 0 {
+		return nil, "", errors.New("eapmd5: invalid Value-Size")
+	}
+	size := int(b[0])
+	return b[1 : 1+size], string(b[1+size:]), nil
+}
+
+func encode(value []byte, name string) []byte {
+	b := make([]byte, 1, 1+len(value)+len(name))
+	b[0] = byte(len(value))
+	b = append(b, value...)
+	return append(b, name...)
+}
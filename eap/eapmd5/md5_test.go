@@ -0,0 +1,78 @@
+package eapmd5
+
+import (
+	"bytes"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/internal/eaptest"
+)
+
+func testServer() *eap.Server {
+	return &eap.Server{
+		Methods: []eap.Method{
+			&Method{
+				Password: func(s *eap.Session, identity string) ([]byte, error) {
+					if identity != "tim" {
+						return nil, nil
+					}
+					return []byte("clientPass"), nil
+				},
+			},
+		},
+	}
+}
+
+func TestMethod(t *testing.T) {
+	tests := []struct {
+		Identity string
+		Password string
+		Code     radius.Code
+	}{
+		{"tim", "clientPass", radius.CodeAccessAccept},
+		{"tim", "wrong", radius.CodeAccessReject},
+		{"unknown", "clientPass", radius.CodeAccessReject},
+	}
+	for _, tt := range tests {
+		peer := &eap.Peer{
+			Identity: tt.Identity,
+			Methods:  []eap.PeerMethod{&Peer{Password: []byte(tt.Password)}},
+		}
+		response, err := eaptest.Authenticate(testServer(), peer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != tt.Code {
+			t.Fatalf("%s/%s: got %v, expected %v", tt.Identity, tt.Password, response.Code, tt.Code)
+		}
+	}
+}
+
+func TestPeer(t *testing.T) {
+	// rfc1994 response: MD5(Identifier || secret || challenge)
+	p := &Peer{Password: []byte("secret"), Name: "tim"}
+	response, err := p.Respond(&eap.Packet{
+		Code:       eap.CodeRequest,
+		Identifier: 1,
+		Type:       eap.TypeMD5Challenge,
+		Data:       encode([]byte("0123456789abcdef"), ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, name, err := parse(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) != 16 || name != "tim" {
+		t.Fatalf("got %x, %q", value, name)
+	}
+
+	if _, err := p.Respond(&eap.Packet{Data: []byte{17, 1, 2}}); err == nil {
+		t.Fatal("expected error")
+	}
+	if !bytes.Equal(encode([]byte{1, 2}, "x"), []byte{2, 1, 2, 'x'}) {
+		t.Fatal("unexpected encoding")
+	}
+}
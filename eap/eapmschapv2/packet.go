@@ -0,0 +1,126 @@
+// Package eapmschapv2 implements EAP-MSCHAPv2
+// (draft-kamath-pppext-eap-mschapv2), most commonly used as the inner method
+// of PEAP.
+package eapmschapv2
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc3079"
+)
+
+// EAP-MSCHAPv2 OpCodes
+const (
+	OpChallenge      = 1
+	OpResponse       = 2
+	OpSuccess        = 3
+	OpFailure        = 4
+	OpChangePassword = 7
+)
+
+const (
+	challengeLength = 16
+	responseLength  = 49
+)
+
+// packet is an EAP-MSCHAPv2 packet. For Challenge and Response packets,
+// value and name hold the Value and Name fields; for Success and Failure
+// packets sent by the authenticator, message holds the message.
+type packet struct {
+	op      byte
+	id      byte
+	value   []byte
+	name    string
+	message string
+}
+
+func parse(b []byte) (*packet, error) {
+	if len(b) < 1 {
+		return nil, errors.New("eapmschapv2: empty packet")
+	}
+	p := &packet{
+		op: b[0],
+	}
+	switch p.op {
+	case OpSuccess, OpFailure:
+		if len(b) == 1 {
+			// peer acknowledgement
+			return p, nil
+		}
+	}
+	if len(b) < 4 {
+		return nil, errors.New("eapmschapv2: packet too short")
+	}
+	p.id = b[1]
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < 4 || length > len(b) {
+		return nil, errors.New("eapmschapv2: invalid MS-Length")
+	}
+	b = b[4:length]
+
+	switch p.op {
+	case OpChallenge, OpResponse:
+		if len(b) < 1 || int(b[0]) > len(b)-1 {
+			return nil, errors.New("eapmschapv2: invalid Value-Size")
+		}
+		size := int(b[0])
+		p.value = append([]byte(nil), b[1:1+size]...)
+		p.name = string(b[1+size:])
+	case OpSuccess, OpFailure:
+		p.message = string(b)
+	default:
+		return nil, errors.New("eapmschapv2: unsupported OpCode")
+	}
+	return p, nil
+}
+
+func (p *packet) encode() []byte {
+	var b []byte
+	switch p.op {
+	case OpChallenge, OpResponse:
+		b = make([]byte, 5, 5+len(p.value)+len(p.name))
+		b[4] = byte(len(p.value))
+		b = append(b, p.value...)
+		b = append(b, p.name...)
+	default:
+		b = make([]byte, 4, 4+len(p.message))
+		b = append(b, p.message...)
+	}
+	b[0] = p.op
+	b[1] = p.id
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+// stripDomain returns the user name without a prepended domain, as used in
+// the challenge hash - rfc2759, 8.2
+func stripDomain(name string) string {
+	if i := strings.LastIndexByte(name, '\\'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// msk returns the 32 octet MSK of EAP-MSCHAPv2: the authenticator's
+// MS-MPPE-Recv-Key followed by its MS-MPPE-Send-Key.
+func msk(ntResponse, password []byte) ([]byte, error) {
+	ucs2Password, err := rfc2759.ToUTF16(password)
+	if err != nil {
+		return nil, err
+	}
+	passwordHashHash := rfc2759.NTPasswordHash(rfc2759.NTPasswordHash(ucs2Password))
+	masterKey := rfc3079.GetMasterKey(passwordHashHash, ntResponse)
+
+	recvKey, err := rfc3079.GetAsymmetricStartKey(masterKey, rfc3079.KeyLength128Bit, false)
+	if err != nil {
+		return nil, err
+	}
+	sendKey, err := rfc3079.GetAsymmetricStartKey(masterKey, rfc3079.KeyLength128Bit, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(recvKey, sendKey...), nil
+}
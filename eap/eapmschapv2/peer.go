@@ -0,0 +1,105 @@
+package eapmschapv2
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/rfc2433"
+	"layeh.com/radius/rfc2759"
+)
+
+// Peer is the peer side of EAP-MSCHAPv2.
+type Peer struct {
+	// Name is the user name sent in the response, optionally prefixed with a
+	// domain ("DOMAIN\user").
+	Name string
+	// Password is the user's cleartext password.
+	Password []byte
+}
+
+// Type implements eap.PeerMethod.
+func (p *Peer) Type() eap.Type {
+	return eap.TypeMSCHAPv2
+}
+
+// Start implements eap.PeerMethod.
+func (p *Peer) Start() (eap.PeerConversation, error) {
+	return &peerConversation{peer: p}, nil
+}
+
+type peerConversation struct {
+	peer *Peer
+
+	challenge     []byte
+	peerChallenge []byte
+	ntResponse    []byte
+	msk           []byte
+}
+
+// Respond implements eap.PeerConversation.
+func (c *peerConversation) Respond(request *eap.Packet) ([]byte, error) {
+	p, err := parse(request.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.op {
+	case OpChallenge:
+		if len(p.value) != challengeLength {
+			return nil, errors.New("eapmschapv2: invalid challenge")
+		}
+		c.challenge = p.value
+		c.peerChallenge = make([]byte, 16)
+		if _, err := rand.Read(c.peerChallenge); err != nil {
+			return nil, err
+		}
+		username := []byte(stripDomain(c.peer.Name))
+		c.ntResponse, err = rfc2759.GenerateNTResponse(c.challenge, c.peerChallenge, username, c.peer.Password)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, responseLength)
+		copy(value, c.peerChallenge)
+		copy(value[24:], c.ntResponse)
+		response := &packet{
+			op:    OpResponse,
+			id:    p.id,
+			value: value,
+			name:  c.peer.Name,
+		}
+		return response.encode(), nil
+
+	case OpSuccess:
+		if c.ntResponse == nil {
+			return nil, errors.New("eapmschapv2: unexpected Success")
+		}
+		username := []byte(stripDomain(c.peer.Name))
+		expected, err := rfc2759.GenerateAuthenticatorResponse(c.challenge, c.peerChallenge, c.ntResponse, username, c.peer.Password)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.message) < len(expected) || subtle.ConstantTimeCompare([]byte(p.message[:len(expected)]), []byte(expected)) != 1 {
+			return nil, errors.New("eapmschapv2: invalid authenticator response")
+		}
+		if c.msk, err = msk(c.ntResponse, c.peer.Password); err != nil {
+			return nil, err
+		}
+		return []byte{OpSuccess}, nil
+
+	case OpFailure:
+		// the failure is reported by the following EAP-Failure
+		if _, err := rfc2433.ParseError(p.message); err != nil {
+			return nil, err
+		}
+		return []byte{OpFailure}, nil
+	}
+	return nil, errors.New("eapmschapv2: unsupported OpCode")
+}
+
+// Keys returns the MSK once the authenticator has been authenticated.
+// EAP-MSCHAPv2 derives no EMSK.
+func (c *peerConversation) Keys() (msk, emsk []byte) {
+	return c.msk, nil
+}
@@ -0,0 +1,62 @@
+package eapmschapv2
+
+import (
+	"bytes"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/internal/eaptest"
+)
+
+func TestPeer(t *testing.T) {
+	tests := []struct {
+		Password string
+		Code     radius.Code
+	}{
+		{"clientPass", radius.CodeAccessAccept},
+		{"wrong", radius.CodeAccessReject},
+	}
+	for _, tt := range tests {
+		var session *eap.Session
+		server := &eap.Server{
+			Methods: []eap.Method{testMethod()},
+			Authorize: func(s *eap.Session, response *radius.Packet) error {
+				session = s
+				return nil
+			},
+		}
+		peer := &eap.Peer{
+			Identity: "tim",
+			Methods: []eap.PeerMethod{
+				&Peer{Name: `EXAMPLE\tim`, Password: []byte(tt.Password)},
+			},
+		}
+		response, err := eaptest.Authenticate(server, peer)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.Password, err)
+		}
+		if response.Code != tt.Code {
+			t.Fatalf("%s: got %v, expected %v", tt.Password, response.Code, tt.Code)
+		}
+		msk, _ := peer.Keys()
+		if tt.Code == radius.CodeAccessAccept && (len(msk) != 32 || !bytes.Equal(msk, session.MSK)) {
+			t.Fatalf("got MSK %x, expected %x", msk, session.MSK)
+		}
+		if tt.Code == radius.CodeAccessReject && msk != nil {
+			t.Fatal("unexpected MSK")
+		}
+	}
+}
+
+func TestPeer_invalidAuthenticatorResponse(t *testing.T) {
+	c, _ := (&Peer{Name: "tim", Password: []byte("clientPass")}).Start()
+	challenge := &packet{op: OpChallenge, id: 1, value: make([]byte, 16), name: "radius"}
+	if _, err := c.Respond(&eap.Packet{Data: challenge.encode()}); err != nil {
+		t.Fatal(err)
+	}
+	success := &packet{op: OpSuccess, id: 1, message: "S=0000000000000000000000000000000000000000 M=OK"}
+	if _, err := c.Respond(&eap.Packet{Data: success.encode()}); err == nil {
+		t.Fatal("expected error")
+	}
+}
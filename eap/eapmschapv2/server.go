@@ -0,0 +1,141 @@
+package eapmschapv2
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/rfc2433"
+	"layeh.com/radius/rfc2759"
+)
+
+// Method is the authenticator side of EAP-MSCHAPv2.
+//
+// On success, the session's MSK is set to the 32 octet key used by tunneled
+// methods; no MS-MPPE keys are derived from it.
+type Method struct {
+	// Password returns the cleartext password of the user with the given
+	// name, as sent by the peer. Returning a nil password fails the
+	// authentication.
+	Password func(s *eap.Session, name string) ([]byte, error)
+
+	// Name is the authenticator name sent in the challenge. Defaults to
+	// "radius".
+	Name string
+}
+
+// Type implements eap.Method.
+func (m *Method) Type() eap.Type {
+	return eap.TypeMSCHAPv2
+}
+
+// Start implements eap.Method.
+func (m *Method) Start(s *eap.Session) (eap.Conversation, []byte, error) {
+	if m.Password == nil {
+		return nil, nil, errors.New("eapmschapv2: nil Password")
+	}
+	c := &conversation{
+		method:    m,
+		session:   s,
+		challenge: make([]byte, challengeLength+1),
+	}
+	if _, err := rand.Read(c.challenge); err != nil {
+		return nil, nil, err
+	}
+	c.id = c.challenge[challengeLength]
+	c.challenge = c.challenge[:challengeLength]
+
+	name := m.Name
+	if name == "" {
+		name = "radius"
+	}
+	challenge := &packet{
+		op:    OpChallenge,
+		id:    c.id,
+		value: c.challenge,
+		name:  name,
+	}
+	return c, challenge.encode(), nil
+}
+
+type conversation struct {
+	method    *Method
+	session   *eap.Session
+	id        byte
+	challenge []byte
+
+	// sent Success or Failure request, awaiting the peer's acknowledgement
+	result eap.Status
+	msk    []byte
+}
+
+func (c *conversation) Process(response []byte) (eap.Status, []byte, error) {
+	p, err := parse(response)
+	if err != nil {
+		return eap.StatusFailure, nil, err
+	}
+
+	switch c.result {
+	case eap.StatusSuccess:
+		if p.op != OpSuccess {
+			return eap.StatusFailure, nil, errors.New("eapmschapv2: expected Success acknowledgement")
+		}
+		c.session.MSK = c.msk
+		return eap.StatusSuccess, nil, nil
+	case eap.StatusFailure:
+		return eap.StatusFailure, nil, nil
+	}
+
+	if p.op != OpResponse || p.id != c.id || len(p.value) != responseLength {
+		return eap.StatusFailure, nil, errors.New("eapmschapv2: invalid Response")
+	}
+	peerChallenge := p.value[:16]
+	ntResponse := p.value[24:48]
+
+	password, err := c.method.Password(c.session, p.name)
+	if err != nil {
+		return eap.StatusFailure, nil, err
+	}
+	if password != nil {
+		username := []byte(stripDomain(p.name))
+		expected, err := rfc2759.GenerateNTResponse(c.challenge, peerChallenge, username, password)
+		if err != nil {
+			return eap.StatusFailure, nil, err
+		}
+		if subtle.ConstantTimeCompare(expected, ntResponse) == 1 {
+			authenticatorResponse, err := rfc2759.GenerateAuthenticatorResponse(c.challenge, peerChallenge, ntResponse, username, password)
+			if err != nil {
+				return eap.StatusFailure, nil, err
+			}
+			if c.msk, err = msk(ntResponse, password); err != nil {
+				return eap.StatusFailure, nil, err
+			}
+			c.result = eap.StatusSuccess
+			success := &packet{
+				op:      OpSuccess,
+				id:      c.id,
+				message: authenticatorResponse + " M=OK",
+			}
+			return eap.StatusContinue, success.encode(), nil
+		}
+	}
+
+	var newChallenge [16]byte
+	if _, err := rand.Read(newChallenge[:]); err != nil {
+		return eap.StatusFailure, nil, err
+	}
+	e := rfc2433.Error{
+		Code:      rfc2433.ErrorAuthenticationFailure,
+		Challenge: newChallenge[:],
+		Version:   3,
+		Message:   "Authentication failed",
+	}
+	c.result = eap.StatusFailure
+	failure := &packet{
+		op:      OpFailure,
+		id:      c.id,
+		message: e.String(),
+	}
+	return eap.StatusContinue, failure.encode(), nil
+}
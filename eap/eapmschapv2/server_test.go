@@ -0,0 +1,128 @@
+package eapmschapv2
+
+import (
+	"bytes"
+	"testing"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/rfc2759"
+)
+
+func testMethod() *Method {
+	return &Method{
+		Password: func(s *eap.Session, name string) ([]byte, error) {
+			if name != `EXAMPLE\tim` {
+				return nil, nil
+			}
+			return []byte("clientPass"), nil
+		},
+	}
+}
+
+// respond returns the peer's Response to the Challenge request.
+func respond(t *testing.T, request []byte, name string, password string) []byte {
+	t.Helper()
+
+	challenge, err := parse(request)
+	if err != nil || challenge.op != OpChallenge || len(challenge.value) != challengeLength {
+		t.Fatalf("invalid challenge %v: %v", challenge, err)
+	}
+	peerChallenge := bytes.Repeat([]byte{0x21}, 16)
+	ntResponse, err := rfc2759.GenerateNTResponse(challenge.value, peerChallenge, []byte(stripDomain(name)), []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, responseLength)
+	copy(value, peerChallenge)
+	copy(value[24:], ntResponse)
+	response := &packet{
+		op:    OpResponse,
+		id:    challenge.id,
+		value: value,
+		name:  name,
+	}
+	return response.encode()
+}
+
+func TestMethod(t *testing.T) {
+	s := &eap.Session{}
+	c, request, err := testMethod().Start(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, request, err := c.Process(respond(t, request, `EXAMPLE\tim`, "clientPass"))
+	if status != eap.StatusContinue || err != nil {
+		t.Fatalf("got %v, %v", status, err)
+	}
+	success, err := parse(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success.op != OpSuccess || !bytes.HasPrefix([]byte(success.message), []byte("S=")) {
+		t.Fatalf("unexpected request %+v", success)
+	}
+
+	status, _, err = c.Process([]byte{OpSuccess})
+	if status != eap.StatusSuccess || err != nil {
+		t.Fatalf("got %v, %v", status, err)
+	}
+	if len(s.MSK) != 32 {
+		t.Fatalf("got MSK %x", s.MSK)
+	}
+}
+
+func TestMethod_invalidPassword(t *testing.T) {
+	for _, name := range []string{`EXAMPLE\tim`, "unknown"} {
+		s := &eap.Session{}
+		c, request, err := testMethod().Start(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, request, err := c.Process(respond(t, request, name, "wrong"))
+		if status != eap.StatusContinue || err != nil {
+			t.Fatalf("got %v, %v", status, err)
+		}
+		failure, err := parse(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failure.op != OpFailure || !bytes.HasPrefix([]byte(failure.message), []byte("E=691 R=0 C=")) {
+			t.Fatalf("unexpected request %+v", failure)
+		}
+
+		status, _, _ = c.Process([]byte{OpFailure})
+		if status != eap.StatusFailure {
+			t.Fatalf("got %v", status)
+		}
+		if s.MSK != nil {
+			t.Fatal("unexpected MSK")
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	p := &packet{
+		op:    OpChallenge,
+		id:    7,
+		value: []byte{1, 2, 3},
+		name:  "radius",
+	}
+	b := p.encode()
+	expected := []byte{OpChallenge, 7, 0, 14, 3, 1, 2, 3, 'r', 'a', 'd', 'i', 'u', 's'}
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("got %v, expected %v", b, expected)
+	}
+	q, err := parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.op != p.op || q.id != p.id || !bytes.Equal(q.value, p.value) || q.name != p.name {
+		t.Fatalf("got %+v", q)
+	}
+
+	if _, err := parse([]byte{OpResponse, 1, 0, 10, 49}); err == nil {
+		t.Fatal("expected error")
+	}
+}
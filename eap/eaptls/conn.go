@@ -0,0 +1,126 @@
+package eaptls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// errTimeout is returned by recordConn when the other side of the EAP
+// conversation stops sending data.
+var errTimeout = errors.New("eaptls: conversation timed out")
+
+// recordConn is an in-memory net.Conn carrying the TLS records of an EAP
+// conversation. The TLS connection runs in its own goroutine; reads block
+// until the conversation delivers the next reassembled EAP message, and
+// writes are buffered until the conversation collects them.
+type recordConn struct {
+	in        chan []byte
+	needInput chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	timeout   time.Duration
+
+	pending []byte
+
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func newRecordConn(timeout time.Duration) *recordConn {
+	return &recordConn{
+		in:        make(chan []byte),
+		needInput: make(chan struct{}),
+		closed:    make(chan struct{}),
+		timeout:   timeout,
+	}
+}
+
+// wait signals that the TLS goroutine needs input, and blocks until the
+// conversation delivers it.
+func (c *recordConn) wait() ([]byte, error) {
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case c.needInput <- struct{}{}:
+	case <-c.closed:
+		return nil, io.EOF
+	case <-timer.C:
+		return nil, errTimeout
+	}
+
+	select {
+	case data := <-c.in:
+		return data, nil
+	case <-c.closed:
+		return nil, io.EOF
+	case <-timer.C:
+		return nil, errTimeout
+	}
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		data, err := c.wait()
+		if err != nil {
+			return 0, err
+		}
+		if len(data) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		c.pending = data
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.Write(b)
+}
+
+// hasOutput reports whether written data is waiting to be collected.
+func (c *recordConn) hasOutput() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.Len() > 0
+}
+
+// takeOutput returns and clears the written data.
+func (c *recordConn) takeOutput() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := append([]byte(nil), c.out.Bytes()...)
+	c.out.Reset()
+	return b
+}
+
+func (c *recordConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *recordConn) LocalAddr() net.Addr  { return eapAddr{} }
+func (c *recordConn) RemoteAddr() net.Addr { return eapAddr{} }
+
+func (c *recordConn) SetDeadline(t time.Time) error      { return nil }
+func (c *recordConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *recordConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type eapAddr struct{}
+
+func (eapAddr) Network() string { return "eap" }
+func (eapAddr) String() string  { return "eap" }
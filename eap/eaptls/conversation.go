@@ -0,0 +1,373 @@
+package eaptls
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"layeh.com/radius/eap"
+)
+
+// EAP-TLS flags - rfc5216, 3.1
+const (
+	FlagLength = 0x80
+	FlagMore   = 0x40
+	FlagStart  = 0x20
+
+	// VersionMask selects the method version carried in the low bits of the
+	// flags by PEAP and EAP-TTLS.
+	VersionMask = 0x07
+)
+
+const (
+	// DefaultFragmentSize is the default maximum number of TLS octets sent in
+	// a single EAP-Request.
+	DefaultFragmentSize = 1024
+	// DefaultTimeout is the default time the TLS connection waits for the
+	// next message of the peer.
+	DefaultTimeout = time.Minute
+
+	maxMessageLength = 64 * 1024
+)
+
+// Conversation carries a TLS connection over EAP-TLS style Type-Data:
+// TLS records are fragmented using the L, M and S flags, and each fragment is
+// acknowledged by an empty message (rfc5216, 2.1.5 and 3.2).
+//
+// It implements eap.Conversation for the server side of EAP-TLS and of the
+// tunneled methods built on it, and the peer side through Respond.
+type Conversation struct {
+	// Version is sent in the low bits of the flags of each message.
+	Version byte
+	// FragmentSize is the maximum number of TLS octets sent in a single
+	// message. Defaults to DefaultFragmentSize.
+	FragmentSize int
+
+	conn    *recordConn
+	tls     *tls.Conn
+	inner   func(conn *tls.Conn) error
+	client  bool
+	done    chan struct{}
+	err     error
+	waiting bool // TLS goroutine has signaled that it needs input
+
+	incoming       []byte
+	incomingLength int
+	outgoing       []byte
+	outgoingFirst  bool
+}
+
+// NewServerConversation starts the server side of a TLS connection with the
+// given configuration.
+//
+// inner, if non-nil, is called with the established connection once the
+// handshake has completed and the peer has acknowledged the server's final
+// handshake flight. It runs in its own goroutine, and any data it reads from
+// and writes to conn is exchanged with the peer through further rounds. The
+// conversation succeeds if both the handshake and inner succeed.
+func NewServerConversation(config *tls.Config, timeout time.Duration, inner func(conn *tls.Conn) error) *Conversation {
+	c := newConversation(timeout, inner)
+	config = config.Clone()
+	config.SessionTicketsDisabled = true
+	c.tls = tls.Server(c.conn, config)
+	go c.serve()
+	return c
+}
+
+// NewClientConversation starts the peer side of a TLS connection with the
+// given configuration. EAP-Requests are handled by Respond.
+//
+// inner, if non-nil, is called with the established connection once the
+// handshake has completed, as with NewServerConversation.
+func NewClientConversation(config *tls.Config, timeout time.Duration, inner func(conn *tls.Conn) error) *Conversation {
+	c := newConversation(timeout, inner)
+	config = config.Clone()
+	config.SessionTicketsDisabled = true
+	c.tls = tls.Client(c.conn, config)
+	c.client = true
+	go c.serve()
+	return c
+}
+
+func newConversation(timeout time.Duration, inner func(conn *tls.Conn) error) *Conversation {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Conversation{
+		conn:  newRecordConn(timeout),
+		inner: inner,
+		done:  make(chan struct{}),
+	}
+}
+
+func (c *Conversation) serve() {
+	defer close(c.done)
+
+	if err := c.tls.Handshake(); err != nil {
+		c.err = err
+		return
+	}
+	if c.err = c.flush(); c.err != nil {
+		return
+	}
+	if c.inner != nil {
+		if c.err = c.inner(c.tls); c.err != nil || c.client {
+			// the server ends the conversation without acknowledging the
+			// peer's final message
+			return
+		}
+		c.err = c.flush()
+	}
+}
+
+// flush waits until the peer has acknowledged any data written by the TLS
+// connection. Data sent by the peer instead of an acknowledgement is kept for
+// the next Read.
+func (c *Conversation) flush() error {
+	if !c.conn.hasOutput() {
+		return nil
+	}
+	data, err := c.conn.wait()
+	if err != nil {
+		return err
+	}
+	c.conn.pending = data
+	return nil
+}
+
+// Start returns the Type-Data of the EAP-TLS Start request.
+func (c *Conversation) Start() []byte {
+	return []byte{FlagStart | c.Version}
+}
+
+// ConnectionState returns the state of the TLS connection. It must only be
+// called once the conversation has ended.
+func (c *Conversation) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
+
+// Close releases the resources of the conversation.
+func (c *Conversation) Close() error {
+	return c.conn.Close()
+}
+
+// Process implements eap.Conversation.
+func (c *Conversation) Process(response []byte) (eap.Status, []byte, error) {
+	if len(response) < 1 {
+		return eap.StatusFailure, nil, errors.New("eaptls: missing flags")
+	}
+	flags, data := response[0], response[1:]
+	if flags&FlagLength != 0 {
+		if len(data) < 4 {
+			return eap.StatusFailure, nil, errors.New("eaptls: missing TLS message length")
+		}
+		length := int(binary.BigEndian.Uint32(data))
+		if length > maxMessageLength {
+			return eap.StatusFailure, nil, errors.New("eaptls: TLS message too long")
+		}
+		if c.incoming == nil {
+			c.incomingLength = length
+		}
+		data = data[4:]
+	}
+
+	if len(c.outgoing) > 0 {
+		// peer acknowledges the previous fragment
+		if len(data) > 0 {
+			return eap.StatusFailure, nil, errors.New("eaptls: expected fragment acknowledgement")
+		}
+		return eap.StatusContinue, c.nextFragment(), nil
+	}
+
+	c.incoming = append(c.incoming, data...)
+	if len(c.incoming) > maxMessageLength {
+		return eap.StatusFailure, nil, errors.New("eaptls: TLS message too long")
+	}
+	if flags&FlagMore != 0 {
+		return eap.StatusContinue, []byte{c.Version}, nil
+	}
+	message := c.incoming
+	if c.incomingLength > 0 && len(message) != c.incomingLength {
+		return eap.StatusFailure, nil, errors.New("eaptls: TLS message length mismatch")
+	}
+	c.incoming = nil
+	c.incomingLength = 0
+
+	select {
+	case <-c.done:
+		// peer acknowledges the final flight
+		if len(message) > 0 {
+			return eap.StatusFailure, nil, errors.New("eaptls: unexpected data after TLS connection ended")
+		}
+		return c.result()
+	default:
+	}
+
+	c.outgoing = c.exchange(message)
+	c.outgoingFirst = true
+	if len(c.outgoing) == 0 {
+		select {
+		case <-c.done:
+			return c.result()
+		default:
+			return eap.StatusFailure, nil, errors.New("eaptls: no data to send")
+		}
+	}
+	return eap.StatusContinue, c.nextFragment(), nil
+}
+
+func (c *Conversation) result() (eap.Status, []byte, error) {
+	if c.err != nil {
+		return eap.StatusFailure, nil, c.err
+	}
+	return eap.StatusSuccess, nil, nil
+}
+
+// exchange delivers message to the TLS goroutine and waits until it needs
+// more input or finishes, returning the data it has written meanwhile.
+func (c *Conversation) exchange(message []byte) []byte {
+	c.await()
+	if c.waiting {
+		select {
+		case c.conn.in <- message:
+		case <-c.done:
+		}
+		c.waiting = false
+	}
+	return c.collect()
+}
+
+// collect waits until the TLS goroutine needs input or finishes, and returns
+// the data it has written meanwhile.
+func (c *Conversation) collect() []byte {
+	c.await()
+	return c.conn.takeOutput()
+}
+
+func (c *Conversation) await() {
+	if c.waiting {
+		return
+	}
+	select {
+	case <-c.conn.needInput:
+		c.waiting = true
+	case <-c.done:
+	}
+}
+
+// Respond handles the Type-Data of an EAP-Request on the peer side of the
+// conversation, and returns the Type-Data of the EAP-Response.
+func (c *Conversation) Respond(request []byte) ([]byte, error) {
+	if len(request) < 1 {
+		return nil, errors.New("eaptls: missing flags")
+	}
+	flags, data := request[0], request[1:]
+	if flags&FlagStart != 0 {
+		c.outgoing = c.collect()
+		c.outgoingFirst = true
+		if len(c.outgoing) == 0 {
+			return nil, c.failure()
+		}
+		return c.nextFragment(), nil
+	}
+	if flags&FlagLength != 0 {
+		if len(data) < 4 {
+			return nil, errors.New("eaptls: missing TLS message length")
+		}
+		data = data[4:]
+	}
+
+	if len(c.outgoing) > 0 {
+		// server acknowledges the previous fragment
+		if len(data) > 0 {
+			return nil, errors.New("eaptls: expected fragment acknowledgement")
+		}
+		return c.nextFragment(), nil
+	}
+
+	c.incoming = append(c.incoming, data...)
+	if len(c.incoming) > maxMessageLength {
+		return nil, errors.New("eaptls: TLS message too long")
+	}
+	if flags&FlagMore != 0 {
+		return []byte{c.Version}, nil
+	}
+	message := c.incoming
+	c.incoming = nil
+
+	select {
+	case <-c.done:
+		if len(message) > 0 {
+			return nil, c.failure()
+		}
+		return []byte{c.Version}, nil
+	default:
+	}
+
+	c.outgoing = c.exchange(message)
+	c.outgoingFirst = true
+	if len(c.outgoing) == 0 {
+		select {
+		case <-c.done:
+			if c.err != nil {
+				return nil, c.err
+			}
+		default:
+		}
+		return []byte{c.Version}, nil
+	}
+	return c.nextFragment(), nil
+}
+
+// Done reports whether the TLS connection and inner function have
+// finished, and returns the error that ended them.
+func (c *Conversation) Done() (bool, error) {
+	select {
+	case <-c.done:
+		return true, c.err
+	default:
+		return false, nil
+	}
+}
+
+func (c *Conversation) failure() error {
+	select {
+	case <-c.done:
+		if c.err != nil {
+			return c.err
+		}
+	default:
+	}
+	return errors.New("eaptls: unexpected TLS data")
+}
+
+// nextFragment returns the Type-Data of the next message carrying outgoing
+// TLS data. The first fragment includes the total message length.
+func (c *Conversation) nextFragment() []byte {
+	size := c.FragmentSize
+	if size <= 0 {
+		size = DefaultFragmentSize
+	}
+
+	flags := c.Version
+	var b []byte
+	if c.outgoingFirst {
+		flags |= FlagLength
+		b = make([]byte, 5, 5+size)
+		binary.BigEndian.PutUint32(b[1:], uint32(len(c.outgoing)))
+		c.outgoingFirst = false
+	} else {
+		b = make([]byte, 1, 1+size)
+	}
+
+	n := len(c.outgoing)
+	if n > size {
+		n = size
+		flags |= FlagMore
+	}
+	b[0] = flags
+	b = append(b, c.outgoing[:n]...)
+	c.outgoing = c.outgoing[n:]
+	return b
+}
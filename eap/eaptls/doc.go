@@ -0,0 +1,3 @@
+// Package eaptls implements EAP-TLS (RFC 5216, RFC 9190), and the TLS
+// fragmentation shared by the TLS based EAP methods.
+package eaptls
@@ -0,0 +1,28 @@
+package eaptls
+
+import (
+	"crypto/tls"
+
+	"layeh.com/radius/eap"
+)
+
+// Keys derives the MSK and EMSK of a TLS based EAP method from the state of
+// its established TLS connection (rfc5216, 2.3; rfc5281, 8; rfc9427, 2.1).
+func Keys(state *tls.ConnectionState, method eap.Type) (msk, emsk []byte, err error) {
+	var label string
+	var context []byte
+	switch {
+	case state.Version >= tls.VersionTLS13:
+		label = "EXPORTER_EAP_TLS_Key_Material"
+		context = []byte{byte(method)}
+	case method == eap.TypeTTLS:
+		label = "ttls keying material"
+	default:
+		label = "client EAP encryption"
+	}
+	material, err := state.ExportKeyingMaterial(label, context, 128)
+	if err != nil {
+		return nil, nil, err
+	}
+	return material[:64], material[64:], nil
+}
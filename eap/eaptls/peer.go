@@ -0,0 +1,77 @@
+package eaptls
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"layeh.com/radius/eap"
+)
+
+// Peer is the peer side of EAP-TLS.
+type Peer struct {
+	// Config is the TLS configuration of the peer, holding its certificate
+	// and the roots verifying the server's certificate.
+	Config *tls.Config
+
+	// FragmentSize is the maximum number of TLS octets sent in a single
+	// EAP-Response. Defaults to DefaultFragmentSize.
+	FragmentSize int
+
+	// Timeout is how long the TLS connection waits for the server's next
+	// request. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Type implements eap.PeerMethod.
+func (p *Peer) Type() eap.Type {
+	return eap.TypeTLS
+}
+
+// Start implements eap.PeerMethod.
+func (p *Peer) Start() (eap.PeerConversation, error) {
+	if p.Config == nil {
+		return nil, errors.New("eaptls: nil Config")
+	}
+	c := &peerConversation{
+		Conversation: NewClientConversation(p.Config, p.Timeout, readCommitment),
+	}
+	c.FragmentSize = p.FragmentSize
+	return c, nil
+}
+
+// readCommitment reads the protected success indication of TLS 1.3
+// (rfc9190, 2.5).
+func readCommitment(conn *tls.Conn) error {
+	if conn.ConnectionState().Version < tls.VersionTLS13 {
+		return nil
+	}
+	var b [1]byte
+	if _, err := conn.Read(b[:]); err != nil {
+		return err
+	}
+	if b[0] != 0x00 {
+		return errors.New("eaptls: invalid commitment message")
+	}
+	return nil
+}
+
+type peerConversation struct {
+	*Conversation
+}
+
+// Respond implements eap.PeerConversation.
+func (c *peerConversation) Respond(request *eap.Packet) ([]byte, error) {
+	return c.Conversation.Respond(request.Data)
+}
+
+// Keys returns the MSK and EMSK once the TLS connection has been
+// established.
+func (c *peerConversation) Keys() (msk, emsk []byte) {
+	if done, err := c.Done(); !done || err != nil {
+		return nil, nil
+	}
+	state := c.ConnectionState()
+	msk, emsk, _ = Keys(&state, eap.TypeTLS)
+	return msk, emsk
+}
@@ -0,0 +1,62 @@
+package eaptls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/internal/eaptest"
+	"layeh.com/radius/eap/internal/testcert"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+func TestPeer(t *testing.T) {
+	server := &eap.Server{
+		Methods: []eap.Method{
+			&Method{
+				Config: &tls.Config{
+					Certificates: []tls.Certificate{testcert.Server()},
+					ClientCAs:    testcert.Pool(),
+				},
+			},
+		},
+	}
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		peer := &eap.Peer{
+			Identity: "tim",
+			Methods: []eap.PeerMethod{
+				&Peer{
+					Config: &tls.Config{
+						Certificates: []tls.Certificate{testcert.Client()},
+						RootCAs:      testcert.Pool(),
+						ServerName:   "radius.example.com",
+						MaxVersion:   version,
+					},
+					FragmentSize: 300,
+				},
+			},
+		}
+		response, err := eaptest.Authenticate(server, peer)
+		if err != nil {
+			t.Fatalf("version %x: %v", version, err)
+		}
+		if response.Code != radius.CodeAccessAccept {
+			t.Fatalf("version %x: got %v", version, response.Code)
+		}
+
+		msk, emsk := peer.Keys()
+		if len(msk) != 64 || len(emsk) != 64 {
+			t.Fatalf("version %x: missing keys", version)
+		}
+		recvKey, sendKey, err := microsoft.MPPEKeys_Lookup(response, response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(recvKey, msk[:32]) || !bytes.Equal(sendKey, msk[32:]) {
+			t.Fatalf("version %x: MS-MPPE keys do not match MSK", version)
+		}
+		peer.Close()
+	}
+}
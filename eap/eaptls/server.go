@@ -0,0 +1,84 @@
+package eaptls
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"layeh.com/radius/eap"
+)
+
+// Method is the server side of EAP-TLS (RFC 5216, RFC 9190).
+//
+// On success, the session's MSK and EMSK are set, and the session's TLS field
+// holds the state of the connection, so that Server.Authorize can inspect the
+// peer's certificate.
+type Method struct {
+	// Config is the TLS configuration of the server. Peers must present a
+	// certificate: if Config.ClientAuth does not require one,
+	// tls.RequireAndVerifyClientCert is used.
+	Config *tls.Config
+
+	// FragmentSize is the maximum number of TLS octets sent in a single
+	// EAP-Request. Defaults to DefaultFragmentSize.
+	FragmentSize int
+
+	// Timeout is how long the TLS connection waits for the peer's next
+	// response. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Type implements eap.Method.
+func (m *Method) Type() eap.Type {
+	return eap.TypeTLS
+}
+
+// Start implements eap.Method.
+func (m *Method) Start(s *eap.Session) (eap.Conversation, []byte, error) {
+	if m.Config == nil {
+		return nil, nil, errors.New("eaptls: nil Config")
+	}
+	config := m.Config.Clone()
+	switch config.ClientAuth {
+	case tls.RequireAnyClientCert, tls.RequireAndVerifyClientCert:
+	default:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	c := &conversation{
+		Conversation: NewServerConversation(config, m.Timeout, commit),
+		session:      s,
+	}
+	c.FragmentSize = m.FragmentSize
+	return c, c.Start(), nil
+}
+
+// commit sends the protected success indication of TLS 1.3 (rfc9190, 2.5).
+func commit(conn *tls.Conn) error {
+	if conn.ConnectionState().Version < tls.VersionTLS13 {
+		return nil
+	}
+	_, err := conn.Write([]byte{0x00})
+	return err
+}
+
+type conversation struct {
+	*Conversation
+	session *eap.Session
+}
+
+func (c *conversation) Process(response []byte) (eap.Status, []byte, error) {
+	status, request, err := c.Conversation.Process(response)
+	if status != eap.StatusSuccess {
+		return status, request, err
+	}
+	state := c.ConnectionState()
+	msk, emsk, err := Keys(&state, eap.TypeTLS)
+	if err != nil {
+		return eap.StatusFailure, nil, err
+	}
+	c.session.MSK = msk
+	c.session.EMSK = emsk
+	c.session.TLS = &state
+	return eap.StatusSuccess, nil, nil
+}
@@ -0,0 +1,136 @@
+package eaptls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/internal/testcert"
+)
+
+func authenticate(t *testing.T, m eap.Method, config *tls.Config) (*eap.Session, *Conversation, error) {
+	t.Helper()
+
+	s := &eap.Session{Identity: "tim"}
+	c, request, err := m.Start(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(*conversation).Close()
+
+	peer := NewClientConversation(config, time.Second, readCommitment)
+	defer peer.Close()
+	for i := 0; i < 50; i++ {
+		response, err := peer.Respond(request)
+		if err != nil {
+			return s, peer, err
+		}
+		status, next, err := c.Process(response)
+		switch status {
+		case eap.StatusSuccess:
+			return s, peer, nil
+		case eap.StatusFailure:
+			if err == nil {
+				err = errors.New("failure")
+			}
+			return s, peer, err
+		}
+		request = next
+	}
+	t.Fatal("too many rounds")
+	return nil, nil, nil
+}
+
+func TestMethod(t *testing.T) {
+	versions := []uint16{tls.VersionTLS12, tls.VersionTLS13}
+	for _, version := range versions {
+		m := &Method{
+			Config: &tls.Config{
+				Certificates: []tls.Certificate{testcert.Server()},
+				ClientCAs:    testcert.Pool(),
+			},
+			FragmentSize: 200,
+		}
+		config := &tls.Config{
+			Certificates: []tls.Certificate{testcert.Client()},
+			RootCAs:      testcert.Pool(),
+			ServerName:   "radius.example.com",
+			MaxVersion:   version,
+		}
+		s, peer, err := authenticate(t, m, config)
+		if err != nil {
+			t.Fatalf("version %x: %v", version, err)
+		}
+		if done, err := peer.Done(); !done || err != nil {
+			t.Fatalf("version %x: peer: %v, %v", version, done, err)
+		}
+		if s.TLS == nil || s.TLS.Version != version {
+			t.Fatalf("version %x: unexpected connection state %+v", version, s.TLS)
+		}
+		if cn := s.TLS.PeerCertificates[0].Subject.CommonName; cn != "tim" {
+			t.Fatalf("version %x: got peer %q", version, cn)
+		}
+		state := peer.ConnectionState()
+		msk, emsk, err := Keys(&state, eap.TypeTLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(s.MSK, msk) || !bytes.Equal(s.EMSK, emsk) {
+			t.Fatalf("version %x: keys do not match", version)
+		}
+		if len(s.MSK) != 64 || len(s.EMSK) != 64 || bytes.Equal(s.MSK, s.EMSK) {
+			t.Fatalf("version %x: invalid keys", version)
+		}
+	}
+}
+
+func TestMethod_noClientCertificate(t *testing.T) {
+	clientAuths := []tls.ClientAuthType{
+		tls.NoClientCert,
+		tls.RequestClientCert,
+		tls.RequireAnyClientCert,
+		tls.VerifyClientCertIfGiven,
+		tls.RequireAndVerifyClientCert,
+	}
+	for _, clientAuth := range clientAuths {
+		m := &Method{
+			Config: &tls.Config{
+				Certificates: []tls.Certificate{testcert.Server()},
+				ClientCAs:    testcert.Pool(),
+				ClientAuth:   clientAuth,
+			},
+		}
+		config := &tls.Config{
+			RootCAs:    testcert.Pool(),
+			ServerName: "radius.example.com",
+			MaxVersion: tls.VersionTLS12,
+		}
+		s, _, err := authenticate(t, m, config)
+		if err == nil {
+			t.Fatalf("%v: expected error", clientAuth)
+		}
+		if s.MSK != nil || s.TLS != nil {
+			t.Fatalf("%v: unexpected keys", clientAuth)
+		}
+	}
+}
+
+func TestConversation_fragments(t *testing.T) {
+	c := &Conversation{FragmentSize: 4}
+	c.outgoing = []byte("0123456789")
+	c.outgoingFirst = true
+
+	fragments := [][]byte{
+		{FlagLength | FlagMore, 0, 0, 0, 10, '0', '1', '2', '3'},
+		{FlagMore, '4', '5', '6', '7'},
+		{0, '8', '9'},
+	}
+	for i, expected := range fragments {
+		if b := c.nextFragment(); !bytes.Equal(b, expected) {
+			t.Fatalf("fragment %d: got %v, expected %v", i, b, expected)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Package eaptest runs EAP conversations between an eap.Peer and an
+// eap.Server in tests.
+package eaptest
+
+import (
+	"errors"
+	"net"
+
+	"layeh.com/radius"
+	"layeh.com/radius/eap"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+// Secret is the RADIUS secret used by Authenticate.
+var Secret = []byte(`secret`)
+
+// Authenticate runs an EAP conversation between peer and server, carried in
+// Access-Requests, until server sends an Access-Accept or Access-Reject,
+// which is returned. Every Access-Challenge, Access-Accept and Access-Reject
+// must carry an EAP-Message and a valid Message-Authenticator.
+func Authenticate(server *eap.Server, peer *eap.Peer) (*radius.Packet, error) {
+	msg := &eap.Packet{
+		Code:       eap.CodeResponse,
+		Identifier: 1,
+		Type:       eap.TypeIdentity,
+		Data:       []byte(peer.Identity),
+	}
+	var state []byte
+	for i := 0; i < 100; i++ {
+		packet := radius.New(radius.CodeAccessRequest, Secret)
+		rfc2865.UserName_SetString(packet, peer.Identity)
+		if err := eap.SetMessage(packet, msg); err != nil {
+			return nil, err
+		}
+		if state != nil {
+			rfc2865.State_Set(packet, state)
+		}
+		if err := rfc2869.MessageAuthenticator_Sign(packet); err != nil {
+			return nil, err
+		}
+
+		response, err := server.Handle(&radius.Request{
+			LocalAddr:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1812},
+			RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000},
+			Packet:     packet,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := rfc2869.MessageAuthenticator_Verify(response, nil); err != nil {
+			return nil, err
+		}
+		request, err := eap.LookupMessage(response)
+		if err != nil {
+			return nil, err
+		}
+
+		switch response.Code {
+		case radius.CodeAccessAccept:
+			if request.Code != eap.CodeSuccess {
+				return nil, errors.New("eaptest: Access-Accept without EAP-Success")
+			}
+			return response, nil
+		case radius.CodeAccessReject:
+			if request.Code != eap.CodeFailure {
+				return nil, errors.New("eaptest: Access-Reject without EAP-Failure")
+			}
+			return response, nil
+		case radius.CodeAccessChallenge:
+		default:
+			return nil, errors.New("eaptest: unexpected " + response.Code.String())
+		}
+
+		state = rfc2865.State_Get(response)
+		if msg, err = peer.Respond(request); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("eaptest: too many rounds")
+}
@@ -0,0 +1,166 @@
+package eap
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Code is the EAP packet code.
+type Code byte
+
+// EAP packet codes - rfc3748, 4
+const (
+	CodeRequest  Code = 1
+	CodeResponse Code = 2
+	CodeSuccess  Code = 3
+	CodeFailure  Code = 4
+)
+
+// String returns a string representation of the code.
+func (c Code) String() string {
+	switch c {
+	case CodeRequest:
+		return `Request`
+	case CodeResponse:
+		return `Response`
+	case CodeSuccess:
+		return `Success`
+	case CodeFailure:
+		return `Failure`
+	}
+	return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Type is the EAP method type of a Request or Response packet.
+type Type byte
+
+// EAP method types - rfc3748, 5 and the IANA EAP registry
+const (
+	TypeIdentity     Type = 1
+	TypeNotification Type = 2
+	TypeNak          Type = 3
+	TypeMD5Challenge Type = 4
+	TypeOTP          Type = 5
+	TypeGTC          Type = 6
+	TypeTLS          Type = 13
+	TypeTTLS         Type = 21
+	TypePEAP         Type = 25
+	TypeMSCHAPv2     Type = 26
+	TypeExtensions   Type = 33
+	TypeExpanded     Type = 254
+)
+
+// String returns a string representation of the type.
+func (t Type) String() string {
+	switch t {
+	case TypeIdentity:
+		return `Identity`
+	case TypeNotification:
+		return `Notification`
+	case TypeNak:
+		return `Nak`
+	case TypeMD5Challenge:
+		return `MD5-Challenge`
+	case TypeOTP:
+		return `OTP`
+	case TypeGTC:
+		return `GTC`
+	case TypeTLS:
+		return `TLS`
+	case TypeTTLS:
+		return `TTLS`
+	case TypePEAP:
+		return `PEAP`
+	case TypeMSCHAPv2:
+		return `MSCHAPv2`
+	case TypeExtensions:
+		return `Extensions`
+	case TypeExpanded:
+		return `Expanded`
+	}
+	return "Type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// MaxPacketLength is the maximum wire length of an EAP packet carried in a
+// RADIUS packet.
+const MaxPacketLength = 4096
+
+// Packet is an EAP packet.
+type Packet struct {
+	Code       Code
+	Identifier byte
+	// Type and Data are only present in Request and Response packets.
+	Type Type
+	Data []byte
+}
+
+// Parse parses an encoded EAP packet b. An error is returned if the packet
+// is malformed. Octets past the packet's Length field are ignored.
+func Parse(b []byte) (*Packet, error) {
+	if len(b) < 4 {
+		return nil, errors.New("eap: packet not at least 4 bytes long")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < 4 || len(b) < length {
+		return nil, errors.New("eap: invalid packet length")
+	}
+
+	p := &Packet{
+		Code:       Code(b[0]),
+		Identifier: b[1],
+	}
+	switch p.Code {
+	case CodeRequest, CodeResponse:
+		if length < 5 {
+			return nil, errors.New("eap: missing type")
+		}
+		p.Type = Type(b[4])
+		p.Data = append([]byte(nil), b[5:length]...)
+	case CodeSuccess, CodeFailure:
+		// rfc3748, 4.2: no data
+	default:
+		return nil, errors.New("eap: unknown packet code")
+	}
+	return p, nil
+}
+
+// Encode encodes the EAP packet to wire format.
+func (p *Packet) Encode() ([]byte, error) {
+	var b []byte
+	switch p.Code {
+	case CodeRequest, CodeResponse:
+		b = make([]byte, 5+len(p.Data))
+		b[4] = byte(p.Type)
+		copy(b[5:], p.Data)
+	case CodeSuccess, CodeFailure:
+		b = make([]byte, 4)
+	default:
+		return nil, errors.New("eap: unknown packet code")
+	}
+	if len(b) > MaxPacketLength {
+		return nil, errors.New("eap: packet is too large")
+	}
+	b[0] = byte(p.Code)
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b, nil
+}
+
+// Nak returns the Nak Response a peer sends to the request p, proposing the
+// given authentication types instead - rfc3748, 5.3.1
+func (p *Packet) Nak(types ...Type) *Packet {
+	data := make([]byte, len(types))
+	for i, t := range types {
+		data[i] = byte(t)
+	}
+	if len(data) == 0 {
+		data = []byte{0}
+	}
+	return &Packet{
+		Code:       CodeResponse,
+		Identifier: p.Identifier,
+		Type:       TypeNak,
+		Data:       data,
+	}
+}
@@ -0,0 +1,106 @@
+package eap
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+)
+
+func TestPacket(t *testing.T) {
+	tests := []struct {
+		Packet *Packet
+		Wire   []byte
+	}{
+		{
+			&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")},
+			[]byte{0x02, 0x01, 0x00, 0x08, 0x01, 't', 'i', 'm'},
+		},
+		{
+			&Packet{Code: CodeRequest, Identifier: 2, Type: TypeIdentity},
+			[]byte{0x01, 0x02, 0x00, 0x05, 0x01},
+		},
+		{
+			&Packet{Code: CodeSuccess, Identifier: 3},
+			[]byte{0x03, 0x03, 0x00, 0x04},
+		},
+		{
+			&Packet{Code: CodeFailure, Identifier: 4},
+			[]byte{0x04, 0x04, 0x00, 0x04},
+		},
+	}
+	for _, tt := range tests {
+		wire, err := tt.Packet.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wire, tt.Wire) {
+			t.Fatalf("got %x; expected %x", wire, tt.Wire)
+		}
+		p, err := Parse(tt.Wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p, tt.Packet) {
+			t.Fatalf("got %#v; expected %#v", p, tt.Packet)
+		}
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	tests := [][]byte{
+		{0x01, 0x01, 0x00},
+		{0x01, 0x01, 0x00, 0x04},
+		{0x02, 0x01, 0x00, 0x09, 0x01, 't'},
+		{0x09, 0x01, 0x00, 0x04},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt); err == nil {
+			t.Fatalf("expected error parsing %x", tt)
+		}
+	}
+}
+
+func TestPacket_Nak(t *testing.T) {
+	request := &Packet{Code: CodeRequest, Identifier: 9, Type: TypeTLS}
+	nak := request.Nak(TypeMD5Challenge, TypeGTC)
+	if nak.Code != CodeResponse || nak.Identifier != 9 || nak.Type != TypeNak || !bytes.Equal(nak.Data, []byte{4, 6}) {
+		t.Fatalf("unexpected Nak %#v", nak)
+	}
+	if nak := request.Nak(); !bytes.Equal(nak.Data, []byte{0}) {
+		t.Fatalf("unexpected Nak data %x", nak.Data)
+	}
+}
+
+func TestSetMessage(t *testing.T) {
+	p := radius.New(radius.CodeAccessChallenge, []byte(`secret`))
+	e := &Packet{
+		Code:       CodeRequest,
+		Identifier: 5,
+		Type:       TypeTLS,
+		Data:       bytes.Repeat([]byte{0xee}, 1000),
+	}
+	if err := SetMessage(p, e); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := rfc2869.EAPMessage_Lookup(p); len(values) != 1005 {
+		t.Fatalf("got EAP-Message length %d; expected 1005", len(values))
+	}
+	if n := len(p.Attributes); n != 4 {
+		t.Fatalf("got %d EAP-Message attributes; expected 4", n)
+	}
+
+	// replaces the previous message
+	if err := SetMessage(p, e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LookupMessage(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, e) {
+		t.Fatalf("got %#v; expected %#v", got, e)
+	}
+}
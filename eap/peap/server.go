@@ -0,0 +1,360 @@
+// Package peap implements the server side of PEAPv0 (MS-PEAP), tunneling an
+// inner EAP method, most commonly EAP-MSCHAPv2, through a TLS connection.
+package peap
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/eaptls"
+)
+
+// ErrAuthenticationFailed is returned when the inner method fails.
+var ErrAuthenticationFailed = errors.New("peap: inner authentication failed")
+
+// Method is the server side of PEAPv0.
+//
+// The inner methods are started with their own session, whose Identity is
+// the inner identity and whose Request is the Access-Request currently being
+// processed. On completion, the outer session's InnerIdentity and
+// InnerMethod are set; on success, its MSK, EMSK and TLS fields are set too.
+type Method struct {
+	// Config is the TLS configuration of the server. The TLS version is
+	// limited to TLS 1.2.
+	Config *tls.Config
+
+	// Methods lists the inner methods in order of preference.
+	Methods []eap.Method
+
+	// DisableCryptoBinding disables the Crypto-Binding TLV, binding the inner
+	// method to the tunnel. When disabled, the keys are derived from the TLS
+	// connection alone.
+	DisableCryptoBinding bool
+
+	// FragmentSize is the maximum number of TLS octets sent in a single
+	// EAP-Request. Defaults to eaptls.DefaultFragmentSize.
+	FragmentSize int
+
+	// Timeout is how long the TLS connection waits for the peer's next
+	// response. Defaults to eaptls.DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Type implements eap.Method.
+func (m *Method) Type() eap.Type {
+	return eap.TypePEAP
+}
+
+// Start implements eap.Method.
+func (m *Method) Start(s *eap.Session) (eap.Conversation, []byte, error) {
+	if m.Config == nil {
+		return nil, nil, errors.New("peap: nil Config")
+	}
+	if len(m.Methods) == 0 {
+		return nil, nil, errors.New("peap: no inner methods")
+	}
+	config := m.Config.Clone()
+	if config.MaxVersion == 0 || config.MaxVersion > tls.VersionTLS12 {
+		config.MaxVersion = tls.VersionTLS12
+	}
+
+	c := &conversation{
+		method:  m,
+		session: s,
+	}
+	c.Conversation = eaptls.NewServerConversation(config, m.Timeout, c.tunnel)
+	c.FragmentSize = m.FragmentSize
+	return c, c.Start(), nil
+}
+
+type conversation struct {
+	*eaptls.Conversation
+	method  *Method
+	session *eap.Session
+
+	// guards the results of the inner authentication, which runs in the
+	// TLS goroutine
+	mu            sync.Mutex
+	innerIdentity string
+	innerMethod   eap.Type
+
+	// used by the TLS goroutine only, or once it has finished
+	inner         *eap.Session
+	identifier    byte
+	buf           []byte
+	msk, emsk     []byte
+	cryptoBinding bool
+}
+
+func (c *conversation) Process(response []byte) (eap.Status, []byte, error) {
+	status, request, err := c.Conversation.Process(response)
+	if status == eap.StatusContinue {
+		return status, request, err
+	}
+	c.mu.Lock()
+	c.session.InnerIdentity = c.innerIdentity
+	c.session.InnerMethod = c.innerMethod
+	c.mu.Unlock()
+	if status != eap.StatusSuccess {
+		return status, request, err
+	}
+
+	state := c.ConnectionState()
+	if !c.cryptoBinding {
+		if c.msk, c.emsk, err = eaptls.Keys(&state, eap.TypePEAP); err != nil {
+			return eap.StatusFailure, nil, err
+		}
+	}
+	c.session.MSK = c.msk
+	c.session.EMSK = c.emsk
+	c.session.TLS = &state
+	return eap.StatusSuccess, nil, nil
+}
+
+// tunnel runs the inner authentication inside the established TLS
+// connection.
+func (c *conversation) tunnel(conn *tls.Conn) error {
+	c.buf = make([]byte, 16*1024)
+	var identifier [1]byte
+	if _, err := rand.Read(identifier[:]); err != nil {
+		return err
+	}
+	c.identifier = identifier[0]
+
+	msg, err := c.exchange(conn, eap.TypeIdentity, nil)
+	if err != nil {
+		return err
+	}
+	if msg.Type != eap.TypeIdentity {
+		return errors.New("peap: expected inner identity")
+	}
+	c.inner = &eap.Session{
+		ID:       c.session.ID,
+		Identity: string(msg.Data),
+		Request:  c.session.Request,
+	}
+	c.mu.Lock()
+	c.innerIdentity = c.inner.Identity
+	c.mu.Unlock()
+
+	status, isk, err := c.authenticate(conn)
+	if err != nil {
+		return err
+	}
+	return c.result(conn, status == eap.StatusSuccess, isk)
+}
+
+// authenticate runs the inner methods, returning the session key of the
+// successful method.
+func (c *conversation) authenticate(conn *tls.Conn) (eap.Status, []byte, error) {
+	var tried []eap.Type
+	var conversation eap.Conversation
+	defer func() {
+		if closer, ok := conversation.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+
+	start := func(methods []eap.Method) ([]byte, bool, error) {
+		for _, m := range methods {
+			if containsType(tried, m.Type()) {
+				continue
+			}
+			tried = append(tried, m.Type())
+			if closer, ok := conversation.(io.Closer); ok {
+				closer.Close()
+			}
+			var data []byte
+			var err error
+			conversation, data, err = m.Start(c.inner)
+			if err != nil {
+				return nil, false, err
+			}
+			c.mu.Lock()
+			c.innerMethod = m.Type()
+			c.mu.Unlock()
+			return data, true, nil
+		}
+		return nil, false, nil
+	}
+
+	data, ok, err := start(c.method.Methods)
+	if !ok {
+		return eap.StatusFailure, nil, err
+	}
+	started := false
+	for {
+		msg, err := c.exchange(conn, c.innerMethod, data)
+		if err != nil {
+			return eap.StatusFailure, nil, err
+		}
+		c.inner.Request = c.session.Request
+
+		if msg.Type == eap.TypeNak && !started {
+			var methods []eap.Method
+			for _, m := range c.method.Methods {
+				if containsType(typesOf(msg.Data), m.Type()) {
+					methods = append(methods, m)
+				}
+			}
+			if data, ok, err = start(methods); !ok {
+				return eap.StatusFailure, nil, err
+			}
+			continue
+		}
+		if msg.Type != c.innerMethod {
+			return eap.StatusFailure, nil, nil
+		}
+		started = true
+
+		var status eap.Status
+		status, data, err = conversation.Process(msg.Data)
+		if err != nil {
+			return eap.StatusFailure, nil, err
+		}
+		if status != eap.StatusContinue {
+			return status, c.inner.MSK, nil
+		}
+	}
+}
+
+// result sends the Result TLV, and, on success, the Crypto-Binding TLV, and
+// verifies the peer's reply.
+func (c *conversation) result(conn *tls.Conn, success bool, isk []byte) error {
+	result := []byte{0, ResultSuccess}
+	if !success {
+		result[1] = ResultFailure
+	}
+	data := appendTLV(nil, tlvMandatory|TLVResult, result)
+
+	var ipmk, cmk, nonce []byte
+	binding := success && !c.method.DisableCryptoBinding
+	if binding {
+		state := conn.ConnectionState()
+		tk, err := state.ExportKeyingMaterial("client EAP encryption", nil, 60)
+		if err != nil {
+			return err
+		}
+		ipmk, cmk = compoundKeys(tk, isk)
+		nonce = make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		nonce[31] &^= 0x01
+		data = appendTLV(data, TLVCryptoBinding, cryptoBinding(cmk, 0, nonce))
+	}
+
+	c.identifier++
+	request := &eap.Packet{
+		Code:       eap.CodeRequest,
+		Identifier: c.identifier,
+		Type:       eap.TypeExtensions,
+		Data:       data,
+	}
+	msg, err := c.roundTrip(conn, request, true)
+	if err != nil {
+		return err
+	}
+	if msg.Type != eap.TypeExtensions {
+		return errors.New("peap: expected Extensions response")
+	}
+	tlvs, err := parseTLVs(msg.Data)
+	if err != nil {
+		return err
+	}
+	if r := findTLV(tlvs, TLVResult); len(r) != 2 || r[1] != result[1] {
+		return errors.New("peap: invalid Result TLV")
+	}
+	if !success {
+		return ErrAuthenticationFailed
+	}
+
+	if binding {
+		nonce[31]++
+		expected := cryptoBinding(cmk, 1, nonce)
+		if !hmac.Equal(findTLV(tlvs, TLVCryptoBinding), expected) {
+			return errors.New("peap: invalid Crypto-Binding TLV")
+		}
+		c.msk, c.emsk = sessionKeys(ipmk)
+		c.cryptoBinding = true
+	}
+	return nil
+}
+
+// exchange sends an inner EAP-Request, and returns the peer's response.
+// Inner packets other than Extensions are sent without their EAP header -
+// MS-PEAP, 3.1.5.6
+func (c *conversation) exchange(conn *tls.Conn, t eap.Type, data []byte) (*eap.Packet, error) {
+	c.identifier++
+	if c.inner != nil {
+		c.inner.Identifier = c.identifier
+	}
+	request := &eap.Packet{
+		Code:       eap.CodeRequest,
+		Identifier: c.identifier,
+		Type:       t,
+		Data:       data,
+	}
+	return c.roundTrip(conn, request, false)
+}
+
+func (c *conversation) roundTrip(conn *tls.Conn, request *eap.Packet, header bool) (*eap.Packet, error) {
+	b, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if !header {
+		b = b[4:]
+	}
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	n, err := conn.Read(c.buf)
+	if err != nil {
+		return nil, err
+	}
+	b = c.buf[:n]
+	if header {
+		msg, err := eap.Parse(b)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Code != eap.CodeResponse || msg.Identifier != request.Identifier {
+			return nil, errors.New("peap: unexpected inner response")
+		}
+		return msg, nil
+	}
+	if len(b) < 1 {
+		return nil, errors.New("peap: empty inner response")
+	}
+	return &eap.Packet{
+		Code:       eap.CodeResponse,
+		Identifier: request.Identifier,
+		Type:       eap.Type(b[0]),
+		Data:       append([]byte(nil), b[1:]...),
+	}, nil
+}
+
+func typesOf(b []byte) []eap.Type {
+	types := make([]eap.Type, len(b))
+	for i, t := range b {
+		types[i] = eap.Type(t)
+	}
+	return types
+}
+
+func containsType(types []eap.Type, t eap.Type) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+package peap
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/eapmschapv2"
+	"layeh.com/radius/eap/eaptls"
+	"layeh.com/radius/eap/internal/testcert"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc3079"
+)
+
+func testMethod() *Method {
+	return &Method{
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{testcert.Server()},
+		},
+		Methods: []eap.Method{
+			&eapmschapv2.Method{
+				Password: func(s *eap.Session, name string) ([]byte, error) {
+					if s.Identity != "tim" || name != "tim" {
+						return nil, nil
+					}
+					return []byte("clientPass"), nil
+				},
+			},
+		},
+		FragmentSize: 300,
+	}
+}
+
+// testPeer is the inner side of a PEAPv0 peer authenticating with
+// EAP-MSCHAPv2.
+type testPeer struct {
+	password string
+	msk      []byte
+}
+
+func (p *testPeer) tunnel(conn *tls.Conn) error {
+	buf := make([]byte, 4096)
+	read := func() ([]byte, error) {
+		n, err := conn.Read(buf)
+		return buf[:n], err
+	}
+
+	b, err := read()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b, []byte{byte(eap.TypeIdentity)}) {
+		return errors.New("expected identity request")
+	}
+	conn.Write(append([]byte{byte(eap.TypeIdentity)}, "tim"...))
+
+	// EAP-MSCHAPv2 Challenge
+	if b, err = read(); err != nil {
+		return err
+	}
+	if len(b) < 22 || b[0] != byte(eap.TypeMSCHAPv2) || b[1] != eapmschapv2.OpChallenge {
+		return errors.New("expected challenge")
+	}
+	id, challenge := b[2], append([]byte(nil), b[6:22]...)
+	peerChallenge := bytes.Repeat([]byte{0x21}, 16)
+	ntResponse, err := rfc2759.GenerateNTResponse(challenge, peerChallenge, []byte("tim"), []byte(p.password))
+	if err != nil {
+		return err
+	}
+	response := []byte{byte(eap.TypeMSCHAPv2), eapmschapv2.OpResponse, id, 0, 57, 49}
+	response = append(response, peerChallenge...)
+	response = append(response, make([]byte, 8)...)
+	response = append(response, ntResponse...)
+	response = append(response, 0)
+	response = append(response, "tim"...)
+	conn.Write(response)
+
+	// EAP-MSCHAPv2 Success or Failure
+	if b, err = read(); err != nil {
+		return err
+	}
+	if len(b) < 2 || b[0] != byte(eap.TypeMSCHAPv2) {
+		return errors.New("expected success or failure")
+	}
+	op := b[1]
+	conn.Write([]byte{byte(eap.TypeMSCHAPv2), op})
+
+	// Extensions
+	if b, err = read(); err != nil {
+		return err
+	}
+	msg, err := eap.Parse(b)
+	if err != nil {
+		return err
+	}
+	tlvs, err := parseTLVs(msg.Data)
+	if err != nil {
+		return err
+	}
+	result := findTLV(tlvs, TLVResult)
+	data := appendTLV(nil, tlvMandatory|TLVResult, result)
+	binding := findTLV(tlvs, TLVCryptoBinding)
+	if op == eapmschapv2.OpSuccess && binding != nil {
+		ucs2Password, _ := rfc2759.ToUTF16([]byte(p.password))
+		masterKey := rfc3079.GetMasterKey(rfc2759.NTPasswordHash(rfc2759.NTPasswordHash(ucs2Password)), ntResponse)
+		recvKey, _ := rfc3079.GetAsymmetricStartKey(masterKey, rfc3079.KeyLength128Bit, false)
+		sendKey, _ := rfc3079.GetAsymmetricStartKey(masterKey, rfc3079.KeyLength128Bit, true)
+
+		state := conn.ConnectionState()
+		tk, err := state.ExportKeyingMaterial("client EAP encryption", nil, 60)
+		if err != nil {
+			return err
+		}
+		ipmk, cmk := compoundKeys(tk, append(recvKey, sendKey...))
+		if len(binding) != cryptoBindingLength {
+			return errors.New("invalid Crypto-Binding TLV length")
+		}
+		nonce := append([]byte(nil), binding[4:36]...)
+		if !bytes.Equal(binding, cryptoBinding(cmk, 0, nonce)) {
+			return errors.New("invalid Crypto-Binding TLV")
+		}
+		nonce[31]++
+		data = appendTLV(data, TLVCryptoBinding, cryptoBinding(cmk, 1, nonce))
+		p.msk, _ = sessionKeys(ipmk)
+	}
+	reply, err := (&eap.Packet{
+		Code:       eap.CodeResponse,
+		Identifier: msg.Identifier,
+		Type:       eap.TypeExtensions,
+		Data:       data,
+	}).Encode()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(reply)
+	return err
+}
+
+func authenticate(t *testing.T, m *Method, password string) (*eap.Session, *testPeer, error) {
+	t.Helper()
+
+	s := &eap.Session{Identity: "anonymous"}
+	c, request, err := m.Start(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(*conversation).Close()
+
+	p := &testPeer{password: password}
+	config := &tls.Config{
+		RootCAs:    testcert.Pool(),
+		ServerName: "radius.example.com",
+	}
+	peer := eaptls.NewClientConversation(config, time.Second, p.tunnel)
+	defer peer.Close()
+	for i := 0; i < 50; i++ {
+		response, err := peer.Respond(request)
+		if err != nil {
+			return s, p, err
+		}
+		status, next, err := c.Process(response)
+		switch status {
+		case eap.StatusSuccess:
+			return s, p, nil
+		case eap.StatusFailure:
+			return s, p, err
+		}
+		request = next
+	}
+	t.Fatal("too many rounds")
+	return nil, nil, nil
+}
+
+func TestMethod(t *testing.T) {
+	s, p, err := authenticate(t, testMethod(), "clientPass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Identity != "anonymous" || s.InnerIdentity != "tim" || s.InnerMethod != eap.TypeMSCHAPv2 {
+		t.Fatalf("unexpected identities %q, %q, %v", s.Identity, s.InnerIdentity, s.InnerMethod)
+	}
+	if s.TLS == nil || s.TLS.Version != tls.VersionTLS12 {
+		t.Fatalf("unexpected connection state %+v", s.TLS)
+	}
+	if len(s.MSK) != 64 || !bytes.Equal(s.MSK, p.msk) {
+		t.Fatalf("got MSK %x, expected %x", s.MSK, p.msk)
+	}
+}
+
+func TestMethod_invalidPassword(t *testing.T) {
+	s, _, err := authenticate(t, testMethod(), "wrong")
+	if err != ErrAuthenticationFailed {
+		t.Fatalf("got %v", err)
+	}
+	if s.InnerIdentity != "tim" || s.MSK != nil {
+		t.Fatalf("unexpected session %+v", s)
+	}
+}
+
+func TestMethod_noCryptoBinding(t *testing.T) {
+	m := testMethod()
+	m.DisableCryptoBinding = true
+	s, p, err := authenticate(t, m, "clientPass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.msk != nil {
+		t.Fatal("unexpected Crypto-Binding TLV")
+	}
+	msk, _, err := eaptls.Keys(s.TLS, eap.TypePEAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.MSK, msk) {
+		t.Fatalf("got MSK %x, expected %x", s.MSK, msk)
+	}
+}
+
+// TestCompoundKeys checks the key derivation and the Compound MAC against
+// known answers, computed with an implementation of MS-PEAP, 3.1.5.5.2 and
+// 3.1.5.7 independent of this package, so that the test peer, which shares
+// these functions with the server, cannot hide a defect in them.
+func TestCompoundKeys(t *testing.T) {
+	tk := make([]byte, 60)
+	for i := range tk {
+		tk[i] = byte(i)
+	}
+	isk := make([]byte, 32)
+	nonce := make([]byte, 32)
+	for i := range isk {
+		isk[i] = byte(0x80 + i)
+		nonce[i] = byte(0x40 + i)
+	}
+
+	tests := []struct {
+		ISK  []byte
+		IPMK string
+		CMK  string
+	}{
+		{
+			isk,
+			"d09f95bdc82242ef082b01f379ec2eb46d49f01f84b975865dfa0b65faf67b052dabc6c496f8a9b3",
+			"8adb63fcbe7efe4b3ce82a8a53aab6bac090a9df",
+		},
+		{
+			// an inner method without keys uses an all-zero ISK
+			nil,
+			"d5a1e52eb1a406716f70a2cab4f31e2f956c7ca0e02ea4f61a83cbca1f68070b2fb406f67be84d14",
+			"81e27e1da4b054c5e835cdea50d538556e8aebd1",
+		},
+	}
+	for i, tt := range tests {
+		ipmk, cmk := compoundKeys(tk, tt.ISK)
+		if hex.EncodeToString(ipmk) != tt.IPMK || hex.EncodeToString(cmk) != tt.CMK {
+			t.Errorf("#%d: got IPMK %x and CMK %x", i, ipmk, cmk)
+		}
+	}
+
+	ipmk, cmk := compoundKeys(tk, isk)
+	binding := cryptoBinding(cmk, 0, nonce)
+	if !bytes.Equal(binding[4:36], nonce) || binding[3] != 0 {
+		t.Fatalf("got Crypto-Binding TLV %x", binding)
+	}
+	if mac := hex.EncodeToString(binding[36:]); mac != "f9cc27c10d85ec59fd69c71db35649ad5efbef2a" {
+		t.Fatalf("got Compound MAC %s", mac)
+	}
+
+	msk, emsk := sessionKeys(ipmk)
+	if hex.EncodeToString(msk) != "94837390de739189a0c465b3ec52b6315a22204bfd7f4f0b648f2b5a74e5f7fc"+
+		"94e476e3c573e8e69be2610f1d372981c466771655f6b43582531def8869c3ca" {
+		t.Fatalf("got MSK %x", msk)
+	}
+	if hex.EncodeToString(emsk) != "34cfeea63bce282d9bc8501164dfaf2b6f5807c647ea8825f4b23a181eef98bb"+
+		"9d8bd458400d140575c21d8e135d38a118a2cb99152230efca1e17b9024ee724" {
+		t.Fatalf("got EMSK %x", emsk)
+	}
+}
@@ -0,0 +1,115 @@
+package peap
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+)
+
+// TLV types carried in EAP-Extensions packets - MS-PEAP, 2.2.8
+const (
+	TLVResult        = 3
+	TLVCryptoBinding = 12
+
+	tlvMandatory = 0x8000
+)
+
+// Result TLV values
+const (
+	ResultSuccess = 1
+	ResultFailure = 2
+)
+
+const cryptoBindingLength = 56
+
+type tlv struct {
+	typ   uint16
+	value []byte
+}
+
+func parseTLVs(b []byte) ([]tlv, error) {
+	var tlvs []tlv
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errors.New("peap: truncated TLV")
+		}
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < 4+length {
+			return nil, errors.New("peap: invalid TLV length")
+		}
+		tlvs = append(tlvs, tlv{
+			typ:   binary.BigEndian.Uint16(b[0:2]) &^ 0xC000,
+			value: b[4 : 4+length],
+		})
+		b = b[4+length:]
+	}
+	return tlvs, nil
+}
+
+func appendTLV(b []byte, typ uint16, value []byte) []byte {
+	var hdr [4]byte
+	binary.BigEndian.PutUint16(hdr[0:2], typ)
+	binary.BigEndian.PutUint16(hdr[2:4], uint16(len(value)))
+	return append(append(b, hdr[:]...), value...)
+}
+
+func findTLV(tlvs []tlv, typ uint16) []byte {
+	for _, t := range tlvs {
+		if t.typ == typ {
+			return t.value
+		}
+	}
+	return nil
+}
+
+// prfPlus is the PRF+ function of PEAPv0 - MS-PEAP, 3.1.5.5.2.2
+//
+//	T1 = HMAC-SHA1(K, S | 0x01 | 0x00 | 0x00)
+//	Tn = HMAC-SHA1(K, Tn-1 | S | n | 0x00 | 0x00)
+func prfPlus(key []byte, label string, seed []byte, length int) []byte {
+	out := make([]byte, 0, length+sha1.Size)
+	var t []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		h := hmac.New(sha1.New, key)
+		h.Write(t)
+		h.Write([]byte(label))
+		h.Write(seed)
+		h.Write([]byte{counter, 0x00, 0x00})
+		t = h.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+// compoundKeys derives the IPMK and CMK from the tunnel key and the inner
+// method's session key - MS-PEAP, 3.1.5.5.2.1
+func compoundKeys(tk, isk []byte) (ipmk, cmk []byte) {
+	var seed [32]byte
+	copy(seed[:], isk)
+	imck := prfPlus(tk[:40], "Inner Methods Compound Keys", seed[:], 60)
+	return imck[:40], imck[40:60]
+}
+
+// cryptoBinding returns the value of a Crypto-Binding TLV with the given
+// subtype (0 for a request, 1 for a response) and nonce.
+func cryptoBinding(cmk []byte, subtype byte, nonce []byte) []byte {
+	tlv := make([]byte, 4+cryptoBindingLength)
+	binary.BigEndian.PutUint16(tlv[0:2], TLVCryptoBinding)
+	binary.BigEndian.PutUint16(tlv[2:4], cryptoBindingLength)
+	// reserved, version and received version are zero
+	tlv[7] = subtype
+	copy(tlv[8:40], nonce)
+
+	h := hmac.New(sha1.New, cmk)
+	h.Write(tlv)
+	h.Write([]byte{25}) // EAP type of PEAP
+	copy(tlv[40:60], h.Sum(nil))
+	return tlv[4:]
+}
+
+// sessionKeys derives the MSK and EMSK from the IPMK - MS-PEAP, 3.1.5.7
+func sessionKeys(ipmk []byte) (msk, emsk []byte) {
+	csk := prfPlus(ipmk, "Session Key Generating Function", []byte{0x00}, 128)
+	return csk[:64], csk[64:]
+}
@@ -0,0 +1,121 @@
+package eap
+
+import (
+	"errors"
+	"io"
+)
+
+// PeerMethod is the peer side of an EAP authentication method.
+type PeerMethod interface {
+	// Type returns the EAP type implemented by the method.
+	Type() Type
+	// Start begins a conversation with the authenticator.
+	Start() (PeerConversation, error)
+}
+
+// PeerConversation is the per-conversation state of a PeerMethod.
+type PeerConversation interface {
+	// Respond handles an EAP-Request of the method, and returns the
+	// Type-Data of the EAP-Response.
+	//
+	// If the conversation implements io.Closer, Close is called once the
+	// conversation has ended. Conversations of methods deriving keying
+	// material implement Keys() (msk, emsk []byte).
+	Respond(request *Packet) (response []byte, err error)
+}
+
+// Peer is the peer side of an EAP conversation, independent of the lower
+// layer carrying the EAP packets.
+type Peer struct {
+	// Identity is sent in response to Identity requests.
+	Identity string
+
+	// Methods lists the supported methods. Requests for any other method are
+	// answered with a Nak proposing these methods.
+	Methods []PeerMethod
+
+	method       Type
+	conversation PeerConversation
+}
+
+// Respond returns the EAP-Response to the EAP-Request request.
+func (p *Peer) Respond(request *Packet) (*Packet, error) {
+	if request.Code != CodeRequest {
+		return nil, errors.New("eap: expected request")
+	}
+	response := &Packet{
+		Code:       CodeResponse,
+		Identifier: request.Identifier,
+		Type:       request.Type,
+	}
+
+	switch request.Type {
+	case TypeIdentity:
+		p.Close()
+		response.Data = []byte(p.Identity)
+		return response, nil
+	case TypeNotification:
+		// rfc3748, 5.2: the response carries no data
+		return response, nil
+	case TypeNak, TypeExpanded:
+		return nil, errors.New("eap: unsupported request type " + request.Type.String())
+	}
+
+	if p.conversation == nil || p.method != request.Type {
+		var method PeerMethod
+		for _, m := range p.Methods {
+			if m.Type() == request.Type {
+				method = m
+				break
+			}
+		}
+		if method == nil {
+			types := make([]Type, len(p.Methods))
+			for i, m := range p.Methods {
+				types[i] = m.Type()
+			}
+			return request.Nak(types...), nil
+		}
+
+		p.Close()
+		conversation, err := method.Start()
+		if err != nil {
+			return nil, err
+		}
+		p.method = method.Type()
+		p.conversation = conversation
+	}
+
+	data, err := p.conversation.Respond(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Data = data
+	return response, nil
+}
+
+// Conversation returns the conversation of the method currently
+// authenticating, or nil.
+func (p *Peer) Conversation() PeerConversation {
+	return p.conversation
+}
+
+// Keys returns the MSK and EMSK derived by the current conversation, or nil
+// if its method does not derive keying material or has not completed.
+func (p *Peer) Keys() (msk, emsk []byte) {
+	if c, ok := p.conversation.(interface{ Keys() ([]byte, []byte) }); ok {
+		return c.Keys()
+	}
+	return nil, nil
+}
+
+// Close ends the current conversation.
+func (p *Peer) Close() error {
+	var err error
+	if c, ok := p.conversation.(io.Closer); ok {
+		err = c.Close()
+	}
+	p.conversation = nil
+	p.method = 0
+	return err
+}
@@ -0,0 +1,73 @@
+package eap
+
+import (
+	"bytes"
+	"testing"
+)
+
+type echoPeer struct {
+	typ    Type
+	closed bool
+}
+
+func (p *echoPeer) Type() Type { return p.typ }
+
+func (p *echoPeer) Start() (PeerConversation, error) { return p, nil }
+
+func (p *echoPeer) Respond(request *Packet) ([]byte, error) { return request.Data, nil }
+
+func (p *echoPeer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestPeer(t *testing.T) {
+	method := &echoPeer{typ: TypeGTC}
+	peer := &Peer{
+		Identity: "tim",
+		Methods:  []PeerMethod{method},
+	}
+
+	tests := []struct {
+		Request  *Packet
+		Response *Packet
+	}{
+		{
+			&Packet{Code: CodeRequest, Identifier: 1, Type: TypeIdentity},
+			&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")},
+		},
+		{
+			&Packet{Code: CodeRequest, Identifier: 2, Type: TypeNotification, Data: []byte("hello")},
+			&Packet{Code: CodeResponse, Identifier: 2, Type: TypeNotification},
+		},
+		{
+			&Packet{Code: CodeRequest, Identifier: 3, Type: TypeTLS, Data: []byte{0x20}},
+			&Packet{Code: CodeResponse, Identifier: 3, Type: TypeNak, Data: []byte{byte(TypeGTC)}},
+		},
+		{
+			&Packet{Code: CodeRequest, Identifier: 4, Type: TypeGTC, Data: []byte("token")},
+			&Packet{Code: CodeResponse, Identifier: 4, Type: TypeGTC, Data: []byte("token")},
+		},
+	}
+	for i, tt := range tests {
+		response, err := peer.Respond(tt.Request)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if response.Code != tt.Response.Code || response.Identifier != tt.Response.Identifier || response.Type != tt.Response.Type || !bytes.Equal(response.Data, tt.Response.Data) {
+			t.Fatalf("%d: got %#v, expected %#v", i, response, tt.Response)
+		}
+	}
+
+	if peer.Conversation() == nil {
+		t.Fatal("expected conversation")
+	}
+	peer.Close()
+	if !method.closed || peer.Conversation() != nil {
+		t.Fatal("conversation not closed")
+	}
+
+	if _, err := peer.Respond(&Packet{Code: CodeSuccess}); err == nil {
+		t.Fatal("expected error")
+	}
+}
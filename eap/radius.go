@@ -0,0 +1,29 @@
+package eap
+
+import (
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+)
+
+// LookupMessage reassembles and parses the EAP packet carried in the
+// EAP-Message attributes of p.
+//
+// radius.ErrNoAttribute is returned if p does not contain an EAP-Message.
+func LookupMessage(p *radius.Packet) (*Packet, error) {
+	b, err := rfc2869.EAPMessage_Lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// SetMessage replaces the EAP-Message attributes of p with the encoded EAP
+// packet e, split across as many attributes as required - rfc3579, 3.1
+func SetMessage(p *radius.Packet, e *Packet) error {
+	b, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	rfc2869.EAPMessage_Del(p)
+	return rfc2869.EAPMessage_Set(p, b)
+}
@@ -0,0 +1,442 @@
+package eap
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/internal/statestore"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+// Status is the outcome of processing an EAP-Response.
+type Status int
+
+// Conversation statuses.
+const (
+	// StatusContinue indicates that another EAP-Request must be sent.
+	StatusContinue Status = iota
+	// StatusSuccess indicates that the peer has authenticated.
+	StatusSuccess
+	// StatusFailure indicates that the peer has failed to authenticate.
+	StatusFailure
+)
+
+// Method is a server-side EAP authentication method.
+type Method interface {
+	// Type returns the EAP type implemented by the method.
+	Type() Type
+	// Start begins authenticating the given session. It returns the state
+	// of the conversation and the Type-Data of the first EAP-Request.
+	Start(s *Session) (Conversation, []byte, error)
+}
+
+// Conversation is the per-session state of a Method.
+type Conversation interface {
+	// Process handles the Type-Data of an EAP-Response. When the returned
+	// status is StatusContinue, request holds the Type-Data of the next
+	// EAP-Request.
+	//
+	// A method that derives keying material sets the session's MSK and EMSK
+	// before returning StatusSuccess.
+	//
+	// If the conversation implements io.Closer, Close is called once the
+	// conversation has ended.
+	Process(response []byte) (status Status, request []byte, err error)
+}
+
+// Session is an in-progress EAP conversation.
+type Session struct {
+	// ID is the value of the State attribute identifying the session.
+	ID []byte
+	// Identity is the identity sent by the peer in its EAP-Response/Identity.
+	Identity string
+	// Method is the type of the method currently authenticating the peer.
+	Method Type
+	// Identifier is the Identifier of the last EAP-Request sent to the peer,
+	// and thus of the EAP-Response being processed by a Conversation.
+	Identifier byte
+
+	// Request is the Access-Request currently being processed.
+	Request *radius.Request
+
+	// MSK and EMSK hold the keying material exported by the method.
+	MSK  []byte
+	EMSK []byte
+
+	// InnerIdentity and InnerMethod are set by tunneled methods to the
+	// identity and EAP method authenticated inside the tunnel, whereas
+	// Identity holds the outer, often anonymous, identity. InnerMethod is
+	// zero if the inner authentication is not an EAP method.
+	InnerIdentity string
+	InnerMethod   Type
+
+	// TLS holds the state of the TLS connection established by TLS based
+	// methods, such as the peer's certificates.
+	TLS *tls.ConnectionState
+
+	mu           sync.Mutex
+	conversation Conversation
+	started      bool // method has received a response
+	tried        []Type
+	rounds       int
+	finished     bool // the final reply has been sent
+
+	// last reply sent, resent when the NAS retransmits its request
+	lastAuthenticator [16]byte
+	lastResponse      *radius.Packet
+}
+
+// close releases the current conversation of s.
+func (s *Session) close() {
+	if c, ok := s.conversation.(io.Closer); ok {
+		c.Close()
+	}
+	s.conversation = nil
+}
+
+// Server terminates EAP conversations carried in RADIUS Access-Requests
+// (RFC 3579).
+type Server struct {
+	// Methods lists the supported methods in order of preference. The first
+	// method is proposed to the peer after it has sent its identity; a Nak
+	// selects the most preferred method the peer asked for.
+	Methods []Method
+
+	// SessionTimeout is how long a session waits for the peer's next
+	// response. Defaults to 30 seconds.
+	SessionTimeout time.Duration
+
+	// MaxRounds limits the number of Access-Challenge rounds of a
+	// conversation. Defaults to 50.
+	MaxRounds int
+
+	// Authorize, if non-nil, is called before an Access-Accept is sent. It may
+	// add reply attributes to response; returning an error sends an
+	// Access-Reject instead.
+	Authorize func(s *Session, response *radius.Packet) error
+
+	// ErrorLog specifies an optional logger for errors around packet
+	// processing. If nil, logging is done via the log package's standard
+	// logger.
+	ErrorLog *log.Logger
+
+	// sessions holds the sessions between rounds, keyed by their ID. They
+	// are kept in memory, as they hold the state of the methods, such as
+	// TLS connections.
+	sessions statestore.Memory
+}
+
+// Errors returned by Server.Handle for packets that must be silently
+// discarded.
+var (
+	ErrMissingMessageAuthenticator = errors.New("eap: missing Message-Authenticator")
+	ErrUnexpectedIdentifier        = errors.New("eap: unexpected EAP identifier")
+)
+
+func (srv *Server) logf(format string, args ...interface{}) {
+	if srv.ErrorLog != nil {
+		srv.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (srv *Server) sessionTimeout() time.Duration {
+	if srv.SessionTimeout > 0 {
+		return srv.SessionTimeout
+	}
+	return 30 * time.Second
+}
+
+func (srv *Server) maxRounds() int {
+	if srv.MaxRounds > 0 {
+		return srv.MaxRounds
+	}
+	return 50
+}
+
+// ServeRADIUS implements radius.Handler. Requests that Handle rejects with an
+// error are logged and silently discarded.
+func (srv *Server) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	response, err := srv.Handle(r)
+	if err != nil {
+		srv.logf("eap: discarding request from %v: %v", r.RemoteAddr, err)
+		return
+	}
+	w.Write(response)
+}
+
+// Handle processes the EAP-Message of the Access-Request r and returns the
+// signed Access-Challenge, Access-Accept or Access-Reject to send in reply.
+//
+// An error is returned if the request must be silently discarded: it is not
+// an Access-Request, its Message-Authenticator is missing or invalid
+// (rfc3579, 3.2), or its EAP-Message is malformed.
+func (srv *Server) Handle(r *radius.Request) (*radius.Packet, error) {
+	if r.Code != radius.CodeAccessRequest {
+		return nil, errors.New("eap: not an Access-Request")
+	}
+	if err := rfc2869.MessageAuthenticator_Verify(r.Packet, nil); err != nil {
+		if err == radius.ErrNoAttribute {
+			return nil, ErrMissingMessageAuthenticator
+		}
+		return nil, err
+	}
+	raw, err := rfc2869.EAPMessage_Lookup(r.Packet)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := rfc2865.State_Lookup(r.Packet)
+	if err != nil {
+		if len(raw) == 0 {
+			// rfc3579, 2.6.1: EAP-Start
+			return srv.startIdentity(r)
+		}
+		msg, err := Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		return srv.start(r, msg)
+	}
+
+	msg, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Code != CodeResponse {
+		return nil, errors.New("eap: not an EAP-Response")
+	}
+
+	id := string(state)
+	s, _ := srv.sessions.Get(id).(*Session)
+	if s == nil {
+		return srv.finish(r, nil, msg.Identifier, StatusFailure)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastResponse != nil && r.Identifier == s.lastResponse.Identifier && r.Authenticator == s.lastAuthenticator {
+		return s.lastResponse, nil
+	}
+	if s.finished {
+		return srv.finish(r, nil, msg.Identifier, StatusFailure)
+	}
+	if msg.Identifier != s.Identifier {
+		return nil, ErrUnexpectedIdentifier
+	}
+	s.Request = r
+	s.rounds++
+	if s.rounds > srv.maxRounds() {
+		return srv.end(r, s, msg.Identifier, StatusFailure)
+	}
+
+	var status Status
+	var data []byte
+	switch {
+	case s.conversation == nil && msg.Type == TypeIdentity:
+		// response to an EAP-Start initiated Identity request
+		s.Identity = string(msg.Data)
+		status, data, err = srv.startMethod(s, srv.Methods)
+	case msg.Type == TypeNak && !s.started:
+		status, data, err = srv.startMethod(s, srv.nakMethods(msg.Data))
+	case s.conversation != nil && msg.Type == s.Method:
+		s.started = true
+		status, data, err = s.conversation.Process(msg.Data)
+	default:
+		status = StatusFailure
+	}
+	if err != nil {
+		srv.logf("eap: %v method error for %q: %v", s.Method, s.Identity, err)
+		status = StatusFailure
+	}
+
+	if status != StatusContinue {
+		return srv.end(r, s, msg.Identifier, status)
+	}
+	return srv.challenge(r, s, s.Method, data)
+}
+
+// end ends the conversation of s, and keeps its final reply until the
+// session timeout, so that it is resent when the NAS retransmits r.
+func (srv *Server) end(r *radius.Request, s *Session, identifier byte, status Status) (*radius.Packet, error) {
+	response, err := srv.finish(r, s, identifier, status)
+	if err != nil {
+		srv.sessions.Delete(string(s.ID))
+		return nil, err
+	}
+	s.finished = true
+	s.lastAuthenticator = r.Authenticator
+	s.lastResponse = response
+	srv.sessions.Put(string(s.ID), s, time.Now().Add(srv.sessionTimeout()))
+	return response, nil
+}
+
+// startIdentity begins a conversation with an EAP-Request/Identity.
+func (srv *Server) startIdentity(r *radius.Request) (*radius.Packet, error) {
+	s, err := srv.newSession(r)
+	if err != nil {
+		return nil, err
+	}
+	return srv.challenge(r, s, TypeIdentity, nil)
+}
+
+// start begins a conversation with the peer's EAP-Response/Identity.
+func (srv *Server) start(r *radius.Request, msg *Packet) (*radius.Packet, error) {
+	if msg.Code != CodeResponse || msg.Type != TypeIdentity {
+		return srv.finish(r, nil, msg.Identifier, StatusFailure)
+	}
+	s, err := srv.newSession(r)
+	if err != nil {
+		return nil, err
+	}
+	s.Identity = string(msg.Data)
+	s.Identifier = msg.Identifier
+
+	status, data, err := srv.startMethod(s, srv.Methods)
+	if err != nil {
+		srv.logf("eap: %v method error for %q: %v", s.Method, s.Identity, err)
+		status = StatusFailure
+	}
+	if status != StatusContinue {
+		return srv.finish(r, s, msg.Identifier, status)
+	}
+	return srv.challenge(r, s, s.Method, data)
+}
+
+func (srv *Server) newSession(r *radius.Request) (*Session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	var identifier [1]byte
+	if _, err := rand.Read(identifier[:]); err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:         id,
+		Request:    r,
+		Identifier: identifier[0] - 1,
+	}, nil
+}
+
+// nakMethods returns the configured methods requested in the data of a Nak.
+func (srv *Server) nakMethods(desired []byte) []Method {
+	var methods []Method
+	for _, m := range srv.Methods {
+		for _, t := range desired {
+			if m.Type() == Type(t) {
+				methods = append(methods, m)
+				break
+			}
+		}
+	}
+	return methods
+}
+
+// startMethod starts the first method of methods that has not yet been
+// tried in s.
+func (srv *Server) startMethod(s *Session, methods []Method) (Status, []byte, error) {
+	for _, m := range methods {
+		tried := false
+		for _, t := range s.tried {
+			if t == m.Type() {
+				tried = true
+				break
+			}
+		}
+		if tried {
+			continue
+		}
+
+		s.tried = append(s.tried, m.Type())
+		s.close()
+		conversation, data, err := m.Start(s)
+		if err != nil {
+			return StatusFailure, nil, err
+		}
+		s.Method = m.Type()
+		s.conversation = conversation
+		s.started = false
+		return StatusContinue, data, nil
+	}
+	return StatusFailure, nil, nil
+}
+
+// challenge returns an Access-Challenge carrying the next EAP-Request of s.
+func (srv *Server) challenge(r *radius.Request, s *Session, t Type, data []byte) (*radius.Packet, error) {
+	s.Identifier++
+	response := r.Response(radius.CodeAccessChallenge)
+	err := SetMessage(response, &Packet{
+		Code:       CodeRequest,
+		Identifier: s.Identifier,
+		Type:       t,
+		Data:       data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := rfc2865.State_Set(response, s.ID); err != nil {
+		return nil, err
+	}
+	if err := rfc2869.MessageAuthenticator_Sign(response); err != nil {
+		return nil, err
+	}
+	s.lastAuthenticator = r.Authenticator
+	s.lastResponse = response
+	srv.sessions.Put(string(s.ID), s, time.Now().Add(srv.sessionTimeout()))
+	return response, nil
+}
+
+// finish returns the Access-Accept or Access-Reject ending a conversation. s
+// is nil if the conversation has no session.
+func (srv *Server) finish(r *radius.Request, s *Session, identifier byte, status Status) (*radius.Packet, error) {
+	if s != nil {
+		s.close()
+	}
+	if status == StatusSuccess {
+		response := r.Response(radius.CodeAccessAccept)
+		err := SetMessage(response, &Packet{
+			Code:       CodeSuccess,
+			Identifier: identifier,
+		})
+		if err == nil && len(s.MSK) >= 64 {
+			var recvKey, sendKey []byte
+			recvKey, sendKey, err = microsoft.MPPEKeysFromMSK(s.MSK)
+			if err == nil {
+				err = microsoft.MPPEKeys_Set(response, recvKey, sendKey)
+			}
+		}
+		if err == nil && srv.Authorize != nil {
+			err = srv.Authorize(s, response)
+		}
+		if err == nil {
+			if err := rfc2869.MessageAuthenticator_Sign(response); err != nil {
+				return nil, err
+			}
+			return response, nil
+		}
+		srv.logf("eap: rejecting %q: %v", s.Identity, err)
+	}
+
+	response := r.Response(radius.CodeAccessReject)
+	err := SetMessage(response, &Packet{
+		Code:       CodeFailure,
+		Identifier: identifier,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := rfc2869.MessageAuthenticator_Sign(response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,324 @@
+package eap
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+var testSecret = []byte(`secret`)
+
+// echoMethod accepts the peer once it has echoed the server's nonce.
+type echoMethod struct {
+	typ Type
+}
+
+func (m *echoMethod) Type() Type { return m.typ }
+
+func (m *echoMethod) Start(s *Session) (Conversation, []byte, error) {
+	return &echoConversation{s: s, nonce: []byte("nonce")}, []byte("nonce"), nil
+}
+
+type echoConversation struct {
+	s     *Session
+	nonce []byte
+}
+
+func (c *echoConversation) Process(response []byte) (Status, []byte, error) {
+	if bytes.Equal(response, []byte("error")) {
+		return StatusFailure, nil, errors.New("method error")
+	}
+	if !bytes.Equal(response, c.nonce) {
+		return StatusFailure, nil, nil
+	}
+	c.s.MSK = bytes.Repeat([]byte{0x42}, 64)
+	return StatusSuccess, nil, nil
+}
+
+// testPeer drives a Server with Access-Requests.
+type testPeer struct {
+	t      *testing.T
+	server *Server
+	state  []byte
+}
+
+func (p *testPeer) exchange(msg *Packet) *radius.Packet {
+	p.t.Helper()
+
+	packet := radius.New(radius.CodeAccessRequest, testSecret)
+	rfc2865.UserName_SetString(packet, "tim")
+	if msg != nil {
+		if err := SetMessage(packet, msg); err != nil {
+			p.t.Fatal(err)
+		}
+	} else {
+		// EAP-Start
+		packet.Add(rfc2869.EAPMessage_Type, radius.Attribute{})
+	}
+	if p.state != nil {
+		rfc2865.State_Set(packet, p.state)
+	}
+	if err := rfc2869.MessageAuthenticator_Sign(packet); err != nil {
+		p.t.Fatal(err)
+	}
+
+	response, err := p.server.Handle(p.request(packet))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if err := rfc2869.MessageAuthenticator_Verify(response, nil); err != nil {
+		p.t.Fatalf("response Message-Authenticator: %v", err)
+	}
+	p.state = rfc2865.State_Get(response)
+	return response
+}
+
+func (p *testPeer) request(packet *radius.Packet) *radius.Request {
+	return &radius.Request{
+		LocalAddr:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1812},
+		RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000},
+		Packet:     packet,
+	}
+}
+
+func expectMessage(t *testing.T, response *radius.Packet, code radius.Code, eapCode Code, eapType Type) *Packet {
+	t.Helper()
+	if response.Code != code {
+		t.Fatalf("got %v; expected %v", response.Code, code)
+	}
+	msg, err := LookupMessage(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != eapCode || msg.Type != eapType {
+		t.Fatalf("got EAP %v/%v; expected %v/%v", msg.Code, msg.Type, eapCode, eapType)
+	}
+	return msg
+}
+
+func TestServer_success(t *testing.T) {
+	var authorized *Session
+	server := &Server{
+		Methods: []Method{&echoMethod{typ: TypeMD5Challenge}},
+		Authorize: func(s *Session, response *radius.Packet) error {
+			authorized = s
+			return rfc2865.ReplyMessage_SetString(response, "welcome")
+		},
+	}
+	peer := &testPeer{t: t, server: server}
+
+	response := peer.exchange(&Packet{Code: CodeResponse, Identifier: 7, Type: TypeIdentity, Data: []byte("tim")})
+	msg := expectMessage(t, response, radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+	if msg.Identifier != 8 || !bytes.Equal(msg.Data, []byte("nonce")) {
+		t.Fatalf("unexpected request %#v", msg)
+	}
+	if len(peer.state) == 0 {
+		t.Fatal("missing State")
+	}
+
+	response = peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: msg.Data})
+	expectMessage(t, response, radius.CodeAccessAccept, CodeSuccess, 0)
+	if authorized == nil || authorized.Identity != "tim" || authorized.Method != TypeMD5Challenge {
+		t.Fatalf("unexpected authorized session %#v", authorized)
+	}
+	if rfc2865.ReplyMessage_GetString(response) != "welcome" {
+		t.Fatal("missing Reply-Message added by Authorize")
+	}
+	if _, ok := response.Lookup(rfc2865.VendorSpecific_Type); !ok {
+		t.Fatal("missing MS-MPPE keys")
+	}
+	if s, _ := server.sessions.Get(string(authorized.ID)).(*Session); s == nil || !s.finished || s.conversation != nil {
+		t.Fatal("session not finished")
+	}
+}
+
+func TestServer_eapStartAndNak(t *testing.T) {
+	server := &Server{
+		Methods: []Method{
+			&echoMethod{typ: TypeTLS},
+			&echoMethod{typ: TypeMD5Challenge},
+		},
+	}
+	peer := &testPeer{t: t, server: server}
+
+	response := peer.exchange(nil)
+	msg := expectMessage(t, response, radius.CodeAccessChallenge, CodeRequest, TypeIdentity)
+
+	response = peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeIdentity, Data: []byte("tim")})
+	msg = expectMessage(t, response, radius.CodeAccessChallenge, CodeRequest, TypeTLS)
+
+	response = peer.exchange(msg.Nak(TypeGTC, TypeMD5Challenge))
+	msg = expectMessage(t, response, radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+
+	response = peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: []byte("wrong")})
+	expectMessage(t, response, radius.CodeAccessReject, CodeFailure, 0)
+}
+
+func TestServer_failures(t *testing.T) {
+	server := &Server{
+		Methods: []Method{&echoMethod{typ: TypeMD5Challenge}},
+		Authorize: func(s *Session, response *radius.Packet) error {
+			return errors.New("denied")
+		},
+	}
+
+	// Nak without an acceptable method
+	peer := &testPeer{t: t, server: server}
+	msg := expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")}), radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+	expectMessage(t, peer.exchange(msg.Nak(TypeTLS)), radius.CodeAccessReject, CodeFailure, 0)
+
+	// method error
+	peer = &testPeer{t: t, server: server}
+	msg = expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")}), radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+	expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: []byte("error")}), radius.CodeAccessReject, CodeFailure, 0)
+
+	// Authorize rejects
+	peer = &testPeer{t: t, server: server}
+	msg = expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")}), radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+	response := peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: msg.Data})
+	expectMessage(t, response, radius.CodeAccessReject, CodeFailure, 0)
+	if _, ok := response.Lookup(rfc2865.VendorSpecific_Type); ok {
+		t.Fatal("Access-Reject contains MS-MPPE keys")
+	}
+
+	// unknown State
+	peer = &testPeer{t: t, server: server, state: []byte("unknown")}
+	expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeMD5Challenge}), radius.CodeAccessReject, CodeFailure, 0)
+}
+
+func TestServer_maxRounds(t *testing.T) {
+	server := &Server{
+		Methods:   []Method{&echoMethod{typ: TypeMD5Challenge}},
+		MaxRounds: 1,
+	}
+	peer := &testPeer{t: t, server: server}
+	msg := expectMessage(t, peer.exchange(nil), radius.CodeAccessChallenge, CodeRequest, TypeIdentity)
+	msg = expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeIdentity, Data: []byte("tim")}), radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+	expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: msg.Data}), radius.CodeAccessReject, CodeFailure, 0)
+}
+
+func TestServer_discard(t *testing.T) {
+	server := &Server{
+		Methods: []Method{&echoMethod{typ: TypeMD5Challenge}},
+	}
+	peer := &testPeer{t: t, server: server}
+
+	// missing Message-Authenticator
+	packet := radius.New(radius.CodeAccessRequest, testSecret)
+	SetMessage(packet, &Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")})
+	if _, err := server.Handle(peer.request(packet)); err != ErrMissingMessageAuthenticator {
+		t.Fatalf("got err %v; expected ErrMissingMessageAuthenticator", err)
+	}
+
+	// invalid Message-Authenticator
+	rfc2869.MessageAuthenticator_Set(packet, make([]byte, 16))
+	if _, err := server.Handle(peer.request(packet)); err != rfc2869.ErrInvalidMessageAuthenticator {
+		t.Fatalf("got err %v; expected ErrInvalidMessageAuthenticator", err)
+	}
+
+	// unexpected EAP identifier
+	msg := expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")}), radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+	packet = radius.New(radius.CodeAccessRequest, testSecret)
+	SetMessage(packet, &Packet{Code: CodeResponse, Identifier: msg.Identifier + 1, Type: TypeMD5Challenge, Data: msg.Data})
+	rfc2865.State_Set(packet, peer.state)
+	rfc2869.MessageAuthenticator_Sign(packet)
+	if _, err := server.Handle(peer.request(packet)); err != ErrUnexpectedIdentifier {
+		t.Fatalf("got err %v; expected ErrUnexpectedIdentifier", err)
+	}
+}
+
+func TestServer_retransmit(t *testing.T) {
+	server := &Server{
+		Methods: []Method{&echoMethod{typ: TypeMD5Challenge}},
+	}
+	peer := &testPeer{t: t, server: server}
+	msg := expectMessage(t, peer.exchange(nil), radius.CodeAccessChallenge, CodeRequest, TypeIdentity)
+
+	packet := radius.New(radius.CodeAccessRequest, testSecret)
+	SetMessage(packet, &Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeIdentity, Data: []byte("tim")})
+	rfc2865.State_Set(packet, peer.state)
+	rfc2869.MessageAuthenticator_Sign(packet)
+
+	first, err := server.Handle(peer.request(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := server.Handle(peer.request(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("retransmitted request was not answered with the previous response")
+	}
+}
+
+func TestServer_retransmitAccept(t *testing.T) {
+	server := &Server{
+		Methods: []Method{&echoMethod{typ: TypeMD5Challenge}},
+	}
+	peer := &testPeer{t: t, server: server}
+	msg := expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")}), radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+
+	packet := radius.New(radius.CodeAccessRequest, testSecret)
+	SetMessage(packet, &Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: msg.Data})
+	rfc2865.State_Set(packet, peer.state)
+	rfc2869.MessageAuthenticator_Sign(packet)
+
+	first, err := server.Handle(peer.request(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, first, radius.CodeAccessAccept, CodeSuccess, 0)
+	// the Access-Accept is resent if it was lost
+	second, err := server.Handle(peer.request(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("retransmitted request was not answered with the Access-Accept")
+	}
+
+	// other requests carrying the State of the ended conversation are
+	// rejected
+	if response := peer.exchange(&Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: msg.Data}); response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v", response.Code)
+	}
+}
+
+func TestMPPEKeysInAccept(t *testing.T) {
+	server := &Server{
+		Methods: []Method{&echoMethod{typ: TypeMD5Challenge}},
+	}
+	peer := &testPeer{t: t, server: server}
+	msg := expectMessage(t, peer.exchange(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("tim")}), radius.CodeAccessChallenge, CodeRequest, TypeMD5Challenge)
+
+	request := radius.New(radius.CodeAccessRequest, testSecret)
+	SetMessage(request, &Packet{Code: CodeResponse, Identifier: msg.Identifier, Type: TypeMD5Challenge, Data: msg.Data})
+	rfc2865.State_Set(request, peer.state)
+	rfc2869.MessageAuthenticator_Sign(request)
+	response, err := server.Handle(peer.request(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := radius.Parse(wire, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvKey, sendKey, err := microsoft.MPPEKeys_Lookup(parsed, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recvKey, bytes.Repeat([]byte{0x42}, 32)) || !bytes.Equal(sendKey, bytes.Repeat([]byte{0x42}, 32)) {
+		t.Fatal("unexpected MPPE keys")
+	}
+}
@@ -0,0 +1,129 @@
+package ttls
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// AVP flags - rfc5281, 10.1
+const (
+	FlagVendor    = 0x80
+	FlagMandatory = 0x40
+)
+
+// AVP codes of the RADIUS attributes used for inner authentication. Vendor
+// AVPs carry the Microsoft vendor ID.
+const (
+	AVPUserName     = 1
+	AVPUserPassword = 2
+	AVPEAPMessage   = 79
+
+	VendorMicrosoft = 311
+
+	AVPMSCHAPError     = 2
+	AVPMSCHAPChallenge = 11
+	AVPMSCHAP2Response = 25
+	AVPMSCHAP2Success  = 26
+)
+
+// AVP is a Diameter AVP carried in the TLS tunnel - rfc5281, 10
+type AVP struct {
+	Code   uint32
+	Flags  byte
+	Vendor uint32
+	Data   []byte
+}
+
+// ParseAVPs decodes a sequence of AVPs.
+func ParseAVPs(b []byte) ([]*AVP, error) {
+	var avps []*AVP
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errors.New("ttls: truncated AVP")
+		}
+		avp := &AVP{
+			Code:  binary.BigEndian.Uint32(b[0:4]),
+			Flags: b[4],
+		}
+		length := int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+		header := 8
+		if avp.Flags&FlagVendor != 0 {
+			header = 12
+		}
+		if length < header || length > len(b) {
+			return nil, errors.New("ttls: invalid AVP length")
+		}
+		if header == 12 {
+			avp.Vendor = binary.BigEndian.Uint32(b[8:12])
+		}
+		avp.Data = append([]byte(nil), b[header:length]...)
+		avps = append(avps, avp)
+
+		padded := (length + 3) &^ 3
+		if padded > len(b) {
+			padded = len(b)
+		}
+		b = b[padded:]
+	}
+	return avps, nil
+}
+
+// truncatedAVPs reports whether the last AVP of the sequence b is incomplete.
+// Malformed AVPs are left to ParseAVPs to report.
+func truncatedAVPs(b []byte) bool {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return true
+		}
+		length := int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+		if length < 8 {
+			return false
+		}
+		if length > len(b) {
+			return true
+		}
+		padded := (length + 3) &^ 3
+		if padded > len(b) {
+			padded = len(b)
+		}
+		b = b[padded:]
+	}
+	return false
+}
+
+// AppendAVP appends the encoding of avp, padded to a multiple of four
+// octets, to b. The vendor flag is set if avp has a vendor ID.
+func AppendAVP(b []byte, avp *AVP) []byte {
+	flags := avp.Flags &^ FlagVendor
+	header := 8
+	if avp.Vendor != 0 {
+		flags |= FlagVendor
+		header = 12
+	}
+	length := header + len(avp.Data)
+
+	var hdr [12]byte
+	binary.BigEndian.PutUint32(hdr[0:4], avp.Code)
+	hdr[4] = flags
+	hdr[5] = byte(length >> 16)
+	hdr[6] = byte(length >> 8)
+	hdr[7] = byte(length)
+	binary.BigEndian.PutUint32(hdr[8:12], avp.Vendor)
+
+	b = append(b, hdr[:header]...)
+	b = append(b, avp.Data...)
+	for i := length; i%4 != 0; i++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// findAVP returns the data of the first AVP with the given vendor and code.
+func findAVP(avps []*AVP, vendor, code uint32) []byte {
+	for _, avp := range avps {
+		if avp.Vendor == vendor && avp.Code == code {
+			return avp.Data
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Package ttls implements the server side of EAP-TTLSv0 (RFC 5281) with PAP
+// and MS-CHAP-V2 inner authentication.
+package ttls
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/eaptls"
+	"layeh.com/radius/rfc2759"
+)
+
+// ErrAuthenticationFailed is returned when the inner authentication fails.
+var ErrAuthenticationFailed = errors.New("ttls: inner authentication failed")
+
+// Method is the server side of EAP-TTLSv0.
+//
+// On completion, the outer session's InnerIdentity is set to the User-Name
+// sent in the tunnel; on success, its MSK, EMSK and TLS fields are set too.
+type Method struct {
+	// Config is the TLS configuration of the server. The TLS version is
+	// limited to TLS 1.2.
+	Config *tls.Config
+
+	// Password returns the cleartext password of the user with the given
+	// name. s is a session whose Identity is the inner identity and whose
+	// Request is the Access-Request currently being processed. Returning a
+	// nil password fails the authentication.
+	Password func(s *eap.Session, name string) ([]byte, error)
+
+	// FragmentSize is the maximum number of TLS octets sent in a single
+	// EAP-Request. Defaults to eaptls.DefaultFragmentSize.
+	FragmentSize int
+
+	// Timeout is how long the TLS connection waits for the peer's next
+	// response. Defaults to eaptls.DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Type implements eap.Method.
+func (m *Method) Type() eap.Type {
+	return eap.TypeTTLS
+}
+
+// Start implements eap.Method.
+func (m *Method) Start(s *eap.Session) (eap.Conversation, []byte, error) {
+	if m.Config == nil {
+		return nil, nil, errors.New("ttls: nil Config")
+	}
+	if m.Password == nil {
+		return nil, nil, errors.New("ttls: nil Password")
+	}
+	config := m.Config.Clone()
+	if config.MaxVersion == 0 || config.MaxVersion > tls.VersionTLS12 {
+		config.MaxVersion = tls.VersionTLS12
+	}
+
+	c := &conversation{
+		method:  m,
+		session: s,
+	}
+	c.Conversation = eaptls.NewServerConversation(config, m.Timeout, c.tunnel)
+	c.FragmentSize = m.FragmentSize
+	return c, c.Start(), nil
+}
+
+type conversation struct {
+	*eaptls.Conversation
+	method  *Method
+	session *eap.Session
+
+	// guards the inner identity, set by the TLS goroutine
+	mu            sync.Mutex
+	innerIdentity string
+}
+
+func (c *conversation) Process(response []byte) (eap.Status, []byte, error) {
+	status, request, err := c.Conversation.Process(response)
+	if status == eap.StatusContinue {
+		return status, request, err
+	}
+	c.mu.Lock()
+	c.session.InnerIdentity = c.innerIdentity
+	c.mu.Unlock()
+	if status != eap.StatusSuccess {
+		return status, request, err
+	}
+
+	state := c.ConnectionState()
+	msk, emsk, err := eaptls.Keys(&state, eap.TypeTTLS)
+	if err != nil {
+		return eap.StatusFailure, nil, err
+	}
+	c.session.MSK = msk
+	c.session.EMSK = emsk
+	c.session.TLS = &state
+	return eap.StatusSuccess, nil, nil
+}
+
+// tunnel authenticates the AVPs sent by the peer inside the established TLS
+// connection.
+func (c *conversation) tunnel(conn *tls.Conn) error {
+	avps, err := readAVPs(conn)
+	if err != nil {
+		return err
+	}
+
+	name := string(findAVP(avps, 0, AVPUserName))
+	if name == "" {
+		return errors.New("ttls: missing User-Name")
+	}
+	c.mu.Lock()
+	c.innerIdentity = name
+	c.mu.Unlock()
+	inner := &eap.Session{
+		ID:       c.session.ID,
+		Identity: name,
+		Request:  c.session.Request,
+	}
+
+	password, err := c.method.Password(inner, name)
+	if err != nil {
+		return err
+	}
+	if password == nil {
+		return ErrAuthenticationFailed
+	}
+
+	if userPassword := findAVP(avps, 0, AVPUserPassword); userPassword != nil {
+		// rfc5281, 11.2.5: the password may be padded with nulls
+		userPassword = bytes.TrimRight(userPassword, "\x00")
+		if subtle.ConstantTimeCompare(userPassword, password) != 1 {
+			return ErrAuthenticationFailed
+		}
+		return nil
+	}
+
+	challenge := findAVP(avps, VendorMicrosoft, AVPMSCHAPChallenge)
+	response := findAVP(avps, VendorMicrosoft, AVPMSCHAP2Response)
+	if challenge != nil && response != nil {
+		return c.mschapv2(conn, name, password, challenge, response)
+	}
+	return errors.New("ttls: unsupported inner authentication")
+}
+
+// maxAVPsSize limits the size of the AVPs sent by the peer in the tunnel.
+const maxAVPsSize = 64 * 1024
+
+// readAVPs reads the AVPs sent by the peer. Reads are repeated until the last
+// AVP is complete, as AVPs may span several TLS records. The peer is expected
+// to send its AVPs in a single message, so reading stops at the first read
+// that ends on an AVP boundary.
+func readAVPs(r io.Reader) ([]*AVP, error) {
+	var b []byte
+	buf := make([]byte, 16*1024)
+	for {
+		n, err := r.Read(buf)
+		b = append(b, buf[:n]...)
+		if n > 0 && !truncatedAVPs(b) {
+			return ParseAVPs(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxAVPsSize {
+			return nil, errors.New("ttls: AVPs too long")
+		}
+	}
+}
+
+// mschapv2 verifies MS-CHAP-V2 inner authentication - rfc5281, 11.2.4
+func (c *conversation) mschapv2(conn *tls.Conn, name string, password, challenge, response []byte) error {
+	state := conn.ConnectionState()
+	expected, err := state.ExportKeyingMaterial("ttls challenge", nil, 17)
+	if err != nil {
+		return err
+	}
+	if len(response) != 50 || !bytes.Equal(challenge, expected[:16]) || response[0] != expected[16] {
+		return errors.New("ttls: invalid MS-CHAP-V2 challenge or response")
+	}
+	ident := response[0]
+	peerChallenge := response[2:18]
+	ntResponse := response[26:50]
+
+	expectedResponse, err := rfc2759.GenerateNTResponse(challenge, peerChallenge, []byte(name), password)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(expectedResponse, ntResponse) != 1 {
+		return ErrAuthenticationFailed
+	}
+	authenticatorResponse, err := rfc2759.GenerateAuthenticatorResponse(challenge, peerChallenge, ntResponse, []byte(name), password)
+	if err != nil {
+		return err
+	}
+
+	success := AppendAVP(nil, &AVP{
+		Code:   AVPMSCHAP2Success,
+		Flags:  FlagMandatory,
+		Vendor: VendorMicrosoft,
+		Data:   append([]byte{ident}, authenticatorResponse...),
+	})
+	_, err = conn.Write(success)
+	return err
+}
@@ -0,0 +1,238 @@
+package ttls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"layeh.com/radius/eap"
+	"layeh.com/radius/eap/eaptls"
+	"layeh.com/radius/eap/internal/testcert"
+	"layeh.com/radius/rfc2759"
+)
+
+func testMethod() *Method {
+	return &Method{
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{testcert.Server()},
+		},
+		Password: func(s *eap.Session, name string) ([]byte, error) {
+			if s.Identity != "tim" || name != "tim" {
+				return nil, nil
+			}
+			return []byte("clientPass"), nil
+		},
+	}
+}
+
+func pap(password string) func(conn *tls.Conn) error {
+	return papPadded(password, 2)
+}
+
+// papPadded sends password padded with the given number of nulls.
+func papPadded(password string, padding int) func(conn *tls.Conn) error {
+	return func(conn *tls.Conn) error {
+		b := AppendAVP(nil, &AVP{Code: AVPUserName, Flags: FlagMandatory, Data: []byte("tim")})
+		b = AppendAVP(b, &AVP{Code: AVPUserPassword, Flags: FlagMandatory, Data: []byte(password + strings.Repeat("\x00", padding))})
+		_, err := conn.Write(b)
+		return err
+	}
+}
+
+func mschapv2(password string) func(conn *tls.Conn) error {
+	return func(conn *tls.Conn) error {
+		state := conn.ConnectionState()
+		challenge, err := state.ExportKeyingMaterial("ttls challenge", nil, 17)
+		if err != nil {
+			return err
+		}
+		peerChallenge := bytes.Repeat([]byte{0x21}, 16)
+		ntResponse, err := rfc2759.GenerateNTResponse(challenge[:16], peerChallenge, []byte("tim"), []byte(password))
+		if err != nil {
+			return err
+		}
+		response := []byte{challenge[16], 0}
+		response = append(response, peerChallenge...)
+		response = append(response, make([]byte, 8)...)
+		response = append(response, ntResponse...)
+
+		b := AppendAVP(nil, &AVP{Code: AVPUserName, Flags: FlagMandatory, Data: []byte("tim")})
+		b = AppendAVP(b, &AVP{Code: AVPMSCHAPChallenge, Flags: FlagMandatory, Vendor: VendorMicrosoft, Data: challenge[:16]})
+		b = AppendAVP(b, &AVP{Code: AVPMSCHAP2Response, Flags: FlagMandatory, Vendor: VendorMicrosoft, Data: response})
+		if _, err := conn.Write(b); err != nil {
+			return err
+		}
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		avps, err := ParseAVPs(buf[:n])
+		if err != nil {
+			return err
+		}
+		expected, err := rfc2759.GenerateAuthenticatorResponse(challenge[:16], peerChallenge, ntResponse, []byte("tim"), []byte(password))
+		if err != nil {
+			return err
+		}
+		if success := findAVP(avps, VendorMicrosoft, AVPMSCHAP2Success); string(success) != string(challenge[16:17])+expected {
+			return errors.New("invalid MS-CHAP2-Success")
+		}
+		return nil
+	}
+}
+
+func authenticate(t *testing.T, inner func(conn *tls.Conn) error) (*eap.Session, *eaptls.Conversation, error) {
+	t.Helper()
+
+	s := &eap.Session{Identity: "anonymous"}
+	c, request, err := testMethod().Start(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(*conversation).Close()
+
+	config := &tls.Config{
+		RootCAs:    testcert.Pool(),
+		ServerName: "radius.example.com",
+	}
+	peer := eaptls.NewClientConversation(config, time.Second, inner)
+	defer peer.Close()
+	for i := 0; i < 50; i++ {
+		response, err := peer.Respond(request)
+		if err != nil {
+			return s, peer, err
+		}
+		status, next, err := c.Process(response)
+		switch status {
+		case eap.StatusSuccess:
+			return s, peer, nil
+		case eap.StatusFailure:
+			return s, peer, err
+		}
+		request = next
+	}
+	t.Fatal("too many rounds")
+	return nil, nil, nil
+}
+
+func TestMethod(t *testing.T) {
+	tests := map[string]func(conn *tls.Conn) error{
+		"PAP":      pap("clientPass"),
+		"MSCHAPv2": mschapv2("clientPass"),
+		// the AVPs span several TLS records
+		"PAP padded": papPadded("clientPass", 20000),
+	}
+	for name, inner := range tests {
+		s, peer, err := authenticate(t, inner)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if done, err := peer.Done(); !done || err != nil {
+			t.Fatalf("%s: peer: %v, %v", name, done, err)
+		}
+		if s.Identity != "anonymous" || s.InnerIdentity != "tim" {
+			t.Fatalf("%s: unexpected identities %q, %q", name, s.Identity, s.InnerIdentity)
+		}
+		state := peer.ConnectionState()
+		msk, emsk, err := eaptls.Keys(&state, eap.TypeTTLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(s.MSK, msk) || !bytes.Equal(s.EMSK, emsk) {
+			t.Fatalf("%s: keys do not match", name)
+		}
+	}
+}
+
+func TestMethod_invalidPassword(t *testing.T) {
+	tests := map[string]func(conn *tls.Conn) error{
+		"PAP":      pap("wrong"),
+		"MSCHAPv2": mschapv2("wrong"),
+	}
+	for name, inner := range tests {
+		s, _, err := authenticate(t, inner)
+		if err != ErrAuthenticationFailed {
+			t.Fatalf("%s: got %v", name, err)
+		}
+		if s.InnerIdentity != "tim" || s.MSK != nil {
+			t.Fatalf("%s: unexpected session %+v", name, s)
+		}
+	}
+}
+
+func TestAVP(t *testing.T) {
+	avps := []*AVP{
+		{Code: AVPUserName, Flags: FlagMandatory, Data: []byte("tim")},
+		{Code: AVPMSCHAPChallenge, Flags: FlagMandatory, Vendor: VendorMicrosoft, Data: []byte{1, 2, 3, 4}},
+	}
+	var b []byte
+	for _, avp := range avps {
+		b = AppendAVP(b, avp)
+	}
+	expected := []byte{
+		0, 0, 0, 1, FlagMandatory, 0, 0, 11, 't', 'i', 'm', 0,
+		0, 0, 0, 11, FlagVendor | FlagMandatory, 0, 0, 16, 0, 0, 1, 55, 1, 2, 3, 4,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("got %v, expected %v", b, expected)
+	}
+
+	parsed, err := ParseAVPs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 {
+		t.Fatalf("got %d AVPs", len(parsed))
+	}
+	if parsed[1].Vendor != VendorMicrosoft || parsed[1].Flags != FlagVendor|FlagMandatory || !bytes.Equal(parsed[1].Data, avps[1].Data) {
+		t.Fatalf("got %+v", parsed[1])
+	}
+
+	if _, err := ParseAVPs([]byte{0, 0, 0, 1, 0, 0, 0, 20}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// chunkReader returns its chunks in successive reads.
+type chunkReader [][]byte
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, (*r)[0])
+	*r = (*r)[1:]
+	return n, nil
+}
+
+func TestReadAVPs(t *testing.T) {
+	b := AppendAVP(nil, &AVP{Code: AVPUserName, Flags: FlagMandatory, Data: []byte("tim")})
+	b = AppendAVP(b, &AVP{Code: AVPUserPassword, Flags: FlagMandatory, Data: []byte("clientPass")})
+
+	for _, chunks := range [][][]byte{
+		{b},
+		{b[:4], b[4:]},
+		{b[:10], b[10:20], b[20:]},
+		{b[:len(b)-1], b[len(b)-1:]},
+	} {
+		r := chunkReader(chunks)
+		avps, err := readAVPs(&r)
+		if err != nil {
+			t.Fatalf("%v: %v", chunks, err)
+		}
+		if len(avps) != 2 || string(findAVP(avps, 0, AVPUserPassword)) != "clientPass" {
+			t.Fatalf("%v: got %v", chunks, avps)
+		}
+	}
+
+	r := chunkReader{b[:len(b)-4]}
+	if _, err := readAVPs(&r); err != io.EOF {
+		t.Fatalf("got error %v", err)
+	}
+}
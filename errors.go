@@ -8,3 +8,19 @@ type NonAuthenticResponseError struct {
 func (e *NonAuthenticResponseError) Error() string {
 	return `radius: non-authentic response`
 }
+
+// UnknownAttributeError is returned when an attribute name cannot be
+// resolved.
+type UnknownAttributeError struct {
+	Name string
+	// Suggestion is the name of a similarly named attribute, if any.
+	Suggestion string
+}
+
+func (e *UnknownAttributeError) Error() string {
+	str := `radius: unknown attribute "` + e.Name + `"`
+	if e.Suggestion != "" {
+		str += ` (did you mean "` + e.Suggestion + `"?)`
+	}
+	return str
+}
@@ -0,0 +1,71 @@
+// Package statestore implements the in-memory store of the conversations of
+// Access-Challenge rounds, keyed by the value of the State attribute.
+package statestore
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory holds values until they expire. The zero value is ready to use.
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Get returns the value stored under key, or nil if no unexpired value
+// exists.
+func (m *Memory) Get(key string) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweepLocked(now)
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expires) {
+		return nil
+	}
+	return e.value
+}
+
+// Put stores value under key until the given expiry time.
+func (m *Memory) Put(key string, value interface{}, expires time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweepLocked(time.Now())
+	if m.entries == nil {
+		m.entries = make(map[string]*entry)
+	}
+	m.entries[key] = &entry{
+		value:   value,
+		expires: expires,
+	}
+}
+
+// Delete removes the value stored under key.
+func (m *Memory) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+}
+
+// sweepLocked removes expired values, at most once per second.
+func (m *Memory) sweepLocked(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Second {
+		return
+	}
+	m.lastSweep = now
+	for key, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package statestore_test
+
+import (
+	"testing"
+	"time"
+
+	"layeh.com/radius/internal/statestore"
+)
+
+func TestMemory(t *testing.T) {
+	var m statestore.Memory
+	if got := m.Get("a"); got != nil {
+		t.Fatalf("got %v", got)
+	}
+	m.Put("a", 1, time.Now().Add(time.Hour))
+	m.Put("b", 2, time.Now().Add(-time.Second))
+	if got := m.Get("a"); got != 1 {
+		t.Fatalf("got %v; expected 1", got)
+	}
+	if got := m.Get("b"); got != nil {
+		t.Fatal("got expired value")
+	}
+	m.Delete("a")
+	if got := m.Get("a"); got != nil {
+		t.Fatal("got deleted value")
+	}
+}
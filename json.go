@@ -0,0 +1,230 @@
+package radius
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"layeh.com/radius/dictionary"
+)
+
+// jsonPacket is the JSON representation of a packet, excluding its
+// attributes.
+type jsonPacket struct {
+	Code          string          `json:"code"`
+	Identifier    byte            `json:"id"`
+	Authenticator string          `json:"authenticator,omitempty"`
+	Attributes    json.RawMessage `json:"attributes,omitempty"`
+}
+
+// EncodeJSON returns the JSON representation of p:
+//
+//	{
+//	  "code": "Access-Request",
+//	  "id": 3,
+//	  "authenticator": "4f0e6c0b2a5d0f1c9a3e8b7d6c5b4a39",
+//	  "attributes": {
+//	    "User-Name": ["bob"],
+//	    "Service-Type": ["Framed-User"],
+//	    "Tunnel-Type:1": ["VLAN"],
+//	    "Cisco-AVPair": ["shell:priv-lvl=15", "x=y"],
+//	    "26.9999.1": ["0x0102"]
+//	  }
+//	}
+//
+// Attributes are keyed by the name returned by DecodedAttribute.FullName,
+// in the order of their first occurrence, and map to the list of their
+// values. Values are JSON strings, except for integer values without a
+// dictionary name, which are JSON numbers. Octets, and the values of
+// attributes that are not in the dictionary, are hex encoded with a "0x"
+// prefix.
+//
+// Encrypted attributes are decrypted using requestAuthenticator, which must
+// be the authenticator of the request if p is a received response. p's
+// authenticator is used if requestAuthenticator is nil.
+func (c *Codec) EncodeJSON(p *Packet, requestAuthenticator []byte) ([]byte, error) {
+	if requestAuthenticator == nil {
+		requestAuthenticator = p.Authenticator[:]
+	} else if len(requestAuthenticator) != len(p.Authenticator) {
+		return nil, errors.New("radius: invalid request authenticator")
+	}
+
+	var keys []string
+	values := make(map[string][]interface{})
+	for _, attr := range c.decode(p, requestAuthenticator) {
+		key := attr.FullName()
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], attr.JSONValue())
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		encodedValues, err := json.Marshal(values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(encodedKey)
+		b.WriteByte(':')
+		b.Write(encodedValues)
+	}
+	b.WriteByte('}')
+
+	return json.Marshal(&jsonPacket{
+		Code:          p.Code.String(),
+		Identifier:    p.Identifier,
+		Authenticator: hex.EncodeToString(p.Authenticator[:]),
+		Attributes:    b.Bytes(),
+	})
+}
+
+// JSONValue returns the value of the attribute as it is represented by
+// Codec.EncodeJSON.
+func (d *DecodedAttribute) JSONValue() interface{} {
+	if d.ValueName != "" {
+		return d.ValueName
+	}
+	switch v := d.Value.(type) {
+	case string:
+		return v
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	case uint8, uint16, uint32, uint64, int32:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case net.IP:
+		return v.String()
+	case *net.IPNet:
+		return v.String()
+	case net.HardwareAddr:
+		return v.String()
+	}
+	return d.ValueString()
+}
+
+// DecodeJSON returns the packet of the given JSON representation, as
+// produced by EncodeJSON. A random authenticator is generated if the
+// representation does not include one.
+//
+// Attributes with the encrypt flag are encrypted using secret and
+// requestAuthenticator, which must be the authenticator of the request if
+// the packet is a response. The packet's authenticator is used if
+// requestAuthenticator is nil.
+//
+// Values may be given in any form accepted by Add, and attributes may map to
+// a single value rather than a list.
+func (c *Codec) DecodeJSON(b, secret, requestAuthenticator []byte) (*Packet, error) {
+	var jp jsonPacket
+	if err := json.Unmarshal(b, &jp); err != nil {
+		return nil, err
+	}
+	code, err := ParseCode(jp.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	p := New(code, secret)
+	p.Identifier = jp.Identifier
+	if jp.Authenticator != "" {
+		authenticator, err := hex.DecodeString(jp.Authenticator)
+		if err != nil || len(authenticator) != len(p.Authenticator) {
+			return nil, errors.New("radius: invalid authenticator")
+		}
+		copy(p.Authenticator[:], authenticator)
+	}
+	if requestAuthenticator != nil {
+		if len(requestAuthenticator) != len(p.Authenticator) {
+			return nil, errors.New("radius: invalid request authenticator")
+		}
+		// attributes are encrypted with the request authenticator, and the
+		// packet's own is restored once they have been added
+		authenticator := p.Authenticator
+		copy(p.Authenticator[:], requestAuthenticator)
+		defer func() {
+			p.Authenticator = authenticator
+		}()
+	}
+
+	if len(jp.Attributes) == 0 || string(jp.Attributes) == "null" {
+		return p, nil
+	}
+	// the attributes object is read token by token to preserve its order
+	dec := json.NewDecoder(bytes.NewReader(jp.Attributes))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.New("radius: attributes must be a JSON object")
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name := t.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		if len(raw) > 0 && raw[0] == '[' {
+			err = decodeJSONNumbers(raw, &values)
+		} else {
+			values = make([]interface{}, 1)
+			err = decodeJSONNumbers(raw, &values[0])
+		}
+		if err != nil {
+			return nil, errors.New("radius: " + name + ": " + err.Error())
+		}
+
+		a, _, _, err := c.namedAttribute(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if s, ok := value.(string); ok && isBytesType(a.Type) && strings.HasPrefix(s, "0x") {
+				if value, err = hex.DecodeString(s[2:]); err != nil {
+					return nil, errors.New("radius: " + name + ": invalid hex value")
+				}
+			}
+			switch value.(type) {
+			case string, json.Number, []byte:
+			default:
+				return nil, errors.New("radius: " + name + ": invalid value")
+			}
+			if err := c.Add(p, name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+func decodeJSONNumbers(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// isBytesType reports whether values of the attribute type are byte strings
+// that are hex encoded in text representations.
+func isBytesType(typ dictionary.AttributeType) bool {
+	switch typ {
+	case 0, dictionary.AttributeOctets, dictionary.AttributeABinary, dictionary.AttributeTLV, dictionary.AttributeVSA:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package radius_test
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2868"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+func TestCodec_JSON(t *testing.T) {
+	codec := loadCodec(t)
+
+	p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	p.Identifier = 3
+	p.Authenticator = [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	rfc2865.UserName_SetString(p, "bob")
+	rfc2865.UserPassword_SetString(p, "12345")
+	rfc2865.ServiceType_Set(p, rfc2865.ServiceType_Value_FramedUser)
+	rfc2865.NASPort_Set(p, 7)
+	rfc2865.FramedIPAddress_Set(p, net.IPv4(10, 0, 0, 1))
+	rfc2865.Class_Add(p, []byte{0xca, 0xfe})
+	rfc2868.TunnelType_Add(p, 1, rfc2868.TunnelType_Value_L2TP)
+	microsoft.MSCHAPError_AddString(p, "E=691")
+	codec.Add(p, "Cisco-AVPair", "shell:priv-lvl=15")
+	codec.Add(p, "Cisco-AVPair", "x=y")
+	p.Add(200, radius.Attribute{1, 2})
+	p.Add(26, radius.Attribute{0, 0, 0x27, 0x0f, 1, 4, 0xab, 0xcd})
+
+	b, err := codec.EncodeJSON(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"code":"Access-Request","id":3,"authenticator":"0102030405060708090a0b0c0d0e0f10","attributes":{` +
+		`"User-Name":["bob"],"User-Password":["12345"],"Service-Type":["Framed-User"],"NAS-Port":[7],` +
+		`"Framed-IP-Address":["10.0.0.1"],"Class":["0xcafe"],"Tunnel-Type:1":["L2TP"],"MS-CHAP-Error":["E=691"],` +
+		`"Cisco-AVPair":["shell:priv-lvl=15","x=y"],"200":["0x0102"],"26.9999.1":["0xabcd"]}}`
+	if string(b) != expected {
+		t.Fatalf("got:\n%s\nexpecting:\n%s", b, expected)
+	}
+
+	q, err := codec.DecodeJSON(b, p.Secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Code != p.Code || q.Identifier != p.Identifier || q.Authenticator != p.Authenticator {
+		t.Fatalf("got header %v %d %x", q.Code, q.Identifier, q.Authenticator)
+	}
+	if !reflect.DeepEqual(q.Attributes, p.Attributes) {
+		t.Fatalf("got attributes %v\nexpecting %v", q.Attributes, p.Attributes)
+	}
+}
+
+func TestCodec_JSON_response(t *testing.T) {
+	codec := loadCodec(t)
+
+	request := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	response := request.Response(radius.CodeAccessAccept)
+	rfc2868.TunnelPassword_AddString(response, 1, "hunter2")
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the received response carries its own authenticator
+	received, err := radius.Parse(wire, request.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := codec.EncodeJSON(received, request.Authenticator[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"Tunnel-Password:1":["hunter2"]`)) {
+		t.Fatalf("got %s", b)
+	}
+
+	q, err := codec.DecodeJSON(b, request.Secret, request.Authenticator[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Authenticator != received.Authenticator {
+		t.Fatalf("got authenticator %x", q.Authenticator)
+	}
+	if tag, password, err := rfc2868.TunnelPassword_LookupString(q, request); err != nil || tag != 1 || password != "hunter2" {
+		t.Fatalf("got Tunnel-Password %d %q %v", tag, password, err)
+	}
+
+	if _, err := codec.EncodeJSON(received, []byte{1}); err == nil {
+		t.Fatal("expected error for invalid request authenticator")
+	}
+}
+
+func TestCodec_DecodeJSON(t *testing.T) {
+	codec := loadCodec(t)
+
+	q, err := codec.DecodeJSON([]byte(`{"code":"4","id":9,"attributes":{"Acct-Status-Type":"Start","Acct-Session-Time":60,"Class":"abc"}}`), []byte(`secret`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Code != radius.CodeAccountingRequest || q.Identifier != 9 {
+		t.Fatalf("got header %v %d", q.Code, q.Identifier)
+	}
+	if v, _ := codec.Get(q, "Acct-Status-Type"); v != uint32(1) {
+		t.Fatalf("got Acct-Status-Type %v", v)
+	}
+	if v, _ := codec.Get(q, "Acct-Session-Time"); v != uint32(60) {
+		t.Fatalf("got Acct-Session-Time %v", v)
+	}
+	if v, _ := codec.Get(q, "Class"); !bytes.Equal(v.([]byte), []byte("abc")) {
+		t.Fatalf("got Class %v", v)
+	}
+
+	// unknown codes round-trip
+	p := radius.New(radius.Code(200), []byte(`secret`))
+	b, err := codec.EncodeJSON(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q, err := codec.DecodeJSON(b, p.Secret, nil); err != nil || q.Code != p.Code {
+		t.Fatalf("%s: got %v, %v", b, q, err)
+	}
+
+	for _, input := range []string{
+		`{"code":"Bogus","id":1}`,
+		`{"code":"Access-Request","attributes":{"Bogus":["x"]}}`,
+		`{"code":"Access-Request","attributes":{"NAS-Port":[{}]}}`,
+		`{"code":"Access-Request","attributes":{"Class":["0xzz"]}}`,
+		`{"code":"Access-Request","attributes":[]}`,
+	} {
+		if _, err := codec.DecodeJSON([]byte(input), nil, nil); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
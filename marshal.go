@@ -0,0 +1,406 @@
+package radius
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"layeh.com/radius/dictionary"
+)
+
+// Marshal appends the attributes described by the fields of the struct v,
+// or a pointer to one, to p, using DefaultCodec. See Codec.Marshal.
+func Marshal(p *Packet, v interface{}) error {
+	return DefaultCodec.Marshal(p, v)
+}
+
+// Unmarshal stores the attributes of p in the fields of the struct pointed to
+// by v, using DefaultCodec. See Codec.Unmarshal.
+func Unmarshal(p *Packet, v interface{}) error {
+	return DefaultCodec.Unmarshal(p, v)
+}
+
+// Marshal appends the attributes described by the fields of the struct v,
+// or a pointer to one, to p.
+//
+// A field is mapped to an attribute by the "radius" key in its struct tag,
+// whose first element is the attribute's name or OID:
+//
+//	type Accounting struct {
+//		UserName   string        `radius:"User-Name"`
+//		Status     uint32        `radius:"Acct-Status-Type"`
+//		Class      [][]byte      `radius:"Class"`
+//		TunnelType string        `radius:"Tunnel-Type,tag=1"`
+//		DNS        []net.IP      `radius:"26.311.28,omitempty"`
+//		Session    time.Duration `radius:"-"`
+//	}
+//
+// The remaining elements are options:
+//   - tag=N: the tag of a tagged attribute. Unmarshal only stores values
+//     with the given tag; values with any tag are stored if the option is
+//     omitted.
+//   - encrypt=N: encrypt the value using the given method, as with the
+//     dictionary flag of the same name.
+//   - omitempty: do not add an attribute for the zero value of the field.
+//
+// Fields without a tag, or with the tag "-", are ignored. The fields of
+// embedded structs are treated as if they were fields of the outer struct.
+//
+// Slices, other than byte slices, map to attributes that may occur more than
+// once; an attribute is added for each element. Nil pointers are skipped.
+//
+// Values are converted to the attribute's data type: integers are range
+// checked, and strings are accepted for every data type, including the names
+// of an integer attribute's dictionary values.
+func (c *Codec) Marshal(p *Packet, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return errors.New("radius: Marshal of non-struct " + reflect.TypeOf(v).String())
+	}
+	fields, err := c.fields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.Index)
+		if f.OmitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Ptr {
+			continue
+		}
+
+		if !isMultiValued(fv.Type()) {
+			if err := f.add(p, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		for i := 0; i < fv.Len(); i++ {
+			if err := f.add(p, fv.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unmarshal stores the attributes of p in the fields of the struct pointed to
+// by v. Fields are mapped to attributes as described for Marshal.
+//
+// Fields whose attribute is not present in p are left unchanged. Single
+// valued fields receive the first value of the attribute, and slices receive
+// all of its values. Values are converted to the type of the field: for
+// example, an integer attribute may be stored in a string field, which
+// receives the name of the dictionary value.
+//
+// Encrypted attributes are decrypted using p's authenticator.
+// UnmarshalResponse must be used for received responses.
+func (c *Codec) Unmarshal(p *Packet, v interface{}) error {
+	return c.unmarshal(p, p.Authenticator[:], v)
+}
+
+// UnmarshalResponse stores the attributes of the received response p in the
+// fields of the struct pointed to by v, as Unmarshal does. Encrypted
+// attributes are decrypted using the authenticator of request, the request
+// that p answers.
+func (c *Codec) UnmarshalResponse(p, request *Packet, v interface{}) error {
+	return c.unmarshal(p, request.Authenticator[:], v)
+}
+
+func (c *Codec) unmarshal(p *Packet, requestAuthenticator []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("radius: Unmarshal requires a non-nil pointer to a struct")
+	}
+	rv = rv.Elem()
+	fields, err := c.fields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.Index)
+		attrs := f.Attribute.Lookup(p)
+		if len(attrs) == 0 {
+			continue
+		}
+
+		if isMultiValued(fv.Type()) {
+			slice := reflect.MakeSlice(fv.Type(), 0, len(attrs))
+			for _, attr := range attrs {
+				elem := reflect.New(fv.Type().Elem()).Elem()
+				ok, err := f.decode(p, requestAuthenticator, attr, elem)
+				if err != nil {
+					return err
+				}
+				if ok {
+					slice = reflect.Append(slice, elem)
+				}
+			}
+			if slice.Len() > 0 {
+				fv.Set(slice)
+			}
+			continue
+		}
+
+		for _, attr := range attrs {
+			target := fv
+			if fv.Kind() == reflect.Ptr {
+				target = reflect.New(fv.Type().Elem()).Elem()
+			}
+			ok, err := f.decode(p, requestAuthenticator, attr, target)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if fv.Kind() == reflect.Ptr {
+				fv.Set(target.Addr())
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// codecField is a struct field mapped to an attribute.
+type codecField struct {
+	Name      string
+	Index     []int
+	Attribute *codecAttribute
+	Tag       byte
+	HasTag    bool
+	OmitEmpty bool
+}
+
+// fields returns the fields of the struct type t that are mapped to
+// attributes.
+func (c *Codec) fields(t reflect.Type) ([]*codecField, error) {
+	var fields []*codecField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("radius")
+		if !ok && sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			embedded, err := c.fields(sf.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range embedded {
+				f.Index = append([]int{i}, f.Index...)
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+
+		options := strings.Split(tag, ",")
+		attr, err := c.attribute(options[0])
+		if err != nil {
+			return nil, errors.New("radius: field " + sf.Name + ": unknown attribute " + strconv.Quote(options[0]))
+		}
+		f := &codecField{
+			Name:      sf.Name,
+			Index:     []int{i},
+			Attribute: attr,
+		}
+		for _, option := range options[1:] {
+			switch {
+			case option == "omitempty":
+				f.OmitEmpty = true
+			case strings.HasPrefix(option, "tag="):
+				n, err := strconv.ParseUint(option[len("tag="):], 10, 8)
+				if err != nil || n > 0x1F {
+					return nil, errors.New("radius: field " + sf.Name + ": invalid tag option " + strconv.Quote(option))
+				}
+				f.Tag, f.HasTag = byte(n), true
+			case strings.HasPrefix(option, "encrypt="):
+				n, err := strconv.Atoi(option[len("encrypt="):])
+				if err != nil {
+					return nil, errors.New("radius: field " + sf.Name + ": invalid encrypt option " + strconv.Quote(option))
+				}
+				attr.Encrypt = n
+			default:
+				return nil, errors.New("radius: field " + sf.Name + ": unknown option " + strconv.Quote(option))
+			}
+		}
+		if f.HasTag {
+			attr.HasTag = true
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (f *codecField) add(p *Packet, v reflect.Value) error {
+	attr, err := f.Attribute.Encode(p, f.Tag, v.Interface())
+	if err == nil {
+		err = f.Attribute.Add(p, attr)
+	}
+	if err != nil {
+		return f.error(err)
+	}
+	return nil
+}
+
+// decode decodes the attribute attr of p into dst, decrypting it with
+// requestAuthenticator. false is returned if the value's tag does not match
+// the field's tag option.
+func (f *codecField) decode(p *Packet, requestAuthenticator []byte, attr Attribute, dst reflect.Value) (bool, error) {
+	a := f.Attribute
+	if a.Type == 0 {
+		typed := *a
+		typed.Type = inferAttributeType(reflect.Zero(dst.Type()).Interface())
+		if dst.Type() == reflect.TypeOf(net.IP(nil)) && len(attr) == net.IPv4len {
+			typed.Type = dictionary.AttributeIPAddr
+		}
+		a = &typed
+	}
+
+	tag, v, err := a.Decode(p, requestAuthenticator, attr)
+	if err != nil {
+		return false, f.error(err)
+	}
+	if f.HasTag && tag != f.Tag {
+		return false, nil
+	}
+	if err := a.assign(dst, v); err != nil {
+		return false, f.error(err)
+	}
+	return true, nil
+}
+
+func (f *codecField) error(err error) error {
+	return errors.New("radius: field " + f.Name + " (" + f.Attribute.Name + "): " + err.Error())
+}
+
+// assign stores the decoded value v in dst, converting it to dst's type.
+func (a *codecAttribute) assign(dst reflect.Value, v interface{}) error {
+	src := reflect.ValueOf(v)
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(a.format(v))
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch src.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = src.Int()
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if src.Uint() > 1<<63-1 {
+				return errors.New("value overflows " + dst.Type().String())
+			}
+			n = int64(src.Uint())
+		default:
+			if t, ok := v.(time.Time); ok {
+				n = t.Unix()
+				break
+			}
+			return errors.New("cannot decode " + src.Type().String() + " into " + dst.Type().String())
+		}
+		if dst.OverflowInt(n) {
+			return errors.New("value overflows " + dst.Type().String())
+		}
+		dst.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		switch src.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if src.Int() < 0 {
+				return errors.New("value overflows " + dst.Type().String())
+			}
+			n = uint64(src.Int())
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = src.Uint()
+		default:
+			return errors.New("cannot decode " + src.Type().String() + " into " + dst.Type().String())
+		}
+		if dst.OverflowUint(n) {
+			return errors.New("value overflows " + dst.Type().String())
+		}
+		dst.SetUint(n)
+		return nil
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 && src.Kind() == reflect.String {
+			dst.SetBytes([]byte(src.String()))
+			return nil
+		}
+
+	case reflect.Struct:
+		if ipNet, ok := v.(*net.IPNet); ok && dst.Type() == reflect.TypeOf(net.IPNet{}) {
+			dst.Set(reflect.ValueOf(*ipNet))
+			return nil
+		}
+	}
+
+	if src.Type().ConvertibleTo(dst.Type()) && src.Kind() == dst.Kind() {
+		dst.Set(src.Convert(dst.Type()))
+		return nil
+	}
+	return errors.New("cannot decode " + src.Type().String() + " into " + dst.Type().String())
+}
+
+// format returns the string representation of the decoded value v.
+func (a *codecAttribute) format(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case interface{ String() string }:
+		return v.String()
+	}
+	n := reflect.ValueOf(v).Uint()
+	if name, ok := a.valueName(n); ok {
+		return name
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// isMultiValued reports whether fields of type t hold more than one value.
+func isMultiValued(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct:
+		if z, ok := v.Interface().(interface{ IsZero() bool }); ok {
+			return z.IsZero()
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+package radius
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"layeh.com/radius/dictionary"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+var codecTestDictionary = &dictionary.Dictionary{
+	Attributes: []*dictionary.Attribute{
+		{Name: "User-Name", OID: dictionary.OID{1}, Type: dictionary.AttributeString},
+		{Name: "User-Password", OID: dictionary.OID{2}, Type: dictionary.AttributeOctets, FlagEncrypt: dictionary.IntFlag{Int: 1, Valid: true}},
+		{Name: "Service-Type", OID: dictionary.OID{6}, Type: dictionary.AttributeInteger},
+		{Name: "Framed-IP-Address", OID: dictionary.OID{8}, Type: dictionary.AttributeIPAddr},
+		{Name: "Class", OID: dictionary.OID{25}, Type: dictionary.AttributeOctets},
+		{Name: "Vendor-Specific", OID: dictionary.OID{26}, Type: dictionary.AttributeVSA},
+		{Name: "Session-Timeout", OID: dictionary.OID{27}, Type: dictionary.AttributeInteger},
+		{Name: "Event-Timestamp", OID: dictionary.OID{55}, Type: dictionary.AttributeDate},
+		{Name: "Tunnel-Type", OID: dictionary.OID{64}, Type: dictionary.AttributeInteger, FlagHasTag: dictionary.BoolFlag{Bool: true, Valid: true}},
+		{Name: "Tunnel-Password", OID: dictionary.OID{69}, Type: dictionary.AttributeString, FlagHasTag: dictionary.BoolFlag{Bool: true, Valid: true}, FlagEncrypt: dictionary.IntFlag{Int: 2, Valid: true}},
+		{Name: "Framed-IPv6-Prefix", OID: dictionary.OID{97}, Type: dictionary.AttributeIPv6Prefix},
+	},
+	Values: []*dictionary.Value{
+		{Attribute: "Service-Type", Name: "Login-User", Number: 1},
+		{Attribute: "Service-Type", Name: "Framed-User", Number: 2},
+		{Attribute: "Tunnel-Type", Name: "L2TP", Number: 3},
+		{Attribute: "Tunnel-Type", Name: "VLAN", Number: 13},
+	},
+	Vendors: []*dictionary.Vendor{
+		{
+			Name:   "Microsoft",
+			Number: 311,
+			Attributes: []*dictionary.Attribute{
+				{Name: "MS-Primary-DNS-Server", OID: dictionary.OID{28}, Type: dictionary.AttributeIPAddr},
+			},
+		},
+		{
+			Name:         "Wide",
+			Number:       65000,
+			TypeOctets:   intPtr(2),
+			LengthOctets: intPtr(2),
+			Attributes: []*dictionary.Attribute{
+				{Name: "Wide-Counter", OID: dictionary.OID{300}, Type: dictionary.AttributeInteger64},
+			},
+		},
+	},
+}
+
+func TestMarshal(t *testing.T) {
+	type Common struct {
+		UserName string `radius:"User-Name"`
+	}
+	type Request struct {
+		Common
+		Password       string        `radius:"User-Password"`
+		ServiceType    string        `radius:"Service-Type"`
+		FramedIP       net.IP        `radius:"Framed-IP-Address"`
+		Class          [][]byte      `radius:"Class"`
+		SessionTimeout *int          `radius:"Session-Timeout"`
+		Timestamp      time.Time     `radius:"Event-Timestamp,omitempty"`
+		TunnelType     uint32        `radius:"Tunnel-Type,tag=2"`
+		TunnelPassword string        `radius:"Tunnel-Password,tag=2"`
+		Prefix         *net.IPNet    `radius:"Framed-IPv6-Prefix"`
+		DNS            []net.IP      `radius:"MS-Primary-DNS-Server"`
+		Counter        uint64        `radius:"Wide-Counter"`
+		Raw            string        `radius:"26.311.99"`
+		Ignored        time.Duration `radius:"-"`
+		Untagged       string
+	}
+
+	codec := &Codec{
+		Dictionary: codecTestDictionary,
+	}
+	_, prefix, _ := net.ParseCIDR("2001:db8::/32")
+	in := Request{
+		Common:         Common{UserName: "tim"},
+		Password:       "12345",
+		ServiceType:    "Framed-User",
+		FramedIP:       net.IPv4(10, 0, 0, 1),
+		Class:          [][]byte{{1, 2}, {3}},
+		TunnelType:     13,
+		TunnelPassword: "secret",
+		Prefix:         prefix,
+		DNS:            []net.IP{net.IPv4(10, 0, 0, 53).To4(), net.IPv4(10, 0, 0, 54).To4()},
+		Counter:        1 << 40,
+		Raw:            "raw",
+	}
+
+	p := New(CodeAccessRequest, []byte(`secret`))
+	if err := codec.Marshal(p, &in); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := p.Get(1); string(got) != "tim" {
+		t.Fatalf("got User-Name %q", got)
+	}
+	if got := p.Get(2); len(got) != 16 || bytes.HasPrefix(got, []byte("12345")) {
+		t.Fatalf("got User-Password %x; expected encrypted value", got)
+	}
+	if got := p.Get(6); !bytes.Equal(got, []byte{0, 0, 0, 2}) {
+		t.Fatalf("got Service-Type %x", got)
+	}
+	if got := p.Get(64); !bytes.Equal(got, []byte{2, 0, 0, 13}) {
+		t.Fatalf("got Tunnel-Type %x", got)
+	}
+	if _, ok := p.Lookup(27); ok {
+		t.Fatal("nil pointer field was marshaled")
+	}
+	if _, ok := p.Lookup(55); ok {
+		t.Fatal("omitempty field was marshaled")
+	}
+
+	var vsas [][]byte
+	for _, avp := range p.Attributes {
+		if avp.Type == 26 {
+			vsas = append(vsas, avp.Attribute)
+		}
+	}
+	expectedVSAs := [][]byte{
+		{0, 0, 1, 55, 28, 6, 10, 0, 0, 53},
+		{0, 0, 1, 55, 28, 6, 10, 0, 0, 54},
+		{0, 0, 253, 232, 1, 44, 0, 12, 0, 0, 1, 0, 0, 0, 0, 0},
+		{0, 0, 1, 55, 99, 5, 'r', 'a', 'w'},
+	}
+	if !reflect.DeepEqual(vsas, expectedVSAs) {
+		t.Fatalf("got VSAs %x; expecting %x", vsas, expectedVSAs)
+	}
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := Parse(wire, p.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Request
+	timeout := 30
+	out.SessionTimeout = &timeout
+	if err := codec.Unmarshal(q, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.SessionTimeout = &timeout
+	in.FramedIP = in.FramedIP.To4()
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %#v\nexpecting %#v", out, in)
+	}
+}
+
+func TestUnmarshal_conversions(t *testing.T) {
+	type Accounting struct {
+		ServiceType    uint8     `radius:"Service-Type"`
+		ServiceName    string    `radius:"6"`
+		TunnelTypes    []string  `radius:"Tunnel-Type"`
+		TunnelType1    *uint32   `radius:"Tunnel-Type,tag=1"`
+		TunnelType5    *uint32   `radius:"Tunnel-Type,tag=5"`
+		Timestamp      int64     `radius:"Event-Timestamp"`
+		Time           time.Time `radius:"Event-Timestamp"`
+		Prefix         net.IPNet `radius:"Framed-IPv6-Prefix"`
+		Class          string    `radius:"Class"`
+		UnknownAddress net.IP    `radius:"26.9999.1"`
+	}
+
+	p := New(CodeAccountingRequest, []byte(`secret`))
+	p.Add(6, NewInteger(1))
+	p.Add(64, Attribute{1, 0, 0, 3})
+	p.Add(64, Attribute{2, 0, 0, 13})
+	p.Add(55, NewInteger(1526212510))
+	p.Add(97, Attribute{0, 32, 0x20, 0x01, 0x0d, 0xb8})
+	p.Add(25, Attribute("class"))
+	p.Add(26, Attribute{0, 0, 0x27, 0x0f, 1, 6, 192, 0, 2, 1})
+
+	codec := &Codec{
+		Dictionary: codecTestDictionary,
+	}
+	var out Accounting
+	if err := codec.Unmarshal(p, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.ServiceType != 1 || out.ServiceName != "Login-User" {
+		t.Fatalf("got Service-Type %d, %q", out.ServiceType, out.ServiceName)
+	}
+	if !reflect.DeepEqual(out.TunnelTypes, []string{"L2TP", "VLAN"}) {
+		t.Fatalf("got Tunnel-Type %q", out.TunnelTypes)
+	}
+	if out.TunnelType1 == nil || *out.TunnelType1 != 3 || out.TunnelType5 != nil {
+		t.Fatalf("got tagged Tunnel-Type %v, %v", out.TunnelType1, out.TunnelType5)
+	}
+	if out.Timestamp != 1526212510 || out.Time.Unix() != 1526212510 {
+		t.Fatalf("got Event-Timestamp %d, %v", out.Timestamp, out.Time)
+	}
+	if out.Prefix.String() != "2001:db8::/32" {
+		t.Fatalf("got Framed-IPv6-Prefix %v", out.Prefix)
+	}
+	if out.Class != "class" {
+		t.Fatalf("got Class %q", out.Class)
+	}
+	if !out.UnknownAddress.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("got address %v", out.UnknownAddress)
+	}
+}
+
+func TestMarshal_errors(t *testing.T) {
+	codec := &Codec{
+		Dictionary: codecTestDictionary,
+	}
+	p := New(CodeAccessRequest, []byte(`secret`))
+
+	tests := []struct {
+		Value interface{}
+		Error string
+	}{
+		{struct {
+			X string `radius:"Unknown-Attribute"`
+		}{}, `radius: field X: unknown attribute "Unknown-Attribute"`},
+		{struct {
+			X int `radius:"Service-Type"`
+		}{-1}, `radius: field X (Service-Type): value out of range`},
+		{struct {
+			X string `radius:"Service-Type"`
+		}{"Unknown-Value"}, `radius: field X (Service-Type): invalid integer value "Unknown-Value"`},
+		{struct {
+			X string `radius:"Framed-IP-Address"`
+		}{"::1"}, `radius: field X (Framed-IP-Address): invalid IPv4 address`},
+		{struct {
+			X string `radius:"User-Name,bogus"`
+		}{}, `radius: field X: unknown option "bogus"`},
+		{"string", `radius: Marshal of non-struct string`},
+	}
+
+	for _, tt := range tests {
+		err := codec.Marshal(p, tt.Value)
+		if err == nil || err.Error() != tt.Error {
+			t.Errorf("got error %v; expecting %s", err, tt.Error)
+		}
+	}
+}
+
+func TestMarshal_defaultCodec(t *testing.T) {
+	type Request struct {
+		UserName string `radius:"1"`
+		Port     uint32 `radius:"5"`
+	}
+
+	p := New(CodeAccessRequest, []byte(`secret`))
+	if err := Marshal(p, Request{"tim", 7}); err != nil {
+		t.Fatal(err)
+	}
+	var out Request
+	if err := Unmarshal(p, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.UserName != "tim" || out.Port != 7 {
+		t.Fatalf("got %#v", out)
+	}
+
+	type Named struct {
+		UserName string `radius:"User-Name"`
+	}
+	if err := Marshal(p, Named{}); err == nil {
+		t.Fatal("expected error for name without dictionary")
+	}
+}
@@ -0,0 +1,90 @@
+package rfc1994
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// ChallengeResponse - rfc1994, 4.1
+func ChallengeResponse(ident byte, secret, challenge []byte) []byte {
+	hash := md5.New()
+	hash.Write([]byte{ident})
+	hash.Write(secret)
+	hash.Write(challenge)
+	return hash.Sum(nil)
+}
+
+// ParseCHAPPassword splits a CHAP-Password attribute value into its CHAP
+// identifier and 16-byte response (rfc2865, 5.3). An error is returned if the
+// value is not 17 bytes long.
+func ParseCHAPPassword(a []byte) (ident byte, response []byte, err error) {
+	if len(a) != 1+md5.Size {
+		err = errors.New("invalid CHAP-Password length")
+		return
+	}
+	ident = a[0]
+	response = append([]byte(nil), a[1:]...)
+	return
+}
+
+// NewCHAPPassword returns a CHAP-Password attribute value built from the
+// given CHAP identifier and response.
+func NewCHAPPassword(ident byte, response []byte) ([]byte, error) {
+	if len(response) != md5.Size {
+		return nil, errors.New("invalid CHAP response length")
+	}
+	a := make([]byte, 1+md5.Size)
+	a[0] = ident
+	copy(a[1:], response)
+	return a, nil
+}
+
+// Challenge returns the CHAP challenge of the given Access-Request. The
+// value of CHAP-Challenge is returned if present, otherwise the packet's
+// Request Authenticator is used (rfc2865, 2.2).
+func Challenge(p *radius.Packet) []byte {
+	if challenge, err := rfc2865.CHAPChallenge_Lookup(p); err == nil {
+		return challenge
+	}
+	return append([]byte(nil), p.Authenticator[:]...)
+}
+
+// VerifyPassword reports whether the CHAP-Password of the given
+// Access-Request was computed from the cleartext password. The comparison
+// is performed in constant time.
+//
+// radius.ErrNoAttribute is returned if the packet does not contain a
+// CHAP-Password attribute.
+func VerifyPassword(p *radius.Packet, password []byte) (bool, error) {
+	chapPassword, err := rfc2865.CHAPPassword_Lookup(p)
+	if err != nil {
+		return false, err
+	}
+	ident, response, err := ParseCHAPPassword(chapPassword)
+	if err != nil {
+		return false, err
+	}
+	expected := ChallengeResponse(ident, password, Challenge(p))
+	return subtle.ConstantTimeCompare(expected, response) == 1, nil
+}
+
+// SetPassword sets the CHAP-Password attribute of the given Access-Request,
+// computed from the cleartext password and a random CHAP identifier. The
+// packet's CHAP-Challenge attribute is used as the challenge if present,
+// otherwise the packet's Request Authenticator is used.
+func SetPassword(p *radius.Packet, password []byte) error {
+	var ident [1]byte
+	if _, err := rand.Read(ident[:]); err != nil {
+		return err
+	}
+	a, err := NewCHAPPassword(ident[0], ChallengeResponse(ident[0], password, Challenge(p)))
+	if err != nil {
+		return err
+	}
+	return rfc2865.CHAPPassword_Set(p, a)
+}
@@ -0,0 +1,112 @@
+package rfc1994
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func TestChallengeResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		ident     byte
+		secret    []byte
+		challenge []byte
+		want      string
+	}{
+		{
+			name:      "authenticator",
+			ident:     0x2a,
+			secret:    []byte("password"),
+			challenge: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			want:      "3e0949a5572c2d338c182b7f6377471d",
+		},
+		{
+			name:      "short challenge",
+			ident:     0x01,
+			secret:    []byte("12345"),
+			challenge: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			want:      "37df061f88099b2243b7a2c3e243ed53",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(ChallengeResponse(tt.ident, tt.secret, tt.challenge)); got != tt.want {
+				t.Errorf("ChallengeResponse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	p := &radius.Packet{
+		Code:   radius.CodeAccessRequest,
+		Secret: []byte("secret"),
+	}
+	for i := range p.Authenticator {
+		p.Authenticator[i] = byte(i)
+	}
+
+	if _, err := VerifyPassword(p, []byte("password")); err != radius.ErrNoAttribute {
+		t.Fatalf("got err %v; expected ErrNoAttribute", err)
+	}
+
+	response, _ := hex.DecodeString("3e0949a5572c2d338c182b7f6377471d")
+	chapPassword, err := NewCHAPPassword(0x2a, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rfc2865.CHAPPassword_Set(p, chapPassword)
+
+	if ok, err := VerifyPassword(p, []byte("password")); err != nil || !ok {
+		t.Fatalf("got (%v, %v); expected (true, nil)", ok, err)
+	}
+	if ok, err := VerifyPassword(p, []byte("Password")); err != nil || ok {
+		t.Fatalf("got (%v, %v); expected (false, nil)", ok, err)
+	}
+
+	// CHAP-Challenge takes precedence over the Request Authenticator
+	rfc2865.CHAPChallenge_Set(p, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	if ok, _ := VerifyPassword(p, []byte("password")); ok {
+		t.Fatal("password verified against Request Authenticator; expected CHAP-Challenge")
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	for _, challenge := range [][]byte{nil, []byte("0123456789abcdefghij")} {
+		p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+		if challenge != nil {
+			rfc2865.CHAPChallenge_Set(p, challenge)
+		}
+		if err := SetPassword(p, []byte("12345")); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := p.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := radius.Parse(b, p.Secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := VerifyPassword(q, []byte("12345")); err != nil || !ok {
+			t.Fatalf("got (%v, %v); expected (true, nil)", ok, err)
+		}
+	}
+}
+
+func TestParseCHAPPassword(t *testing.T) {
+	if _, _, err := ParseCHAPPassword(make([]byte, 16)); err == nil {
+		t.Fatal("expected error for short CHAP-Password")
+	}
+	ident, response, err := ParseCHAPPassword(append([]byte{7}, make([]byte, 16)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident != 7 || len(response) != 16 {
+		t.Fatalf("got ident %d, response length %d", ident, len(response))
+	}
+}
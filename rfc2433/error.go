@@ -0,0 +1,113 @@
+package rfc2433
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Failure codes - rfc2433, 5
+const (
+	ErrorRestrictedLogonHours  = 646
+	ErrorAcctDisabled          = 647
+	ErrorPasswdExpired         = 648
+	ErrorNoDialinPermission    = 649
+	ErrorAuthenticationFailure = 691
+	ErrorChangingPassword      = 709
+)
+
+// Error is the failure message carried by MS-CHAP-Error - rfc2433, 5
+type Error struct {
+	// Code is the Windows NT error code.
+	Code int
+	// Retry reports whether the peer is allowed to retry authentication.
+	Retry bool
+	// Challenge is the new authenticator challenge for a retry. It is
+	// omitted if empty.
+	Challenge []byte
+	// Version is the MS-CHAP version supported by the authenticator. It is
+	// omitted if zero.
+	Version int
+	// Message is the optional human readable failure text (rfc2759, 6).
+	Message string
+}
+
+// String returns the failure message in "E=eeeeeeeeee R=r C=cc V=vv M=..."
+// format.
+func (e *Error) String() string {
+	var b strings.Builder
+	b.WriteString("E=")
+	b.WriteString(strconv.Itoa(e.Code))
+	b.WriteString(" R=")
+	if e.Retry {
+		b.WriteByte('1')
+	} else {
+		b.WriteByte('0')
+	}
+	if len(e.Challenge) > 0 {
+		b.WriteString(" C=")
+		b.WriteString(strings.ToUpper(hex.EncodeToString(e.Challenge)))
+	}
+	if e.Version != 0 {
+		b.WriteString(" V=")
+		b.WriteString(strconv.Itoa(e.Version))
+	}
+	if e.Message != "" {
+		b.WriteString(" M=")
+		b.WriteString(e.Message)
+	}
+	return b.String()
+}
+
+// Bytes returns the MS-CHAP-Error attribute value of e: the given PPP
+// identifier followed by the failure message - rfc2548, 2.1.5
+func (e *Error) Bytes(ident byte) []byte {
+	return append([]byte{ident}, e.String()...)
+}
+
+// ParseError decodes a failure message in "E=eeeeeeeeee R=r ..." format.
+func ParseError(s string) (*Error, error) {
+	e := new(Error)
+	seenCode := false
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		if len(s) < 2 || s[1] != '=' {
+			return nil, errors.New("invalid MS-CHAP failure message")
+		}
+		key := s[0]
+		s = s[2:]
+
+		var value string
+		if key == 'M' {
+			// message text runs until the end of the packet
+			value, s = s, ""
+		} else if i := strings.IndexByte(s, ' '); i >= 0 {
+			value, s = s[:i], s[i:]
+		} else {
+			value, s = s, ""
+		}
+
+		var err error
+		switch key {
+		case 'E':
+			e.Code, err = strconv.Atoi(value)
+			seenCode = true
+		case 'R':
+			e.Retry = value == "1"
+		case 'C':
+			e.Challenge, err = hex.DecodeString(value)
+		case 'V':
+			e.Version, err = strconv.Atoi(value)
+		case 'M':
+			e.Message = value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !seenCode {
+		return nil, errors.New("MS-CHAP failure message missing error code")
+	}
+	return e, nil
+}
@@ -0,0 +1,61 @@
+package rfc2433
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{
+			name: "minimal",
+			err:  &Error{Code: ErrorAuthenticationFailure},
+			want: "E=691 R=0",
+		},
+		{
+			name: "retry",
+			err: &Error{
+				Code:      ErrorAuthenticationFailure,
+				Retry:     true,
+				Challenge: testChallenge,
+				Version:   2,
+			},
+			want: "E=691 R=1 C=102DB5DF085D3041 V=2",
+		},
+		{
+			name: "message",
+			err: &Error{
+				Code:      ErrorPasswdExpired,
+				Challenge: testChallenge,
+				Version:   3,
+				Message:   "Password expired",
+			},
+			want: "E=648 R=0 C=102DB5DF085D3041 V=3 M=Password expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.String(); got != tt.want {
+				t.Fatalf("String() = %q, want %q", got, tt.want)
+			}
+			parsed, err := ParseError(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.String() != tt.want {
+				t.Fatalf("ParseError() = %#v", parsed)
+			}
+		})
+	}
+
+	if b := (&Error{Code: ErrorAcctDisabled}).Bytes(9); !bytes.Equal(b, []byte("\x09E=647 R=0")) {
+		t.Fatalf("Bytes() = %q", b)
+	}
+	if _, err := ParseError("R=1"); err == nil {
+		t.Fatal("expected error for missing code")
+	}
+}
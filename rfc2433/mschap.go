@@ -0,0 +1,115 @@
+package rfc2433
+
+import (
+	"bytes"
+	"errors"
+
+	"layeh.com/radius/rfc2759"
+)
+
+// lmMagic is the constant "KGS!@#$%" encrypted by LmPasswordHash.
+var lmMagic = []byte{0x4B, 0x47, 0x53, 0x21, 0x40, 0x23, 0x24, 0x25}
+
+// LmPasswordHash - rfc2433, A.2
+func LmPasswordHash(password []byte) []byte {
+	ucasePassword := make([]byte, 14)
+	copy(ucasePassword, bytes.ToUpper(password))
+
+	passwordHash := make([]byte, 16)
+	copy(passwordHash[0:], rfc2759.DESCrypt(ucasePassword[0:7], lmMagic))
+	copy(passwordHash[8:], rfc2759.DESCrypt(ucasePassword[7:14], lmMagic))
+	return passwordHash
+}
+
+// NtPasswordHash - rfc2433, A.8
+func NtPasswordHash(password []byte) ([]byte, error) {
+	ucs2Password, err := rfc2759.ToUTF16(password)
+	if err != nil {
+		return nil, err
+	}
+	return rfc2759.NTPasswordHash(ucs2Password), nil
+}
+
+// LmChallengeResponse - rfc2433, A.1
+func LmChallengeResponse(challenge, password []byte) []byte {
+	return rfc2759.ChallengeResponse(challenge, LmPasswordHash(password))
+}
+
+// NtChallengeResponse - rfc2433, A.5
+func NtChallengeResponse(challenge, password []byte) ([]byte, error) {
+	passwordHash, err := NtPasswordHash(password)
+	if err != nil {
+		return nil, err
+	}
+	return rfc2759.ChallengeResponse(challenge, passwordHash), nil
+}
+
+// Response flags - rfc2548, 2.1.3
+const (
+	ResponseFlagLM = 0
+	ResponseFlagNT = 1
+)
+
+// Response is a decoded MS-CHAP-Response attribute value - rfc2548, 2.1.3
+type Response struct {
+	Ident      byte
+	Flags      byte
+	LMResponse []byte
+	NTResponse []byte
+}
+
+// ParseResponse decodes the 50-octet value of an MS-CHAP-Response attribute.
+func ParseResponse(b []byte) (*Response, error) {
+	if len(b) != 50 {
+		return nil, errors.New("MS-CHAP-Response must be 50 bytes in size")
+	}
+	return &Response{
+		Ident:      b[0],
+		Flags:      b[1],
+		LMResponse: append([]byte(nil), b[2:26]...),
+		NTResponse: append([]byte(nil), b[26:50]...),
+	}, nil
+}
+
+// Bytes returns the 50-octet MS-CHAP-Response attribute value of r.
+func (r *Response) Bytes() []byte {
+	b := make([]byte, 50)
+	b[0] = r.Ident
+	b[1] = r.Flags
+	copy(b[2:26], r.LMResponse)
+	copy(b[26:50], r.NTResponse)
+	return b
+}
+
+// NewResponse computes the MS-CHAP-Response for the given 8-octet
+// authenticator challenge and password. Only the NT-Response is filled in,
+// as recommended by rfc2433, 3.
+func NewResponse(ident byte, challenge, password []byte) (*Response, error) {
+	if len(challenge) != 8 {
+		return nil, errors.New("challenge must be 8 bytes in size")
+	}
+	ntResponse, err := NtChallengeResponse(challenge, password)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Ident:      ident,
+		Flags:      ResponseFlagNT,
+		LMResponse: make([]byte, 24),
+		NTResponse: ntResponse,
+	}, nil
+}
+
+// MPPEKeys returns the 24-octet value of the MS-CHAP-MPPE-Keys attribute for
+// the given password: the first 8 octets of the LM password hash followed by
+// the MD4 hash of the NT password hash - rfc2548, 2.4.1
+func MPPEKeys(password []byte) ([]byte, error) {
+	ntPasswordHash, err := NtPasswordHash(password)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]byte, 24)
+	copy(keys[0:8], LmPasswordHash(password))
+	copy(keys[8:24], rfc2759.NTPasswordHash(ntPasswordHash))
+	return keys, nil
+}
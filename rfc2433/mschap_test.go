@@ -0,0 +1,115 @@
+package rfc2433
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// rfc2433, B.2
+var (
+	testChallenge = []byte{0x10, 0x2D, 0xB5, 0xDF, 0x08, 0x5D, 0x30, 0x41}
+	testPassword  = []byte("MyPw")
+)
+
+func TestLmPasswordHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		password []byte
+		want     []byte
+	}{
+		{
+			name:     "rfc2433, B.2",
+			password: testPassword,
+			want: []byte{
+				0x75, 0xBA, 0x30, 0x19, 0x8E, 0x6D, 0x19, 0x75, 0xAA, 0xD3, 0xB4, 0x35, 0xB5, 0x14, 0x04, 0xEE,
+			},
+		},
+		{
+			name:     "rfc3079, 2.5",
+			password: []byte("clientPass"),
+			want: []byte{
+				0x76, 0xA1, 0x52, 0x93, 0x60, 0x96, 0xD7, 0x83, 0x0E, 0x23, 0x90, 0x22, 0x74, 0x04, 0xAF, 0xD2,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LmPasswordHash(tt.password); !bytes.Equal(got, tt.want) {
+				t.Errorf("LmPasswordHash() = %s, want %s", hex.EncodeToString(got), hex.EncodeToString(tt.want))
+			}
+		})
+	}
+}
+
+func TestNtPasswordHash(t *testing.T) {
+	want := []byte{
+		0xFC, 0x15, 0x6A, 0xF7, 0xED, 0xCD, 0x6C, 0x0E, 0xDD, 0xE3, 0x33, 0x7D, 0x42, 0x7F, 0x4E, 0xAC,
+	}
+	got, err := NtPasswordHash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("NtPasswordHash() = %s, want %s", hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+}
+
+func TestLmChallengeResponse(t *testing.T) {
+	want := []byte{
+		0x91, 0x88, 0x1D, 0x01, 0x52, 0xAB, 0x0C, 0x33, 0xC5, 0x24, 0x13, 0x5E, 0xC2, 0x4A, 0x95, 0xEE, 0x64, 0xE2, 0x3C, 0xDC, 0x2D, 0x33, 0x34, 0x7D,
+	}
+	if got := LmChallengeResponse(testChallenge, testPassword); !bytes.Equal(got, want) {
+		t.Errorf("LmChallengeResponse() = %s, want %s", hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+}
+
+func TestNtChallengeResponse(t *testing.T) {
+	want := []byte{
+		0x4E, 0x9D, 0x3C, 0x8F, 0x9C, 0xFD, 0x38, 0x5D, 0x5B, 0xF4, 0xD3, 0x24, 0x67, 0x91, 0x95, 0x6C, 0xA4, 0xC3, 0x51, 0xAB, 0x40, 0x9A, 0x3D, 0x61,
+	}
+	got, err := NtChallengeResponse(testChallenge, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("NtChallengeResponse() = %s, want %s", hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+}
+
+func TestResponse(t *testing.T) {
+	r, err := NewResponse(7, testChallenge, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := r.Bytes()
+	if len(b) != 50 || b[0] != 7 || b[1] != ResponseFlagNT {
+		t.Fatalf("unexpected MS-CHAP-Response %x", b)
+	}
+	parsed, err := ParseResponse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.NTResponse, r.NTResponse) || !bytes.Equal(parsed.LMResponse, r.LMResponse) {
+		t.Fatalf("got %#v; expected %#v", parsed, r)
+	}
+	if _, err := ParseResponse(b[:49]); err == nil {
+		t.Fatal("expected error for short MS-CHAP-Response")
+	}
+}
+
+func TestMPPEKeys(t *testing.T) {
+	// LM key is the first 8 octets of the LM hash, NT key is the
+	// PasswordHashHash of rfc3079, 3.5.1.
+	want := []byte{
+		0x76, 0xA1, 0x52, 0x93, 0x60, 0x96, 0xD7, 0x83,
+		0x41, 0xC0, 0x0C, 0x58, 0x4B, 0xD2, 0xD9, 0x1C, 0x40, 0x17, 0xA2, 0xA1, 0x2F, 0xA5, 0x9F, 0x3F,
+	}
+	got, err := MPPEKeys([]byte("clientPass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MPPEKeys() = %s, want %s", hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+}
@@ -0,0 +1,100 @@
+package rfc2759
+
+import (
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// EncryptedPwBlockLength is the size of an encrypted password block, as sent
+// in the Encrypted-Password field of a Change-Password packet - rfc2759, 7
+const EncryptedPwBlockLength = 516
+
+// FromUTF16 takes a UCS-2 / UTF-16 representation and turns it into UTF-8
+func FromUTF16(in []byte) ([]byte, error) {
+	decoder := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	return decoder.Bytes(in)
+}
+
+// NewPasswordEncryptedWithOldNtPasswordHash - rfc2759, 8.9
+func NewPasswordEncryptedWithOldNtPasswordHash(newPassword, oldPassword []byte) ([]byte, error) {
+	ucs2OldPassword, err := ToUTF16(oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptPwBlockWithPasswordHash(newPassword, NTPasswordHash(ucs2OldPassword))
+}
+
+// EncryptPwBlockWithPasswordHash - rfc2759, 8.10
+func EncryptPwBlockWithPasswordHash(password, passwordHash []byte) ([]byte, error) {
+	ucs2Password, err := ToUTF16(password)
+	if err != nil {
+		return nil, err
+	}
+	if len(ucs2Password) > 512 {
+		return nil, errors.New("password must be at most 256 characters long")
+	}
+
+	clearPwBlock := make([]byte, EncryptedPwBlockLength)
+	if _, err := rand.Read(clearPwBlock[:512-len(ucs2Password)]); err != nil {
+		return nil, err
+	}
+	copy(clearPwBlock[512-len(ucs2Password):], ucs2Password)
+	binary.LittleEndian.PutUint32(clearPwBlock[512:], uint32(len(ucs2Password)))
+
+	return rc4Encrypt(clearPwBlock, passwordHash)
+}
+
+// DecryptPwBlockWithPasswordHash reverses EncryptPwBlockWithPasswordHash,
+// returning the UTF-8 representation of the password in the block.
+func DecryptPwBlockWithPasswordHash(block, passwordHash []byte) ([]byte, error) {
+	if len(block) != EncryptedPwBlockLength {
+		return nil, errors.New("encrypted password block must be 516 bytes in size")
+	}
+
+	clearPwBlock, err := rc4Encrypt(block, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordLength := binary.LittleEndian.Uint32(clearPwBlock[512:])
+	if passwordLength > 512 || passwordLength%2 != 0 {
+		return nil, errors.New("invalid password length")
+	}
+	return FromUTF16(clearPwBlock[512-passwordLength : 512])
+}
+
+// rc4Encrypt - rfc2759, 8.11
+func rc4Encrypt(clear, key []byte) ([]byte, error) {
+	cipher, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(clear))
+	cipher.XORKeyStream(out, clear)
+	return out, nil
+}
+
+// OldNtPasswordHashEncryptedWithNewNtPasswordHash - rfc2759, 8.12
+func OldNtPasswordHashEncryptedWithNewNtPasswordHash(newPassword, oldPassword []byte) ([]byte, error) {
+	ucs2OldPassword, err := ToUTF16(oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	ucs2NewPassword, err := ToUTF16(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	return NtPasswordHashEncryptedWithBlock(NTPasswordHash(ucs2OldPassword), NTPasswordHash(ucs2NewPassword)), nil
+}
+
+// NtPasswordHashEncryptedWithBlock - rfc2759, 8.13
+func NtPasswordHashEncryptedWithBlock(passwordHash, block []byte) []byte {
+	cypher := make([]byte, 16)
+	copy(cypher[0:], DESCrypt(block[0:7], passwordHash[0:8]))
+	copy(cypher[8:], DESCrypt(block[7:14], passwordHash[8:16]))
+	return cypher
+}
@@ -0,0 +1,56 @@
+package rfc2759
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewPasswordEncryptedWithOldNtPasswordHash(t *testing.T) {
+	tests := []struct {
+		name        string
+		newPassword []byte
+		oldPassword []byte
+	}{
+		{"ascii", []byte("MyPw"), []byte("clientPass")},
+		{"unicode", []byte("pässwörd"), []byte("superSecretPassword")},
+		{"empty", []byte(""), []byte("clientPass")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := NewPasswordEncryptedWithOldNtPasswordHash(tt.newPassword, tt.oldPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(block) != EncryptedPwBlockLength {
+				t.Fatalf("got block length %d; expected %d", len(block), EncryptedPwBlockLength)
+			}
+
+			ucs2OldPassword, _ := ToUTF16(tt.oldPassword)
+			got, err := DecryptPwBlockWithPasswordHash(block, NTPasswordHash(ucs2OldPassword))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.newPassword) {
+				t.Errorf("DecryptPwBlockWithPasswordHash() = %q, want %q", got, tt.newPassword)
+			}
+		})
+	}
+
+	if _, err := NewPasswordEncryptedWithOldNtPasswordHash(bytes.Repeat([]byte("a"), 257), []byte("old")); err == nil {
+		t.Fatal("expected error for password longer than 256 characters")
+	}
+}
+
+func TestOldNtPasswordHashEncryptedWithNewNtPasswordHash(t *testing.T) {
+	got, err := OldNtPasswordHashEncryptedWithNewNtPasswordHash([]byte("MyPw"), []byte("clientPass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x6F, 0x69, 0xBB, 0xE9, 0x31, 0x1F, 0xD3, 0x67, 0x14, 0xE3, 0x80, 0xE6, 0x28, 0x55, 0x26, 0x1D,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("OldNtPasswordHashEncryptedWithNewNtPasswordHash() = %s, want %s", hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+}
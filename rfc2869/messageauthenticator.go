@@ -0,0 +1,93 @@
+package rfc2869
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/subtle"
+	"errors"
+
+	"layeh.com/radius"
+)
+
+// ErrInvalidMessageAuthenticator is returned when a packet's
+// Message-Authenticator does not match its contents.
+var ErrInvalidMessageAuthenticator = errors.New("rfc2869: invalid Message-Authenticator")
+
+// MessageAuthenticator_Sign computes the Message-Authenticator of p and sets
+// it, adding the attribute if p does not contain one - rfc2869, 5.14
+//
+// For responses, p.Authenticator must hold the Request Authenticator (as set
+// by radius.Packet.Response). p must not be modified after signing.
+func MessageAuthenticator_Sign(p *radius.Packet) error {
+	if _, ok := p.Lookup(MessageAuthenticator_Type); !ok {
+		p.Add(MessageAuthenticator_Type, make(radius.Attribute, md5.Size))
+	}
+	sum, err := messageAuthenticator(p, p.Authenticator[:])
+	if err != nil {
+		return err
+	}
+	for _, avp := range p.Attributes {
+		if avp.Type == MessageAuthenticator_Type {
+			avp.Attribute = sum
+			break
+		}
+	}
+	return nil
+}
+
+// MessageAuthenticator_Verify checks the Message-Authenticator of p. When p
+// is a response, q must be the request it answers; otherwise q must be nil.
+//
+// radius.ErrNoAttribute is returned if p does not contain a
+// Message-Authenticator.
+func MessageAuthenticator_Verify(p, q *radius.Packet) error {
+	value, ok := p.Lookup(MessageAuthenticator_Type)
+	if !ok {
+		return radius.ErrNoAttribute
+	}
+	authenticator := p.Authenticator[:]
+	if q != nil {
+		authenticator = q.Authenticator[:]
+	}
+	sum, err := messageAuthenticator(p, authenticator)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(sum, value) != 1 {
+		return ErrInvalidMessageAuthenticator
+	}
+	return nil
+}
+
+func messageAuthenticator(p *radius.Packet, authenticator []byte) ([]byte, error) {
+	if len(p.Secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+
+	zeroed := *p
+	zeroed.Attributes = make(radius.Attributes, len(p.Attributes))
+	for i, avp := range p.Attributes {
+		if avp.Type == MessageAuthenticator_Type {
+			avp = &radius.AVP{
+				Type:      MessageAuthenticator_Type,
+				Attribute: make(radius.Attribute, md5.Size),
+			}
+		}
+		zeroed.Attributes[i] = avp
+	}
+
+	b, err := zeroed.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	switch p.Code {
+	case radius.CodeAccountingRequest, radius.CodeDisconnectRequest, radius.CodeCoARequest:
+		copy(b[4:20], make([]byte, 16))
+	default:
+		copy(b[4:20], authenticator)
+	}
+
+	hash := hmac.New(md5.New, p.Secret)
+	hash.Write(b)
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,85 @@
+package rfc2869
+
+import (
+	"bytes"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func TestMessageAuthenticator_RFC5997(t *testing.T) {
+	secret := []byte(`xyzzy5461`)
+	request := []byte{
+		0x0c, 0xda, 0x00, 0x26, 0x8a, 0x54, 0xf4, 0x68, 0x6f, 0xb3, 0x94, 0xc5, 0x28, 0x66, 0xe3, 0x02,
+		0x18, 0x5d, 0x06, 0x23, 0x50, 0x12, 0x5a, 0x66, 0x5e, 0x2e, 0x1e, 0x84, 0x11, 0xf3, 0xe2, 0x43,
+		0x82, 0x20, 0x97, 0xc8, 0x4f, 0xa3,
+	}
+	p, err := radius.Parse(request, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MessageAuthenticator_Verify(p, nil); err != nil {
+		t.Fatalf("got err %v; expected nil", err)
+	}
+
+	expected := MessageAuthenticator_Get(p)
+	MessageAuthenticator_Set(p, make([]byte, 16))
+	if err := MessageAuthenticator_Sign(p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(MessageAuthenticator_Get(p), expected) {
+		t.Fatalf("got %x; expected %x", MessageAuthenticator_Get(p), expected)
+	}
+}
+
+func TestMessageAuthenticator_Response(t *testing.T) {
+	request := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	rfc2865.UserName_SetString(request, "tim")
+	if err := MessageAuthenticator_Verify(request, nil); err != radius.ErrNoAttribute {
+		t.Fatalf("got err %v; expected ErrNoAttribute", err)
+	}
+	if err := MessageAuthenticator_Sign(request); err != nil {
+		t.Fatal(err)
+	}
+
+	response := request.Response(radius.CodeAccessChallenge)
+	rfc2865.ReplyMessage_SetString(response, "challenge")
+	if err := MessageAuthenticator_Sign(response); err != nil {
+		t.Fatal(err)
+	}
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := radius.Parse(wire, request.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MessageAuthenticator_Verify(parsed, request); err != nil {
+		t.Fatalf("got err %v; expected nil", err)
+	}
+
+	rfc2865.ReplyMessage_SetString(parsed, "tampered")
+	if err := MessageAuthenticator_Verify(parsed, request); err != ErrInvalidMessageAuthenticator {
+		t.Fatalf("got err %v; expected ErrInvalidMessageAuthenticator", err)
+	}
+}
+
+func TestMessageAuthenticator_Accounting(t *testing.T) {
+	request := radius.New(radius.CodeAccountingRequest, []byte(`secret`))
+	if err := MessageAuthenticator_Sign(request); err != nil {
+		t.Fatal(err)
+	}
+	wire, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := radius.Parse(wire, request.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MessageAuthenticator_Verify(parsed, nil); err != nil {
+		t.Fatalf("got err %v; expected nil", err)
+	}
+}
@@ -0,0 +1,145 @@
+package rfc5090
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"layeh.com/radius"
+)
+
+// Digest-Algorithm and Digest-Qop values - rfc5090, 4.1
+const (
+	AlgorithmMD5     = "MD5"
+	AlgorithmMD5Sess = "MD5-sess"
+
+	QopAuth    = "auth"
+	QopAuthInt = "auth-int"
+)
+
+// Digest holds the Digest-* attributes of an Access-Request carrying an HTTP
+// Digest response (RFC 2617) - rfc5090, 3
+type Digest struct {
+	Response       string
+	Realm          string
+	Nonce          string
+	Method         string
+	URI            string
+	Qop            string
+	Algorithm      string
+	EntityBodyHash string
+	CNonce         string
+	NonceCount     string
+	Username       string
+	Opaque         string
+}
+
+// ErrInvalidDigest is returned by LookupDigest for incomplete or
+// inconsistent Digest-* attributes.
+var ErrInvalidDigest = errors.New("rfc5090: invalid Digest attributes")
+
+// LookupDigest returns the Digest-* attributes of the Access-Request p.
+//
+// radius.ErrNoAttribute is returned if p does not contain a
+// Digest-Response.
+func LookupDigest(p *radius.Packet) (*Digest, error) {
+	response, err := DigestResponse_LookupString(p)
+	if err != nil {
+		return nil, err
+	}
+	d := &Digest{
+		Response:       response,
+		Realm:          DigestRealm_GetString(p),
+		Nonce:          DigestNonce_GetString(p),
+		Method:         DigestMethod_GetString(p),
+		URI:            DigestURI_GetString(p),
+		Qop:            DigestQop_GetString(p),
+		Algorithm:      DigestAlgorithm_GetString(p),
+		EntityBodyHash: DigestEntityBodyHash_GetString(p),
+		CNonce:         DigestCNonce_GetString(p),
+		NonceCount:     DigestNonceCount_GetString(p),
+		Username:       DigestUsername_GetString(p),
+		Opaque:         DigestOpaque_GetString(p),
+	}
+
+	// rfc5090, 2.1.2: mandatory attributes
+	if d.Realm == "" || d.Nonce == "" || d.Method == "" || d.URI == "" || d.Username == "" {
+		return nil, ErrInvalidDigest
+	}
+	switch d.Algorithm {
+	case "", AlgorithmMD5:
+	case AlgorithmMD5Sess:
+		if d.CNonce == "" {
+			return nil, ErrInvalidDigest
+		}
+	default:
+		return nil, ErrInvalidDigest
+	}
+	switch d.Qop {
+	case "":
+	case QopAuth, QopAuthInt:
+		if d.CNonce == "" || d.NonceCount == "" {
+			return nil, ErrInvalidDigest
+		}
+		if d.Qop == QopAuthInt && d.EntityBodyHash == "" {
+			return nil, ErrInvalidDigest
+		}
+	default:
+		return nil, ErrInvalidDigest
+	}
+	return d, nil
+}
+
+func h(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// HA1 returns the hex encoded MD5 hash of "username:realm:password", as
+// stored by many SIP servers instead of the password - rfc2617, 3.2.2.2
+func (d *Digest) HA1(password string) string {
+	return h(d.Username + ":" + d.Realm + ":" + password)
+}
+
+// sessionHA1 returns H(A1) given H(username:realm:password).
+func (d *Digest) sessionHA1(ha1 string) string {
+	if d.Algorithm == AlgorithmMD5Sess {
+		return h(ha1 + ":" + d.Nonce + ":" + d.CNonce)
+	}
+	return ha1
+}
+
+// response returns request-digest for the given method - rfc2617, 3.2.2.1
+func (d *Digest) response(ha1, method string) string {
+	a2 := method + ":" + d.URI
+	if d.Qop == QopAuthInt {
+		a2 += ":" + d.EntityBodyHash
+	}
+	if d.Qop == "" {
+		return h(ha1 + ":" + d.Nonce + ":" + h(a2))
+	}
+	return h(ha1 + ":" + d.Nonce + ":" + d.NonceCount + ":" + d.CNonce + ":" + d.Qop + ":" + h(a2))
+}
+
+// Verify reports whether the Digest-Response was computed from the user's
+// cleartext password.
+func (d *Digest) Verify(password string) bool {
+	return d.VerifyHA1(d.HA1(password))
+}
+
+// VerifyHA1 reports whether the Digest-Response was computed from ha1, as
+// returned by HA1. The comparison is performed in constant time.
+func (d *Digest) VerifyHA1(ha1 string) bool {
+	expected := d.response(d.sessionHA1(strings.ToLower(ha1)), d.Method)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(d.Response))) == 1
+}
+
+// ResponseAuth returns the value of the Digest-Response-Auth attribute of the
+// Access-Accept, from which the RADIUS client builds the rspauth parameter
+// of the Authentication-Info header. ha1 is as returned by HA1 - rfc5090,
+// 4.1.4
+func (d *Digest) ResponseAuth(ha1 string) string {
+	return d.response(d.sessionHA1(strings.ToLower(ha1)), "")
+}
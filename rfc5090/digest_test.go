@@ -0,0 +1,113 @@
+package rfc5090
+
+import (
+	"testing"
+
+	"layeh.com/radius"
+)
+
+// rfc2617, 3.5
+func rfc2617Digest() *Digest {
+	return &Digest{
+		Response:   "6629fae49393a05397450978507c4ef1",
+		Realm:      "testrealm@host.com",
+		Nonce:      "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		Method:     "GET",
+		URI:        "/dir/index.html",
+		Qop:        QopAuth,
+		CNonce:     "0a4f113b",
+		NonceCount: "00000001",
+		Username:   "Mufasa",
+		Opaque:     "5ccc069c403ebaf9f0171e9517f40e41",
+	}
+}
+
+func TestDigest_Verify(t *testing.T) {
+	d := rfc2617Digest()
+	if !d.Verify("Circle Of Life") {
+		t.Fatal("expected valid response")
+	}
+	if d.Verify("circle of life") {
+		t.Fatal("expected invalid response")
+	}
+	if !d.VerifyHA1("939E7578ED9E3C518A452ACEE763BCE9") {
+		t.Fatal("expected valid response for upper case HA1")
+	}
+
+	d.Response = "6629FAE49393A05397450978507C4EF1"
+	if !d.Verify("Circle Of Life") {
+		t.Fatal("expected valid upper case response")
+	}
+}
+
+func TestDigest_variants(t *testing.T) {
+	base := rfc2617Digest()
+	ha1 := base.HA1("Circle Of Life")
+
+	tests := []struct {
+		Qop       string
+		Algorithm string
+	}{
+		{"", ""},
+		{QopAuth, AlgorithmMD5},
+		{QopAuthInt, ""},
+		{QopAuth, AlgorithmMD5Sess},
+		{QopAuthInt, AlgorithmMD5Sess},
+	}
+	for _, tt := range tests {
+		d := *base
+		d.Qop = tt.Qop
+		d.Algorithm = tt.Algorithm
+		d.EntityBodyHash = "d41d8cd98f00b204e9800998ecf8427e"
+
+		sessionHA1 := ha1
+		if tt.Algorithm == AlgorithmMD5Sess {
+			sessionHA1 = h(ha1 + ":" + d.Nonce + ":" + d.CNonce)
+		}
+		a2 := d.Method + ":" + d.URI
+		if tt.Qop == QopAuthInt {
+			a2 += ":" + d.EntityBodyHash
+		}
+		if tt.Qop == "" {
+			d.Response = h(sessionHA1 + ":" + d.Nonce + ":" + h(a2))
+		} else {
+			d.Response = h(sessionHA1 + ":" + d.Nonce + ":" + d.NonceCount + ":" + d.CNonce + ":" + d.Qop + ":" + h(a2))
+		}
+		if !d.Verify("Circle Of Life") {
+			t.Fatalf("%q/%q: expected valid response", tt.Qop, tt.Algorithm)
+		}
+		if d.ResponseAuth(ha1) == d.Response {
+			t.Fatalf("%q/%q: response auth must differ from response", tt.Qop, tt.Algorithm)
+		}
+	}
+}
+
+func TestLookupDigest(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	if _, err := LookupDigest(p); err != radius.ErrNoAttribute {
+		t.Fatalf("got %v", err)
+	}
+
+	d := rfc2617Digest()
+	DigestResponse_SetString(p, d.Response)
+	DigestRealm_SetString(p, d.Realm)
+	DigestNonce_SetString(p, d.Nonce)
+	DigestMethod_SetString(p, d.Method)
+	DigestURI_SetString(p, d.URI)
+	DigestQop_SetString(p, d.Qop)
+	DigestUsername_SetString(p, d.Username)
+	if _, err := LookupDigest(p); err != ErrInvalidDigest {
+		t.Fatalf("got %v for missing Digest-CNonce", err)
+	}
+
+	DigestCNonce_SetString(p, d.CNonce)
+	DigestNonceCount_SetString(p, d.NonceCount)
+	DigestOpaque_SetString(p, d.Opaque)
+	parsed, err := LookupDigest(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *d {
+		t.Fatalf("got %+v, expected %+v", parsed, d)
+	}
+}
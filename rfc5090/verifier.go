@@ -0,0 +1,375 @@
+package rfc5090
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+)
+
+// Verifier is a radius.Handler authenticating HTTP Digest responses carried in
+// Access-Requests, and issuing nonces in Access-Challenges - rfc5090, 2.1
+//
+// Nonces are not stored: they carry their issue time, authenticated with
+// the verifier's nonce key, and are accepted until they expire. A response to
+// an expired nonce is answered with a new nonce and Digest-Stale set to
+// "true". The verifier remembers the highest nonce count accepted for each
+// nonce until it expires, and rejects responses that do not increase it, so
+// that captured responses cannot be replayed. A retransmission of the last
+// accepted request of a nonce, with the same Identifier and Authenticator, is
+// answered with the same Access-Accept.
+//
+// Requests must carry a valid Message-Authenticator.
+type Verifier struct {
+	// Realm is the protection space of the credentials. Responses for other
+	// realms are rejected.
+	Realm string
+
+	// Qop lists the quality of protection values offered in challenges.
+	// Responses using other values, or none, are rejected. Defaults to
+	// QopAuth.
+	Qop []string
+
+	// Algorithm is the algorithm offered in challenges. Responses using
+	// another algorithm are rejected. Defaults to AlgorithmMD5.
+	Algorithm string
+
+	// Password returns the cleartext password of the user, or nil if the user
+	// is unknown.
+	Password func(r *radius.Request, username, realm string) ([]byte, error)
+
+	// HA1, if non-nil, is used instead of Password and returns the hex
+	// encoded MD5 hash of "username:realm:password", or an empty string if
+	// the user is unknown.
+	HA1 func(r *radius.Request, username, realm string) (string, error)
+
+	// NonceKey authenticates the issued nonces. Verifiers sharing the same
+	// clients must use the same key. If nil, a random key is used.
+	NonceKey []byte
+
+	// NonceTimeout is how long issued nonces are accepted. Defaults to five
+	// minutes.
+	NonceTimeout time.Duration
+
+	// ErrorLog specifies an optional logger for errors around packet
+	// processing. If nil, logging is done via the log package's standard
+	// logger.
+	ErrorLog *log.Logger
+
+	initOnce sync.Once
+	key      []byte
+
+	mu sync.Mutex
+	// counts holds the highest nonce count accepted for each nonce
+	counts    map[string]*nonceCount
+	lastPrune time.Time
+}
+
+type nonceCount struct {
+	count   uint64
+	expires time.Time
+
+	// the last Access-Accept for the nonce, resent when the NAS retransmits
+	// its request
+	identifier    byte
+	authenticator [16]byte
+	response      *radius.Packet
+}
+
+// Errors returned by Verifier.CheckNonce.
+var (
+	ErrInvalidNonce = errors.New("rfc5090: invalid nonce")
+	ErrStaleNonce   = errors.New("rfc5090: stale nonce")
+)
+
+// ErrMissingMessageAuthenticator is returned by Verifier.Handle for requests
+// without a Message-Authenticator, which must be silently discarded.
+var ErrMissingMessageAuthenticator = errors.New("rfc5090: missing Message-Authenticator")
+
+const nonceLength = 8 + 8 + 16
+
+func (v *Verifier) init() {
+	v.initOnce.Do(func() {
+		v.key = v.NonceKey
+		if v.key == nil {
+			v.key = make([]byte, 32)
+			if _, err := rand.Read(v.key); err != nil {
+				panic(err)
+			}
+		}
+	})
+}
+
+// NewNonce returns a new nonce.
+func (v *Verifier) NewNonce() (string, error) {
+	v.init()
+	b := make([]byte, nonceLength)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(b[8:16]); err != nil {
+		return "", err
+	}
+	copy(b[16:], v.nonceMAC(b[:16]))
+	return hex.EncodeToString(b), nil
+}
+
+// CheckNonce reports whether nonce was issued by v and has not expired.
+func (v *Verifier) CheckNonce(nonce string) error {
+	v.init()
+	b, err := hex.DecodeString(nonce)
+	if err != nil || len(b) != nonceLength || !hmac.Equal(b[16:], v.nonceMAC(b[:16])) {
+		return ErrInvalidNonce
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	if time.Since(issued) > v.nonceTimeout() {
+		return ErrStaleNonce
+	}
+	return nil
+}
+
+func (v *Verifier) nonceTimeout() time.Duration {
+	if v.NonceTimeout > 0 {
+		return v.NonceTimeout
+	}
+	return 5 * time.Minute
+}
+
+// useNonceCount records the nonce count nc of nonce, and reports whether it
+// is higher than the counts previously recorded for nonce - rfc2617, 3.2.2
+func (v *Verifier) useNonceCount(nonce, nc string) bool {
+	count, err := strconv.ParseUint(nc, 16, 32)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	timeout := v.nonceTimeout()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]*nonceCount)
+	}
+	if now.Sub(v.lastPrune) > timeout {
+		for n, c := range v.counts {
+			if now.After(c.expires) {
+				delete(v.counts, n)
+			}
+		}
+		v.lastPrune = now
+	}
+	c := v.counts[nonce]
+	if c == nil {
+		// the nonce expires at most timeout after now
+		c = &nonceCount{
+			expires: now.Add(timeout),
+		}
+		v.counts[nonce] = c
+	} else if count <= c.count {
+		return false
+	}
+	c.count = count
+	return true
+}
+
+func (v *Verifier) nonceMAC(b []byte) []byte {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write(b)
+	return mac.Sum(nil)[:16]
+}
+
+// ServeRADIUS implements radius.Handler. Requests that cannot be handled are
+// discarded, and the error is logged.
+func (v *Verifier) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	response, err := v.Handle(r)
+	if err != nil {
+		v.logf("rfc5090: discarding request from %v: %v", r.RemoteAddr, err)
+		return
+	}
+	if err := w.Write(response); err != nil {
+		v.logf("rfc5090: %v", err)
+	}
+}
+
+// Handle returns the signed response to the Access-Request r:
+//
+//   - an Access-Challenge carrying a new nonce if r carries no
+//     Digest-Response, or a response to an expired nonce;
+//   - an Access-Accept carrying Digest-Response-Auth if the Digest-Response
+//     is valid, and uses an offered qop and algorithm and a new nonce count;
+//   - an Access-Reject otherwise.
+//
+// An error is returned if the request must be silently discarded: it is not
+// an Access-Request, or its Message-Authenticator is missing or invalid.
+func (v *Verifier) Handle(r *radius.Request) (*radius.Packet, error) {
+	if r.Code != radius.CodeAccessRequest {
+		return nil, errors.New("rfc5090: not an Access-Request")
+	}
+	if err := rfc2869.MessageAuthenticator_Verify(r.Packet, nil); err != nil {
+		if err == radius.ErrNoAttribute {
+			return nil, ErrMissingMessageAuthenticator
+		}
+		return nil, err
+	}
+	if response := v.retransmitted(r); response != nil {
+		return response, nil
+	}
+	response, err := v.handle(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := rfc2869.MessageAuthenticator_Sign(response); err != nil {
+		return nil, err
+	}
+	if response.Code == radius.CodeAccessAccept {
+		v.accepted(r, response)
+	}
+	return response, nil
+}
+
+// retransmitted returns the Access-Accept sent for r if r is a
+// retransmission of an accepted request, or nil.
+func (v *Verifier) retransmitted(r *radius.Request) *radius.Packet {
+	nonce, err := DigestNonce_LookupString(r.Packet)
+	if err != nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c := v.counts[nonce]
+	if c == nil || c.response == nil || c.identifier != r.Identifier || c.authenticator != r.Authenticator {
+		return nil
+	}
+	return c.response
+}
+
+// accepted records response as the Access-Accept of r, whose nonce count
+// has been recorded by useNonceCount.
+func (v *Verifier) accepted(r *radius.Request, response *radius.Packet) {
+	nonce := DigestNonce_GetString(r.Packet)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c := v.counts[nonce]; c != nil {
+		c.identifier = r.Identifier
+		c.authenticator = r.Authenticator
+		c.response = response
+	}
+}
+
+func (v *Verifier) handle(r *radius.Request) (*radius.Packet, error) {
+	d, err := LookupDigest(r.Packet)
+	if err == radius.ErrNoAttribute {
+		return v.challenge(r, false)
+	}
+	if err != nil || d.Realm != v.Realm || !v.offered(d) {
+		return r.Response(radius.CodeAccessReject), nil
+	}
+	switch err := v.CheckNonce(d.Nonce); err {
+	case nil:
+	case ErrStaleNonce:
+		return v.challenge(r, true)
+	default:
+		return r.Response(radius.CodeAccessReject), nil
+	}
+
+	var ha1 string
+	if v.HA1 != nil {
+		if ha1, err = v.HA1(r, d.Username, d.Realm); err != nil {
+			return nil, err
+		}
+	} else if v.Password != nil {
+		password, err := v.Password(r, d.Username, d.Realm)
+		if err != nil {
+			return nil, err
+		}
+		if password != nil {
+			ha1 = d.HA1(string(password))
+		}
+	}
+	if ha1 == "" || !d.VerifyHA1(ha1) || !v.useNonceCount(d.Nonce, d.NonceCount) {
+		return r.Response(radius.CodeAccessReject), nil
+	}
+
+	response := r.Response(radius.CodeAccessAccept)
+	if err := DigestResponseAuth_SetString(response, d.ResponseAuth(ha1)); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// offered reports whether the qop and algorithm of d were offered in the
+// challenges of v. As a qop is always offered, d must use one.
+func (v *Verifier) offered(d *Digest) bool {
+	algorithm := d.Algorithm
+	if algorithm == "" {
+		// rfc2617, 3.2.1
+		algorithm = AlgorithmMD5
+	}
+	if algorithm != v.algorithm() {
+		return false
+	}
+	for _, qop := range v.qop() {
+		if d.Qop == qop {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) qop() []string {
+	if len(v.Qop) > 0 {
+		return v.Qop
+	}
+	return []string{QopAuth}
+}
+
+func (v *Verifier) algorithm() string {
+	if v.Algorithm != "" {
+		return v.Algorithm
+	}
+	return AlgorithmMD5
+}
+
+// challenge returns an Access-Challenge carrying a new nonce - rfc5090, 2.1.1
+func (v *Verifier) challenge(r *radius.Request, stale bool) (*radius.Packet, error) {
+	nonce, err := v.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	response := r.Response(radius.CodeAccessChallenge)
+	if err := DigestNonce_SetString(response, nonce); err != nil {
+		return nil, err
+	}
+	if err := DigestRealm_SetString(response, v.Realm); err != nil {
+		return nil, err
+	}
+	for _, q := range v.qop() {
+		if err := DigestQop_AddString(response, q); err != nil {
+			return nil, err
+		}
+	}
+	if err := DigestAlgorithm_SetString(response, v.algorithm()); err != nil {
+		return nil, err
+	}
+	if stale {
+		if err := DigestStale_SetString(response, "true"); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func (v *Verifier) logf(format string, args ...interface{}) {
+	if l := v.ErrorLog; l != nil {
+		l.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,254 @@
+package rfc5090
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+)
+
+func testVerifier() *Verifier {
+	return &Verifier{
+		Realm: "sip.example.com",
+		Qop:   []string{QopAuth, QopAuthInt},
+		Password: func(r *radius.Request, username, realm string) ([]byte, error) {
+			if username != "tim" {
+				return nil, nil
+			}
+			return []byte("secret"), nil
+		},
+	}
+}
+
+// request returns the request of p, signed with a Message-Authenticator.
+func request(t *testing.T, p *radius.Packet) *radius.Request {
+	t.Helper()
+
+	if err := rfc2869.MessageAuthenticator_Sign(p); err != nil {
+		t.Fatal(err)
+	}
+	return &radius.Request{
+		LocalAddr:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1812},
+		RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000},
+		Packet:     p,
+	}
+}
+
+// digest returns the Digest-* attributes answering nonce, as a SIP proxy
+// would send them.
+func digest(v *Verifier, nonce, username string) *Digest {
+	return &Digest{
+		Realm:      v.Realm,
+		Nonce:      nonce,
+		Method:     "REGISTER",
+		URI:        "sip:sip.example.com",
+		Qop:        QopAuth,
+		CNonce:     "0a4f113b",
+		NonceCount: "00000001",
+		Username:   username,
+	}
+}
+
+// send sends the Digest-Response of d computed from password.
+func send(t *testing.T, v *Verifier, d *Digest, password string) *radius.Packet {
+	t.Helper()
+
+	response := handle(t, v, digestRequest(d, password))
+	if response.Code == radius.CodeAccessAccept {
+		if DigestResponseAuth_GetString(response) != d.ResponseAuth(d.HA1(password)) {
+			t.Fatal("invalid Digest-Response-Auth")
+		}
+	}
+	return response
+}
+
+// digestRequest returns an Access-Request carrying the Digest-Response of d
+// computed from password.
+func digestRequest(d *Digest, password string) *radius.Packet {
+	d.Response = d.response(d.sessionHA1(d.HA1(password)), d.Method)
+
+	p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	DigestResponse_SetString(p, d.Response)
+	DigestRealm_SetString(p, d.Realm)
+	DigestNonce_SetString(p, d.Nonce)
+	DigestMethod_SetString(p, d.Method)
+	DigestURI_SetString(p, d.URI)
+	if d.Qop != "" {
+		DigestQop_SetString(p, d.Qop)
+		DigestNonceCount_SetString(p, d.NonceCount)
+	}
+	if d.Algorithm != "" {
+		DigestAlgorithm_SetString(p, d.Algorithm)
+	}
+	DigestCNonce_SetString(p, d.CNonce)
+	DigestUsername_SetString(p, d.Username)
+	return p
+}
+
+// handle returns the response of v to the Access-Request p.
+func handle(t *testing.T, v *Verifier, p *radius.Packet) *radius.Packet {
+	t.Helper()
+
+	response, err := v.Handle(request(t, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rfc2869.MessageAuthenticator_Verify(response, p); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// authenticate answers nonce with the credentials of username.
+func authenticate(t *testing.T, v *Verifier, nonce, username, password string) *radius.Packet {
+	t.Helper()
+
+	return send(t, v, digest(v, nonce, username), password)
+}
+
+func challenge(t *testing.T, v *Verifier) *radius.Packet {
+	t.Helper()
+
+	p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	DigestMethod_SetString(p, "REGISTER")
+	DigestURI_SetString(p, "sip:sip.example.com")
+	response, err := v.Handle(request(t, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessChallenge {
+		t.Fatalf("got %v", response.Code)
+	}
+	return response
+}
+
+func TestVerifier(t *testing.T) {
+	v := testVerifier()
+	response := challenge(t, v)
+	if DigestRealm_GetString(response) != "sip.example.com" || DigestAlgorithm_GetString(response) != AlgorithmMD5 {
+		t.Fatal("missing challenge attributes")
+	}
+	if qop, _ := DigestQop_GetStrings(response); len(qop) != 2 {
+		t.Fatalf("got Digest-Qop %v", qop)
+	}
+	nonce := DigestNonce_GetString(response)
+
+	if response := authenticate(t, v, nonce, "tim", "secret"); response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %v", response.Code)
+	}
+	if response := authenticate(t, v, nonce, "tim", "wrong"); response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v", response.Code)
+	}
+	if response := authenticate(t, v, nonce, "unknown", "secret"); response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v", response.Code)
+	}
+	if response := authenticate(t, v, "dcd98b7102dd2f0e8b11d0f600bfb0c093", "tim", "secret"); response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v for foreign nonce", response.Code)
+	}
+}
+
+func TestVerifier_staleNonce(t *testing.T) {
+	v := testVerifier()
+	v.NonceTimeout = time.Nanosecond
+	nonce := DigestNonce_GetString(challenge(t, v))
+	time.Sleep(time.Millisecond)
+
+	response := authenticate(t, v, nonce, "tim", "secret")
+	if response.Code != radius.CodeAccessChallenge || DigestStale_GetString(response) != "true" {
+		t.Fatalf("got %v", response.Code)
+	}
+	if DigestNonce_GetString(response) == nonce {
+		t.Fatal("expected new nonce")
+	}
+}
+
+func TestVerifier_HA1(t *testing.T) {
+	v := testVerifier()
+	v.Password = nil
+	v.HA1 = func(r *radius.Request, username, realm string) (string, error) {
+		d := &Digest{Username: username, Realm: realm}
+		return d.HA1("secret"), nil
+	}
+	nonce := DigestNonce_GetString(challenge(t, v))
+	if response := authenticate(t, v, nonce, "tim", "secret"); response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %v", response.Code)
+	}
+}
+
+func TestVerifier_replay(t *testing.T) {
+	v := testVerifier()
+	nonce := DigestNonce_GetString(challenge(t, v))
+
+	d := digest(v, nonce, "tim")
+	p := digestRequest(d, "secret")
+	accept := handle(t, v, p)
+	if accept.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %v", accept.Code)
+	}
+	// a retransmission is answered with the same Access-Accept
+	if response := handle(t, v, p); response != accept {
+		t.Fatalf("got %v for retransmitted request", response.Code)
+	}
+	if response := send(t, v, d, "secret"); response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v for replayed response", response.Code)
+	}
+	d.NonceCount = "00000002"
+	if response := send(t, v, d, "secret"); response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %v", response.Code)
+	}
+	d.NonceCount = "00000001"
+	if response := send(t, v, d, "secret"); response.Code != radius.CodeAccessReject {
+		t.Fatalf("got %v for decreasing nonce count", response.Code)
+	}
+}
+
+func TestVerifier_notOffered(t *testing.T) {
+	v := testVerifier()
+	v.Qop = []string{QopAuth}
+	nonce := DigestNonce_GetString(challenge(t, v))
+
+	tests := []struct {
+		Qop       string
+		Algorithm string
+	}{
+		{"", ""},
+		{QopAuthInt, ""},
+		{QopAuth, AlgorithmMD5Sess},
+	}
+	for _, tt := range tests {
+		d := digest(v, nonce, "tim")
+		d.Qop = tt.Qop
+		d.Algorithm = tt.Algorithm
+		d.EntityBodyHash = h("")
+		if response := send(t, v, d, "secret"); response.Code != radius.CodeAccessReject {
+			t.Fatalf("qop %q, algorithm %q: got %v", tt.Qop, tt.Algorithm, response.Code)
+		}
+	}
+
+	d := digest(v, nonce, "tim")
+	d.Algorithm = AlgorithmMD5
+	if response := send(t, v, d, "secret"); response.Code != radius.CodeAccessAccept {
+		t.Fatalf("got %v", response.Code)
+	}
+}
+
+func TestVerifier_messageAuthenticator(t *testing.T) {
+	v := testVerifier()
+	p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	DigestMethod_SetString(p, "REGISTER")
+	DigestURI_SetString(p, "sip:sip.example.com")
+	r := &radius.Request{
+		Packet: p,
+	}
+	if _, err := v.Handle(r); err != ErrMissingMessageAuthenticator {
+		t.Fatalf("got error %v", err)
+	}
+
+	r = request(t, p)
+	DigestURI_SetString(p, "sip:other.example.com")
+	if _, err := v.Handle(r); err == nil {
+		t.Fatal("expected error for invalid Message-Authenticator")
+	}
+}
@@ -0,0 +1,277 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+)
+
+// Datagram is a UDP datagram of a capture file.
+type Datagram struct {
+	Time    time.Time
+	Src     *net.UDPAddr
+	Dst     *net.UDPAddr
+	Payload []byte
+}
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+
+	protocolUDP = 17
+)
+
+// fragmentTimeout is the time after which incomplete fragmented IP packets
+// are discarded.
+const fragmentTimeout = 30 * time.Second
+
+// decoder decodes the UDP datagrams of frames, reassembling fragmented IP
+// packets.
+type decoder struct {
+	fragments map[string]*fragmentedPacket
+}
+
+type fragmentedPacket struct {
+	first     time.Time
+	fragments []fragment
+	// total is the length of the payload, or -1 if the last fragment has
+	// not been seen.
+	total int
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// decode returns the UDP datagram of f, or nil if f does not contain a
+// complete one.
+func (d *decoder) decode(f *frame) *Datagram {
+	b := f.Data
+	var etherType uint16
+	switch f.LinkType {
+	case linkTypeEthernet:
+		if len(b) < 14 {
+			return nil
+		}
+		etherType = binary.BigEndian.Uint16(b[12:14])
+		b = b[14:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(b) < 4 {
+				return nil
+			}
+			etherType = binary.BigEndian.Uint16(b[2:4])
+			b = b[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(b) < 16 {
+			return nil
+		}
+		etherType = binary.BigEndian.Uint16(b[14:16])
+		b = b[16:]
+	case linkTypeSLL2:
+		if len(b) < 20 {
+			return nil
+		}
+		etherType = binary.BigEndian.Uint16(b[0:2])
+		b = b[20:]
+	case linkTypeNull, linkTypeLoop:
+		// the address family is in the byte order of the capturing host, so
+		// the IP version is used instead
+		if len(b) < 4 {
+			return nil
+		}
+		b = b[4:]
+		etherType = ipEtherType(b)
+	case linkTypeRaw:
+		etherType = ipEtherType(b)
+	case linkTypeIPv4:
+		etherType = etherTypeIPv4
+	case linkTypeIPv6:
+		etherType = etherTypeIPv6
+	default:
+		return nil
+	}
+
+	switch etherType {
+	case etherTypeIPv4:
+		return d.decodeIPv4(f.Time, b)
+	case etherTypeIPv6:
+		return d.decodeIPv6(f.Time, b)
+	}
+	return nil
+}
+
+func ipEtherType(b []byte) uint16 {
+	if len(b) == 0 {
+		return 0
+	}
+	switch b[0] >> 4 {
+	case 4:
+		return etherTypeIPv4
+	case 6:
+		return etherTypeIPv6
+	}
+	return 0
+}
+
+func (d *decoder) decodeIPv4(t time.Time, b []byte) *Datagram {
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return nil
+	}
+	headerLen := int(b[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(b[2:4]))
+	if headerLen < 20 || totalLen < headerLen || totalLen > len(b) {
+		return nil
+	}
+	if b[9] != protocolUDP {
+		return nil
+	}
+	src, dst := net.IP(b[12:16]), net.IP(b[16:20])
+	payload := b[headerLen:totalLen]
+
+	flags := binary.BigEndian.Uint16(b[6:8])
+	moreFragments := flags&0x2000 != 0
+	offset := int(flags&0x1fff) * 8
+	if moreFragments || offset > 0 {
+		key := "4" + string(src) + string(dst) + string(b[4:6])
+		if payload = d.reassemble(t, key, offset, moreFragments, payload); payload == nil {
+			return nil
+		}
+	}
+	return decodeUDP(t, src, dst, payload)
+}
+
+func (d *decoder) decodeIPv6(t time.Time, b []byte) *Datagram {
+	if len(b) < 40 || b[0]>>4 != 6 {
+		return nil
+	}
+	payloadLen := int(binary.BigEndian.Uint16(b[4:6]))
+	if 40+payloadLen > len(b) {
+		return nil
+	}
+	src, dst := net.IP(b[8:24]), net.IP(b[24:40])
+	next := b[6]
+	payload := b[40 : 40+payloadLen]
+
+	fragmented := false
+	var offset int
+	var moreFragments bool
+	var id []byte
+	for next != protocolUDP {
+		switch next {
+		case 0, 43, 60: // Hop-by-Hop, Routing and Destination Options
+			if len(payload) < 8 {
+				return nil
+			}
+			length := 8 + int(payload[1])*8
+			if length > len(payload) {
+				return nil
+			}
+			next = payload[0]
+			payload = payload[length:]
+		case 44: // Fragment
+			if len(payload) < 8 {
+				return nil
+			}
+			fragmented = true
+			next = payload[0]
+			fragmentOffset := binary.BigEndian.Uint16(payload[2:4])
+			offset = int(fragmentOffset>>3) * 8
+			moreFragments = fragmentOffset&1 != 0
+			id = payload[4:8]
+			payload = payload[8:]
+		default:
+			return nil
+		}
+	}
+	if fragmented {
+		key := "6" + string(src) + string(dst) + string(id)
+		if payload = d.reassemble(t, key, offset, moreFragments, payload); payload == nil {
+			return nil
+		}
+	}
+	return decodeUDP(t, src, dst, payload)
+}
+
+// reassemble adds a fragment of the IP packet identified by key, and returns
+// the payload of the packet once all of its fragments have been seen.
+func (d *decoder) reassemble(t time.Time, key string, offset int, moreFragments bool, data []byte) []byte {
+	if d.fragments == nil {
+		d.fragments = make(map[string]*fragmentedPacket)
+	}
+	for k, p := range d.fragments {
+		if t.Sub(p.first) > fragmentTimeout {
+			delete(d.fragments, k)
+		}
+	}
+
+	p := d.fragments[key]
+	if p == nil {
+		p = &fragmentedPacket{
+			first: t,
+			total: -1,
+		}
+		d.fragments[key] = p
+	}
+	p.fragments = append(p.fragments, fragment{
+		offset: offset,
+		data:   append([]byte(nil), data...),
+	})
+	if !moreFragments {
+		p.total = offset + len(data)
+	}
+	if p.total < 0 {
+		return nil
+	}
+
+	sort.Slice(p.fragments, func(i, j int) bool {
+		return p.fragments[i].offset < p.fragments[j].offset
+	})
+	payload := make([]byte, p.total)
+	end := 0
+	for _, f := range p.fragments {
+		if f.offset > end {
+			// missing fragment
+			return nil
+		}
+		if f.offset+len(f.data) > p.total {
+			delete(d.fragments, key)
+			return nil
+		}
+		copy(payload[f.offset:], f.data)
+		if f.offset+len(f.data) > end {
+			end = f.offset + len(f.data)
+		}
+	}
+	if end < p.total {
+		return nil
+	}
+	delete(d.fragments, key)
+	return payload
+}
+
+func decodeUDP(t time.Time, src, dst net.IP, b []byte) *Datagram {
+	if len(b) < 8 {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(b[4:6]))
+	if length < 8 || length > len(b) {
+		return nil
+	}
+	return &Datagram{
+		Time: t,
+		Src: &net.UDPAddr{
+			IP:   append(net.IP(nil), src...),
+			Port: int(binary.BigEndian.Uint16(b[0:2])),
+		},
+		Dst: &net.UDPAddr{
+			IP:   append(net.IP(nil), dst...),
+			Port: int(binary.BigEndian.Uint16(b[2:4])),
+		},
+		Payload: b[8:length],
+	}
+}
@@ -0,0 +1,257 @@
+package sniff
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"layeh.com/radius"
+)
+
+// DefaultTimeout is the time after which requests are considered
+// unanswered if Matcher.Timeout is zero.
+const DefaultTimeout = 30 * time.Second
+
+// Message is a RADIUS packet of a capture file.
+type Message struct {
+	*Datagram
+	Packet *radius.Packet
+}
+
+// Exchange is a request and its response.
+type Exchange struct {
+	// Request is nil if the response could not be matched to a request.
+	Request *Message
+	// Response is nil if the request was not answered.
+	Response *Message
+
+	// Retransmissions is the number of times the request was retransmitted.
+	Retransmissions int
+
+	// Verified is true if the authenticators of the request and response
+	// were verified using a secret of the Matcher's SecretSource, in which
+	// case Authentic reports if they are correct.
+	Verified  bool
+	Authentic bool
+}
+
+// RTT returns the time between the request and its response, or zero if the
+// exchange does not have both.
+func (e *Exchange) RTT() time.Duration {
+	if e.Request == nil || e.Response == nil {
+		return 0
+	}
+	return e.Response.Time.Sub(e.Request.Time)
+}
+
+// Matcher matches RADIUS requests to responses by their addresses and
+// Identifier.
+type Matcher struct {
+	// SecretSource supplies the secrets used to verify the authenticators of
+	// packets. It is called with the address of the client that sent the
+	// request. Authenticators are not verified if SecretSource is nil or
+	// returns an empty secret.
+	SecretSource radius.SecretSource
+
+	// Timeout is the capture time after which requests without a response
+	// are considered unanswered. DefaultTimeout is used if zero.
+	Timeout time.Duration
+
+	pending map[exchangeKey]*Exchange
+	// queue contains the pending exchanges in the order of their requests
+	queue []*Exchange
+}
+
+type exchangeKey struct {
+	client, server string
+	identifier     byte
+}
+
+// isRequest returns if packets of code are sent by clients.
+func isRequest(code radius.Code) bool {
+	switch code {
+	case radius.CodeAccessRequest, radius.CodeAccountingRequest, radius.CodeStatusServer,
+		radius.CodeDisconnectRequest, radius.CodeCoARequest:
+		return true
+	}
+	return false
+}
+
+// Add adds the RADIUS packet of d to the matcher. It returns the exchanges
+// that are complete: the exchange of a response, and the exchanges of
+// requests that have timed out or whose Identifier has been reused.
+//
+// An error is returned if d is not a RADIUS packet.
+func (m *Matcher) Add(d *Datagram) ([]*Exchange, error) {
+	if m.pending == nil {
+		m.pending = make(map[exchangeKey]*Exchange)
+	}
+	done := m.expire(d.Time)
+
+	p, err := radius.Parse(d.Payload, nil)
+	if err != nil {
+		return done, err
+	}
+	msg := &Message{
+		Datagram: d,
+		Packet:   p,
+	}
+
+	if isRequest(p.Code) {
+		key := exchangeKey{d.Src.String(), d.Dst.String(), p.Identifier}
+		if e := m.pending[key]; e != nil {
+			if bytes.Equal(e.Request.Payload, d.Payload) {
+				e.Retransmissions++
+				return done, nil
+			}
+			// the identifier has been reused for a new request
+			delete(m.pending, key)
+			done = append(done, e)
+		}
+		p.Secret = m.secret(d.Src)
+		e := &Exchange{
+			Request: msg,
+		}
+		m.pending[key] = e
+		m.queue = append(m.queue, e)
+		return done, nil
+	}
+
+	key := exchangeKey{d.Dst.String(), d.Src.String(), p.Identifier}
+	e := m.pending[key]
+	if e == nil {
+		return append(done, &Exchange{Response: msg}), nil
+	}
+	delete(m.pending, key)
+	e.Response = msg
+	p.Secret = e.Request.Packet.Secret
+	if secret := p.Secret; len(secret) > 0 {
+		e.Verified = true
+		e.Authentic = radius.IsAuthenticRequest(e.Request.Payload, secret) &&
+			radius.IsAuthenticResponse(d.Payload, e.Request.Payload, secret)
+	}
+	return append(done, e), nil
+}
+
+// Flush returns the exchanges of all pending requests, which are considered
+// unanswered.
+func (m *Matcher) Flush() []*Exchange {
+	var done []*Exchange
+	for _, e := range m.queue {
+		if e.Response == nil && m.pending[m.key(e)] == e {
+			done = append(done, e)
+		}
+	}
+	m.pending = nil
+	m.queue = nil
+	return done
+}
+
+// expire returns the exchanges of the requests that have timed out at t.
+func (m *Matcher) expire(t time.Time) []*Exchange {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	var done []*Exchange
+	i := 0
+	for ; i < len(m.queue); i++ {
+		e := m.queue[i]
+		if t.Sub(e.Request.Time) < timeout {
+			break
+		}
+		if key := m.key(e); e.Response == nil && m.pending[key] == e {
+			delete(m.pending, key)
+			done = append(done, e)
+		}
+	}
+	m.queue = m.queue[i:]
+	return done
+}
+
+func (m *Matcher) key(e *Exchange) exchangeKey {
+	return exchangeKey{e.Request.Src.String(), e.Request.Dst.String(), e.Request.Packet.Identifier}
+}
+
+func (m *Matcher) secret(client net.Addr) []byte {
+	if m.SecretSource == nil {
+		return nil
+	}
+	secret, err := m.SecretSource.RADIUSSecret(context.Background(), client)
+	if err != nil {
+		return nil
+	}
+	return secret
+}
+
+// Stats are statistics of exchanges.
+type Stats struct {
+	Requests        int
+	Responses       int
+	Retransmissions int
+	// Unanswered is the number of requests without a response.
+	Unanswered int
+	// Unmatched is the number of responses without a request.
+	Unmatched int
+	// NotAuthentic is the number of verified exchanges whose authenticators
+	// are incorrect.
+	NotAuthentic int
+	// Codes is the number of packets of each code.
+	Codes map[radius.Code]int
+
+	rtts   []time.Duration
+	sorted bool
+}
+
+// Add adds e to the statistics.
+func (s *Stats) Add(e *Exchange) {
+	if s.Codes == nil {
+		s.Codes = make(map[radius.Code]int)
+	}
+	if e.Request != nil {
+		s.Requests++
+		s.Codes[e.Request.Packet.Code]++
+		s.Retransmissions += e.Retransmissions
+	}
+	if e.Response != nil {
+		s.Responses++
+		s.Codes[e.Response.Packet.Code]++
+	}
+	switch {
+	case e.Request == nil:
+		s.Unmatched++
+	case e.Response == nil:
+		s.Unanswered++
+	default:
+		s.rtts = append(s.rtts, e.RTT())
+		s.sorted = false
+	}
+	if e.Verified && !e.Authentic {
+		s.NotAuthentic++
+	}
+}
+
+// RTT returns the q-th quantile, between 0 and 1, of the response times of
+// the exchanges, or zero if none have been answered.
+func (s *Stats) RTT(q float64) time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	if !s.sorted {
+		sort.Slice(s.rtts, func(i, j int) bool {
+			return s.rtts[i] < s.rtts[j]
+		})
+		s.sorted = true
+	}
+	i := int(math.Ceil(q*float64(len(s.rtts)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.rtts) {
+		i = len(s.rtts) - 1
+	}
+	return s.rtts[i]
+}
@@ -0,0 +1,319 @@
+// Package sniff reads RADIUS packets from pcap and pcapng capture files, and
+// matches requests to their responses.
+//
+// API is currently unstable.
+package sniff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// Link types of captured frames.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// ErrFormat is returned when the capture file is not in the pcap or pcapng
+// format.
+var ErrFormat = errors.New("sniff: unknown capture file format")
+
+// frame is a captured link layer frame.
+type frame struct {
+	Time     time.Time
+	LinkType uint16
+	Data     []byte
+}
+
+// frameReader reads the frames of a capture file.
+type frameReader interface {
+	next() (*frame, error)
+}
+
+// newFrameReader returns the frame reader of the file format of r.
+func newFrameReader(r io.Reader) (frameReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrFormat
+		}
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(magic) == 0x0a0d0d0a {
+		return &pcapngReader{r: br}, nil
+	}
+	return newPcapReader(br)
+}
+
+// pcapReader reads classic pcap files.
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint16
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, ErrFormat
+	}
+	pr := &pcapReader{
+		r: r,
+	}
+	switch magic := binary.LittleEndian.Uint32(header[:4]); magic {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		pr.order = binary.LittleEndian
+		pr.nano = magic == 0xa1b23c4d
+	default:
+		switch binary.BigEndian.Uint32(header[:4]) {
+		case 0xa1b2c3d4:
+			pr.order = binary.BigEndian
+		case 0xa1b23c4d:
+			pr.order = binary.BigEndian
+			pr.nano = true
+		default:
+			return nil, ErrFormat
+		}
+	}
+	pr.linkType = uint16(pr.order.Uint32(header[20:24]))
+	return pr, nil
+}
+
+func (pr *pcapReader) next() (*frame, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(pr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("sniff: truncated pcap record header")
+		}
+		return nil, err
+	}
+	seconds := int64(pr.order.Uint32(header[0:4]))
+	fraction := int64(pr.order.Uint32(header[4:8]))
+	length := pr.order.Uint32(header[8:12])
+	if length > 1<<18 {
+		return nil, errors.New("sniff: invalid pcap record length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		return nil, errors.New("sniff: truncated pcap record")
+	}
+	if !pr.nano {
+		fraction *= 1000
+	}
+	return &frame{
+		Time:     time.Unix(seconds, fraction),
+		LinkType: pr.linkType,
+		Data:     data,
+	}, nil
+}
+
+// pcapngReader reads pcapng files.
+type pcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+type pcapngInterface struct {
+	linkType uint16
+	// units per second of timestamps
+	resolution uint64
+}
+
+func (pr *pcapngReader) next() (*frame, error) {
+	for {
+		blockType, body, err := pr.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case 0x0a0d0d0a: // Section Header Block
+			pr.interfaces = nil
+
+		case 1: // Interface Description Block
+			if len(body) < 8 {
+				return nil, errors.New("sniff: invalid pcapng interface description block")
+			}
+			iface := pcapngInterface{
+				linkType:   pr.order.Uint16(body[0:2]),
+				resolution: 1000000,
+			}
+			pr.options(body[8:], func(code uint16, value []byte) {
+				// if_tsresol
+				if code == 9 && len(value) >= 1 {
+					exp := uint64(value[0] & 0x7f)
+					base := uint64(10)
+					if value[0]&0x80 != 0 {
+						base = 2
+					}
+					if exp <= 18 || base == 2 && exp < 64 {
+						iface.resolution = 1
+						for i := uint64(0); i < exp; i++ {
+							iface.resolution *= base
+						}
+					}
+				}
+			})
+			pr.interfaces = append(pr.interfaces, iface)
+
+		case 6: // Enhanced Packet Block
+			if len(body) < 20 {
+				return nil, errors.New("sniff: invalid pcapng enhanced packet block")
+			}
+			id := pr.order.Uint32(body[0:4])
+			if int(id) >= len(pr.interfaces) {
+				return nil, errors.New("sniff: pcapng packet of unknown interface")
+			}
+			iface := pr.interfaces[id]
+			timestamp := uint64(pr.order.Uint32(body[4:8]))<<32 | uint64(pr.order.Uint32(body[8:12]))
+			length := pr.order.Uint32(body[12:16])
+			if uint64(length) > uint64(len(body)-20) {
+				return nil, errors.New("sniff: invalid pcapng enhanced packet block")
+			}
+			return &frame{
+				Time:     pcapngTime(timestamp, iface.resolution),
+				LinkType: iface.linkType,
+				Data:     body[20 : 20+length],
+			}, nil
+
+		case 3: // Simple Packet Block
+			if len(body) < 4 || len(pr.interfaces) == 0 {
+				return nil, errors.New("sniff: invalid pcapng simple packet block")
+			}
+			length := pr.order.Uint32(body[0:4])
+			if uint64(length) > uint64(len(body)-4) {
+				length = uint32(len(body) - 4)
+			}
+			return &frame{
+				LinkType: pr.interfaces[0].linkType,
+				Data:     body[4 : 4+length],
+			}, nil
+		}
+	}
+}
+
+// readBlock reads the next block, and returns its type and body.
+func (pr *pcapngReader) readBlock() (uint32, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(pr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errors.New("sniff: truncated pcapng block header")
+		}
+		return 0, nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[0:4]) == 0x0a0d0d0a {
+		// the byte order of a section is given by its header
+		var magic [4]byte
+		if _, err := io.ReadFull(pr.r, magic[:]); err != nil {
+			return 0, nil, errors.New("sniff: truncated pcapng section header")
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic[:]) == 0x1a2b3c4d:
+			pr.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic[:]) == 0x1a2b3c4d:
+			pr.order = binary.BigEndian
+		default:
+			return 0, nil, ErrFormat
+		}
+		length := pr.order.Uint32(header[4:8])
+		if length < 28 || length%4 != 0 || length > 1<<24 {
+			return 0, nil, errors.New("sniff: invalid pcapng section header length")
+		}
+		rest := make([]byte, length-12)
+		if _, err := io.ReadFull(pr.r, rest); err != nil {
+			return 0, nil, errors.New("sniff: truncated pcapng section header")
+		}
+		return 0x0a0d0d0a, rest[:len(rest)-4], nil
+	}
+
+	if pr.order == nil {
+		return 0, nil, ErrFormat
+	}
+	blockType := pr.order.Uint32(header[0:4])
+	length := pr.order.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > 1<<24 {
+		return 0, nil, errors.New("sniff: invalid pcapng block length")
+	}
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(pr.r, body); err != nil {
+		return 0, nil, errors.New("sniff: truncated pcapng block")
+	}
+	// the body is followed by a copy of the block length
+	return blockType, body[:len(body)-4], nil
+}
+
+// options calls fn for each option in b.
+func (pr *pcapngReader) options(b []byte, fn func(code uint16, value []byte)) {
+	for len(b) >= 4 {
+		code := pr.order.Uint16(b[0:2])
+		length := int(pr.order.Uint16(b[2:4]))
+		if code == 0 || 4+length > len(b) {
+			return
+		}
+		fn(code, b[4:4+length])
+		padded := 4 + (length+3)&^3
+		if padded > len(b) {
+			return
+		}
+		b = b[padded:]
+	}
+}
+
+func pcapngTime(timestamp, resolution uint64) time.Time {
+	seconds := timestamp / resolution
+	fraction := timestamp % resolution
+	nanoseconds := uint64(float64(fraction) * (1e9 / float64(resolution)))
+	if seconds > math.MaxInt64 {
+		seconds = math.MaxInt64
+	}
+	return time.Unix(int64(seconds), int64(nanoseconds))
+}
+
+// Reader reads the UDP datagrams of a pcap or pcapng capture file. IP
+// fragments are reassembled, and frames that are not UDP are skipped.
+type Reader struct {
+	frames  frameReader
+	decoder decoder
+}
+
+// NewReader returns a Reader of the capture file r, whose format is detected
+// automatically. ErrFormat is returned if r is neither a pcap nor a pcapng
+// file.
+func NewReader(r io.Reader) (*Reader, error) {
+	frames, err := newFrameReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		frames: frames,
+	}, nil
+}
+
+// Next returns the next UDP datagram of the capture file. io.EOF is returned
+// at the end of the file.
+func (r *Reader) Next() (*Datagram, error) {
+	for {
+		f, err := r.frames.next()
+		if err != nil {
+			return nil, err
+		}
+		if d := r.decoder.decode(f); d != nil {
+			return d, nil
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package sniff_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/sniff"
+)
+
+var (
+	client = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 40000}
+	server = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 1812}
+	secret = []byte(`secret`)
+	epoch  = time.Unix(1500000000, 0)
+)
+
+// ipv4Frames returns the Ethernet frames of a UDP datagram, split into IPv4
+// fragments of at most mtu bytes of payload if mtu is non-zero.
+func ipv4Frames(src, dst *net.UDPAddr, payload []byte, mtu int) [][]byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], payload)
+	if mtu == 0 {
+		mtu = len(udp)
+	}
+
+	var frames [][]byte
+	for offset := 0; offset < len(udp); offset += mtu {
+		end := offset + mtu
+		if end > len(udp) {
+			end = len(udp)
+		}
+		frame := make([]byte, 14+20+end-offset)
+		binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+		ip := frame[14:]
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+end-offset))
+		binary.BigEndian.PutUint16(ip[4:6], 1234)
+		flags := uint16(offset / 8)
+		if end < len(udp) {
+			flags |= 0x2000
+		}
+		binary.BigEndian.PutUint16(ip[6:8], flags)
+		ip[8] = 64
+		ip[9] = 17
+		copy(ip[12:16], src.IP)
+		copy(ip[16:20], dst.IP)
+		copy(ip[20:], udp[offset:end])
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+type capturedFrame struct {
+	Time time.Time
+	Data []byte
+}
+
+func writePcap(frames []capturedFrame) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], 1)
+	b.Write(header)
+	for _, f := range frames {
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(f.Time.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(f.Time.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(f.Data)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(f.Data)))
+		b.Write(record)
+		b.Write(f.Data)
+	}
+	return b.Bytes()
+}
+
+func writePcapng(frames []capturedFrame) []byte {
+	var b bytes.Buffer
+	block := func(blockType uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		length := uint32(12 + len(body))
+		binary.Write(&b, binary.BigEndian, blockType)
+		binary.Write(&b, binary.BigEndian, length)
+		b.Write(body)
+		binary.Write(&b, binary.BigEndian, length)
+	}
+
+	// section header, followed by an interface with nanosecond timestamps
+	block(0x0a0d0d0a, []byte{0x1a, 0x2b, 0x3c, 0x4d, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	block(1, []byte{0, 1, 0, 0, 0, 0, 0xff, 0xff, 0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0})
+	for _, f := range frames {
+		body := make([]byte, 20+len(f.Data))
+		timestamp := uint64(f.Time.UnixNano())
+		binary.BigEndian.PutUint32(body[4:8], uint32(timestamp>>32))
+		binary.BigEndian.PutUint32(body[8:12], uint32(timestamp))
+		binary.BigEndian.PutUint32(body[12:16], uint32(len(f.Data)))
+		binary.BigEndian.PutUint32(body[16:20], uint32(len(f.Data)))
+		copy(body[20:], f.Data)
+		block(6, body)
+	}
+	return b.Bytes()
+}
+
+// capture returns the frames of an answered Access-Request with a
+// retransmission and a fragmented Access-Accept, an unanswered
+// Accounting-Request, and a response that does not match a request.
+func capture(t *testing.T) []capturedFrame {
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Identifier = 1
+	rfc2865.UserName_SetString(request, "tim")
+	requestWire, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := request.Response(radius.CodeAccessAccept)
+	rfc2865.ReplyMessage_SetString(response, string(bytes.Repeat([]byte("x"), 200)))
+	responseWire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounting := radius.New(radius.CodeAccountingRequest, secret)
+	accounting.Identifier = 2
+	accountingWire, _ := accounting.Encode()
+
+	stray := radius.New(radius.CodeAccessReject, secret)
+	stray.Identifier = 9
+	strayWire, _ := stray.Encode()
+
+	var frames []capturedFrame
+	add := func(d time.Duration, src, dst *net.UDPAddr, payload []byte, mtu int) {
+		for _, frame := range ipv4Frames(src, dst, payload, mtu) {
+			frames = append(frames, capturedFrame{epoch.Add(d), frame})
+		}
+	}
+	add(0, client, server, requestWire, 0)
+	add(time.Millisecond, client, server, accountingWire, 0)
+	add(2*time.Millisecond, client, server, requestWire, 0)
+	add(5*time.Millisecond, server, client, responseWire, 104)
+	add(6*time.Millisecond, server, client, strayWire, 0)
+	return frames
+}
+
+func TestMatcher(t *testing.T) {
+	for name, write := range map[string]func([]capturedFrame) []byte{
+		"pcap":   writePcap,
+		"pcapng": writePcapng,
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := sniff.NewReader(bytes.NewReader(write(capture(t))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &sniff.Matcher{
+				SecretSource: radius.StaticSecretSource(secret),
+			}
+			var exchanges []*sniff.Exchange
+			for {
+				d, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				done, err := m.Add(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				exchanges = append(exchanges, done...)
+			}
+			exchanges = append(exchanges, m.Flush()...)
+
+			if len(exchanges) != 3 {
+				t.Fatalf("got %d exchanges", len(exchanges))
+			}
+			e := exchanges[0]
+			if e.Request.Packet.Code != radius.CodeAccessRequest || e.Response.Packet.Code != radius.CodeAccessAccept {
+				t.Fatalf("got first exchange %v", e)
+			}
+			if e.RTT() != 5*time.Millisecond || e.Retransmissions != 1 || !e.Verified || !e.Authentic {
+				t.Fatalf("got RTT %v, %d retransmissions, verified %v, authentic %v", e.RTT(), e.Retransmissions, e.Verified, e.Authentic)
+			}
+			if rfc2865.UserName_GetString(e.Request.Packet) != "tim" || !e.Request.Src.IP.Equal(client.IP) || e.Request.Dst.Port != 1812 {
+				t.Fatalf("got request %v from %v to %v", e.Request.Packet.Attributes, e.Request.Src, e.Request.Dst)
+			}
+			if e := exchanges[1]; e.Request != nil || e.Response.Packet.Code != radius.CodeAccessReject {
+				t.Fatalf("got unmatched exchange %v", e)
+			}
+			if e := exchanges[2]; e.Response != nil || e.Request.Packet.Code != radius.CodeAccountingRequest {
+				t.Fatalf("got unanswered exchange %v", e)
+			}
+
+			var stats sniff.Stats
+			for _, e := range exchanges {
+				stats.Add(e)
+			}
+			if stats.Requests != 2 || stats.Responses != 2 || stats.Unanswered != 1 || stats.Unmatched != 1 || stats.Retransmissions != 1 {
+				t.Fatalf("got stats %+v", stats)
+			}
+			if stats.RTT(0.5) != 5*time.Millisecond {
+				t.Fatalf("got median RTT %v", stats.RTT(0.5))
+			}
+		})
+	}
+}
+
+// stats returns the statistics of the exchanges of the capture file b.
+func stats(t *testing.T, b []byte, m *sniff.Matcher) *sniff.Stats {
+	r, err := sniff.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s sniff.Stats
+	for {
+		d, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		done, err := m.Add(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range done {
+			s.Add(e)
+		}
+	}
+	for _, e := range m.Flush() {
+		s.Add(e)
+	}
+	return &s
+}
+
+func TestMatcher_notAuthentic(t *testing.T) {
+	s := stats(t, writePcap(capture(t)), &sniff.Matcher{
+		SecretSource: radius.StaticSecretSource([]byte(`wrong`)),
+	})
+	if s.NotAuthentic != 1 || s.Responses != 2 {
+		t.Fatalf("got stats %+v", s)
+	}
+}
+
+func TestMatcher_timeout(t *testing.T) {
+	// the requests time out before the retransmission and the response
+	s := stats(t, writePcap(capture(t)), &sniff.Matcher{
+		Timeout: time.Millisecond,
+	})
+	if s.Requests != 3 || s.Unanswered != 3 || s.Unmatched != 2 || s.Retransmissions != 0 || s.NotAuthentic != 0 {
+		t.Fatalf("got stats %+v", s)
+	}
+}
+
+func TestNewReader_format(t *testing.T) {
+	if _, err := sniff.NewReader(bytes.NewReader([]byte("not a capture file at all"))); err != sniff.ErrFormat {
+		t.Fatalf("got error %v", err)
+	}
+}
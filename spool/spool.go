@@ -0,0 +1,492 @@
+// Package spool implements a durable queue of Accounting-Requests, which
+// are forwarded to a RADIUS server in the background.
+//
+// API is currently unstable.
+package spool
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2866"
+)
+
+// Default values of the fields of Spool.
+const (
+	DefaultTimeout          = 10 * time.Second
+	DefaultRetryInterval    = time.Second
+	DefaultMaxRetryInterval = time.Minute
+	DefaultConcurrency      = 8
+)
+
+// File names of spooled requests.
+const (
+	fileSuffix    = ".acct"
+	tmpSuffix     = ".tmp"
+	expiredSuffix = ".expired"
+)
+
+// fileMagic is the start of the files of spooled requests.
+var fileMagic = []byte("RSPL")
+
+// Spool is a durable queue of Accounting-Requests that are forwarded to a
+// RADIUS server.
+//
+// Requests are written to a file in Dir before Enqueue returns, and are
+// removed once the server has acknowledged them, so that requests survive
+// restarts of the process. A request may be delivered more than once if the
+// process stops between its acknowledgement and the removal of its file.
+//
+// Requests are delivered in the order in which they were enqueued for each
+// Acct-Session-Id, while requests of different sessions are delivered
+// concurrently.
+//
+// When a request is sent, its Acct-Delay-Time is increased by the time for
+// which it has been queued - rfc2866, 5.2.
+type Spool struct {
+	// Dir is the directory in which requests are stored. It must not be
+	// used by other spools.
+	Dir string
+
+	// Addr is the address of the server.
+	Addr string
+
+	// Secret is the secret shared with the server.
+	Secret []byte
+
+	// Client sends requests to the server. If nil, radius.DefaultClient is
+	// used.
+	Client *radius.Client
+
+	// Timeout is the time for which the server's response to a delivery
+	// attempt is waited for. DefaultTimeout is used if zero.
+	Timeout time.Duration
+
+	// RetryInterval is the time after which a failed delivery is retried.
+	// It is doubled after each failure of a request, up to
+	// MaxRetryInterval. DefaultRetryInterval and DefaultMaxRetryInterval are
+	// used if zero.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// MaxAge is the time after which requests that could not be delivered
+	// are given up on, and their files are renamed with the suffix
+	// ".expired". Requests are retried indefinitely if zero.
+	MaxAge time.Duration
+
+	// Concurrency is the maximum number of requests that are sent at the
+	// same time. DefaultConcurrency is used if zero.
+	Concurrency int
+
+	// ErrorLog specifies an optional logger for delivery errors. If nil,
+	// logging is done via the log package's standard logger.
+	ErrorLog *log.Logger
+
+	mu       sync.Mutex
+	loaded   bool
+	seq      uint64
+	records  []*record
+	inflight int
+	wake     chan struct{}
+}
+
+// record is a spooled request.
+type record struct {
+	seq     uint64
+	queued  time.Time
+	session string
+	wire    []byte
+
+	attempts int
+	next     time.Time
+	inflight bool
+}
+
+func (s *Spool) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Spool) path(seq uint64, suffix string) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%020d%s", seq, suffix))
+}
+
+// loadLocked reads the requests of Dir, if they have not been read yet.
+func (s *Spool) loadLocked() error {
+	if s.loaded {
+		return nil
+	}
+	if s.wake == nil {
+		s.wake = make(chan struct{}, 1)
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		seq, suffix, ok := parseName(name)
+		if !ok || info.IsDir() {
+			continue
+		}
+		// the sequence numbers of expired requests are not reused, so that
+		// their files are not overwritten
+		if seq > s.seq {
+			s.seq = seq
+		}
+		switch suffix {
+		case tmpSuffix:
+			// the request was not acknowledged to the caller of Enqueue
+			os.Remove(filepath.Join(s.Dir, name))
+		case fileSuffix:
+			r, err := readRecord(filepath.Join(s.Dir, name), seq)
+			if err != nil {
+				s.logf("spool: %v", err)
+				continue
+			}
+			s.records = append(s.records, r)
+		}
+	}
+	sort.Slice(s.records, func(i, j int) bool {
+		return s.records[i].seq < s.records[j].seq
+	})
+	s.loaded = true
+	return nil
+}
+
+// parseName returns the sequence number and suffix of the file name of a
+// spooled request.
+func parseName(name string) (seq uint64, suffix string, ok bool) {
+	for _, suffix := range []string{fileSuffix, tmpSuffix, expiredSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			seq, err := strconv.ParseUint(strings.TrimSuffix(name, suffix), 10, 64)
+			return seq, suffix, err == nil
+		}
+	}
+	return 0, "", false
+}
+
+func readRecord(path string, seq uint64) (*record, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < len(fileMagic)+8 || !bytes.Equal(b[:len(fileMagic)], fileMagic) {
+		return nil, errors.New(path + ": invalid spool file")
+	}
+	queued := int64(binary.BigEndian.Uint64(b[len(fileMagic):]))
+	return newRecord(seq, time.Unix(0, queued), b[len(fileMagic)+8:])
+}
+
+func newRecord(seq uint64, queued time.Time, wire []byte) (*record, error) {
+	p, err := radius.Parse(wire, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &record{
+		seq:     seq,
+		queued:  queued,
+		session: rfc2866.AcctSessionID_GetString(p),
+		wire:    wire,
+	}, nil
+}
+
+// Enqueue adds the Accounting-Request p to the spool. The request is stored
+// durably when Enqueue returns without error.
+func (s *Spool) Enqueue(p *radius.Packet) error {
+	if p.Code != radius.CodeAccountingRequest {
+		return errors.New("spool: packet is not an Accounting-Request")
+	}
+	wire, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if err := s.loadLocked(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.seq++
+	seq := s.seq
+	s.mu.Unlock()
+
+	r, err := newRecord(seq, time.Now(), wire)
+	if err != nil {
+		return err
+	}
+	if err := s.writeRecord(r); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	i := sort.Search(len(s.records), func(i int) bool {
+		return s.records[i].seq > seq
+	})
+	s.records = append(s.records, nil)
+	copy(s.records[i+1:], s.records[i:])
+	s.records[i] = r
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+// writeRecord writes the file of r, and syncs it to stable storage.
+func (s *Spool) writeRecord(r *record) error {
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], uint64(r.queued.UnixNano()))
+
+	tmp := s.path(r.seq, tmpSuffix)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(append(append([]byte(nil), fileMagic...), header[:]...), r.wire...))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path(r.seq, fileSuffix))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// the rename is made durable by syncing the directory, which is not
+	// supported on all platforms
+	if dir, err := os.Open(s.Dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func (s *Spool) notify() {
+	s.mu.Lock()
+	wake := s.wake
+	s.mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of requests in the spool. Requests stored in Dir by
+// a previous process are only counted once Enqueue or Run has been called.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+// Run forwards the requests of the spool to the server until ctx is
+// canceled, after which it waits for the requests being sent and returns
+// ctx.Err(). Run must not be called concurrently.
+func (s *Spool) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if err := s.loadLocked(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	wake := s.wake
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		next := s.dispatch(ctx, &wg)
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// dispatch starts sending the requests that are due and are not preceded by
+// an undelivered request of the same session. It returns the time at which
+// the next request is due, or the zero time if there is none.
+func (s *Spool) dispatch(ctx context.Context, wg *sync.WaitGroup) time.Time {
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var next time.Time
+	blocked := make(map[string]bool)
+	for _, r := range s.records {
+		if s.inflight >= concurrency {
+			break
+		}
+		if blocked[r.session] {
+			continue
+		}
+		blocked[r.session] = true
+		if r.inflight {
+			continue
+		}
+		if r.next.After(now) {
+			if next.IsZero() || r.next.Before(next) {
+				next = r.next
+			}
+			continue
+		}
+		r.inflight = true
+		s.inflight++
+		wg.Add(1)
+		go func(r *record) {
+			defer wg.Done()
+			err := s.send(ctx, r)
+			s.done(ctx, r, err)
+		}(r)
+	}
+	return next
+}
+
+// send sends the request of r and waits for its acknowledgement.
+func (s *Spool) send(ctx context.Context, r *record) error {
+	p, err := radius.Parse(r.wire, s.Secret)
+	if err != nil {
+		return err
+	}
+	// a changed request is sent with a new identifier - rfc2866, 5.2
+	var id [1]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	p.Identifier = id[0]
+	delay := time.Since(r.queued) / time.Second
+	if delay < 0 {
+		delay = 0
+	}
+	delay += time.Duration(rfc2866.AcctDelayTime_Get(p))
+	if err := rfc2866.AcctDelayTime_Set(p, rfc2866.AcctDelayTime(delay)); err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	client := s.Client
+	if client == nil {
+		client = radius.DefaultClient
+	}
+	resp, err := client.Exchange(ctx, p, s.Addr)
+	if err != nil {
+		return err
+	}
+	if resp.Code != radius.CodeAccountingResponse {
+		return fmt.Errorf("unexpected response %v", resp.Code)
+	}
+	return nil
+}
+
+// done updates r after an attempt to send it, which failed if err is not
+// nil.
+func (s *Spool) done(ctx context.Context, r *record, err error) {
+	s.mu.Lock()
+	r.inflight = false
+	s.inflight--
+	remove := ""
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// Run is returning, and the request is sent again by the next call
+		s.mu.Unlock()
+		return
+	case err == nil:
+		remove = s.path(r.seq, fileSuffix)
+	case s.MaxAge > 0 && time.Since(r.queued) > s.MaxAge:
+		s.logf("spool: giving up on request %d of session %q after %d attempts: %v", r.seq, r.session, r.attempts+1, err)
+		if err := expire(s.path(r.seq, fileSuffix), s.path(r.seq, expiredSuffix)); err != nil {
+			s.logf("spool: %v", err)
+		}
+	default:
+		s.logf("spool: could not deliver request %d of session %q: %v", r.seq, r.session, err)
+		interval := s.RetryInterval
+		if interval == 0 {
+			interval = DefaultRetryInterval
+		}
+		maxInterval := s.MaxRetryInterval
+		if maxInterval == 0 {
+			maxInterval = DefaultMaxRetryInterval
+		}
+		for i := 0; i < r.attempts && interval < maxInterval; i++ {
+			interval *= 2
+		}
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+		r.attempts++
+		r.next = time.Now().Add(interval)
+		s.mu.Unlock()
+		s.notify()
+		return
+	}
+
+	for i, existing := range s.records {
+		if existing == r {
+			s.records = append(s.records[:i], s.records[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	if remove != "" {
+		if err := os.Remove(remove); err != nil {
+			s.logf("spool: %v", err)
+		}
+	}
+	s.notify()
+}
+
+// expire renames the file of an expired request to path. An existing file at
+// path is not overwritten.
+func expire(file, path string) error {
+	// unlike os.Rename, os.Link fails if path exists
+	if err := os.Link(file, path); err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
@@ -0,0 +1,232 @@
+package spool_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/spool"
+)
+
+var secret = []byte(`secret`)
+
+// server is an accounting server that records the requests it receives.
+type server struct {
+	radius.PacketServer
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	received []*radius.Packet
+	// drop is the number of requests of each session that are not answered
+	drop map[string]int
+}
+
+func newServer(t *testing.T, addr string, drop map[string]int) *server {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		conn: conn,
+		drop: drop,
+	}
+	s.SecretSource = radius.StaticSecretSource(secret)
+	s.Handler = radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+		session := rfc2866.AcctSessionID_GetString(r.Packet)
+		s.mu.Lock()
+		if s.drop[session] > 0 {
+			s.drop[session]--
+			s.mu.Unlock()
+			return
+		}
+		s.received = append(s.received, r.Packet)
+		s.mu.Unlock()
+		w.Write(r.Response(radius.CodeAccountingResponse))
+	})
+	go s.Serve(conn)
+	return s
+}
+
+func (s *server) packets() []*radius.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*radius.Packet(nil), s.received...)
+}
+
+func (s *server) sessions() []string {
+	var sessions []string
+	for _, p := range s.packets() {
+		sessions = append(sessions, rfc2866.AcctSessionID_GetString(p))
+	}
+	return sessions
+}
+
+func (s *server) Close() {
+	s.Shutdown(context.Background())
+}
+
+func newRequest(session string, delay uint32) *radius.Packet {
+	p := radius.New(radius.CodeAccountingRequest, secret)
+	rfc2866.AcctSessionID_SetString(p, session)
+	rfc2866.AcctStatusType_Set(p, rfc2866.AcctStatusType_Value_InterimUpdate)
+	rfc2866.AcctDelayTime_Set(p, rfc2866.AcctDelayTime(delay))
+	return p
+}
+
+func newSpool(dir, addr string) *spool.Spool {
+	return &spool.Spool{
+		Dir:              dir,
+		Addr:             addr,
+		Secret:           secret,
+		Client:           &radius.Client{},
+		Timeout:          50 * time.Millisecond,
+		RetryInterval:    10 * time.Millisecond,
+		MaxRetryInterval: 20 * time.Millisecond,
+		ErrorLog:         log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSpool_restart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// the requests are persisted without a running forwarder
+	first := newSpool(dir, "")
+	for _, session := range []string{"a", "b", "c"} {
+		if err := first.Enqueue(newRequest(session, 5)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.acct"))
+	if len(files) != 3 {
+		t.Fatalf("got files %v", files)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	srv := newServer(t, "127.0.0.1:0", nil)
+	defer srv.Close()
+	second := newSpool(dir, srv.conn.LocalAddr().String())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- second.Run(ctx)
+	}()
+	waitFor(t, func() bool { return len(srv.sessions()) == 3 && second.Len() == 0 })
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got error %v", err)
+	}
+
+	if sessions := srv.sessions(); len(sessions) != 3 {
+		t.Fatalf("got sessions %v", sessions)
+	}
+	for _, p := range srv.packets() {
+		if delay := rfc2866.AcctDelayTime_Get(p); delay < 6 || delay > 10 {
+			t.Fatalf("got Acct-Delay-Time %d", delay)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("got files %v", files)
+	}
+}
+
+func TestSpool_order(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	srv := newServer(t, "127.0.0.1:0", map[string]int{"a": 3})
+	defer srv.Close()
+
+	s := newSpool(dir, srv.conn.LocalAddr().String())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	for _, session := range []string{"a", "a", "b", "a", "b"} {
+		if err := s.Enqueue(newRequest(session, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return s.Len() == 0 })
+
+	// the requests of session a are delivered in order after the first one
+	// has been retried, and do not delay those of session b
+	sessions := srv.sessions()
+	expected := []string{"b", "b", "a", "a", "a"}
+	for i := range expected {
+		if len(sessions) != len(expected) || sessions[i] != expected[i] {
+			t.Fatalf("got sessions %v; expecting %v", sessions, expected)
+		}
+	}
+}
+
+func TestSpool_maxAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// nothing listens on the address of the closed connection
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	// the second spool, which only finds the expired request of the first,
+	// does not overwrite it
+	for i := 1; i <= 2; i++ {
+		s := newSpool(dir, addr)
+		s.MaxAge = 100 * time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- s.Run(ctx)
+		}()
+
+		if err := s.Enqueue(newRequest("a", 0)); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool { return s.Len() == 0 })
+		cancel()
+		<-done
+		if files, _ := filepath.Glob(filepath.Join(dir, "*.expired")); len(files) != i {
+			t.Fatalf("got files %v", files)
+		}
+	}
+}
+
+func TestSpool_Enqueue_code(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := newSpool(dir, "")
+	if err := s.Enqueue(radius.New(radius.CodeAccessRequest, secret)); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,463 @@
+package radius
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"layeh.com/radius/dictionary"
+)
+
+// ParseText adds the attributes of an attribute list in the FreeRADIUS text
+// format, as used by radclient input files, to p:
+//
+//	User-Name = "bob"
+//	NAS-IP-Address = 192.0.2.1, NAS-Port = 7
+//	Tunnel-Type:1 = VLAN
+//	Cisco-AVPair = "shell:priv-lvl=15"
+//	Vendor-Specific = { Cisco = { AVPair = "x=y" } }
+//	Vendor-Specific.Cisco.AVPair = "a=b"
+//
+// Attributes are separated by newlines or commas, and are referred to by
+// name, with an optional tag, or by OID. The operators "=", ":=" and "+="
+// all add the attribute. Values are double or single quoted strings, or
+// bare words; the bare values of octets attributes and of attributes that
+// are not in the dictionary are hex encoded with a "0x" prefix. Text after a
+// "#" is a comment.
+//
+// Vendor attributes may be nested inside Vendor-Specific, in which case the
+// vendor's name may be omitted from their names.
+//
+// The list may start with a header line of the form "Access-Request Id 3",
+// optionally prefixed with "Sent" or "Received", as written by the debug
+// package and FreeRADIUS, which sets the code and identifier of p.
+// The pseudo-attribute Packet-Type sets the code of p.
+func (c *Codec) ParseText(p *Packet, text string) error {
+	return c.parseText(p, text, 1)
+}
+
+// ParseTextPackets parses attribute lists separated by blank lines, as in
+// radclient input files, and returns a packet with the given code and
+// secret for each of them. See ParseText.
+func (c *Codec) ParseTextPackets(code Code, secret []byte, text string) ([]*Packet, error) {
+	var packets []*Packet
+	var block strings.Builder
+	blockLine := 1
+
+	flush := func() error {
+		if strings.TrimSpace(block.String()) == "" {
+			return nil
+		}
+		p := New(code, secret)
+		if err := c.parseText(p, block.String(), blockLine); err != nil {
+			return err
+		}
+		packets = append(packets, p)
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			block.Reset()
+			blockLine = i + 2
+			continue
+		}
+		block.WriteString(line)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return packets, nil
+}
+
+// WriteText writes the attributes of p to w in the FreeRADIUS text format,
+// one per line. The output can be parsed by ParseText.
+func (c *Codec) WriteText(w io.Writer, p *Packet) error {
+	bw := bufio.NewWriter(w)
+	for _, attr := range c.Decode(p) {
+		bw.WriteString(attr.FullName())
+		bw.WriteString(" = ")
+		bw.WriteString(attr.ValueString())
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// ValueString returns the value of the attribute in the FreeRADIUS text
+// format: strings are quoted, integers are represented by the name of their
+// dictionary value if they have one, and byte strings are hex encoded.
+func (d *DecodedAttribute) ValueString() string {
+	if d.ValueName != "" {
+		if isBareWord(d.ValueName) {
+			return d.ValueName
+		}
+		return quoteText(d.ValueName)
+	}
+	switch v := d.Value.(type) {
+	case string:
+		return quoteText(v)
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case interface{ String() string }:
+		return v.String()
+	}
+	return ""
+}
+
+// quoteText returns s as a double quoted string. Quotes, backslashes and
+// control characters are escaped.
+func quoteText(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			b = append(b, '\\', byte(r))
+		case r == '\n':
+			b = append(b, '\\', 'n')
+		case r == '\r':
+			b = append(b, '\\', 'r')
+		case r == '\t':
+			b = append(b, '\\', 't')
+		case r == utf8.RuneError && size == 1, r < ' ', r == 0x7f:
+			b = append(b, '\\', '0'+s[i]>>6, '0'+(s[i]>>3)&7, '0'+s[i]&7)
+		default:
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return string(append(b, '"'))
+}
+
+func isBareWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune(`"'#,{}=\`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// textParser parses an attribute list in the FreeRADIUS text format.
+type textParser struct {
+	codec *Codec
+	p     *Packet
+	s     string
+	pos   int
+	line  int
+}
+
+func (c *Codec) parseText(p *Packet, text string, line int) error {
+	tp := &textParser{
+		codec: c,
+		p:     p,
+		s:     text,
+		line:  line,
+	}
+	tp.header()
+	return tp.list(nil, false)
+}
+
+func (tp *textParser) errorf(msg string) error {
+	return errors.New("radius: line " + strconv.Itoa(tp.line) + ": " + msg)
+}
+
+// header parses an optional "Access-Request Id 3" line.
+func (tp *textParser) header() {
+	tp.skipSpace(true)
+	end := strings.IndexByte(tp.s[tp.pos:], '\n')
+	if end < 0 {
+		end = len(tp.s) - tp.pos
+	}
+	fields := strings.Fields(tp.s[tp.pos : tp.pos+end])
+	if len(fields) > 0 && (fields[0] == "Sent" || fields[0] == "Received") {
+		fields = fields[1:]
+	}
+	if len(fields) < 3 || fields[1] != "Id" {
+		return
+	}
+	code, err := ParseCode(fields[0])
+	if err != nil {
+		return
+	}
+	id, err := strconv.ParseUint(fields[2], 10, 8)
+	if err != nil {
+		return
+	}
+	tp.p.Code = code
+	tp.p.Identifier = byte(id)
+	tp.pos += end
+}
+
+// skipSpace skips whitespace and comments. Newlines are only skipped if
+// newlines is true.
+func (tp *textParser) skipSpace(newlines bool) {
+	for tp.pos < len(tp.s) {
+		switch ch := tp.s[tp.pos]; {
+		case ch == '\n':
+			if !newlines {
+				return
+			}
+			tp.line++
+		case ch == '#':
+			for tp.pos < len(tp.s) && tp.s[tp.pos] != '\n' {
+				tp.pos++
+			}
+			continue
+		case ch == ' ' || ch == '\t' || ch == '\r':
+		default:
+			return
+		}
+		tp.pos++
+	}
+}
+
+// list parses attributes until the end of the input, or until a closing
+// brace if nested is true. Attribute names are resolved within vendor, if
+// non-nil.
+func (tp *textParser) list(vendor *dictionary.Vendor, nested bool) error {
+	for {
+		tp.skipSpace(true)
+		if tp.pos >= len(tp.s) {
+			if nested {
+				return tp.errorf("missing '}'")
+			}
+			return nil
+		}
+		if tp.s[tp.pos] == '}' {
+			if !nested {
+				return tp.errorf("unexpected '}'")
+			}
+			tp.pos++
+			return nil
+		}
+
+		if err := tp.pair(vendor); err != nil {
+			return err
+		}
+
+		tp.skipSpace(false)
+		if tp.pos < len(tp.s) {
+			switch tp.s[tp.pos] {
+			case ',', '\n':
+				if tp.s[tp.pos] == '\n' {
+					tp.line++
+				}
+				tp.pos++
+			case '}':
+			default:
+				return tp.errorf("expected ',' or newline after value")
+			}
+		}
+	}
+}
+
+func (tp *textParser) pair(vendor *dictionary.Vendor) error {
+	name := tp.name()
+	if name == "" {
+		return tp.errorf("expected attribute name")
+	}
+	tp.skipSpace(false)
+	if !tp.operator() {
+		return tp.errorf("expected operator after " + strconv.Quote(name))
+	}
+	tp.skipSpace(false)
+	if tp.pos >= len(tp.s) {
+		return tp.errorf("missing value of " + strconv.Quote(name))
+	}
+
+	if tp.s[tp.pos] == '{' {
+		tp.pos++
+		nestedVendor, err := tp.nestedVendor(vendor, name)
+		if err != nil {
+			return err
+		}
+		return tp.list(nestedVendor, true)
+	}
+
+	value, quoted, err := tp.value()
+	if err != nil {
+		return err
+	}
+	return tp.add(vendor, name, value, quoted)
+}
+
+// nestedVendor returns the vendor whose attributes are listed inside the
+// braces following name.
+func (tp *textParser) nestedVendor(vendor *dictionary.Vendor, name string) (*dictionary.Vendor, error) {
+	if vendor == nil && (name == "Vendor-Specific" || name == "26") {
+		return nil, nil
+	}
+	if vendor == nil && tp.codec.Dictionary != nil {
+		if v := dictionary.VendorByName(tp.codec.Dictionary.Vendors, name); v != nil {
+			return v, nil
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			if v := dictionary.VendorByNumber(tp.codec.Dictionary.Vendors, n); v != nil {
+				return v, nil
+			}
+		}
+	}
+	return nil, tp.errorf("unexpected '{' after " + strconv.Quote(name))
+}
+
+func (tp *textParser) name() string {
+	start := tp.pos
+	for tp.pos < len(tp.s) {
+		ch := tp.s[tp.pos]
+		if ch == ':' && tp.pos+1 < len(tp.s) && tp.s[tp.pos+1] >= '0' && tp.s[tp.pos+1] <= '9' {
+			tp.pos += 2
+			continue
+		}
+		if ch == '-' || ch == '_' || ch == '.' || ch == '/' || ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' {
+			tp.pos++
+			continue
+		}
+		break
+	}
+	return tp.s[start:tp.pos]
+}
+
+func (tp *textParser) operator() bool {
+	for _, op := range []string{":=", "+=", "="} {
+		if strings.HasPrefix(tp.s[tp.pos:], op) {
+			tp.pos += len(op)
+			return true
+		}
+	}
+	return false
+}
+
+// value parses a quoted or bare value.
+func (tp *textParser) value() (value string, quoted bool, err error) {
+	quote := tp.s[tp.pos]
+	if quote != '"' && quote != '\'' {
+		start := tp.pos
+		for tp.pos < len(tp.s) && !strings.ContainsRune(" \t\r\n,}#", rune(tp.s[tp.pos])) {
+			tp.pos++
+		}
+		return tp.s[start:tp.pos], false, nil
+	}
+
+	var b []byte
+	for tp.pos++; tp.pos < len(tp.s); tp.pos++ {
+		ch := tp.s[tp.pos]
+		switch {
+		case ch == quote:
+			tp.pos++
+			return string(b), true, nil
+		case ch == '\n':
+			return "", false, tp.errorf("unterminated string")
+		case ch == '\\' && tp.pos+1 < len(tp.s):
+			tp.pos++
+			switch esc := tp.s[tp.pos]; esc {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case '0', '1', '2', '3':
+				if tp.pos+2 >= len(tp.s) {
+					return "", false, tp.errorf("invalid escape sequence")
+				}
+				n, err := strconv.ParseUint(tp.s[tp.pos:tp.pos+3], 8, 8)
+				if err != nil {
+					return "", false, tp.errorf("invalid escape sequence")
+				}
+				b = append(b, byte(n))
+				tp.pos += 2
+			default:
+				b = append(b, esc)
+			}
+		default:
+			b = append(b, ch)
+		}
+	}
+	return "", false, tp.errorf("unterminated string")
+}
+
+func (tp *textParser) add(vendor *dictionary.Vendor, name, value string, quoted bool) error {
+	if vendor == nil && name == "Packet-Type" {
+		code, err := ParseCode(value)
+		if err != nil {
+			return tp.errorf(err.Error())
+		}
+		tp.p.Code = code
+		return nil
+	}
+
+	name = tp.resolveName(vendor, name)
+	a, _, _, err := tp.codec.namedAttribute(name)
+	if err != nil {
+		return tp.errorf(strings.TrimPrefix(err.Error(), "radius: "))
+	}
+	var v interface{} = value
+	if !quoted && isBytesType(a.Type) && strings.HasPrefix(value, "0x") {
+		b, err := hex.DecodeString(value[2:])
+		if err != nil {
+			return tp.errorf("invalid hex value of " + strconv.Quote(name))
+		}
+		v = b
+	}
+	if err := tp.codec.Add(tp.p, name, v); err != nil {
+		return tp.errorf(strings.TrimPrefix(err.Error(), "radius: "))
+	}
+	return nil
+}
+
+// resolveName returns the name of the attribute name listed inside vendor's
+// braces, or given as Vendor-Specific.Vendor.Attribute.
+func (tp *textParser) resolveName(vendor *dictionary.Vendor, name string) string {
+	d := tp.codec.Dictionary
+	if vendor == nil && d != nil {
+		if parts := strings.SplitN(name, ".", 3); len(parts) == 3 && (parts[0] == "Vendor-Specific" || parts[0] == "26") {
+			if v := dictionary.VendorByName(d.Vendors, parts[1]); v != nil {
+				vendor, name = v, parts[2]
+			}
+		}
+	}
+	if vendor == nil {
+		return name
+	}
+
+	attrName, tag := name, ""
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		attrName, tag = name[:i], name[i:]
+	}
+	if n, err := strconv.Atoi(attrName); err == nil {
+		return "26." + strconv.Itoa(vendor.Number) + "." + strconv.Itoa(n) + tag
+	}
+	if dictionary.AttributeByName(vendor.Attributes, attrName) == nil {
+		if prefixed := vendor.Name + "-" + attrName; dictionary.AttributeByName(vendor.Attributes, prefixed) != nil {
+			return prefixed + tag
+		}
+	}
+	return name
+}
@@ -0,0 +1,132 @@
+package radius_test
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2868"
+)
+
+func TestCodec_Text(t *testing.T) {
+	codec := loadCodec(t)
+
+	p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	rfc2865.UserName_SetString(p, "bob \"the\" builder\n")
+	rfc2865.UserPassword_SetString(p, "12345")
+	rfc2865.ServiceType_Set(p, rfc2865.ServiceType_Value_FramedUser)
+	rfc2865.NASPort_Set(p, 7)
+	rfc2865.FramedIPAddress_Set(p, net.IPv4(10, 0, 0, 1))
+	rfc2865.Class_Add(p, []byte{0xca, 0xfe})
+	rfc2868.TunnelType_Add(p, 1, rfc2868.TunnelType_Value_L2TP)
+	codec.Add(p, "Cisco-AVPair", "shell:priv-lvl=15")
+	p.Add(200, radius.Attribute{1, 2})
+
+	var b bytes.Buffer
+	if err := codec.WriteText(&b, p); err != nil {
+		t.Fatal(err)
+	}
+	expected := `User-Name = "bob \"the\" builder\n"
+User-Password = "12345"
+Service-Type = Framed-User
+NAS-Port = 7
+Framed-IP-Address = 10.0.0.1
+Class = 0xcafe
+Tunnel-Type:1 = L2TP
+Cisco-AVPair = "shell:priv-lvl=15"
+200 = 0x0102
+`
+	if b.String() != expected {
+		t.Fatalf("got:\n%s\nexpecting:\n%s", b.String(), expected)
+	}
+
+	q := radius.New(radius.CodeAccessRequest, p.Secret)
+	q.Authenticator = p.Authenticator
+	if err := codec.ParseText(q, b.String()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(q.Attributes, p.Attributes) {
+		t.Fatalf("got attributes %v\nexpecting %v", q.Attributes, p.Attributes)
+	}
+}
+
+func TestCodec_ParseTextPackets(t *testing.T) {
+	codec := loadCodec(t)
+
+	input := `# first packet
+User-Name = 'bob', NAS-Port := 7
+Vendor-Specific = { Cisco = { AVPair = "a=b" } }
+
+Access-Accept Id 9
+	Vendor-Specific.Cisco.AVPair = "c=d"
+	Tunnel-Type:2 += 3 # L2TP
+	Reply-Message = "\150\151"
+
+Packet-Type = Accounting-Request
+Acct-Status-Type = Start
+`
+	packets, err := codec.ParseTextPackets(radius.CodeAccessRequest, []byte(`secret`), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("got %d packets", len(packets))
+	}
+
+	p := packets[0]
+	if p.Code != radius.CodeAccessRequest || rfc2865.UserName_GetString(p) != "bob" || rfc2865.NASPort_Get(p) != 7 {
+		t.Fatalf("got first packet %v %v", p.Code, p.Attributes)
+	}
+	if v, _ := codec.Get(p, "Cisco-AVPair"); v != "a=b" {
+		t.Fatalf("got Cisco-AVPair %v", v)
+	}
+
+	p = packets[1]
+	if p.Code != radius.CodeAccessAccept || p.Identifier != 9 || rfc2865.ReplyMessage_GetString(p) != "hi" {
+		t.Fatalf("got second packet %v %d %v", p.Code, p.Identifier, p.Attributes)
+	}
+	if v, _ := codec.Get(p, "Cisco-AVPair"); v != "c=d" {
+		t.Fatalf("got Cisco-AVPair %v", v)
+	}
+	if tag, v := rfc2868.TunnelType_Get(p); tag != 2 || v != rfc2868.TunnelType_Value_L2TP {
+		t.Fatalf("got Tunnel-Type %d, %v", tag, v)
+	}
+
+	p = packets[2]
+	if v, _ := codec.Get(p, "Acct-Status-Type"); p.Code != radius.CodeAccountingRequest || v != uint32(1) {
+		t.Fatalf("got third packet %v %v", p.Code, v)
+	}
+}
+
+func TestCodec_ParseText_errors(t *testing.T) {
+	codec := loadCodec(t)
+
+	tests := []struct {
+		Input string
+		Error string
+	}{
+		{"User-Name", `radius: line 1: expected operator after "User-Name"`},
+		{"User-Name = \"bob", `radius: line 1: unterminated string`},
+		{"User-Name = bob\nBogus = 1", `radius: line 2: unknown attribute "Bogus"`},
+		{"User-Name = bob NAS-Port = 1", `radius: line 1: expected ',' or newline after value`},
+		{"Vendor-Specific = { Cisco = { AVPair = x }", `radius: line 1: missing '}'`},
+		{"User-Name = { a = b }", `radius: line 1: unexpected '{' after "User-Name"`},
+		{"\n\nClass = 0xzz", `radius: line 3: invalid hex value of "Class"`},
+	}
+	for _, tt := range tests {
+		p := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+		err := codec.ParseText(p, tt.Input)
+		if err == nil || err.Error() != tt.Error {
+			t.Errorf("%q: got error %v; expecting %s", tt.Input, err, tt.Error)
+		}
+	}
+
+	_, err := codec.ParseTextPackets(radius.CodeAccessRequest, nil, "User-Name = a\n\n\nBogus = 1\n")
+	if err == nil || !strings.HasPrefix(err.Error(), "radius: line 4: ") {
+		t.Fatalf("got error %v", err)
+	}
+}
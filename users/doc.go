@@ -0,0 +1,20 @@
+// Package users parses and evaluates FreeRADIUS users files.
+//
+// A users file is a list of entries, each of which consists of a user name
+// and check items on its first line, followed by indented reply items:
+//
+//	bob	Cleartext-Password := "hello", NAS-IP-Address == 192.0.2.1
+//		Reply-Message = "Hello, bob",
+//		Session-Timeout = 3600
+//
+//	DEFAULT	Auth-Type := Reject
+//		Reply-Message = "Unknown user"
+//
+// Attribute names and values are resolved using a dictionary. Besides the
+// dictionary attributes, the internal attributes Cleartext-Password,
+// Crypt-Password, NT-Password, Auth-Type and Fall-Through are understood.
+// Check items may set other attributes that are not in the dictionary, such
+// as Simultaneous-Use, which are kept as control items with string values.
+//
+// API is currently unstable.
+package users
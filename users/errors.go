@@ -0,0 +1,31 @@
+package users
+
+import (
+	"strconv"
+
+	"layeh.com/radius/dictionary"
+)
+
+type ParseError struct {
+	Inner error
+	File  dictionary.File
+	Line  int
+}
+
+func (e *ParseError) Error() string {
+	str := "users: parse error in " + e.File.Name() + ":" + strconv.Itoa(e.Line)
+	if e.Inner != nil {
+		str += ": " + e.Inner.Error()
+	}
+	return str
+}
+
+func (e *ParseError) Unwrap() error { return e.Inner }
+
+type RecursiveIncludeError struct {
+	Filename string
+}
+
+func (e *RecursiveIncludeError) Error() string {
+	return `file already included "` + e.Filename + `"`
+}
@@ -0,0 +1,401 @@
+package users
+
+import (
+	"bufio"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+)
+
+// Parser parses users files.
+type Parser struct {
+	// Dictionary resolves the names and values of attributes. The
+	// attributes of RFC 2865 and the other standard dictionaries must be
+	// included in it.
+	Dictionary *dictionary.Dictionary
+
+	// Opener opens the files given to ParseFile and included by $INCLUDE.
+	Opener dictionary.Opener
+}
+
+// ParseFile parses the users file filename.
+func (p *Parser) ParseFile(filename string) (*Users, error) {
+	f, err := p.Opener.OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return p.Parse(f)
+}
+
+// Parse parses the users file f.
+func (p *Parser) Parse(f dictionary.File) (*Users, error) {
+	u := &Users{
+		codec: &radius.Codec{
+			Dictionary: p.Dictionary,
+		},
+	}
+	parsedFiles := map[string]struct{}{
+		f.Name(): {},
+	}
+	if err := p.parse(u, parsedFiles, f); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (p *Parser) parse(u *Users, parsedFiles map[string]struct{}, f dictionary.File) error {
+	s := bufio.NewScanner(f)
+
+	var entry *Entry
+	// continued is true if the last reply line ended with a comma
+	continued := false
+
+	lineNo := 1
+	for ; s.Scan(); lineNo++ {
+		line := s.Text()
+		newError := func(err error) error {
+			return &ParseError{
+				Inner: err,
+				File:  f,
+				Line:  lineNo,
+			}
+		}
+
+		if strings.TrimSpace(stripComment(line)) == "" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			// reply items
+			if entry == nil {
+				return newError(errors.New("reply items without entry"))
+			}
+			if len(entry.Reply) > 0 && !continued {
+				return newError(errors.New("expected comma after previous reply item"))
+			}
+			items, trailingComma, err := p.parseItems(u, strings.TrimSpace(line), false)
+			if err != nil {
+				return newError(err)
+			}
+			for _, item := range items {
+				if item.Attribute == FallThrough {
+					entry.FallThrough = item.Value.(string) == "Yes"
+					continue
+				}
+				entry.Reply = append(entry.Reply, item)
+			}
+			continued = trailingComma
+			continue
+		}
+
+		fields := strings.Fields(stripComment(line))
+		if len(fields) == 2 && fields[0] == "$INCLUDE" {
+			entry = nil
+			err := func() error {
+				incFile, err := p.Opener.OpenFile(fields[1])
+				if err != nil {
+					return newError(err)
+				}
+				defer incFile.Close()
+
+				incFileName := incFile.Name()
+				if _, included := parsedFiles[incFileName]; included {
+					return newError(&RecursiveIncludeError{
+						Filename: incFileName,
+					})
+				}
+				parsedFiles[incFileName] = struct{}{}
+				return p.parse(u, parsedFiles, incFile)
+			}()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		name, rest, err := parseName(line)
+		if err != nil {
+			return newError(err)
+		}
+		items, trailingComma, err := p.parseItems(u, rest, true)
+		if err != nil {
+			return newError(err)
+		}
+		if trailingComma {
+			return newError(errors.New("unexpected comma after check items"))
+		}
+		entry = &Entry{
+			Name: name,
+			File: f.Name(),
+			Line: lineNo,
+		}
+		for _, item := range items {
+			if item.Attribute == FallThrough {
+				entry.FallThrough = item.Value.(string) == "Yes"
+				continue
+			}
+			entry.Check = append(entry.Check, item)
+		}
+		continued = false
+		u.Entries = append(u.Entries, entry)
+	}
+	return s.Err()
+}
+
+// stripComment removes the comment at the end of line.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseName returns the user name at the start of line, and the rest of
+// the line.
+func parseName(line string) (name, rest string, err error) {
+	if line[0] == '"' {
+		end := quotedEnd(line)
+		if end < 0 {
+			return "", "", errors.New("unterminated quoted user name")
+		}
+		if name, err = strconv.Unquote(line[:end]); err != nil {
+			return "", "", errors.New("invalid quoted user name")
+		}
+		return name, line[end:], nil
+	}
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, "", nil
+	}
+	return line[:i], line[i:], nil
+}
+
+// quotedEnd returns the index after the end of the string quoted by the
+// first character of s, or -1 if it is not terminated.
+func quotedEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case s[0]:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// operatorTokens are the operators, longest first.
+var operatorTokens = []struct {
+	token string
+	op    Operator
+}{
+	{"==", OpEqual},
+	{"!=", OpNotEqual},
+	{"<=", OpLessEqual},
+	{">=", OpGreaterEqual},
+	{"=~", OpMatch},
+	{"!~", OpNotMatch},
+	{"=*", OpPresent},
+	{"!*", OpAbsent},
+	{":=", OpSet},
+	{"+=", OpAdd},
+	{"=", OpAssign},
+	{"<", OpLess},
+	{">", OpGreater},
+}
+
+// parseItems parses the comma separated items of s, and returns if s ends
+// with a comma.
+func (p *Parser) parseItems(u *Users, s string, check bool) ([]*Item, bool, error) {
+	var items []*Item
+	s = strings.TrimSpace(stripComment(s))
+	for s != "" {
+		i := nameEnd(s)
+		if i <= 0 {
+			return nil, false, errors.New("expected attribute and operator in " + strconv.Quote(s))
+		}
+		name := s[:i]
+		s = strings.TrimLeft(s[i:], " \t")
+
+		var op Operator
+		for _, o := range operatorTokens {
+			if strings.HasPrefix(s, o.token) {
+				op = o.op
+				s = strings.TrimLeft(s[len(o.token):], " \t")
+				break
+			}
+		}
+		if op == 0 {
+			return nil, false, errors.New("expected operator after " + name)
+		}
+		if !check && !op.isAssignment() {
+			return nil, false, errors.New("invalid reply item operator " + op.String())
+		}
+
+		var value string
+		switch {
+		case s == "":
+			return nil, false, errors.New("expected value of " + name)
+		case s[0] == '"' || s[0] == '\'':
+			end := quotedEnd(s)
+			if end < 0 {
+				return nil, false, errors.New("unterminated value of " + name)
+			}
+			value, s = s[:end], s[end:]
+		case s[0] == '`':
+			return nil, false, errors.New("unsupported back-quoted value of " + name)
+		default:
+			end := strings.IndexAny(s, " \t,")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+
+		item, err := p.newItem(u, name, op, value, check)
+		if err != nil {
+			return nil, false, err
+		}
+		items = append(items, item)
+
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		if s[0] != ',' {
+			return nil, false, errors.New("expected comma after value of " + name)
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		if s == "" {
+			return items, true, nil
+		}
+	}
+	return items, false, nil
+}
+
+// nameEnd returns the index of the end of the attribute name at the start
+// of s. Names may contain a tag, as in "Tunnel-Type:1".
+func nameEnd(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '=', '!', '<', '>', '+':
+			return i
+		case ':':
+			if i+1 < len(s) && s[i+1] == '=' {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// newItem returns the item of the given attribute name, operator and value,
+// as written in the file. check is true for check items.
+func (p *Parser) newItem(u *Users, name string, op Operator, value string, check bool) (*Item, error) {
+	item := &Item{
+		Attribute: name,
+		Operator:  op,
+	}
+
+	if isInternal(name) {
+		if !op.isAssignment() {
+			return nil, errors.New("invalid operator " + op.String() + " for " + name)
+		}
+		s, err := unquote(value)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case FallThrough:
+			switch strings.ToLower(s) {
+			case "yes", "1":
+				s = "Yes"
+			case "no", "0":
+				s = "No"
+			default:
+				return nil, errors.New("invalid " + name + " value " + strconv.Quote(s))
+			}
+		case NTPassword:
+			if _, err := parseNTPassword(s); err != nil {
+				return nil, err
+			}
+		}
+		item.Value = s
+		return item, nil
+	}
+
+	// the attribute must be known, unless it is set as a control item, as
+	// are the internal attributes of FreeRADIUS such as Simultaneous-Use
+	if _, err := u.codec.Gets(radius.New(radius.CodeAccessRequest, nil), name); err != nil {
+		unknown, ok := err.(*radius.UnknownAttributeError)
+		if !ok || !check || !op.isAssignment() || unknown.Suggestion != "" {
+			return nil, err
+		}
+		s, err := unquote(value)
+		if err != nil {
+			return nil, err
+		}
+		item.Value = s
+		return item, nil
+	}
+	if _, values := lookupAttribute(p.Dictionary, name); len(values) > 0 {
+		item.values = values
+	}
+
+	switch op {
+	case OpPresent, OpAbsent:
+		return item, nil
+	case OpMatch, OpNotMatch:
+		s, err := unquote(value)
+		if err != nil {
+			return nil, err
+		}
+		if item.regexp, err = regexp.Compile(s); err != nil {
+			return nil, err
+		}
+		item.Value = s
+		return item, nil
+	}
+
+	// values are parsed as the FreeRADIUS text format
+	packet := radius.New(radius.CodeAccessRequest, []byte(`users`))
+	if err := u.codec.ParseText(packet, name+" = "+value); err != nil {
+		return nil, err
+	}
+	values, err := u.codec.Gets(packet, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 1 {
+		return nil, errors.New("invalid value of " + name)
+	}
+	item.Value = values[0]
+	return item, nil
+}
+
+// unquote returns the string of a quoted or bare value.
+func unquote(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, `'`):
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
@@ -0,0 +1,433 @@
+package users
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/rfc2865"
+)
+
+// Operator is the operator of an item.
+type Operator int
+
+// Operators of items. The assignment operators OpAssign, OpSet and OpAdd
+// are used by reply items, and by check items that set control items. The
+// other operators are used by check items that are compared to the
+// attributes of requests.
+const (
+	OpAssign       Operator = iota + 1 // =
+	OpSet                              // :=
+	OpAdd                              // +=
+	OpEqual                            // ==
+	OpNotEqual                         // !=
+	OpLess                             // <
+	OpLessEqual                        // <=
+	OpGreater                          // >
+	OpGreaterEqual                     // >=
+	OpMatch                            // =~
+	OpNotMatch                         // !~
+	OpPresent                          // =*
+	OpAbsent                           // !*
+)
+
+var operators = map[Operator]string{
+	OpAssign:       "=",
+	OpSet:          ":=",
+	OpAdd:          "+=",
+	OpEqual:        "==",
+	OpNotEqual:     "!=",
+	OpLess:         "<",
+	OpLessEqual:    "<=",
+	OpGreater:      ">",
+	OpGreaterEqual: ">=",
+	OpMatch:        "=~",
+	OpNotMatch:     "!~",
+	OpPresent:      "=*",
+	OpAbsent:       "!*",
+}
+
+func (o Operator) String() string {
+	if s, ok := operators[o]; ok {
+		return s
+	}
+	return "Operator(" + fmt.Sprint(int(o)) + ")"
+}
+
+// isAssignment returns if o is an assignment operator.
+func (o Operator) isAssignment() bool {
+	return o == OpAssign || o == OpSet || o == OpAdd
+}
+
+// Internal attributes, which are not sent in packets.
+const (
+	CleartextPassword = "Cleartext-Password"
+	CryptPassword     = "Crypt-Password"
+	NTPassword        = "NT-Password"
+	AuthType          = "Auth-Type"
+	FallThrough       = "Fall-Through"
+)
+
+func isInternal(name string) bool {
+	switch name {
+	case CleartextPassword, CryptPassword, NTPassword, AuthType, FallThrough:
+		return true
+	}
+	return false
+}
+
+// Item is a check or reply item.
+type Item struct {
+	// Attribute is the name of the attribute, optionally followed by a tag
+	// as in "Tunnel-Type:1".
+	Attribute string
+	Operator  Operator
+	// Value is the value of the item, in the Go type returned by
+	// radius.Codec.Get. It is a string for internal attributes, for control
+	// items of attributes that are not in the dictionary and for the regular
+	// expressions of OpMatch and OpNotMatch, and nil for OpPresent and
+	// OpAbsent.
+	Value interface{}
+
+	regexp *regexp.Regexp
+	// values are the dictionary values of integer attributes
+	values []*dictionary.Value
+}
+
+func (i *Item) String() string {
+	return fmt.Sprintf("%s %s %v", i.Attribute, i.Operator, i.Value)
+}
+
+// Entry is an entry of a users file.
+type Entry struct {
+	// Name is the user name of the entry, or "DEFAULT" if it matches all
+	// users.
+	Name  string
+	Check []*Item
+	Reply []*Item
+	// FallThrough is true if the entries following this one are evaluated
+	// after it matches.
+	FallThrough bool
+
+	// File and Line are the location of the entry.
+	File string
+	Line int
+}
+
+// Users are the entries of a users file.
+type Users struct {
+	Entries []*Entry
+
+	codec *radius.Codec
+}
+
+// Result is the result of evaluating a users file for a request.
+type Result struct {
+	// Entries are the entries that matched the request.
+	Entries []*Entry
+	// Control are the items set by the check items of the matched entries,
+	// such as Cleartext-Password and Auth-Type, or attributes that are not in
+	// the dictionary, such as Simultaneous-Use.
+	Control []*Item
+	// Reply are the reply items of the matched entries.
+	Reply []*Item
+
+	codec *radius.Codec
+}
+
+// Authorize evaluates the entries for the request p. Entries are evaluated
+// in order, and those whose name is the User-Name of p or DEFAULT match if
+// all of their check items match p. Evaluation stops at the first matching
+// entry that does not fall through.
+//
+// The result does not have any entries if none matched.
+func (u *Users) Authorize(p *radius.Packet) (*Result, error) {
+	name := rfc2865.UserName_GetString(p)
+	r := &Result{
+		codec: u.codec,
+	}
+	for _, e := range u.Entries {
+		if e.Name != "DEFAULT" && e.Name != name {
+			continue
+		}
+		ok, err := u.match(p, e)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		r.Entries = append(r.Entries, e)
+		for _, item := range e.Check {
+			if item.Operator.isAssignment() {
+				r.Control = assign(r.Control, item)
+			}
+		}
+		for _, item := range e.Reply {
+			r.Reply = assign(r.Reply, item)
+		}
+		if !e.FallThrough {
+			break
+		}
+	}
+	return r, nil
+}
+
+// assign applies the assignment item to list.
+func assign(list []*Item, item *Item) []*Item {
+	switch item.Operator {
+	case OpSet:
+		var kept []*Item
+		for _, existing := range list {
+			if existing.Attribute != item.Attribute {
+				kept = append(kept, existing)
+			}
+		}
+		return append(kept, item)
+	case OpAssign:
+		for _, existing := range list {
+			if existing.Attribute == item.Attribute {
+				return list
+			}
+		}
+	}
+	return append(list, item)
+}
+
+func (u *Users) match(p *radius.Packet, e *Entry) (bool, error) {
+	for _, item := range e.Check {
+		if item.Operator.isAssignment() {
+			continue
+		}
+		values, err := u.codec.Gets(p, item.Attribute)
+		if err != nil {
+			return false, err
+		}
+		if !item.match(values) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// match returns if the check item matches the values of its attribute in a
+// request.
+func (i *Item) match(values []interface{}) bool {
+	switch i.Operator {
+	case OpPresent:
+		return len(values) > 0
+	case OpAbsent:
+		return len(values) == 0
+	case OpEqual:
+		for _, v := range values {
+			if equal(v, i.Value) {
+				return true
+			}
+		}
+		return false
+	case OpNotEqual:
+		for _, v := range values {
+			if equal(v, i.Value) {
+				return false
+			}
+		}
+		return len(values) > 0
+	case OpMatch, OpNotMatch:
+		matched := false
+		for _, v := range values {
+			if i.regexp.MatchString(i.text(v)) {
+				matched = true
+				break
+			}
+		}
+		return len(values) > 0 && matched == (i.Operator == OpMatch)
+	case OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		if len(values) == 0 {
+			return false
+		}
+		a, b := number(values[0]), number(i.Value)
+		if a == nil || b == nil {
+			return false
+		}
+		c := a.Cmp(b)
+		switch i.Operator {
+		case OpLess:
+			return c < 0
+		case OpLessEqual:
+			return c <= 0
+		case OpGreater:
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case net.IP:
+		b, ok := b.(net.IP)
+		return ok && a.Equal(b)
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// number returns the integer of the value of an integer or date attribute,
+// or nil if v is not one.
+func number(v interface{}) *big.Int {
+	switch v := v.(type) {
+	case uint8:
+		return big.NewInt(int64(v))
+	case uint16:
+		return big.NewInt(int64(v))
+	case uint32:
+		return big.NewInt(int64(v))
+	case uint64:
+		return new(big.Int).SetUint64(v)
+	case int32:
+		return big.NewInt(int64(v))
+	case time.Time:
+		return big.NewInt(v.Unix())
+	}
+	return nil
+}
+
+// text returns the text that regular expressions are matched against: the
+// dictionary name of integer values, and the text representation of other
+// values.
+func (i *Item) text(v interface{}) string {
+	if n := number(v); n != nil && n.IsUint64() {
+		for _, value := range i.values {
+			if value.Number == n.Uint64() {
+				return value.Name
+			}
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// Password returns the value of the Cleartext-Password control item, which
+// is the password that the credentials of the request are checked against.
+func (r *Result) Password() (string, bool) {
+	return r.control(CleartextPassword)
+}
+
+// CryptPassword returns the value of the Crypt-Password control item, which
+// is the crypt(3) hash of the password.
+func (r *Result) CryptPassword() (string, bool) {
+	return r.control(CryptPassword)
+}
+
+// NTPassword returns the value of the NT-Password control item, which is
+// the MD4 hash of the UTF-16LE encoded password.
+func (r *Result) NTPassword() ([]byte, bool) {
+	s, ok := r.control(NTPassword)
+	if !ok {
+		return nil, false
+	}
+	hash, err := parseNTPassword(s)
+	if err != nil {
+		return nil, false
+	}
+	return hash, true
+}
+
+// AuthType returns the value of the Auth-Type control item, such as Accept
+// or Reject, or an empty string if there is none.
+func (r *Result) AuthType() string {
+	s, _ := r.control(AuthType)
+	return s
+}
+
+// control returns the string value of the named control item.
+func (r *Result) control(name string) (string, bool) {
+	for _, item := range r.Control {
+		if item.Attribute == name {
+			s, ok := item.Value.(string)
+			return s, ok
+		}
+	}
+	return "", false
+}
+
+// parseNTPassword returns the hash of an NT-Password value, which is hex
+// encoded with an optional "0x" prefix.
+func parseNTPassword(s string) ([]byte, error) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(hash) != 16 {
+		return nil, errors.New("invalid " + NTPassword + " value " + strconv.Quote(s))
+	}
+	return hash, nil
+}
+
+// Apply adds the reply items to the response p. Items with OpSet replace
+// the attributes of p, and items with OpAssign are only added if p does not
+// contain the attribute.
+func (r *Result) Apply(p *radius.Packet) error {
+	for _, item := range r.Reply {
+		name := item.Attribute
+		switch item.Operator {
+		case OpSet:
+			if err := r.codec.Set(p, name, item.Value); err != nil {
+				return err
+			}
+			continue
+		case OpAssign:
+			values, err := r.codec.Gets(p, name)
+			if err != nil {
+				return err
+			}
+			if len(values) > 0 {
+				continue
+			}
+		}
+		if err := r.codec.Add(p, name, item.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupAttribute returns the dictionary attribute of name, without its tag,
+// and its values.
+func lookupAttribute(d *dictionary.Dictionary, name string) (*dictionary.Attribute, []*dictionary.Value) {
+	if d == nil {
+		return nil, nil
+	}
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[:i]
+	}
+	if attr := dictionary.AttributeByName(d.Attributes, name); attr != nil {
+		return attr, dictionary.ValuesByAttribute(d.Values, attr.Name)
+	}
+	for _, vendor := range d.Vendors {
+		if attr := dictionary.AttributeByName(vendor.Attributes, name); attr != nil {
+			return attr, dictionary.ValuesByAttribute(vendor.Values, attr.Name)
+		}
+	}
+	return nil, nil
+}
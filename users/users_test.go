@@ -0,0 +1,249 @@
+package users_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/users"
+)
+
+func parseTestdata(t *testing.T) *users.Users {
+	parser := &users.Parser{
+		Dictionary: debug.IncludedDictionary,
+		Opener: &dictionary.FileSystemOpener{
+			Root: "testdata",
+		},
+	}
+	u, err := parser.ParseFile("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestParser(t *testing.T) {
+	u := parseTestdata(t)
+	if len(u.Entries) != 6 {
+		t.Fatalf("got %d entries", len(u.Entries))
+	}
+
+	e := u.Entries[2]
+	if e.Name != "john smith" || !e.FallThrough || e.Line != 12 {
+		t.Fatalf("got entry %+v", e)
+	}
+	var check []string
+	for _, item := range e.Check {
+		check = append(check, item.String())
+	}
+	if expected := []string{"Cleartext-Password := secret", "NAS-IP-Address == 192.0.2.1"}; !reflect.DeepEqual(check, expected) {
+		t.Fatalf("got check items %q", check)
+	}
+	if len(e.Reply) != 1 || e.Reply[0].Operator != users.OpSet || e.Reply[0].Value != "Hello, John" {
+		t.Fatalf("got reply items %v", e.Reply)
+	}
+
+	if e := u.Entries[4]; e.Name != "alice" || !strings.HasSuffix(e.File, "users.include") || e.Line != 1 {
+		t.Fatalf("got included entry %+v", e)
+	}
+}
+
+func TestParser_errors(t *testing.T) {
+	tests := []struct {
+		Users string
+		Error string
+	}{
+		{"bob Unknown-Attribute == 1", "users:1: radius: unknown attribute"},
+		{"bob NAS-Port == x", `users:1: radius: line 1: NAS-Port: invalid integer value "x"`},
+		{"bob\n\tReply-Message == \"x\"", "users:2: invalid reply item operator =="},
+		{"bob\n\tReply-Message = \"x\"\n\tSession-Timeout = 1", "users:3: expected comma after previous reply item"},
+		{"bob NAS-Port = 1 NAS-Port = 2", "users:1: expected comma after value of NAS-Port"},
+		{"\tReply-Message = \"x\"", "users:1: reply items without entry"},
+		{"bob Called-Station-Id =~ \"(\"", "users:1: error parsing regexp"},
+		{"bob Fall-Through = Maybe", "users:1: invalid Fall-Through value"},
+		{"bob Auth-Type == Reject", "users:1: invalid operator == for Auth-Type"},
+		{"bob NT-Password := 0x1234", `users:1: invalid NT-Password value "0x1234"`},
+		{"bob Simultaneous-Use == 1", "users:1: radius: unknown attribute"},
+		{"bob\n\tSimultaneous-Use = 1", "users:2: radius: unknown attribute"},
+	}
+	for _, tt := range tests {
+		parser := &users.Parser{
+			Dictionary: debug.IncludedDictionary,
+		}
+		_, err := parser.Parse(&testFile{strings.NewReader(tt.Users)})
+		if err == nil || !strings.Contains(err.Error(), tt.Error) {
+			t.Errorf("%q: got error %v; expecting %q", tt.Users, err, tt.Error)
+		}
+	}
+}
+
+type testFile struct {
+	*strings.Reader
+}
+
+func (f *testFile) Name() string { return "users" }
+func (f *testFile) Close() error { return nil }
+
+func TestUsers_Authorize(t *testing.T) {
+	u := parseTestdata(t)
+
+	tests := []struct {
+		Name     string
+		Request  func(p *radius.Packet)
+		Entries  []string
+		Password string
+		AuthType string
+		Reply    string
+	}{
+		{
+			Name: "user",
+			Request: func(p *radius.Packet) {
+				rfc2865.UserName_SetString(p, "bob")
+				rfc2865.ServiceType_Set(p, rfc2865.ServiceType_Value_FramedUser)
+				rfc2865.FramedProtocol_Set(p, rfc2865.FramedProtocol_Value_PPP)
+			},
+			Entries:  []string{"DEFAULT", "bob"},
+			Password: "hello",
+			Reply:    "Framed-IP-Address = 255.255.255.254\nFramed-MTU = 1500\nReply-Message = \"Hello, bob\"\nSession-Timeout = 3600\n",
+		},
+		{
+			Name: "fall through",
+			Request: func(p *radius.Packet) {
+				rfc2865.UserName_SetString(p, "john smith")
+				rfc2865.NASIPAddress_Set(p, []byte{192, 0, 2, 1})
+				rfc2865.NASPort_Set(p, 120)
+				rfc2865.CalledStationID_SetString(p, "ssid-5")
+			},
+			Entries:  []string{"john smith", "DEFAULT"},
+			Password: "secret",
+			Reply:    "Reply-Message = \"Hello, John\"\nSession-Timeout = 60\nReply-Message = \"High port\"\nFramed-MTU = 1400\n",
+		},
+		{
+			Name: "check items not matching",
+			Request: func(p *radius.Packet) {
+				rfc2865.UserName_SetString(p, "john smith")
+				rfc2865.NASIPAddress_Set(p, []byte{192, 0, 2, 2})
+				rfc2865.NASPort_Set(p, 120)
+				rfc2865.CalledStationID_SetString(p, "ssid-x")
+			},
+			Entries:  []string{"DEFAULT"},
+			AuthType: "Reject",
+			Reply:    "Reply-Message = \"Unknown user\"\n",
+		},
+		{
+			Name: "included",
+			Request: func(p *radius.Packet) {
+				rfc2865.UserName_SetString(p, "alice")
+			},
+			Entries:  []string{"alice"},
+			Password: "wonderland",
+			Reply:    "Acct-Interim-Interval = 300\n",
+		},
+		{
+			Name: "absent attribute",
+			Request: func(p *radius.Packet) {
+				rfc2865.UserName_SetString(p, "alice")
+				rfc2865.CallingStationID_SetString(p, "00-11-22-33-44-55")
+			},
+			Entries:  []string{"DEFAULT"},
+			AuthType: "Reject",
+			Reply:    "Reply-Message = \"Unknown user\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+			tt.Request(req)
+			result, err := u.Authorize(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var entries []string
+			for _, e := range result.Entries {
+				entries = append(entries, e.Name)
+			}
+			if !reflect.DeepEqual(entries, tt.Entries) {
+				t.Fatalf("got entries %q; expecting %q", entries, tt.Entries)
+			}
+			if password, ok := result.Password(); password != tt.Password || ok != (tt.Password != "") {
+				t.Fatalf("got password %q", password)
+			}
+			if authType := result.AuthType(); authType != tt.AuthType {
+				t.Fatalf("got Auth-Type %q", authType)
+			}
+
+			resp := req.Response(radius.CodeAccessAccept)
+			if err := result.Apply(resp); err != nil {
+				t.Fatal(err)
+			}
+			var reply strings.Builder
+			codec := &radius.Codec{
+				Dictionary: debug.IncludedDictionary,
+			}
+			if err := codec.WriteText(&reply, resp); err != nil {
+				t.Fatal(err)
+			}
+			if reply.String() != tt.Reply {
+				t.Fatalf("got reply\n%s\nexpecting\n%s", reply.String(), tt.Reply)
+			}
+		})
+	}
+}
+
+func TestUsers_Authorize_control(t *testing.T) {
+	parser := &users.Parser{
+		Dictionary: debug.IncludedDictionary,
+	}
+	u, err := parser.Parse(&testFile{strings.NewReader(
+		"bob\tCrypt-Password := \"$1$salt$hash\", Simultaneous-Use := 1, Expiration := \"Jan 1 2030\"\n" +
+			"alice\tNT-Password := 8846F7EAEE8FB117AD06BDD830B7586C\n",
+	)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	rfc2865.UserName_SetString(req, "bob")
+	result, err := u.Authorize(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var control []string
+	for _, item := range result.Control {
+		control = append(control, item.String())
+	}
+	if expected := []string{"Crypt-Password := $1$salt$hash", "Simultaneous-Use := 1", "Expiration := Jan 1 2030"}; !reflect.DeepEqual(control, expected) {
+		t.Fatalf("got control items %q", control)
+	}
+	if password, ok := result.CryptPassword(); password != "$1$salt$hash" || !ok {
+		t.Fatalf("got Crypt-Password %q", password)
+	}
+
+	rfc2865.UserName_SetString(req, "alice")
+	if result, err = u.Authorize(req); err != nil {
+		t.Fatal(err)
+	}
+	if hash, ok := result.NTPassword(); !ok || hex.EncodeToString(hash) != "8846f7eaee8fb117ad06bdd830b7586c" {
+		t.Fatalf("got NT-Password %x", hash)
+	}
+}
+
+func TestParser_recursiveInclude(t *testing.T) {
+	parser := &users.Parser{
+		Dictionary: debug.IncludedDictionary,
+		Opener: &dictionary.FileSystemOpener{
+			Root: "testdata",
+		},
+	}
+	_, err := parser.Parse(&testFile{strings.NewReader("$INCLUDE users.include\n$INCLUDE users.include\n")})
+	if err == nil || !strings.Contains(err.Error(), "file already included") {
+		t.Fatalf("got error %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package microsoft
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2759"
+)
+
+// MS-CHAP2-CPW and MS-CHAP-NT-Enc-PW code values - rfc2548, 2.3.3 and 2.3.4
+const (
+	ChangePasswordCode    = 7
+	NTEncryptedPWCode     = 6
+	maxNTEncryptedPWChunk = 243
+)
+
+// ChangePassword is an MS-CHAPv2 change password request, carried in the
+// MS-CHAP2-CPW and MS-CHAP-NT-Enc-PW attributes of an Access-Request.
+type ChangePassword struct {
+	Ident byte
+
+	// EncryptedPassword is the new password encrypted with the old NT
+	// password hash (516 octets).
+	EncryptedPassword []byte
+	// EncryptedHash is the old NT password hash encrypted with the new NT
+	// password hash (16 octets).
+	EncryptedHash []byte
+
+	PeerChallenge []byte
+	NTResponse    []byte
+	Flags         uint16
+}
+
+// NewChangePassword builds the change password request a peer sends to move
+// from oldPassword to newPassword in response to the given authenticator
+// challenge.
+func NewChangePassword(ident byte, authenticatorChallenge, peerChallenge, username, oldPassword, newPassword []byte) (*ChangePassword, error) {
+	if len(peerChallenge) != 16 {
+		return nil, errors.New("peerChallenge must be 16 bytes in size")
+	}
+	encryptedPassword, err := rfc2759.NewPasswordEncryptedWithOldNtPasswordHash(newPassword, oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	encryptedHash, err := rfc2759.OldNtPasswordHashEncryptedWithNewNtPasswordHash(newPassword, oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	ntResponse, err := rfc2759.GenerateNTResponse(authenticatorChallenge, peerChallenge, username, newPassword)
+	if err != nil {
+		return nil, err
+	}
+	return &ChangePassword{
+		Ident:             ident,
+		EncryptedPassword: encryptedPassword,
+		EncryptedHash:     encryptedHash,
+		PeerChallenge:     append([]byte(nil), peerChallenge...),
+		NTResponse:        ntResponse,
+	}, nil
+}
+
+// ChangePassword_Lookup returns the change password request contained in p.
+// The MS-CHAP-NT-Enc-PW attributes are reassembled in sequence number order.
+//
+// radius.ErrNoAttribute is returned if p does not contain an MS-CHAP2-CPW
+// attribute.
+func ChangePassword_Lookup(p *radius.Packet) (*ChangePassword, error) {
+	cpw, err := MSCHAP2CPW_Lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(cpw) != 68 || cpw[0] != ChangePasswordCode {
+		return nil, errors.New("invalid MS-CHAP2-CPW")
+	}
+
+	c := &ChangePassword{
+		Ident:         cpw[1],
+		EncryptedHash: append([]byte(nil), cpw[2:18]...),
+		PeerChallenge: append([]byte(nil), cpw[18:34]...),
+		// cpw[34:42] reserved
+		NTResponse: append([]byte(nil), cpw[42:66]...),
+		Flags:      binary.BigEndian.Uint16(cpw[66:68]),
+	}
+
+	chunks, err := MSCHAPNTEncPW_Gets(p)
+	if err != nil {
+		return nil, err
+	}
+	type sequence struct {
+		Number int
+		Data   []byte
+	}
+	var sequences []sequence
+	for _, chunk := range chunks {
+		if len(chunk) < 4 || chunk[0] != NTEncryptedPWCode {
+			return nil, errors.New("invalid MS-CHAP-NT-Enc-PW")
+		}
+		if chunk[1] != c.Ident {
+			return nil, errors.New("MS-CHAP-NT-Enc-PW identifier mismatch")
+		}
+		sequences = append(sequences, sequence{
+			Number: int(binary.BigEndian.Uint16(chunk[2:4])),
+			Data:   chunk[4:],
+		})
+	}
+	sort.SliceStable(sequences, func(i, j int) bool {
+		return sequences[i].Number < sequences[j].Number
+	})
+	for i, seq := range sequences {
+		if seq.Number != i+1 {
+			return nil, errors.New("missing MS-CHAP-NT-Enc-PW sequence")
+		}
+		c.EncryptedPassword = append(c.EncryptedPassword, seq.Data...)
+	}
+	if len(c.EncryptedPassword) != rfc2759.EncryptedPwBlockLength {
+		return nil, errors.New("invalid MS-CHAP-NT-Enc-PW length")
+	}
+
+	return c, nil
+}
+
+// ChangePassword_Add adds the MS-CHAP2-CPW attribute and the
+// MS-CHAP-NT-Enc-PW attributes of c to p.
+func ChangePassword_Add(p *radius.Packet, c *ChangePassword) error {
+	if len(c.EncryptedPassword) != rfc2759.EncryptedPwBlockLength || len(c.EncryptedHash) != 16 || len(c.PeerChallenge) != 16 || len(c.NTResponse) != 24 {
+		return errors.New("invalid change password request")
+	}
+
+	cpw := make([]byte, 68)
+	cpw[0] = ChangePasswordCode
+	cpw[1] = c.Ident
+	copy(cpw[2:18], c.EncryptedHash)
+	copy(cpw[18:34], c.PeerChallenge)
+	copy(cpw[42:66], c.NTResponse)
+	binary.BigEndian.PutUint16(cpw[66:68], c.Flags)
+	if err := MSCHAP2CPW_Add(p, cpw); err != nil {
+		return err
+	}
+
+	encryptedPassword := c.EncryptedPassword
+	for seq := 1; len(encryptedPassword) > 0; seq++ {
+		n := len(encryptedPassword)
+		if n > maxNTEncryptedPWChunk {
+			n = maxNTEncryptedPWChunk
+		}
+		chunk := make([]byte, 4+n)
+		chunk[0] = NTEncryptedPWCode
+		chunk[1] = c.Ident
+		binary.BigEndian.PutUint16(chunk[2:4], uint16(seq))
+		copy(chunk[4:], encryptedPassword[:n])
+		if err := MSCHAPNTEncPW_Add(p, chunk); err != nil {
+			return err
+		}
+		encryptedPassword = encryptedPassword[n:]
+	}
+	return nil
+}
+
+// NewPassword decrypts the new password of the request using the old
+// password, and verifies both the encrypted hash block and the NT-Response
+// computed from the new password for the given authenticator challenge.
+func (c *ChangePassword) NewPassword(authenticatorChallenge, username, oldPassword []byte) ([]byte, error) {
+	ucs2OldPassword, err := rfc2759.ToUTF16(oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	newPassword, err := rfc2759.DecryptPwBlockWithPasswordHash(c.EncryptedPassword, rfc2759.NTPasswordHash(ucs2OldPassword))
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedHash, err := rfc2759.OldNtPasswordHashEncryptedWithNewNtPasswordHash(newPassword, oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(encryptedHash, c.EncryptedHash) != 1 {
+		return nil, errors.New("encrypted hash mismatch")
+	}
+
+	ntResponse, err := rfc2759.GenerateNTResponse(authenticatorChallenge, c.PeerChallenge, username, newPassword)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(ntResponse, c.NTResponse) != 1 {
+		return nil, errors.New("NT-Response mismatch")
+	}
+
+	return newPassword, nil
+}
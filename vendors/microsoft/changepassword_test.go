@@ -0,0 +1,73 @@
+package microsoft
+
+import (
+	"bytes"
+	"testing"
+
+	"layeh.com/radius"
+)
+
+func TestChangePassword(t *testing.T) {
+	authenticatorChallenge := bytes.Repeat([]byte{0x5a}, 16)
+	peerChallenge := bytes.Repeat([]byte{0x21}, 16)
+	username := []byte("User")
+	oldPassword := []byte("clientPass")
+	newPassword := []byte("MyPw")
+
+	c, err := NewChangePassword(4, authenticatorChallenge, peerChallenge, username, oldPassword, newPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := radius.New(radius.CodeAccessRequest, []byte(`12345`))
+	if err := ChangePassword_Add(p, c); err != nil {
+		t.Fatal(err)
+	}
+	if chunks, _ := MSCHAPNTEncPW_Gets(p); len(chunks) != 3 {
+		t.Fatalf("got %d MS-CHAP-NT-Enc-PW attributes; expected 3", len(chunks))
+	}
+
+	// sequence numbers, not attribute order, determine reassembly
+	p.Attributes[1], p.Attributes[3] = p.Attributes[3], p.Attributes[1]
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, p.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ChangePassword_Lookup(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.EncryptedPassword, c.EncryptedPassword) {
+		t.Fatal("reassembled encrypted password does not match")
+	}
+
+	got, err := parsed.NewPassword(authenticatorChallenge, username, oldPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newPassword) {
+		t.Fatalf("NewPassword() = %q; expected %q", got, newPassword)
+	}
+
+	if _, err := parsed.NewPassword(authenticatorChallenge, username, []byte("wrong")); err == nil {
+		t.Fatal("expected error with wrong old password")
+	}
+	if _, err := parsed.NewPassword(bytes.Repeat([]byte{0}, 16), username, oldPassword); err == nil {
+		t.Fatal("expected error with wrong authenticator challenge")
+	}
+
+	MSCHAPNTEncPW_Del(q)
+	if _, err := ChangePassword_Lookup(q); err == nil {
+		t.Fatal("expected error with missing MS-CHAP-NT-Enc-PW")
+	}
+	MSCHAP2CPW_Del(q)
+	if _, err := ChangePassword_Lookup(q); err != radius.ErrNoAttribute {
+		t.Fatalf("got err %v; expected ErrNoAttribute", err)
+	}
+}
@@ -0,0 +1,92 @@
+package microsoft
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"layeh.com/radius"
+)
+
+// NewMPPEKey returns the salt-encrypted value of an MS-MPPE-Send-Key or
+// MS-MPPE-Recv-Key attribute (rfc2548, 2.4.2 and 2.4.3).
+//
+// Unlike Tunnel-Password, the value carries no tag: it is the two-octet salt
+// followed by the encrypted, length-prefixed and zero padded key. The
+// requestAuthenticator must be the one from the Access-Request being
+// answered, and salt must have its most significant bit set and be unique
+// among the attributes of the response.
+func NewMPPEKey(key, salt, secret, requestAuthenticator []byte) (radius.Attribute, error) {
+	return radius.NewTunnelPassword(key, salt, secret, requestAuthenticator)
+}
+
+// MPPEKey decrypts the value of an MS-MPPE-Send-Key or MS-MPPE-Recv-Key
+// attribute. The requestAuthenticator must be the one from the
+// Access-Request.
+func MPPEKey(a radius.Attribute, secret, requestAuthenticator []byte) (key []byte, err error) {
+	key, _, err = radius.TunnelPassword(a, secret, requestAuthenticator)
+	return
+}
+
+// MPPEKeysFromMSK splits a 64-octet EAP Master Session Key into the
+// MS-MPPE-Recv-Key and MS-MPPE-Send-Key values (rfc5216, 2.3).
+func MPPEKeysFromMSK(msk []byte) (recvKey, sendKey []byte, err error) {
+	if len(msk) < 64 {
+		err = errors.New("MSK must be at least 64 bytes in size")
+		return
+	}
+	recvKey = append([]byte(nil), msk[:32]...)
+	sendKey = append([]byte(nil), msk[32:64]...)
+	return
+}
+
+// MPPEKeys_Set replaces the MS-MPPE-Recv-Key and MS-MPPE-Send-Key attributes
+// of the response p with the given keys. Each key is encrypted with its own
+// random salt using p.Secret and p.Authenticator, which must hold the
+// Request Authenticator (as set by radius.Packet.Response).
+func MPPEKeys_Set(p *radius.Packet, recvKey, sendKey []byte) error {
+	var salts [4]byte
+	if _, err := rand.Read(salts[:]); err != nil {
+		return err
+	}
+	salts[0] |= 0x80
+	salts[2] |= 0x80
+	if salts[0] == salts[2] && salts[1] == salts[3] {
+		salts[3]++
+	}
+
+	recv, err := NewMPPEKey(recvKey, salts[0:2], p.Secret, p.Authenticator[:])
+	if err != nil {
+		return err
+	}
+	send, err := NewMPPEKey(sendKey, salts[2:4], p.Secret, p.Authenticator[:])
+	if err != nil {
+		return err
+	}
+
+	_Microsoft_DelVendor(p, 17)
+	_Microsoft_DelVendor(p, 16)
+	if err := _Microsoft_AddVendor(p, 17, recv); err != nil {
+		return err
+	}
+	return _Microsoft_AddVendor(p, 16, send)
+}
+
+// MPPEKeys_Lookup decrypts the MS-MPPE-Recv-Key and MS-MPPE-Send-Key
+// attributes of the response p, where q is the Access-Request p answers.
+func MPPEKeys_Lookup(p, q *radius.Packet) (recvKey, sendKey []byte, err error) {
+	recv, ok := _Microsoft_LookupVendor(p, 17)
+	if !ok {
+		err = radius.ErrNoAttribute
+		return
+	}
+	send, ok := _Microsoft_LookupVendor(p, 16)
+	if !ok {
+		err = radius.ErrNoAttribute
+		return
+	}
+	if recvKey, err = MPPEKey(recv, p.Secret, q.Authenticator[:]); err != nil {
+		return
+	}
+	sendKey, err = MPPEKey(send, p.Secret, q.Authenticator[:])
+	return
+}
@@ -0,0 +1,88 @@
+package microsoft
+
+import (
+	"bytes"
+	"testing"
+
+	"layeh.com/radius"
+)
+
+func TestMPPEKeys(t *testing.T) {
+	msk := make([]byte, 64)
+	for i := range msk {
+		msk[i] = byte(i)
+	}
+	recvKey, sendKey, err := MPPEKeysFromMSK(msk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recvKey, msk[:32]) || !bytes.Equal(sendKey, msk[32:]) {
+		t.Fatal("unexpected MSK split")
+	}
+
+	request := radius.New(radius.CodeAccessRequest, []byte(`secret`))
+	response := request.Response(radius.CodeAccessAccept)
+	if err := MPPEKeys_Set(response, recvKey, sendKey); err != nil {
+		t.Fatal(err)
+	}
+
+	recv, _ := _Microsoft_LookupVendor(response, 17)
+	send, _ := _Microsoft_LookupVendor(response, 16)
+	if len(recv) != 2+48 || len(send) != 2+48 {
+		t.Fatalf("got encrypted lengths %d and %d; expected 50", len(recv), len(send))
+	}
+	if recv[0]&0x80 == 0 || send[0]&0x80 == 0 {
+		t.Fatal("salt MSB not set")
+	}
+	if bytes.Equal(recv[:2], send[:2]) {
+		t.Fatal("salts are not unique")
+	}
+
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = radius.Parse(wire, request.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotRecv, gotSend, err := MPPEKeys_Lookup(response, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotRecv, recvKey) || !bytes.Equal(gotSend, sendKey) {
+		t.Fatal("decrypted keys do not match")
+	}
+
+	// generated accessors agree on the encoding
+	if v, err := MSMPPERecvKey_Lookup(response, request); err != nil || !bytes.Equal(v, recvKey) {
+		t.Fatalf("MSMPPERecvKey_Lookup = %x, %v", v, err)
+	}
+
+	if _, _, err := MPPEKeysFromMSK(msk[:63]); err == nil {
+		t.Fatal("expected error for short MSK")
+	}
+}
+
+func TestMPPEKey(t *testing.T) {
+	requestAuthenticator := bytes.Repeat([]byte{0x0f}, 16)
+	key := bytes.Repeat([]byte{0xaa}, 16)
+	a, err := NewMPPEKey(key, []byte{0x81, 0x02}, []byte(`secret`), requestAuthenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 2+32 {
+		t.Fatalf("got length %d; expected 34", len(a))
+	}
+	got, err := MPPEKey(a, []byte(`secret`), requestAuthenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatalf("MPPEKey() = %x; expected %x", got, key)
+	}
+	if _, err := NewMPPEKey(key, []byte{0x01, 0x02}, []byte(`secret`), requestAuthenticator); err == nil {
+		t.Fatal("expected error for salt without MSB set")
+	}
+}